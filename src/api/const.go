@@ -3,4 +3,13 @@ package api
 const (
 	//API_VERSION is the API version of Convoy daemon
 	API_VERSION = "1"
+	// API_V2_VERSION is the version of the resource api, served next to
+	// the first one
+	API_V2_VERSION = "2"
+
+	// IDEMPOTENCY_KEY_HEADER names a mutating request, sending it again
+	// with the same key gets the first response instead of doing it twice
+	IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+	// IDEMPOTENCY_REPLAY_HEADER marks the response of an earlier request
+	IDEMPOTENCY_REPLAY_HEADER = "Idempotency-Replayed"
 )
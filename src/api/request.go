@@ -1,5 +1,9 @@
 package api
 
+import (
+	"encoding/json"
+)
+
 type VolumeGetRequest struct {
 	VolumeId   string
 	DriverName string
@@ -31,11 +35,26 @@ type VolumeDetachRequest struct {
 	Mode        string
 }
 
+type VolumeFenceRequest struct {
+	VolumeId   string
+	DriverName string
+	Host       string
+}
+
 type VolumeDeleteRequest struct {
 	VolumeId   string
 	DriverName string
 }
 
+// VolumeMigrateRequest moves a volume to ToDevice, or to a device
+// scheduled on ToHost.
+type VolumeMigrateRequest struct {
+	VolumeId   string
+	DriverName string
+	ToDevice   string
+	ToHost     string
+}
+
 type HostAddRequest struct {
 	Ip string
 }
@@ -81,3 +100,73 @@ type DeviceDelRequest struct {
 	Port    int
 	Backend string
 }
+
+// DeviceDrainRequest puts a device in maintenance, and moves its volume
+// away when Migrate is set.
+type DeviceDrainRequest struct {
+	ID      string
+	Backend string
+	Migrate bool
+}
+
+// HostDrainRequest puts a host and its devices in maintenance, and moves
+// their volumes away when Migrate is set.
+type HostDrainRequest struct {
+	Ip      string
+	Migrate bool
+}
+
+type ContainerAddRequest struct {
+	ContainerId string
+	DriverName  string
+	Mode        string
+	Volumes     []string
+}
+
+type ContainerGetRequest struct {
+	ContainerId string
+}
+
+type ContainerListRequest struct {
+}
+
+type ContainerDeleteRequest struct {
+	ContainerId string
+}
+
+type BackupCreateRequest struct {
+	VolumeId   string
+	DriverName string
+	Full       bool
+}
+
+type BackupListRequest struct {
+	VolumeId string
+}
+
+type BackupRestoreRequest struct {
+	BackupId string
+	VolumeId string
+}
+
+type BackupDeleteRequest struct {
+	BackupId string
+}
+
+// EventListRequest lists the events of Object, a host ip or device id, or
+// all events when empty.
+type EventListRequest struct {
+	Object string
+}
+
+// FsckRequest checks the metadata, and repairs it when Repair is set.
+type FsckRequest struct {
+	Repair bool
+}
+
+// MetaImportRequest restores an archive made by meta export, a dry run
+// only tells the changes.
+type MetaImportRequest struct {
+	Archive json.RawMessage
+	DryRun  bool
+}
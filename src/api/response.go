@@ -12,6 +12,36 @@ type ErrorResponse struct {
 	Error string
 }
 
+// Error is the error of a failed request, added to the response of the
+// request next to its Result.
+type Error struct {
+	Code    int               `json:"code"`
+	Name    string            `json:"name"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// ErrorEntry is a code of the catalog, with the http status of requests
+// failing with it.
+type ErrorEntry struct {
+	Code    int    `json:"code"`
+	Name    string `json:"name"`
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+type ErrorListResponse struct {
+	Result string
+	Errors []ErrorEntry
+}
+
+// RequestResponse answers a request sent with an idempotency key that was
+// not run.
+type RequestResponse struct {
+	Result string
+	Key    string
+}
+
 type DeviceIdentify struct {
 	IP   string
 	Port string
@@ -21,11 +51,61 @@ type DeviceIdentify struct {
 type VolumeResponse struct {
 	Result     string
 	ID         string
+	DriverName string
 	Status     string
 	Capacity   string
 	Writable   string
+	Path       string
 	Containers []string
 	Devices    []DeviceIdentify
+	// the operation finishing the request, when it runs after the response
+	Operation string
+}
+
+const (
+	OPERATION_CREATE = "create"
+	OPERATION_DELETE = "delete"
+	OPERATION_ATTACH = "attach"
+	OPERATION_DETACH = "detach"
+
+	OPERATION_QUEUED  = "queued"
+	OPERATION_RUNNING = "running"
+	OPERATION_DONE    = "done"
+	OPERATION_FAILED  = "failed"
+)
+
+// OperationResponse tells the status of a volume operation, and once it
+// ended its result code and the volume as the operation left it.
+type OperationResponse struct {
+	Result     string
+	ID         string
+	Type       string
+	VolumeId   string
+	DriverName string
+	Status     string
+	OpResult   string
+	Volume     VolumeResponse
+	Created    string
+	Ended      string
+}
+
+const (
+	MIGRATE_COPYING = "copying"
+	MIGRATE_DONE    = "done"
+	MIGRATE_FAILED  = "failed"
+)
+
+// MigrateResponse tells the progress of a volume migration, Done and
+// Total in bytes.
+type MigrateResponse struct {
+	Result     string
+	ID         string
+	Status     string
+	FromDevice string
+	ToDevice   string
+	Done       string
+	Total      string
+	Error      string
 }
 
 type VolumeListResponse struct {
@@ -40,6 +120,15 @@ type HostResponse struct {
 	Devs   []string
 }
 
+// FailoverResponse lists the volumes released from a dead host, and those
+// whose release failed.
+type FailoverResponse struct {
+	Result  string
+	IP      string
+	Volumes []string
+	Failed  []string
+}
+
 type HostListResponse struct {
 	Result string
 	IPs    []string
@@ -48,7 +137,9 @@ type HostListResponse struct {
 type DeviceResponse struct {
 	Result   string
 	ID       string
+	Backend  string
 	IP       string
+	Status   string
 	Capacity string
 	Resource string
 }
@@ -58,6 +149,118 @@ type DeviceListResponse struct {
 	Devices []string
 }
 
+// VolumeQueryResponse, HostQueryResponse and DeviceQueryResponse list the
+// records matching the query of a request of the resource api.
+type VolumeQueryResponse struct {
+	Result  string
+	Volumes []VolumeResponse
+}
+
+type HostQueryResponse struct {
+	Result string
+	Hosts  []HostResponse
+}
+
+type DeviceQueryResponse struct {
+	Result  string
+	Devices []DeviceResponse
+}
+
+// DrainResponse lists the drained devices and the volumes still on them,
+// the drain is complete when there are none left.
+type DrainResponse struct {
+	Result  string
+	Devices []string
+	Volumes []string
+}
+
+type ContainerVolume struct {
+	ID     string
+	Driver string
+	Mode   string
+}
+
+type ContainerResponse struct {
+	Result  string
+	ID      string
+	Status  string
+	Optime  string
+	Volumes []ContainerVolume
+}
+
+type ContainerListResponse struct {
+	Result     string
+	Containers []string
+}
+
+type BackupResponse struct {
+	Result   string
+	ID       string
+	VolumeID string
+	Driver   string
+	Parent   string
+	Size     string
+	Created  string
+	Store    string
+}
+
+type BackupListResponse struct {
+	Result  string
+	Backups []BackupResponse
+}
+
+type EventResponse struct {
+	ID      string
+	Kind    string
+	Object  string
+	Message string
+	Created string
+}
+
+type EventListResponse struct {
+	Result string
+	Events []EventResponse
+}
+
+type FsckProblem struct {
+	Class    string
+	Key      string
+	Message  string
+	Repaired bool
+}
+
+// FsckResponse lists the inconsistencies found, and counts them by class.
+type FsckResponse struct {
+	Result   string
+	Problems []FsckProblem
+	Classes  map[string]int
+}
+
+type MetaExportResponse struct {
+	Result  string
+	Archive json.RawMessage
+}
+
+type MetaChange struct {
+	Key    string
+	Action string
+}
+
+// MetaImportResponse lists the keys an import adds, changes or deletes.
+type MetaImportResponse struct {
+	Result  string
+	Changes []MetaChange
+}
+
+// SchemaResponse tells the schema version of the records, the version the
+// daemon upgrades them to and the last key upgraded.
+type SchemaResponse struct {
+	Result  string
+	Version int
+	Target  int
+	Cursor  string
+}
+
 //ResponseError would generate a error information in JSON format for output
 func ResponseError(format string, a ...interface{}) {
 	response := ErrorResponse{Error: fmt.Sprintf(format, a...)}
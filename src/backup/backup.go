@@ -0,0 +1,275 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"util"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// exports are cut in blocks of that size, compressed one by one and
+	// stored by checksum, so blocks unchanged between backups are shared
+	BLOCK_SIZE = 2 << 20
+
+	BACKUPS_DIR = "backups"
+	BLOCKS_DIR  = "blocks"
+
+	BACKUP_SUFFIX = ".json"
+	BLOCK_SUFFIX  = ".blk"
+
+	// attributes of the manifests, so the store can be browsed without
+	// reading them
+	ATTR_VOLUME = "volume"
+	ATTR_PARENT = "parent"
+)
+
+var (
+	ErrNotFound = errors.New("backup not found")
+	ErrInUse    = errors.New("backup is the parent of other backups")
+
+	log = logrus.WithFields(logrus.Fields{"pkg": "backup"})
+)
+
+// Block is a piece of an export, Checksum is the one of the uncompressed
+// data.
+type Block struct {
+	Checksum string
+	Length   int64
+}
+
+// Backup describes one export of a volume snapshot. A backup with a Parent
+// only holds the changes since the snapshot of its parent, restoring it
+// takes the whole chain down to the full backup it started from.
+type Backup struct {
+	ID       string
+	VolumeID string
+	Driver   string
+	Capacity string
+	Snapshot string
+	Parent   string
+	Size     int64
+	Created  time.Time
+	Blocks   []Block
+}
+
+// Target keeps backups in a store, every backup as a manifest listing its
+// blocks.
+type Target struct {
+	store BackupStore
+}
+
+func NewTarget(store BackupStore) *Target {
+	return &Target{store: store}
+}
+
+func (t *Target) Store() BackupStore {
+	return t.store
+}
+
+func backupName(id string) string {
+	return BACKUPS_DIR + "/" + id + BACKUP_SUFFIX
+}
+
+func blockName(checksum string) string {
+	return BLOCKS_DIR + "/" + checksum[:2] + "/" + checksum + BLOCK_SUFFIX
+}
+
+// Save stores the blocks of the export in file, then records b. The blocks
+// are stored before b, a backup that is listed can always be restored.
+func (t *Target) Save(b *Backup, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b.Blocks = []Block{}
+	b.Size = 0
+	buf := make([]byte, BLOCK_SIZE)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			block, err := t.putBlock(buf[:n])
+			if err != nil {
+				return err
+			}
+			b.Blocks = append(b.Blocks, block)
+			b.Size += block.Length
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	attrs := map[string]string{ATTR_VOLUME: b.VolumeID, ATTR_PARENT: b.Parent}
+	return t.store.Put(backupName(b.ID), bytes.NewReader(data), attrs)
+}
+
+func (t *Target) putBlock(data []byte) (Block, error) {
+	block := Block{Checksum: util.GetChecksum(data), Length: int64(len(data))}
+	name := blockName(block.Checksum)
+	if _, err := t.store.Stat(name); err == nil {
+		return block, nil
+	} else if err != ErrNotFound {
+		return block, err
+	}
+
+	compressed, err := util.CompressData(data)
+	if err != nil {
+		return block, err
+	}
+	return block, t.store.Put(name, compressed, nil)
+}
+
+// Restore writes the export b was saved from to w, every block is checked
+// against its checksum.
+func (t *Target) Restore(b *Backup, w io.Writer) error {
+	for _, block := range b.Blocks {
+		f, _, err := t.store.Get(blockName(block.Checksum))
+		if err != nil {
+			return err
+		}
+		data, err := util.DecompressAndVerify(f, block.Checksum)
+		if err == nil {
+			_, err = io.Copy(w, data)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Target) Get(id string) (*Backup, error) {
+	f, _, err := t.store.Get(backupName(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	b := &Backup{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// List returns the backups of volumeid, all of them for an empty one,
+// oldest first.
+func (t *Target) List(volumeid string) ([]*Backup, error) {
+	names, err := t.store.List(BACKUPS_DIR + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []*Backup{}
+	for _, name := range names {
+		if !strings.HasSuffix(name, BACKUP_SUFFIX) {
+			continue
+		}
+		b, err := t.Get(strings.TrimSuffix(path.Base(name), BACKUP_SUFFIX))
+		if err != nil {
+			log.Warnf("[List] read backup %s error: %s", name, err.Error())
+			continue
+		}
+		if volumeid == "" || b.VolumeID == volumeid {
+			backups = append(backups, b)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Created.Before(backups[j].Created)
+	})
+	return backups, nil
+}
+
+// Latest returns the last backup of volumeid, nil when there is none.
+func (t *Target) Latest(volumeid string) (*Backup, error) {
+	backups, err := t.List(volumeid)
+	if err != nil || len(backups) == 0 {
+		return nil, err
+	}
+	return backups[len(backups)-1], nil
+}
+
+// Chain returns the backups to restore in order to get the one of id, the
+// full backup first.
+func (t *Target) Chain(id string) ([]*Backup, error) {
+	chain := []*Backup{}
+	seen := map[string]bool{}
+	for id != "" {
+		if seen[id] {
+			return nil, errors.New("backup chain loops at " + id)
+		}
+		seen[id] = true
+
+		b, err := t.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		chain = append([]*Backup{b}, chain...)
+		id = b.Parent
+	}
+	return chain, nil
+}
+
+// Delete refuses the backups other backups are based on, the blocks only
+// used by the deleted backup are removed with it.
+func (t *Target) Delete(id string) error {
+	b, err := t.Get(id)
+	if err != nil {
+		return err
+	}
+	backups, err := t.List("")
+	if err != nil {
+		return err
+	}
+
+	used := map[string]bool{}
+	for _, other := range backups {
+		if other.ID == id {
+			continue
+		}
+		if other.Parent == id {
+			return ErrInUse
+		}
+		for _, block := range other.Blocks {
+			used[block.Checksum] = true
+		}
+	}
+
+	if err := t.store.Delete(backupName(id)); err != nil {
+		return err
+	}
+	for _, block := range b.Blocks {
+		if used[block.Checksum] {
+			continue
+		}
+		used[block.Checksum] = true
+		if err := t.store.Delete(blockName(block.Checksum)); err != nil && err != ErrNotFound {
+			log.Warnf("[Delete] remove block %s of backup %s error: %s", block.Checksum, id, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeExport(t *testing.T, dir string, data []byte) string {
+	file := filepath.Join(dir, "export")
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(filepath.Join(dir, "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := NewTarget(store)
+
+	// two identical blocks and a partial one
+	data := append(bytes.Repeat([]byte("a"), 2*BLOCK_SIZE), []byte("tail")...)
+	full := &Backup{ID: "full", VolumeID: "vol001", Created: time.Now()}
+	if err := target.Save(full, writeExport(t, dir, data)); err != nil {
+		t.Fatal(err)
+	}
+	if len(full.Blocks) != 3 || full.Size != int64(len(data)) || full.Blocks[0] != full.Blocks[1] {
+		t.Fatalf("unexpected blocks %+v", full.Blocks)
+	}
+	diff := &Backup{ID: "diff", VolumeID: "vol001", Parent: "full", Created: time.Now().Add(time.Second)}
+	if err := target.Save(diff, writeExport(t, dir, []byte("tail"))); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := target.Restore(full, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("restore should give the export back: %v", err)
+	}
+	chain, err := target.Chain("diff")
+	if err != nil || len(chain) != 2 || chain[0].ID != "full" {
+		t.Fatalf("unexpected chain %v %v", chain, err)
+	}
+	if latest, _ := target.Latest("vol001"); latest == nil || latest.ID != "diff" {
+		t.Fatalf("unexpected latest backup %v", latest)
+	}
+
+	if err := target.Delete("full"); err != ErrInUse {
+		t.Fatalf("parent backup should be in use, got %v", err)
+	}
+	if err := target.Delete("diff"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(blockName(full.Blocks[2].Checksum)); err != nil {
+		t.Fatalf("block shared with the full backup should be kept: %v", err)
+	}
+	if _, err := target.Get("diff"); err != ErrNotFound {
+		t.Fatalf("deleted backup should be not found, got %v", err)
+	}
+
+	// a damaged block is caught by its checksum
+	path := store.(*localStore).path(blockName(full.Blocks[2].Checksum))
+	other := &Backup{ID: "other", Created: time.Now()}
+	if err := target.Save(other, writeExport(t, dir, []byte("other"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(store.(*localStore).path(blockName(other.Blocks[0].Checksum)), path); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.Restore(full, ioutil.Discard); err == nil {
+		t.Fatal("restore of a damaged block should fail")
+	}
+}
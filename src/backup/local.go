@@ -0,0 +1,160 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"util"
+)
+
+const (
+	ATTRS_SUFFIX = ".attrs"
+	TMP_SUFFIX   = ".tmp"
+)
+
+// localStore keeps blobs as files under a directory, usually one shared
+// by the hosts or synced elsewhere. The attributes of a blob are kept next
+// to it.
+type localStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) (BackupStore, error) {
+	if !filepath.IsAbs(dir) {
+		return nil, fmt.Errorf("backup directory %s is not an absolute path", dir)
+	}
+	if err := util.MkdirIfNotExists(dir); err != nil {
+		return nil, err
+	}
+	return &localStore{dir: filepath.Clean(dir)}, nil
+}
+
+func (s *localStore) URL() string {
+	return "file://" + s.dir
+}
+
+func (s *localStore) path(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+// writeFile replaces path at once, readers never see a partial file.
+func writeFile(path string, r io.Reader) error {
+	if err := util.MkdirIfNotExists(filepath.Dir(path)); err != nil {
+		return err
+	}
+	tmp := path + TMP_SUFFIX
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Put writes the attributes first, a blob is never seen without them.
+func (s *localStore) Put(name string, r io.Reader, attrs map[string]string) error {
+	if attrs == nil {
+		attrs = map[string]string{}
+	}
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	path := s.path(name)
+	if err := writeFile(path+ATTRS_SUFFIX, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	return writeFile(path, r)
+}
+
+func (s *localStore) Get(name string) (io.ReadCloser, map[string]string, error) {
+	attrs, err := s.Stat(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(s.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+	return f, attrs, nil
+}
+
+func (s *localStore) Stat(name string) (map[string]string, error) {
+	path := s.path(name)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	attrs := map[string]string{}
+	data, err := ioutil.ReadFile(path + ATTRS_SUFFIX)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return attrs, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &attrs); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+func (s *localStore) List(prefix string) ([]string, error) {
+	names := []string{}
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, ATTRS_SUFFIX) || strings.HasSuffix(path, TMP_SUFFIX) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *localStore) Delete(name string) error {
+	path := s.path(name)
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	os.Remove(path + ATTRS_SUFFIX)
+	return nil
+}
@@ -0,0 +1,332 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	S3_ENDPOINT   = "s3.endpoint"
+	S3_REGION     = "s3.region"
+	S3_ACCESS_KEY = "s3.access-key"
+	S3_SECRET_KEY = "s3.secret-key"
+
+	DEFAULT_S3_REGION = "us-east-1"
+
+	S3_META_PREFIX      = "X-Amz-Meta-"
+	S3_UNSIGNED_PAYLOAD = "UNSIGNED-PAYLOAD"
+	S3_TIME_FORMAT      = "20060102T150405Z"
+	S3_DATE_FORMAT      = "20060102"
+)
+
+var (
+	// overridden by tests
+	now = time.Now
+)
+
+// s3Store keeps blobs as objects under a prefix of a bucket of any S3
+// compatible service, addressed path style so that it works with other
+// endpoints than AWS. Attributes are kept as user metadata.
+type s3Store struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func NewS3Store(bucket string, prefix string, opts map[string]string) (BackupStore, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 backup store needs a bucket")
+	}
+	if opts[S3_ENDPOINT] == "" || opts[S3_ACCESS_KEY] == "" || opts[S3_SECRET_KEY] == "" {
+		return nil, fmt.Errorf("s3 backup store needs %s, %s and %s", S3_ENDPOINT, S3_ACCESS_KEY, S3_SECRET_KEY)
+	}
+	endpoint, err := url.Parse(opts[S3_ENDPOINT])
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %s", opts[S3_ENDPOINT])
+	}
+	region := opts[S3_REGION]
+	if region == "" {
+		region = DEFAULT_S3_REGION
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &s3Store{
+		endpoint:  endpoint,
+		bucket:    bucket,
+		prefix:    prefix,
+		region:    region,
+		accessKey: opts[S3_ACCESS_KEY],
+		secretKey: opts[S3_SECRET_KEY],
+		client:    &http.Client{},
+	}, nil
+}
+
+func (s *s3Store) URL() string {
+	return "s3://" + s.bucket + "/" + strings.TrimSuffix(s.prefix, "/")
+}
+
+// s3Error is the error document S3 answers failed requests with.
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+func (s *s3Store) request(method string, key string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	// sent as signed
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	signRequest(req, s.region, s.accessKey, s.secretKey, now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	e := s3Error{}
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, e.Code, e.Message)
+	}
+	return nil, fmt.Errorf("s3 %s %s: %s", method, key, resp.Status)
+}
+
+// Put reads the blob in memory, S3 wants the length of the object upfront
+// and blobs are blocks of a few MB at most.
+func (s *s3Store) Put(name string, r io.Reader, attrs map[string]string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	for k, v := range attrs {
+		header.Set(S3_META_PREFIX+k, v)
+	}
+	resp, err := s.request("PUT", s.prefix+name, nil, bytes.NewReader(data), header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func objectAttrs(header http.Header) map[string]string {
+	attrs := map[string]string{}
+	for k := range header {
+		if strings.HasPrefix(k, S3_META_PREFIX) {
+			attrs[strings.ToLower(strings.TrimPrefix(k, S3_META_PREFIX))] = header.Get(k)
+		}
+	}
+	return attrs
+}
+
+func (s *s3Store) Get(name string) (io.ReadCloser, map[string]string, error) {
+	resp, err := s.request("GET", s.prefix+name, nil, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, objectAttrs(resp.Header), nil
+}
+
+func (s *s3Store) Stat(name string) (map[string]string, error) {
+	resp, err := s.request("HEAD", s.prefix+name, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return objectAttrs(resp.Header), nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *s3Store) List(prefix string) ([]string, error) {
+	names := []string{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.request("GET", "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		result := listBucketResult{}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			names = append(names, strings.TrimPrefix(c.Key, s.prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Delete reports ErrNotFound itself, S3 takes deleting a missing object as
+// a success.
+func (s *s3Store) Delete(name string) error {
+	if _, err := s.Stat(name); err != nil {
+		return err
+	}
+	resp, err := s.request("DELETE", s.prefix+name, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// escapePath encodes a path the way signature version 4 wants it, every
+// byte but the unreserved ones and the slashes.
+func escapePath(path string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalRequest is the part of signature version 4 computed from the
+// request itself, the server rebuilds it from what it received.
+func canonicalRequest(r *http.Request, signedHeaders []string) string {
+	query := r.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := []string{}
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			params = append(params, url.QueryEscape(k)+"="+strings.Replace(url.QueryEscape(v), "+", "%20", -1))
+		}
+	}
+
+	headers := ""
+	for _, h := range signedHeaders {
+		value := r.Header.Get(h)
+		if h == "host" {
+			value = r.Host
+			if value == "" {
+				value = r.URL.Host
+			}
+		}
+		headers += h + ":" + strings.TrimSpace(value) + "\n"
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		escapePath(r.URL.Path),
+		strings.Join(params, "&"),
+		headers,
+		strings.Join(signedHeaders, ";"),
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+}
+
+// requestSignature returns the signature of a request whose x-amz-date and
+// x-amz-content-sha256 headers are set.
+func requestSignature(r *http.Request, signedHeaders []string, region string, secretKey string) string {
+	date := r.Header.Get("X-Amz-Date")
+	if len(date) < len(S3_DATE_FORMAT) {
+		return ""
+	}
+	day := date[:len(S3_DATE_FORMAT)]
+	scope := day + "/" + region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + sha256Hex(canonicalRequest(r, signedHeaders))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// signRequest authenticates the request with signature version 4. The
+// payload is left unsigned, it is protected by the checksums of the blocks.
+func signRequest(r *http.Request, region string, accessKey string, secretKey string, t time.Time) {
+	r.Header.Set("X-Amz-Date", t.Format(S3_TIME_FORMAT))
+	r.Header.Set("X-Amz-Content-Sha256", S3_UNSIGNED_PAYLOAD)
+
+	signedHeaders := []string{"host"}
+	for k := range r.Header {
+		lower := strings.ToLower(k)
+		if strings.HasPrefix(lower, "x-amz-") {
+			signedHeaders = append(signedHeaders, lower)
+		}
+	}
+	sort.Strings(signedHeaders)
+
+	scope := t.Format(S3_DATE_FORMAT) + "/" + region + "/s3/aws4_request"
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, strings.Join(signedHeaders, ";"), requestSignature(r, signedHeaders, region, secretKey)))
+}
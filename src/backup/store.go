@@ -0,0 +1,44 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// BackupStore keeps the blobs backups are made of, each with a few string
+// attributes. Names are slash separated paths, a missing blob is reported
+// with ErrNotFound.
+type BackupStore interface {
+	// URL locates the store, NewStore opens it again from it
+	URL() string
+
+	Put(name string, r io.Reader, attrs map[string]string) error
+	Get(name string) (io.ReadCloser, map[string]string, error)
+	Stat(name string) (map[string]string, error)
+	// List returns the names starting with prefix
+	List(prefix string) ([]string, error)
+	Delete(name string) error
+}
+
+// NewStore opens the store of url, a local directory given as a path or a
+// file:// url, or an S3 bucket given as s3://bucket/prefix along with the
+// s3.* options.
+func NewStore(storeURL string, opts map[string]string) (BackupStore, error) {
+	if strings.HasPrefix(storeURL, "/") {
+		return NewLocalStore(storeURL)
+	}
+
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		return NewLocalStore(u.Path)
+	case "s3":
+		return NewS3Store(u.Host, strings.Trim(u.Path, "/"), opts)
+	}
+	return nil, fmt.Errorf("unsupported backup store %s", storeURL)
+}
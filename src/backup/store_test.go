@@ -0,0 +1,220 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testAccessKey = "AKTEST"
+	testSecretKey = "secret"
+)
+
+type stubObject struct {
+	data   []byte
+	header http.Header
+}
+
+// s3Stub serves the few S3 calls the store makes, checking their
+// signatures, and pages listings by pageSize keys.
+type s3Stub struct {
+	sync.Mutex
+	bucket   string
+	objects  map[string]stubObject
+	pageSize int
+}
+
+func (s *s3Stub) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	fields := map[string]string{}
+	for _, f := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		if kv := strings.SplitN(f, "=", 2); len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	if !strings.HasPrefix(fields["Credential"], testAccessKey+"/") {
+		return false
+	}
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	return fields["Signature"] == requestSignature(r, signed, DEFAULT_S3_REGION, testSecretKey)
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.authorized(r) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>bad signature</Message></Error>")
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if parts[0] != s.bucket {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if len(parts) == 1 {
+		s.list(w, r)
+		return
+	}
+
+	key := parts[1]
+	switch r.Method {
+	case "PUT":
+		data, _ := ioutil.ReadAll(r.Body)
+		header := http.Header{}
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, S3_META_PREFIX) {
+				header[k] = v
+			}
+		}
+		s.objects[key] = stubObject{data: data, header: header}
+	case "GET", "HEAD":
+		o, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, v := range o.header {
+			w.Header()[k] = v
+		}
+		if r.Method == "GET" {
+			w.Write(o.data)
+		}
+	case "DELETE":
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *s3Stub) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	keys := []string{}
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+	result := listBucketResult{}
+	for i := start; i < len(keys) && i < start+s.pageSize; i++ {
+		result.Contents = append(result.Contents, struct{ Key string }{keys[i]})
+	}
+	if start+s.pageSize < len(keys) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(start + s.pageSize)
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+func testStore(t *testing.T, store BackupStore) {
+	if _, err := store.Stat("blocks/missing"); err != ErrNotFound {
+		t.Fatalf("missing blob should be not found, got %v", err)
+	}
+	if err := store.Delete("blocks/missing"); err != ErrNotFound {
+		t.Fatalf("deleting a missing blob should be not found, got %v", err)
+	}
+
+	names := []string{"backups/a b.json", "backups/b.json", "blocks/00/c.blk"}
+	for _, name := range names {
+		if err := store.Put(name, strings.NewReader("data of "+name), map[string]string{"volume": "vol001"}); err != nil {
+			t.Fatalf("put %s: %v", name, err)
+		}
+	}
+
+	r, attrs, err := store.Get("backups/a b.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	if !bytes.Equal(data, []byte("data of backups/a b.json")) || attrs["volume"] != "vol001" {
+		t.Fatalf("unexpected blob %q %v", data, attrs)
+	}
+
+	listed, err := store.List("backups/")
+	if err != nil || len(listed) != 2 || listed[0] != names[0] || listed[1] != names[1] {
+		t.Fatalf("unexpected listing %v %v", listed, err)
+	}
+	if err := store.Delete(names[0]); err != nil {
+		t.Fatal(err)
+	}
+	if listed, _ := store.List(""); len(listed) != 2 {
+		t.Fatalf("deleted blob should not be listed, got %v", listed)
+	}
+
+	reopened, err := NewStore(store.URL(), map[string]string{
+		S3_ENDPOINT:   endpointOf(store),
+		S3_ACCESS_KEY: testAccessKey,
+		S3_SECRET_KEY: testSecretKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Stat(names[2]); err != nil {
+		t.Fatalf("store reopened from its url should see the blobs: %v", err)
+	}
+}
+
+func endpointOf(store BackupStore) string {
+	if s, ok := store.(*s3Store); ok {
+		return s.endpoint.String()
+	}
+	return ""
+}
+
+func TestLocalStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := NewStore("relative/dir", nil); err == nil {
+		t.Fatal("relative directory should be refused")
+	}
+	store, err := NewStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+}
+
+func TestS3Store(t *testing.T) {
+	stub := &s3Stub{bucket: "bucket", objects: map[string]stubObject{}, pageSize: 1}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	opts := map[string]string{S3_ENDPOINT: server.URL, S3_ACCESS_KEY: testAccessKey, S3_SECRET_KEY: testSecretKey}
+	store, err := NewStore("s3://bucket/cluster1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+	for key := range stub.objects {
+		if !strings.HasPrefix(key, "cluster1/") {
+			t.Fatalf("object %s stored out of the prefix", key)
+		}
+	}
+
+	opts[S3_SECRET_KEY] = "wrong"
+	bad, _ := NewStore("s3://bucket/cluster1", opts)
+	if _, err := bad.Stat("backups/b.json"); err == nil || err == ErrNotFound {
+		t.Fatalf("request with a wrong key should be refused, got %v", err)
+	}
+	if err := bad.Put("x", strings.NewReader("x"), nil); err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("error document should be reported, got %v", err)
+	}
+}
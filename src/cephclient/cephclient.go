@@ -1,23 +1,69 @@
 package cephclient
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ceph/go-ceph/rados"
 	"github.com/ceph/go-ceph/rbd"
 )
 
-type CephClient struct {
+const (
+	DEFAULT_MAX_OPEN_IMAGES = 64
+	DEFAULT_IDLE_TIMEOUT    = 5 * time.Minute
+	DEFAULT_OP_TIMEOUT      = 30 * time.Second
+)
+
+// ClientOptions tunes the handles a client keeps, zero values take the
+// defaults.
+type ClientOptions struct {
+	// images open at once, operations wait for one to be unused beyond
+	MaxOpenImages int
+	// pools and images unused for that long are closed
+	IdleTimeout time.Duration
+	// a read not done by then fails with ErrTimeout, librados ends a change
+	// about then
+	OpTimeout time.Duration
+}
+
+// radosClient implements CephClient over librados and librbd. It is safe
+// for concurrent use: pool and image handles are shared by the operations
+// using them and closed when idle, a broken connection is replaced by the
+// next operation.
+type radosClient struct {
 	monHost string
 	monPort int
-	conn    *rados.Conn
-	ioctxs  map[string]*rados.IOContext
-	images  map[string]map[string]*rbd.Image
+	opts    ClientOptions
+
+	lock    sync.Mutex
+	current *connection
+	gen     int
+	closed  bool
+
+	pools  *handleCache
+	images *handleCache
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// connection is a rados connection and the operations and handles using
+// it, a dead one is shut down by its last user.
+type connection struct {
+	conn *rados.Conn
+	gen  int
+	refs int
+	dead bool
 }
 
 /*
 func NewCephClient(Host string, port int) (*CephClient, error) {
-	var cephclient = &CephClient{monHost: Host, monPort: port}
+	var cephclient = &radosClient{monHost: Host, monPort: port}
 	cephclient.ioctxs = make(map[string]*rados.IOContext)
 	cephclient.images = make(map[string]map[string]*rbd.Image)
 	err := cephclient.connect()
@@ -29,7 +75,7 @@ func NewCephClient(Host string, port int) (*CephClient, error) {
 }
 */
 
-func NewCephClient(args ...interface{}) (*CephClient, error) {
+func NewCephClient(args ...interface{}) (CephClient, error) {
 	if (len(args) != 0) && (len(args) != 2) {
 		return nil, fmt.Errorf("input parameter number is error")
 	}
@@ -49,315 +95,708 @@ func NewCephClient(args ...interface{}) (*CephClient, error) {
 			return nil, fmt.Errorf("input parameter type is error")
 		}
 	}
-	var cephclient *CephClient = nil
-	if (host != "") && (port != -1) {
-		cephclient = &CephClient{monHost: host, monPort: port}
-	} else {
-		cephclient = &CephClient{}
+	if (host == "") || (port == -1) {
+		host, port = "", 0
 	}
-	cephclient.ioctxs = make(map[string]*rados.IOContext)
-	cephclient.images = make(map[string]map[string]*rbd.Image)
-	err := cephclient.connect()
+	return NewCephClientWithOptions(host, port, ClientOptions{})
+}
+
+// NewCephClientWithOptions connects to the cluster of the monitor, an empty
+// host uses the monitors of the default config file.
+func NewCephClientWithOptions(host string, port int, opts ClientOptions) (CephClient, error) {
+	if opts.MaxOpenImages <= 0 {
+		opts.MaxOpenImages = DEFAULT_MAX_OPEN_IMAGES
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DEFAULT_IDLE_TIMEOUT
+	}
+	if opts.OpTimeout <= 0 {
+		opts.OpTimeout = DEFAULT_OP_TIMEOUT
+	}
+
+	cc := &radosClient{
+		monHost: host,
+		monPort: port,
+		opts:    opts,
+		pools:   newHandleCache(0),
+		images:  newHandleCache(opts.MaxOpenImages),
+		stop:    make(chan struct{}),
+	}
+	c, err := cc.acquireConn()
 	if err != nil {
 		return nil, err
 	}
-	return cephclient, nil
+	cc.releaseConn(c)
 
+	cc.wg.Add(1)
+	go cc.evictIdle()
+	return cc, nil
 }
 
-func (cc *CephClient) connect() error {
+func (cc *radosClient) connect() (*rados.Conn, error) {
 	conn, err := rados.NewConn()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	conn.ReadDefaultConfigFile()
 
 	if cc.monHost != "" {
 		monAddr := fmt.Sprintf("%s:%d", cc.monHost, cc.monPort)
-		fmt.Println(monAddr)
 		conn.SetConfigOption("mon_host", monAddr)
 	}
 	conn.SetConfigOption("client_mount_timeout", "5")
+	// librados gives up about when the caller does
+	timeout := strconv.Itoa(int(cc.opts.OpTimeout / time.Second))
+	conn.SetConfigOption("rados_osd_op_timeout", timeout)
+	conn.SetConfigOption("rados_mon_op_timeout", timeout)
 	err = conn.Connect()
 	if err != nil {
 		conn.Shutdown()
-		return err
+		return nil, err
 	}
-	cc.conn = conn
-	return nil
+	return conn, nil
 }
 
-func (cc *CephClient) Destroy() {
-	for pool, _ := range cc.ioctxs {
-		cc.destroyPool(pool)
-	}
-	cc.conn.Shutdown()
-	cc.conn = nil
-}
+func (cc *radosClient) acquireConn() (*connection, error) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
 
-func (cc *CephClient) ListPools() ([]string, error) {
-	if cc.conn == nil {
-		return nil, fmt.Errorf("can not connect to monitor node")
+	if cc.closed {
+		return nil, ErrClosed
 	}
-	pools, err := cc.conn.ListPools()
-	if err != nil {
-		return nil, err
-	} else {
-		return pools, nil
+	if cc.current == nil {
+		conn, err := cc.connect()
+		if err != nil {
+			return nil, fmt.Errorf("can not connect to monitor node: %s", err.Error())
+		}
+		cc.gen++
+		cc.current = &connection{conn: conn, gen: cc.gen}
 	}
+	cc.current.refs++
+	return cc.current, nil
 }
 
-func (cc *CephClient) CreatePool(name string) error {
-	err := cc.conn.MakePool(name)
-	if err != nil {
-		return err
+func (cc *radosClient) releaseConn(c *connection) {
+	cc.lock.Lock()
+	c.refs--
+	shutdown := c.dead && c.refs == 0
+	cc.lock.Unlock()
+
+	if shutdown {
+		c.conn.Shutdown()
 	}
-	return nil
 }
 
-func (cc *CephClient) DeletePool(name string) error {
-	cc.destroyPool(name)
-	err := cc.conn.DeletePool(name)
-	if err != nil {
-		return err
+// retire drops a broken connection, the next operation connects again.
+// The handles opened through it are closed as soon as they are unused,
+// the connection itself by its last user.
+func (cc *radosClient) retire(c *connection) {
+	cc.lock.Lock()
+	if c.dead {
+		cc.lock.Unlock()
+		return
 	}
-	return nil
+	c.dead = true
+	if cc.current == c {
+		cc.current = nil
+	}
+	c.refs++
+	cc.lock.Unlock()
+
+	prefix := strconv.Itoa(c.gen) + "/"
+	match := func(key string) bool { return strings.HasPrefix(key, prefix) }
+	cc.images.drop(match)
+	cc.pools.drop(match)
+	cc.releaseConn(c)
 }
 
-func (cc *CephClient) openPool(name string) (*rados.IOContext, error) {
-	ioctx, ok := cc.ioctxs[name]
-	if ok {
-		return ioctx, nil
+func (cc *radosClient) Destroy() {
+	cc.lock.Lock()
+	if cc.closed {
+		cc.lock.Unlock()
+		return
 	}
+	cc.closed = true
+	c := cc.current
+	cc.lock.Unlock()
 
-	ioctx, err := cc.conn.OpenIOContext(name)
-	if err != nil {
-		return nil, err
+	close(cc.stop)
+	cc.wg.Wait()
+
+	all := func(key string) bool { return true }
+	cc.images.drop(all)
+	cc.pools.drop(all)
+	if c != nil {
+		cc.retire(c)
 	}
-	cc.ioctxs[name] = ioctx
-	return ioctx, nil
 }
 
-func (cc *CephClient) destroyPool(name string) {
-	cc.closeImages(name)
-	ioctx, ok := cc.ioctxs[name]
-	if !ok {
-		return
-	} else {
-		ioctx.Destroy()
-		delete(cc.ioctxs, name)
-		return
+func (cc *radosClient) evictIdle() {
+	defer cc.wg.Done()
+
+	ticker := time.NewTicker(cc.opts.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cc.stop:
+			return
+		case <-ticker.C:
+			cc.images.evictIdle(cc.opts.IdleTimeout)
+			cc.pools.evictIdle(cc.opts.IdleTimeout)
+		}
 	}
 }
 
-func (cc *CephClient) ListImageNames(poolName string) ([]string, error) {
-	ioctx, err := cc.openPool(poolName)
-	if err != nil {
-		return nil, err
+// do runs a read on the current connection within the operation timeout,
+// its value only reaches the caller through the result of the run. A read
+// failing because the connection broke is run once more on a new one.
+func (cc *radosClient) do(op func(c *connection, deadline time.Time) (interface{}, error)) (interface{}, error) {
+	value, err := cc.timed(op)
+	if c, ok := err.(*connError); ok {
+		cc.retire(c.conn)
+		value, err = cc.timed(op)
 	}
-	imageNames, err := rbd.GetImageNames(ioctx)
-	if err != nil {
-		return nil, err
-	} else {
-		return imageNames, nil
+	if c, ok := err.(*connError); ok {
+		cc.retire(c.conn)
+		err = c.err
 	}
+	return value, translateError(err)
 }
 
-// size单位为M
-func (cc *CephClient) CreateImage(poolName string, imageName string,
-	size uint64, order int) error {
-	ioctx, err := cc.openPool(poolName)
-	if err != nil {
-		return err
-	}
-	size = size << 20
-	var features uint64 = 1
-	_, err = rbd.Create(ioctx, imageName, size, order, features)
-	if err != nil {
-		return err
+// mutate runs a change of the cluster until librados tells its outcome,
+// a change left running may still be applied after the caller gave up.
+// It is run once more on a new connection only when the connection was
+// down, one timing out may have been applied and fails with ErrInDoubt.
+func (cc *radosClient) mutate(op func(c *connection, deadline time.Time) error) error {
+	err := cc.run(op)
+	if c, ok := err.(*connError); ok && !isErrno(c.err, errnoETIMEDOUT) {
+		cc.retire(c.conn)
+		err = cc.run(op)
+	}
+	if c, ok := err.(*connError); ok {
+		cc.retire(c.conn)
+		err = c.err
+		if isErrno(err, errnoETIMEDOUT) {
+			return ErrInDoubt
+		}
 	}
-	return nil
+	return translateError(err)
 }
 
-func (cc *CephClient) DeleteImage(poolName string, imageName string) error {
-	image, err := cc.openImage(poolName, imageName)
-	if err != nil {
-		return err
-	}
-	cc.closeImage(poolName, imageName)
-	err = image.Remove()
-	if err != nil {
-		return err
-	}
-	return nil
+// connError marks the errors telling that the connection is unusable.
+type connError struct {
+	conn *connection
+	err  error
 }
 
-func (cc *CephClient) openImage(poolName string, imageName string) (*rbd.Image, error) {
-	images, ok := cc.images[poolName]
-	if ok {
-		image, ok := images[imageName]
-		if ok {
-			return image, nil
+func (e *connError) Error() string {
+	return e.err.Error()
+}
+
+func isErrno(err error, errnos ...int) bool {
+	var errno int
+	switch e := err.(type) {
+	case rbd.RBDError:
+		errno = -int(e)
+	case rados.RadosError:
+		errno = -int(e)
+	default:
+		return false
+	}
+	for _, n := range errnos {
+		if errno == n {
+			return true
 		}
 	}
-	ioctx, err := cc.openPool(poolName)
-	if err != nil {
-		return nil, err
-	}
-	img := rbd.GetImage(ioctx, imageName)
-	err = img.Open()
+	return false
+}
+
+func isConnErrno(err error) bool {
+	return isErrno(err, errnoENOTCONN, errnoESHUTDOWN, errnoETIMEDOUT)
+}
+
+// run runs op on the current connection, handles are waited for until the
+// operation timeout.
+func (cc *radosClient) run(op func(c *connection, deadline time.Time) error) error {
+	c, err := cc.acquireConn()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer cc.releaseConn(c)
 
-	images, ok = cc.images[poolName]
-	if ok {
-		images[imageName] = img
-	} else {
-		images = map[string]*rbd.Image{imageName: img}
-		cc.images[poolName] = images
+	err = op(c, time.Now().Add(cc.opts.OpTimeout))
+	if isConnErrno(err) {
+		err = &connError{conn: c, err: err}
 	}
-	return img, nil
+	return err
 }
 
-func (cc *CephClient) closeImage(poolName string, imageName string) {
-	images, ok := cc.images[poolName]
-	if ok {
-		image, ok := images[imageName]
-		if ok {
-			image.Close()
-			delete(images, imageName)
-			if len(images) == 0 {
-				delete(cc.images, poolName)
-			}
-			return
-		}
-	}
+// opResult is what a read sends back, the caller shares nothing with a
+// read it gave up on.
+type opResult struct {
+	value interface{}
+	err   error
 }
 
-func (cc *CephClient) closeImages(poolName string) {
-	images, ok := cc.images[poolName]
-	if ok {
-		for _, image := range images {
-			image.Close()
-		}
-		delete(cc.images, poolName)
-		return
+func (cc *radosClient) timed(op func(c *connection, deadline time.Time) (interface{}, error)) (interface{}, error) {
+	done := make(chan opResult, 1)
+	go func() {
+		var value interface{}
+		err := cc.run(func(c *connection, deadline time.Time) error {
+			var err error
+			value, err = op(c, deadline)
+			return err
+		})
+		done <- opResult{value: value, err: err}
+	}()
+
+	timer := time.NewTimer(cc.opts.OpTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-timer.C:
+		return nil, ErrTimeout
 	}
 }
 
-func (cc *CephClient) ImageStat(poolName string, imageName string) (map[string]uint64, error) {
-	image, err := cc.openImage(poolName, imageName)
-	if err != nil {
-		return nil, err
+func poolKey(c *connection, poolName string) string {
+	return strconv.Itoa(c.gen) + "/" + poolName
+}
+
+func imageKey(c *connection, poolName string, imageName string) string {
+	return strconv.Itoa(c.gen) + "/" + poolName + "/" + imageName
+}
+
+// matchPool and matchImage find the handles of a pool or an image opened
+// through any connection.
+func matchPool(poolName string) func(key string) bool {
+	return func(key string) bool {
+		parts := strings.SplitN(key, "/", 3)
+		return len(parts) >= 2 && parts[1] == poolName
 	}
-	imginfo, err := image.Stat()
-	if err != nil {
-		return nil, err
-	} else {
-		imgInfoMap := make(map[string]uint64, 2)
-		imgInfoMap["size"] = imginfo.Size >> 20
-		imgInfoMap["obj_size"] = imginfo.Obj_size >> 20
-		return imgInfoMap, nil
+}
+
+func matchImage(poolName string, imageName string) func(key string) bool {
+	return func(key string) bool {
+		parts := strings.SplitN(key, "/", 3)
+		return len(parts) == 3 && parts[1] == poolName && parts[2] == imageName
 	}
 }
 
-func (cc *CephClient) CreateSnapshot(poolName string, imageName string, snapName string) error {
-	image, err := cc.openImage(poolName, imageName)
+func (cc *radosClient) acquirePool(c *connection, poolName string, deadline time.Time) (*handle, error) {
+	return cc.pools.acquire(poolKey(c, poolName), deadline, func() (interface{}, func(), error) {
+		ioctx, err := c.conn.OpenIOContext(poolName)
+		if err != nil {
+			return nil, nil, err
+		}
+		cc.lock.Lock()
+		c.refs++
+		cc.lock.Unlock()
+		return ioctx, func() {
+			ioctx.Destroy()
+			cc.releaseConn(c)
+		}, nil
+	})
+}
+
+func (cc *radosClient) withPool(c *connection, poolName string, deadline time.Time, f func(ioctx *rados.IOContext) error) error {
+	h, err := cc.acquirePool(c, poolName, deadline)
 	if err != nil {
 		return err
 	}
-	_, err = image.CreateSnapshot(snapName)
+	defer cc.pools.release(h)
+	return f(h.value.(*rados.IOContext))
+}
+
+// withImage runs f on the open image, an image keeps the handle of its
+// pool while open.
+func (cc *radosClient) withImage(c *connection, poolName string, imageName string, deadline time.Time, f func(image *rbd.Image) error) error {
+	h, err := cc.images.acquire(imageKey(c, poolName, imageName), deadline, func() (interface{}, func(), error) {
+		ph, err := cc.acquirePool(c, poolName, deadline)
+		if err != nil {
+			return nil, nil, err
+		}
+		image := rbd.GetImage(ph.value.(*rados.IOContext), imageName)
+		if err := image.Open(); err != nil {
+			cc.pools.release(ph)
+			return nil, nil, err
+		}
+		return image, func() {
+			image.Close()
+			cc.pools.release(ph)
+		}, nil
+	})
 	if err != nil {
 		return err
 	}
-	return nil
+	defer cc.images.release(h)
+	return f(h.value.(*rbd.Image))
 }
 
-func (cc *CephClient) getSnapshot(poolName string, imageName string, snapName string) (*rbd.Snapshot, error) {
-	image, err := cc.openImage(poolName, imageName)
+func (cc *radosClient) ListPools() ([]string, error) {
+	pools, err := cc.do(func(c *connection, deadline time.Time) (interface{}, error) {
+		return c.conn.ListPools()
+	})
 	if err != nil {
 		return nil, err
 	}
-	snapshot := image.GetSnapshot(snapName)
-	return snapshot, nil
+	return pools.([]string), nil
 }
 
-func (cc *CephClient) RemoveSnapshot(poolName string, imageName string, snapName string) error {
-	snapshot, err := cc.getSnapshot(poolName, imageName, snapName)
-	if err != nil {
-		return err
-	}
-	err = snapshot.Remove()
-	if err != nil {
-		return err
+func (cc *radosClient) CreatePool(name string) error {
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return c.conn.MakePool(name)
+	})
+}
+
+// DeletePool fails with ErrBusy while an operation uses the pool.
+func (cc *radosClient) DeletePool(name string) error {
+	if cc.images.inUse(matchPool(name)) || cc.pools.inUse(matchPool(name)) {
+		return ErrBusy
 	}
-	return nil
+	cc.images.drop(matchPool(name))
+	cc.pools.drop(matchPool(name))
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return c.conn.DeletePool(name)
+	})
 }
 
-func (cc *CephClient) Rollback(poolName string, imageName string, snapName string) error {
-	snapshot, err := cc.getSnapshot(poolName, imageName, snapName)
+func (cc *radosClient) ClusterStats() (ClusterStat, error) {
+	result, err := cc.do(func(c *connection, deadline time.Time) (interface{}, error) {
+		stat, err := c.conn.GetClusterStats()
+		if err != nil {
+			return nil, err
+		}
+		return ClusterStat{
+			Total: stat.Kb >> 10,
+			Used:  stat.Kb_used >> 10,
+			Avail: stat.Kb_avail >> 10,
+		}, nil
+	})
 	if err != nil {
-		return err
+		return ClusterStat{}, err
 	}
-	err = snapshot.Rollback()
+	return result.(ClusterStat), nil
+}
+
+// poolDF is what the df command of the monitors tells of the pools,
+// max_avail in bytes.
+type poolDF struct {
+	Pools []struct {
+		Name  string `json:"name"`
+		Stats struct {
+			MaxAvail uint64 `json:"max_avail"`
+		} `json:"stats"`
+	} `json:"pools"`
+}
+
+// PoolStats asks the monitors for what the pool can still take, the raw
+// space left in the cluster is shared by the pools and their copies.
+func (cc *radosClient) PoolStats(poolName string) (PoolStat, error) {
+	result, err := cc.do(func(c *connection, deadline time.Time) (interface{}, error) {
+		var result PoolStat
+		err := cc.withPool(c, poolName, deadline, func(ioctx *rados.IOContext) error {
+			stat, err := ioctx.GetPoolStats()
+			if err != nil {
+				return err
+			}
+			result = PoolStat{Used: stat.Num_kb >> 10, Objects: stat.Num_objects}
+
+			data, _, err := c.conn.MonCommand([]byte(`{"prefix": "df", "format": "json"}`))
+			if err != nil {
+				return err
+			}
+			df := poolDF{}
+			if err := json.Unmarshal(data, &df); err != nil {
+				return err
+			}
+			for _, pool := range df.Pools {
+				if pool.Name == poolName {
+					result.MaxAvail = pool.Stats.MaxAvail >> 20
+				}
+			}
+			return nil
+		})
+		return result, err
+	})
 	if err != nil {
-		return err
+		return PoolStat{}, err
 	}
-	return nil
+	return result.(PoolStat), nil
 }
 
-func (cc *CephClient) GetSnapshotNames(poolName string, imageName string) ([]string, error) {
-	image, err := cc.openImage(poolName, imageName)
+func (cc *radosClient) ListImageNames(poolName string) ([]string, error) {
+	imageNames, err := cc.do(func(c *connection, deadline time.Time) (interface{}, error) {
+		var imageNames []string
+		err := cc.withPool(c, poolName, deadline, func(ioctx *rados.IOContext) error {
+			var err error
+			imageNames, err = rbd.GetImageNames(ioctx)
+			return err
+		})
+		return imageNames, err
+	})
 	if err != nil {
 		return nil, err
 	}
-	snapInfo, err := image.GetSnapshotNames()
+	return imageNames.([]string), nil
+}
+
+// size单位为M
+func (cc *radosClient) CreateImage(poolName string, imageName string,
+	size uint64, order int) error {
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withPool(c, poolName, deadline, func(ioctx *rados.IOContext) error {
+			var features uint64 = 1
+			_, err := rbd.Create(ioctx, imageName, size<<20, order, features)
+			return err
+		})
+	})
+}
+
+// DeleteImage fails with ErrBusy while an operation uses the image.
+func (cc *radosClient) DeleteImage(poolName string, imageName string) error {
+	if cc.images.inUse(matchImage(poolName, imageName)) {
+		return ErrBusy
+	}
+	cc.images.drop(matchImage(poolName, imageName))
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withPool(c, poolName, deadline, func(ioctx *rados.IOContext) error {
+			return rbd.GetImage(ioctx, imageName).Remove()
+		})
+	})
+}
+
+func (cc *radosClient) ImageStat(poolName string, imageName string) (map[string]uint64, error) {
+	imgInfoMap, err := cc.do(func(c *connection, deadline time.Time) (interface{}, error) {
+		var imgInfoMap map[string]uint64
+		err := cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			imginfo, err := image.Stat()
+			if err != nil {
+				return err
+			}
+			imgInfoMap = make(map[string]uint64, 2)
+			imgInfoMap["size"] = imginfo.Size >> 20
+			imgInfoMap["obj_size"] = imginfo.Obj_size >> 20
+			return nil
+		})
+		return imgInfoMap, err
+	})
 	if err != nil {
 		return nil, err
 	}
-	fmt.Printf("%v", snapInfo)
-	snapNames := make([]string, len(snapInfo))
-	for index, snap := range snapInfo {
-		snapNames[index] = snap.Name
-	}
-	return snapNames, nil
+	return imgInfoMap.(map[string]uint64), nil
 }
 
-func (cc *CephClient) Backup(poolName string, imageName string, snapName string, destImageName string) error {
+func (cc *radosClient) CreateSnapshot(poolName string, imageName string, snapName string) error {
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			_, err := image.CreateSnapshot(snapName)
+			return err
+		})
+	})
+}
 
-	ioctx, err := cc.openPool(poolName)
-	if err != nil {
-		return err
-	}
-	image, err := cc.openImage(poolName, imageName)
-	if err != nil {
-		return err
-	}
-	snapshot, err := cc.getSnapshot(poolName, imageName, snapName)
-	if err != nil {
-		return err
-	}
-	err = snapshot.Protect()
+// RemoveSnapshot unprotects the snapshot first, it fails while clones of
+// it are not flattened.
+func (cc *radosClient) RemoveSnapshot(poolName string, imageName string, snapName string) error {
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			snapshot := image.GetSnapshot(snapName)
+			protected, err := snapshot.IsProtected()
+			if err != nil {
+				return err
+			}
+			if protected {
+				if err := snapshot.Unprotect(); err != nil {
+					return err
+				}
+			}
+			return snapshot.Remove()
+		})
+	})
+}
+
+func (cc *radosClient) Rollback(poolName string, imageName string, snapName string) error {
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			return image.GetSnapshot(snapName).Rollback()
+		})
+	})
+}
+
+func (cc *radosClient) GetSnapshotNames(poolName string, imageName string) ([]string, error) {
+	snapNames, err := cc.do(func(c *connection, deadline time.Time) (interface{}, error) {
+		var snapNames []string
+		err := cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			snapInfo, err := image.GetSnapshotNames()
+			if err != nil {
+				return err
+			}
+			snapNames = make([]string, len(snapInfo))
+			for index, snap := range snapInfo {
+				snapNames[index] = snap.Name
+			}
+			return nil
+		})
+		return snapNames, err
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer snapshot.Unprotect()
+	return snapNames.([]string), nil
+}
 
-	var features uint64 = 1
-	var order int = 22
+// ResizeImage sets the size of the image in MB, shrinking drops the data
+// past the new end.
+func (cc *radosClient) ResizeImage(poolName string, imageName string, size uint64) error {
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			return image.Resize(size << 20)
+		})
+	})
+}
 
-	_, err = image.Clone(snapName, ioctx, destImageName, features, order)
+// ReadImage reads at most len(data) bytes at offset, less at the end of the
+// image. A read given up on keeps its own buffer, data is only filled once
+// it is done.
+func (cc *radosClient) ReadImage(poolName string, imageName string, data []byte, offset uint64) (int, error) {
+	read, err := cc.do(func(c *connection, deadline time.Time) (interface{}, error) {
+		buf := make([]byte, len(data))
+		var n int
+		err := cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			var err error
+			n, err = image.ReadAt(buf, int64(offset))
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		})
+		return buf[:n], err
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
-	destImage, err := cc.openImage(poolName, destImageName)
-	if err != nil {
+	return copy(data, read.([]byte)), nil
+}
+
+func (cc *radosClient) WriteImage(poolName string, imageName string, data []byte, offset uint64) error {
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			_, err := image.WriteAt(data, int64(offset))
+			return err
+		})
+	})
+}
+
+// CloneImage creates destImageName in the same pool as a copy on write
+// child of the snapshot, which gets protected.
+func (cc *radosClient) CloneImage(poolName string, imageName string, snapName string, destImageName string) error {
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withPool(c, poolName, deadline, func(ioctx *rados.IOContext) error {
+			return cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+				snapshot := image.GetSnapshot(snapName)
+				protected, err := snapshot.IsProtected()
+				if err != nil {
+					return err
+				}
+				if !protected {
+					if err := snapshot.Protect(); err != nil {
+						return err
+					}
+				}
+
+				var features uint64 = 1
+				var order int = 22
+				_, err = image.Clone(snapName, ioctx, destImageName, features, order)
+				return err
+			})
+		})
+	})
+}
+
+// FlattenImage copies the data a clone shares with its parent snapshot,
+// the clone no longer depends on it afterwards.
+func (cc *radosClient) FlattenImage(poolName string, imageName string) error {
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			return image.Flatten()
+		})
+	})
+}
+
+// Backup leaves a flattened copy of the snapshot, the copy is closed as it
+// is not used by the client afterwards.
+func (cc *radosClient) Backup(poolName string, imageName string, snapName string, destImageName string) error {
+	if err := cc.CloneImage(poolName, imageName, snapName, destImageName); err != nil {
 		return err
 	}
-	err = destImage.Flatten()
+	err := cc.FlattenImage(poolName, destImageName)
+	cc.images.drop(matchImage(poolName, destImageName))
 	if err != nil {
 		return err
 	}
+	// other clones of the snapshot keep it protected
+	cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			return image.GetSnapshot(snapName).Unprotect()
+		})
+	})
 	return nil
 }
+
+func (cc *radosClient) LockExclusive(poolName string, imageName string, cookie string) error {
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			return image.LockExclusive(cookie)
+		})
+	})
+}
+
+func (cc *radosClient) LockShared(poolName string, imageName string, cookie string, tag string) error {
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			return image.LockShared(cookie, tag)
+		})
+	})
+}
+
+// Unlock only releases locks taken through this connection, BreakLock is
+// needed for the ones of another client.
+func (cc *radosClient) Unlock(poolName string, imageName string, cookie string) error {
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			return image.Unlock(cookie)
+		})
+	})
+}
+
+// ListLockers returns the tag of the shared lock, empty for an exclusive
+// one, and every client holding a lock on the image.
+func (cc *radosClient) ListLockers(poolName string, imageName string) (string, []Locker, error) {
+	type lockList struct {
+		tag     string
+		lockers []Locker
+	}
+	list, err := cc.do(func(c *connection, deadline time.Time) (interface{}, error) {
+		list := lockList{}
+		err := cc.withPool(c, poolName, deadline, func(ioctx *rados.IOContext) error {
+			var err error
+			list.tag, list.lockers, err = listLockers(ioctx, imageName)
+			return err
+		})
+		return list, err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return list.(lockList).tag, list.(lockList).lockers, nil
+}
+
+func (cc *radosClient) BreakLock(poolName string, imageName string, client string, cookie string) error {
+	return cc.mutate(func(c *connection, deadline time.Time) error {
+		return cc.withImage(c, poolName, imageName, deadline, func(image *rbd.Image) error {
+			return image.BreakLock(client, cookie)
+		})
+	})
+}
@@ -0,0 +1,65 @@
+package cephclient
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	QUOTA_MAX_BYTES = "ceph.quota.max_bytes"
+)
+
+// CephFSClient is what policy knows of a ceph filesystem, paths are
+// absolute in the filesystem and quotas are in MB.
+type CephFSClient interface {
+	Destroy()
+
+	MakeDir(path string) error
+	RemoveDir(path string) error
+	SetQuota(path string, size uint64) error
+}
+
+// mountClient implements CephFSClient over libcephfs.
+type mountClient struct {
+	mount *cephMount
+}
+
+func NewCephFSClient(host string, port int) (CephFSClient, error) {
+	mount, err := createMount()
+	if err != nil {
+		return nil, translateError(err)
+	}
+	mount.ReadDefaultConfigFile()
+
+	if host != "" {
+		monAddr := fmt.Sprintf("%s:%d", host, port)
+		if err := mount.SetConfigOption("mon_host", monAddr); err != nil {
+			mount.Release()
+			return nil, translateError(err)
+		}
+	}
+	if err := mount.Mount(); err != nil {
+		mount.Release()
+		return nil, fmt.Errorf("can not mount cephfs of monitor %s:%d: %s", host, port, err.Error())
+	}
+	return &mountClient{mount: mount}, nil
+}
+
+func (mc *mountClient) Destroy() {
+	mc.mount.Unmount()
+	mc.mount.Release()
+}
+
+func (mc *mountClient) MakeDir(path string) error {
+	return translateError(mc.mount.MakeDir(path, 0755))
+}
+
+func (mc *mountClient) RemoveDir(path string) error {
+	return translateError(mc.mount.RemoveDir(path))
+}
+
+// SetQuota limits the bytes below path, a size of 0 removes the limit.
+func (mc *mountClient) SetQuota(path string, size uint64) error {
+	value := strconv.FormatUint(size<<20, 10)
+	return translateError(mc.mount.SetXattr(path, QUOTA_MAX_BYTES, []byte(value), 0))
+}
@@ -0,0 +1,121 @@
+package cephclient
+
+import (
+	"errors"
+
+	"github.com/ceph/go-ceph/cephfs"
+	"github.com/ceph/go-ceph/rados"
+	"github.com/ceph/go-ceph/rbd"
+)
+
+// CephClient is what the rest of policy knows of a ceph cluster. Sizes are
+// in MB and the errors below are returned for the conditions they name, so
+// callers and the fake behave the same.
+type CephClient interface {
+	Destroy()
+
+	ListPools() ([]string, error)
+	CreatePool(name string) error
+	DeletePool(name string) error
+	ClusterStats() (ClusterStat, error)
+	PoolStats(poolName string) (PoolStat, error)
+
+	ListImageNames(poolName string) ([]string, error)
+	CreateImage(poolName string, imageName string, size uint64, order int) error
+	DeleteImage(poolName string, imageName string) error
+	ImageStat(poolName string, imageName string) (map[string]uint64, error)
+	ResizeImage(poolName string, imageName string, size uint64) error
+	// offsets and lengths of the data of images are in bytes
+	ReadImage(poolName string, imageName string, data []byte, offset uint64) (int, error)
+	WriteImage(poolName string, imageName string, data []byte, offset uint64) error
+
+	CreateSnapshot(poolName string, imageName string, snapName string) error
+	RemoveSnapshot(poolName string, imageName string, snapName string) error
+	Rollback(poolName string, imageName string, snapName string) error
+	GetSnapshotNames(poolName string, imageName string) ([]string, error)
+
+	CloneImage(poolName string, imageName string, snapName string, destImageName string) error
+	FlattenImage(poolName string, imageName string) error
+	Backup(poolName string, imageName string, snapName string, destImageName string) error
+
+	LockExclusive(poolName string, imageName string, cookie string) error
+	LockShared(poolName string, imageName string, cookie string, tag string) error
+	Unlock(poolName string, imageName string, cookie string) error
+	ListLockers(poolName string, imageName string) (string, []Locker, error)
+	BreakLock(poolName string, imageName string, client string, cookie string) error
+}
+
+// ClusterStat is the raw capacity of the cluster in MB, replicas included.
+type ClusterStat struct {
+	Total uint64
+	Used  uint64
+	Avail uint64
+}
+
+// PoolStat is the space used by a pool in MB, and what it can still take
+// once its copies are accounted for.
+type PoolStat struct {
+	Used     uint64
+	Objects  uint64
+	MaxAvail uint64
+}
+
+type Locker struct {
+	Client    string
+	Cookie    string
+	Addr      string
+	Exclusive bool
+}
+
+var (
+	ErrNotFound = errors.New("ceph: no such pool, image, snapshot, lock or directory")
+	ErrExist    = errors.New("ceph: already exists")
+	ErrBusy     = errors.New("ceph: in use")
+	ErrTimeout  = errors.New("ceph: operation timed out")
+	ErrClosed   = errors.New("ceph: client is destroyed")
+	// a change that timed out may still have been applied
+	ErrInDoubt = errors.New("ceph: operation timed out, it may have been applied")
+)
+
+const (
+	errnoENOENT    = 2
+	errnoEBUSY     = 16
+	errnoEEXIST    = 17
+	errnoENOTEMPTY = 39
+	errnoENOTCONN  = 107
+	errnoESHUTDOWN = 108
+	errnoETIMEDOUT = 110
+)
+
+// translateError maps librados, librbd and libcephfs return codes to the
+// errors of the package, anything else is passed through.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var errno int
+	switch e := err.(type) {
+	case rbd.RBDError:
+		errno = -int(e)
+	case rados.RadosError:
+		errno = -int(e)
+	case cephfs.CephError:
+		errno = -int(e)
+	default:
+		if err == rbd.RbdErrorNotFound {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	switch errno {
+	case errnoENOENT:
+		return ErrNotFound
+	case errnoEEXIST:
+		return ErrExist
+	case errnoEBUSY, errnoENOTEMPTY:
+		return ErrBusy
+	}
+	return err
+}
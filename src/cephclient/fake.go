@@ -0,0 +1,665 @@
+package cephclient
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FakeCluster keeps pools, images, snapshots and locks in memory for unit
+// tests. Every Connect gives a client of its own, the way each daemon is
+// its own rados client, so locks taken by different hosts can be told apart.
+type FakeCluster struct {
+	lock     sync.Mutex
+	pools    map[string]map[string]*fakeImage
+	clients  int
+	capacity uint64
+	// copies kept of the pools, 1 when not set
+	sizes map[string]uint64
+}
+
+type fakeSnap struct {
+	name     string
+	size     uint64
+	children int
+}
+
+type fakeImage struct {
+	size  uint64
+	order int
+	snaps []*fakeSnap
+	// what was written, the rest of the image reads as zeroes
+	data []byte
+
+	parentImage string
+	parentSnap  string
+
+	tag     string
+	lockers []Locker
+}
+
+type fakeClient struct {
+	cluster *FakeCluster
+	name    string
+	addr    string
+	closed  bool
+}
+
+func NewFakeCluster() *FakeCluster {
+	return &FakeCluster{pools: make(map[string]map[string]*fakeImage), sizes: make(map[string]uint64)}
+}
+
+// SetCapacity sets the raw capacity of the cluster in MB, images use their
+// full size of it.
+func (fc *FakeCluster) SetCapacity(capacity uint64) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.capacity = capacity
+}
+
+// SetPoolSize sets the copies kept of the data of a pool, its images use
+// that many times their size of the raw capacity.
+func (fc *FakeCluster) SetPoolSize(name string, size uint64) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.sizes[name] = size
+}
+
+func (fc *FakeCluster) poolSize(name string) uint64 {
+	if size, ok := fc.sizes[name]; ok && size > 0 {
+		return size
+	}
+	return 1
+}
+
+// avail is the raw capacity left, the caller holds the lock.
+func (fc *FakeCluster) avail() uint64 {
+	used := uint64(0)
+	for name, pool := range fc.pools {
+		used += poolUsed(pool) * fc.poolSize(name)
+	}
+	if fc.capacity > used {
+		return fc.capacity - used
+	}
+	return 0
+}
+
+func (fc *FakeCluster) Connect() CephClient {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fc.clients++
+	return &fakeClient{
+		cluster: fc,
+		name:    fmt.Sprintf("client.%d", fc.clients),
+		addr:    fmt.Sprintf("10.0.0.%d:0/%d", fc.clients, fc.clients),
+	}
+}
+
+func (img *fakeImage) snap(name string) *fakeSnap {
+	for _, s := range img.snaps {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// enter locks the cluster for one call, the caller unlocks it.
+func (c *fakeClient) enter() error {
+	c.cluster.lock.Lock()
+	if c.closed {
+		c.cluster.lock.Unlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+func (c *fakeClient) leave() {
+	c.cluster.lock.Unlock()
+}
+
+func (c *fakeClient) image(poolName string, imageName string) (*fakeImage, error) {
+	pool, ok := c.cluster.pools[poolName]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	img, ok := pool[imageName]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return img, nil
+}
+
+func (c *fakeClient) Destroy() {
+	c.cluster.lock.Lock()
+	defer c.cluster.lock.Unlock()
+	c.closed = true
+}
+
+func (c *fakeClient) ListPools() ([]string, error) {
+	if err := c.enter(); err != nil {
+		return nil, err
+	}
+	defer c.leave()
+
+	pools := []string{}
+	for name := range c.cluster.pools {
+		pools = append(pools, name)
+	}
+	sort.Strings(pools)
+	return pools, nil
+}
+
+func (c *fakeClient) CreatePool(name string) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	if _, ok := c.cluster.pools[name]; ok {
+		return ErrExist
+	}
+	c.cluster.pools[name] = make(map[string]*fakeImage)
+	return nil
+}
+
+func (c *fakeClient) DeletePool(name string) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	if _, ok := c.cluster.pools[name]; !ok {
+		return ErrNotFound
+	}
+	delete(c.cluster.pools, name)
+	return nil
+}
+
+func poolUsed(pool map[string]*fakeImage) uint64 {
+	used := uint64(0)
+	for _, img := range pool {
+		used += img.size
+	}
+	return used
+}
+
+func (c *fakeClient) ClusterStats() (ClusterStat, error) {
+	if err := c.enter(); err != nil {
+		return ClusterStat{}, err
+	}
+	defer c.leave()
+
+	avail := c.cluster.avail()
+	return ClusterStat{Total: c.cluster.capacity, Used: c.cluster.capacity - avail, Avail: avail}, nil
+}
+
+func (c *fakeClient) PoolStats(poolName string) (PoolStat, error) {
+	if err := c.enter(); err != nil {
+		return PoolStat{}, err
+	}
+	defer c.leave()
+
+	pool, ok := c.cluster.pools[poolName]
+	if !ok {
+		return PoolStat{}, ErrNotFound
+	}
+	return PoolStat{
+		Used:     poolUsed(pool),
+		Objects:  uint64(len(pool)),
+		MaxAvail: c.cluster.avail() / c.cluster.poolSize(poolName),
+	}, nil
+}
+
+func (c *fakeClient) ListImageNames(poolName string) ([]string, error) {
+	if err := c.enter(); err != nil {
+		return nil, err
+	}
+	defer c.leave()
+
+	pool, ok := c.cluster.pools[poolName]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	names := []string{}
+	for name := range pool {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (c *fakeClient) CreateImage(poolName string, imageName string, size uint64, order int) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	pool, ok := c.cluster.pools[poolName]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := pool[imageName]; ok {
+		return ErrExist
+	}
+	pool[imageName] = &fakeImage{size: size, order: order}
+	return nil
+}
+
+func (c *fakeClient) DeleteImage(poolName string, imageName string) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return err
+	}
+	if len(img.snaps) != 0 {
+		return ErrBusy
+	}
+	c.unparent(poolName, img)
+	delete(c.cluster.pools[poolName], imageName)
+	return nil
+}
+
+func (c *fakeClient) unparent(poolName string, img *fakeImage) {
+	if img.parentImage == "" {
+		return
+	}
+	if parent, err := c.image(poolName, img.parentImage); err == nil {
+		if s := parent.snap(img.parentSnap); s != nil {
+			s.children--
+		}
+	}
+	img.parentImage, img.parentSnap = "", ""
+}
+
+func (c *fakeClient) ImageStat(poolName string, imageName string) (map[string]uint64, error) {
+	if err := c.enter(); err != nil {
+		return nil, err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]uint64{
+		"size":     img.size,
+		"obj_size": uint64(1<<uint(img.order)) >> 20,
+	}, nil
+}
+
+func (c *fakeClient) ResizeImage(poolName string, imageName string, size uint64) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return err
+	}
+	img.size = size
+	return nil
+}
+
+func (c *fakeClient) ReadImage(poolName string, imageName string, data []byte, offset uint64) (int, error) {
+	if err := c.enter(); err != nil {
+		return 0, err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return 0, err
+	}
+	size := img.size << 20
+	if offset >= size {
+		return 0, nil
+	}
+	n := len(data)
+	if uint64(n) > size-offset {
+		n = int(size - offset)
+	}
+	for i := 0; i < n; i++ {
+		data[i] = 0
+	}
+	if offset < uint64(len(img.data)) {
+		copy(data[:n], img.data[offset:])
+	}
+	return n, nil
+}
+
+func (c *fakeClient) WriteImage(poolName string, imageName string, data []byte, offset uint64) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return err
+	}
+	end := offset + uint64(len(data))
+	if end > img.size<<20 {
+		return fmt.Errorf("write past the end of image %s/%s", poolName, imageName)
+	}
+	if end > uint64(len(img.data)) {
+		img.data = append(img.data, make([]byte, end-uint64(len(img.data)))...)
+	}
+	copy(img.data[offset:], data)
+	return nil
+}
+
+func (c *fakeClient) CreateSnapshot(poolName string, imageName string, snapName string) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return err
+	}
+	if img.snap(snapName) != nil {
+		return ErrExist
+	}
+	img.snaps = append(img.snaps, &fakeSnap{name: snapName, size: img.size})
+	return nil
+}
+
+func (c *fakeClient) RemoveSnapshot(poolName string, imageName string, snapName string) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return err
+	}
+	s := img.snap(snapName)
+	if s == nil {
+		return ErrNotFound
+	}
+	if s.children != 0 {
+		return ErrBusy
+	}
+	snaps := []*fakeSnap{}
+	for _, other := range img.snaps {
+		if other != s {
+			snaps = append(snaps, other)
+		}
+	}
+	img.snaps = snaps
+	return nil
+}
+
+func (c *fakeClient) Rollback(poolName string, imageName string, snapName string) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return err
+	}
+	s := img.snap(snapName)
+	if s == nil {
+		return ErrNotFound
+	}
+	img.size = s.size
+	return nil
+}
+
+func (c *fakeClient) GetSnapshotNames(poolName string, imageName string) ([]string, error) {
+	if err := c.enter(); err != nil {
+		return nil, err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(img.snaps))
+	for i, s := range img.snaps {
+		names[i] = s.name
+	}
+	return names, nil
+}
+
+func (c *fakeClient) CloneImage(poolName string, imageName string, snapName string, destImageName string) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return err
+	}
+	s := img.snap(snapName)
+	if s == nil {
+		return ErrNotFound
+	}
+	pool := c.cluster.pools[poolName]
+	if _, ok := pool[destImageName]; ok {
+		return ErrExist
+	}
+
+	s.children++
+	pool[destImageName] = &fakeImage{
+		size:        s.size,
+		order:       img.order,
+		parentImage: imageName,
+		parentSnap:  snapName,
+	}
+	return nil
+}
+
+func (c *fakeClient) FlattenImage(poolName string, imageName string) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return err
+	}
+	c.unparent(poolName, img)
+	return nil
+}
+
+func (c *fakeClient) Backup(poolName string, imageName string, snapName string, destImageName string) error {
+	if err := c.CloneImage(poolName, imageName, snapName, destImageName); err != nil {
+		return err
+	}
+	return c.FlattenImage(poolName, destImageName)
+}
+
+func (c *fakeClient) lockImage(poolName string, imageName string, cookie string, tag string, exclusive bool) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return err
+	}
+	for _, l := range img.lockers {
+		if l.Client == c.name && l.Cookie == cookie {
+			return ErrExist
+		}
+	}
+	if len(img.lockers) != 0 && (exclusive || img.lockers[0].Exclusive || img.tag != tag) {
+		return ErrBusy
+	}
+
+	img.tag = tag
+	img.lockers = append(img.lockers, Locker{Client: c.name, Cookie: cookie, Addr: c.addr, Exclusive: exclusive})
+	return nil
+}
+
+func (c *fakeClient) LockExclusive(poolName string, imageName string, cookie string) error {
+	return c.lockImage(poolName, imageName, cookie, "", true)
+}
+
+func (c *fakeClient) LockShared(poolName string, imageName string, cookie string, tag string) error {
+	return c.lockImage(poolName, imageName, cookie, tag, false)
+}
+
+func (c *fakeClient) unlockImage(poolName string, imageName string, client string, cookie string) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return err
+	}
+	lockers := []Locker{}
+	for _, l := range img.lockers {
+		if l.Client != client || l.Cookie != cookie {
+			lockers = append(lockers, l)
+		}
+	}
+	if len(lockers) == len(img.lockers) {
+		return ErrNotFound
+	}
+	img.lockers = lockers
+	if len(lockers) == 0 {
+		img.tag = ""
+	}
+	return nil
+}
+
+func (c *fakeClient) Unlock(poolName string, imageName string, cookie string) error {
+	return c.unlockImage(poolName, imageName, c.name, cookie)
+}
+
+func (c *fakeClient) BreakLock(poolName string, imageName string, client string, cookie string) error {
+	return c.unlockImage(poolName, imageName, client, cookie)
+}
+
+func (c *fakeClient) ListLockers(poolName string, imageName string) (string, []Locker, error) {
+	if err := c.enter(); err != nil {
+		return "", nil, err
+	}
+	defer c.leave()
+
+	img, err := c.image(poolName, imageName)
+	if err != nil {
+		return "", nil, err
+	}
+	lockers := make([]Locker, len(img.lockers))
+	copy(lockers, img.lockers)
+	return img.tag, lockers, nil
+}
+
+// FakeFilesystem keeps the directories of a ceph filesystem and their
+// quotas in memory for unit tests, all clients share it.
+type FakeFilesystem struct {
+	lock   sync.Mutex
+	quotas map[string]uint64 // path : quota in MB
+}
+
+type fakeFSClient struct {
+	fs     *FakeFilesystem
+	closed bool
+}
+
+func NewFakeFilesystem() *FakeFilesystem {
+	return &FakeFilesystem{quotas: map[string]uint64{"/": 0}}
+}
+
+func (fs *FakeFilesystem) Connect() CephFSClient {
+	return &fakeFSClient{fs: fs}
+}
+
+// Quota reports the quota of path and whether the directory exists.
+func (fs *FakeFilesystem) Quota(path string) (uint64, bool) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	quota, ok := fs.quotas[path]
+	return quota, ok
+}
+
+func (c *fakeFSClient) enter() error {
+	c.fs.lock.Lock()
+	if c.closed {
+		c.fs.lock.Unlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+func (c *fakeFSClient) leave() {
+	c.fs.lock.Unlock()
+}
+
+func (c *fakeFSClient) Destroy() {
+	c.fs.lock.Lock()
+	defer c.fs.lock.Unlock()
+	c.closed = true
+}
+
+func (c *fakeFSClient) MakeDir(path string) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	path = filepath.Clean(path)
+	if _, ok := c.fs.quotas[path]; ok {
+		return ErrExist
+	}
+	if _, ok := c.fs.quotas[filepath.Dir(path)]; !ok {
+		return ErrNotFound
+	}
+	c.fs.quotas[path] = 0
+	return nil
+}
+
+func (c *fakeFSClient) RemoveDir(path string) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	path = filepath.Clean(path)
+	if _, ok := c.fs.quotas[path]; !ok {
+		return ErrNotFound
+	}
+	for other := range c.fs.quotas {
+		if strings.HasPrefix(other, path+"/") {
+			return ErrBusy
+		}
+	}
+	delete(c.fs.quotas, path)
+	return nil
+}
+
+func (c *fakeFSClient) SetQuota(path string, size uint64) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.leave()
+
+	path = filepath.Clean(path)
+	if _, ok := c.fs.quotas[path]; !ok {
+		return ErrNotFound
+	}
+	c.fs.quotas[path] = size
+	return nil
+}
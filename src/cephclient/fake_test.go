@@ -0,0 +1,145 @@
+package cephclient
+
+import (
+	"testing"
+)
+
+func TestFakeImages(t *testing.T) {
+	c := NewFakeCluster().Connect()
+	defer c.Destroy()
+
+	if err := c.CreateImage("rbd", "img", 100, 22); err != ErrNotFound {
+		t.Fatalf("image in a missing pool should be not found, got %v", err)
+	}
+	if err := c.CreatePool("rbd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreatePool("rbd"); err != ErrExist {
+		t.Fatalf("duplicate pool should exist, got %v", err)
+	}
+	if err := c.CreateImage("rbd", "img", 100, 22); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateImage("rbd", "img", 100, 22); err != ErrExist {
+		t.Fatalf("duplicate image should exist, got %v", err)
+	}
+	if err := c.ResizeImage("rbd", "img", 200); err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := c.ImageStat("rbd", "img"); err != nil || stat["size"] != 200 {
+		t.Fatalf("image should be resized, got %v %v", stat, err)
+	}
+
+	if err := c.CreateSnapshot("rbd", "img", "snap"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteImage("rbd", "img"); err != ErrBusy {
+		t.Fatalf("image with snapshots should be busy, got %v", err)
+	}
+	if err := c.CloneImage("rbd", "img", "snap", "clone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveSnapshot("rbd", "img", "snap"); err != ErrBusy {
+		t.Fatalf("snapshot with children should be busy, got %v", err)
+	}
+	if err := c.FlattenImage("rbd", "clone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveSnapshot("rbd", "img", "snap"); err != nil {
+		t.Fatalf("flattened clone should free the snapshot: %v", err)
+	}
+	if err := c.RemoveSnapshot("rbd", "img", "snap"); err != ErrNotFound {
+		t.Fatalf("removed snapshot should be not found, got %v", err)
+	}
+	if names, _ := c.ListImageNames("rbd"); len(names) != 2 || names[0] != "clone" || names[1] != "img" {
+		t.Fatalf("unexpected images %v", names)
+	}
+	if err := c.DeleteImage("rbd", "img"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ImageStat("rbd", "img"); err != ErrNotFound {
+		t.Fatalf("deleted image should be not found, got %v", err)
+	}
+}
+
+func TestFakeLocks(t *testing.T) {
+	cluster := NewFakeCluster()
+	a, b := cluster.Connect(), cluster.Connect()
+	if err := a.CreatePool("rbd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.CreateImage("rbd", "img", 100, 22); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.LockExclusive("rbd", "img", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.LockExclusive("rbd", "img", "a"); err != ErrExist {
+		t.Fatalf("relock by the holder should exist, got %v", err)
+	}
+	if err := b.LockShared("rbd", "img", "b", "ro"); err != ErrBusy {
+		t.Fatalf("lock over an exclusive one should be busy, got %v", err)
+	}
+	if err := b.Unlock("rbd", "img", "a"); err != ErrNotFound {
+		t.Fatalf("unlock of another client's lock should be not found, got %v", err)
+	}
+	if err := a.Unlock("rbd", "img", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.LockShared("rbd", "img", "a", "ro"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.LockShared("rbd", "img", "b", "ro"); err != nil {
+		t.Fatalf("shared locks with one tag should coexist: %v", err)
+	}
+	if err := b.LockExclusive("rbd", "img", "c"); err != ErrBusy {
+		t.Fatalf("exclusive lock over shared ones should be busy, got %v", err)
+	}
+	tag, lockers, err := a.ListLockers("rbd", "img")
+	if err != nil || tag != "ro" || len(lockers) != 2 {
+		t.Fatalf("unexpected lockers %s %v %v", tag, lockers, err)
+	}
+	if err := a.BreakLock("rbd", "img", lockers[1].Client, lockers[1].Cookie); err != nil {
+		t.Fatal(err)
+	}
+	if _, lockers, _ := b.ListLockers("rbd", "img"); len(lockers) != 1 || lockers[0].Cookie != "a" {
+		t.Fatalf("break should drop only b's lock, got %v", lockers)
+	}
+
+	b.Destroy()
+	if _, err := b.ListPools(); err == nil {
+		t.Fatal("destroyed client should fail")
+	}
+}
+
+func TestFakeFilesystem(t *testing.T) {
+	fs := NewFakeFilesystem()
+	c := fs.Connect()
+
+	if err := c.MakeDir("/volumes/vol"); err != ErrNotFound {
+		t.Fatalf("directory without parent should be not found, got %v", err)
+	}
+	if err := c.MakeDir("/volumes"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.MakeDir("/volumes/vol"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetQuota("/volumes/vol", 100); err != nil {
+		t.Fatal(err)
+	}
+	if quota, ok := fs.Quota("/volumes/vol"); !ok || quota != 100 {
+		t.Fatalf("unexpected quota %d %v", quota, ok)
+	}
+	if err := c.RemoveDir("/volumes"); err != ErrBusy {
+		t.Fatalf("non empty directory should be busy, got %v", err)
+	}
+	if err := c.RemoveDir("/volumes/vol"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetQuota("/volumes/vol", 100); err != ErrNotFound {
+		t.Fatalf("removed directory should be not found, got %v", err)
+	}
+}
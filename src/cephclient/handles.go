@@ -0,0 +1,232 @@
+package cephclient
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// handleCache keeps open librados and librbd handles by key. A handle is
+// shared by every operation using it at the same time, it is closed once
+// it has been unused for too long, or earlier to make room when the number
+// of open handles is bounded.
+type handleCache struct {
+	lock    sync.Mutex
+	max     int // 0 for no bound
+	handles map[string]*handle
+	unused  *list.List    // unused handles, least recently used first
+	wake    chan struct{} // closed when a handle becomes unused
+}
+
+type handle struct {
+	key   string
+	value interface{}
+	close func()
+	err   error
+	ready chan struct{} // closed once opened or failed
+
+	refs     int
+	lastUsed time.Time
+	elem     *list.Element
+	dropped  bool // closed by its last user
+}
+
+func newHandleCache(max int) *handleCache {
+	return &handleCache{
+		max:     max,
+		handles: make(map[string]*handle),
+		unused:  list.New(),
+		wake:    make(chan struct{}),
+	}
+}
+
+// acquire returns the handle of key, opened by open unless it already is.
+// When the cache is full it waits until deadline for a handle to become
+// unused.
+func (hc *handleCache) acquire(key string, deadline time.Time, open func() (interface{}, func(), error)) (*handle, error) {
+	hc.lock.Lock()
+	for {
+		if h, ok := hc.handles[key]; ok {
+			hc.use(h)
+			hc.lock.Unlock()
+			return hc.wait(h, deadline)
+		}
+		if hc.max == 0 || len(hc.handles) < hc.max {
+			break
+		}
+		if front := hc.unused.Front(); front != nil {
+			h := front.Value.(*handle)
+			hc.remove(h)
+			hc.lock.Unlock()
+			h.close()
+			hc.lock.Lock()
+			continue
+		}
+
+		wake := hc.wake
+		hc.lock.Unlock()
+		if err := waitUntil(wake, deadline); err != nil {
+			return nil, err
+		}
+		hc.lock.Lock()
+	}
+
+	h := &handle{key: key, refs: 1, ready: make(chan struct{})}
+	hc.handles[key] = h
+	hc.lock.Unlock()
+
+	value, closer, err := open()
+
+	hc.lock.Lock()
+	h.value, h.close, h.err = value, closer, err
+	close(h.ready)
+	if err != nil {
+		// the ones waiting on it see the error and release it
+		h.refs--
+		if hc.handles[key] == h {
+			delete(hc.handles, key)
+		}
+		hc.notify()
+	}
+	hc.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (hc *handleCache) wait(h *handle, deadline time.Time) (*handle, error) {
+	err := waitUntil(h.ready, deadline)
+	if err == nil {
+		err = h.err
+	}
+	if err != nil {
+		hc.release(h)
+		return nil, err
+	}
+	return h, nil
+}
+
+func waitUntil(c chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-c
+		return nil
+	}
+	timer := time.NewTimer(deadline.Sub(time.Now()))
+	defer timer.Stop()
+	select {
+	case <-c:
+		return nil
+	case <-timer.C:
+		return ErrTimeout
+	}
+}
+
+func (hc *handleCache) use(h *handle) {
+	if h.elem != nil {
+		hc.unused.Remove(h.elem)
+		h.elem = nil
+	}
+	h.refs++
+}
+
+func (hc *handleCache) remove(h *handle) {
+	if h.elem != nil {
+		hc.unused.Remove(h.elem)
+		h.elem = nil
+	}
+	if hc.handles[h.key] == h {
+		delete(hc.handles, h.key)
+	}
+}
+
+func (hc *handleCache) notify() {
+	close(hc.wake)
+	hc.wake = make(chan struct{})
+}
+
+func (hc *handleCache) release(h *handle) {
+	hc.lock.Lock()
+	h.refs--
+	if h.refs > 0 || h.err != nil {
+		hc.lock.Unlock()
+		return
+	}
+	h.lastUsed = time.Now()
+	closeNow := h.dropped
+	if closeNow {
+		hc.remove(h)
+	} else {
+		h.elem = hc.unused.PushBack(h)
+	}
+	hc.notify()
+	hc.lock.Unlock()
+
+	if closeNow {
+		h.close()
+	}
+}
+
+// inUse tells whether a handle matching match is used by an operation.
+func (hc *handleCache) inUse(match func(key string) bool) bool {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	for key, h := range hc.handles {
+		if match(key) && h.refs > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// drop forgets the handles matching match, unused ones are closed at once
+// and the others by their last user.
+func (hc *handleCache) drop(match func(key string) bool) {
+	hc.lock.Lock()
+	closers := []func(){}
+	for key, h := range hc.handles {
+		if !match(key) {
+			continue
+		}
+		if h.refs == 0 {
+			closers = append(closers, h.close)
+			hc.remove(h)
+		} else {
+			h.dropped = true
+			delete(hc.handles, key)
+		}
+	}
+	hc.notify()
+	hc.lock.Unlock()
+
+	for _, c := range closers {
+		c()
+	}
+}
+
+// evictIdle closes the handles unused since before the idle timeout.
+func (hc *handleCache) evictIdle(timeout time.Duration) {
+	limit := time.Now().Add(-timeout)
+	hc.lock.Lock()
+	closers := []func(){}
+	for front := hc.unused.Front(); front != nil; front = hc.unused.Front() {
+		h := front.Value.(*handle)
+		if h.lastUsed.After(limit) {
+			break
+		}
+		closers = append(closers, h.close)
+		hc.remove(h)
+	}
+	hc.lock.Unlock()
+
+	for _, c := range closers {
+		c()
+	}
+}
+
+func (hc *handleCache) open() int {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	return len(hc.handles)
+}
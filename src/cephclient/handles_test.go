@@ -0,0 +1,154 @@
+package cephclient
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// opener counts the handles it opened and closed.
+type opener struct {
+	lock   sync.Mutex
+	opened int
+	closed int
+}
+
+func (o *opener) open() (interface{}, func(), error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.opened++
+	return o.opened, func() {
+		o.lock.Lock()
+		defer o.lock.Unlock()
+		o.closed++
+	}, nil
+}
+
+func (o *opener) counts() (int, int) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.opened, o.closed
+}
+
+func TestHandleSharing(t *testing.T) {
+	hc := newHandleCache(0)
+	o := &opener{}
+
+	a, err := hc.acquire("img", time.Time{}, o.open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := hc.acquire("img", time.Time{}, o.open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Fatal("concurrent users should share the handle")
+	}
+	hc.release(a)
+	hc.release(b)
+	if opened, closed := o.counts(); opened != 1 || closed != 0 {
+		t.Fatalf("unused handle should stay open, got %d opened %d closed", opened, closed)
+	}
+
+	c, _ := hc.acquire("img", time.Time{}, o.open)
+	hc.release(c)
+	if opened, _ := o.counts(); opened != 1 {
+		t.Fatalf("unused handle should be reused, got %d opened", opened)
+	}
+}
+
+func TestHandleBound(t *testing.T) {
+	hc := newHandleCache(2)
+	o := &opener{}
+
+	a, _ := hc.acquire("a", time.Time{}, o.open)
+	b, _ := hc.acquire("b", time.Time{}, o.open)
+	if _, err := hc.acquire("c", time.Now().Add(20*time.Millisecond), o.open); err != ErrTimeout {
+		t.Fatalf("full cache of used handles should time out, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		h, err := hc.acquire("c", time.Now().Add(time.Second), o.open)
+		if err == nil {
+			hc.release(h)
+		}
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	hc.release(a)
+	if err := <-done; err != nil {
+		t.Fatalf("waiter should get the room of the released handle: %v", err)
+	}
+	if opened, closed := o.counts(); opened != 3 || closed != 1 {
+		t.Fatalf("least recently used handle should be evicted, got %d opened %d closed", opened, closed)
+	}
+	if hc.open() != 2 {
+		t.Fatalf("cache should stay bounded, got %d", hc.open())
+	}
+	hc.release(b)
+}
+
+func TestHandleIdle(t *testing.T) {
+	hc := newHandleCache(0)
+	o := &opener{}
+
+	a, _ := hc.acquire("a", time.Time{}, o.open)
+	b, _ := hc.acquire("b", time.Time{}, o.open)
+	hc.release(a)
+	time.Sleep(20 * time.Millisecond)
+	hc.evictIdle(10 * time.Millisecond)
+	if _, closed := o.counts(); closed != 1 {
+		t.Fatalf("only the idle handle should be closed, got %d", closed)
+	}
+	hc.release(b)
+	hc.evictIdle(time.Hour)
+	if hc.open() != 1 {
+		t.Fatalf("recently used handle should stay open, got %d", hc.open())
+	}
+}
+
+func TestHandleDrop(t *testing.T) {
+	hc := newHandleCache(0)
+	o := &opener{}
+
+	a, _ := hc.acquire("img", time.Time{}, o.open)
+	if !hc.inUse(func(key string) bool { return key == "img" }) {
+		t.Fatal("acquired handle should be in use")
+	}
+	hc.drop(func(key string) bool { return true })
+	if _, closed := o.counts(); closed != 0 {
+		t.Fatal("dropped handle should stay open while used")
+	}
+	b, _ := hc.acquire("img", time.Time{}, o.open)
+	if a == b {
+		t.Fatal("dropped handle should not be reused")
+	}
+	hc.release(a)
+	if _, closed := o.counts(); closed != 1 {
+		t.Fatalf("dropped handle should be closed by its last user, got %d", closed)
+	}
+	hc.release(b)
+}
+
+func TestHandleOpenError(t *testing.T) {
+	hc := newHandleCache(1)
+	failure := errors.New("open failed")
+	_, err := hc.acquire("img", time.Time{}, func() (interface{}, func(), error) {
+		return nil, nil, failure
+	})
+	if err != failure {
+		t.Fatalf("open error should be returned, got %v", err)
+	}
+	if hc.open() != 0 {
+		t.Fatal("failed handle should not take room")
+	}
+	o := &opener{}
+	h, err := hc.acquire("img", time.Now().Add(time.Second), o.open)
+	if err != nil {
+		t.Fatalf("failed open should be retried: %v", err)
+	}
+	hc.release(h)
+}
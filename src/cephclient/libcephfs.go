@@ -0,0 +1,84 @@
+package cephclient
+
+/*
+#cgo LDFLAGS: -lcephfs
+#cgo CPPFLAGS: -D_FILE_OFFSET_BITS=64
+#include <stdlib.h>
+#include <cephfs/libcephfs.h>
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	"github.com/ceph/go-ceph/cephfs"
+)
+
+// cephMount is a mount of libcephfs, bound here as the cephfs package of
+// go-ceph can't remove directories, set options or xattrs, nor unmount.
+type cephMount struct {
+	mount *C.struct_ceph_mount_info
+}
+
+func cephfsError(ret C.int) error {
+	if ret == 0 {
+		return nil
+	}
+	return cephfs.CephError(ret)
+}
+
+func createMount() (*cephMount, error) {
+	m := &cephMount{}
+	if ret := C.ceph_create(&m.mount, nil); ret != 0 {
+		return nil, cephfs.CephError(ret)
+	}
+	return m, nil
+}
+
+func (m *cephMount) ReadDefaultConfigFile() error {
+	return cephfsError(C.ceph_conf_read_file(m.mount, nil))
+}
+
+func (m *cephMount) SetConfigOption(option string, value string) error {
+	c_option, c_value := C.CString(option), C.CString(value)
+	defer C.free(unsafe.Pointer(c_option))
+	defer C.free(unsafe.Pointer(c_value))
+	return cephfsError(C.ceph_conf_set(m.mount, c_option, c_value))
+}
+
+func (m *cephMount) Mount() error {
+	return cephfsError(C.ceph_mount(m.mount, nil))
+}
+
+func (m *cephMount) MakeDir(path string, mode uint32) error {
+	c_path := C.CString(path)
+	defer C.free(unsafe.Pointer(c_path))
+	return cephfsError(C.ceph_mkdir(m.mount, c_path, C.mode_t(mode)))
+}
+
+func (m *cephMount) RemoveDir(path string) error {
+	c_path := C.CString(path)
+	defer C.free(unsafe.Pointer(c_path))
+	return cephfsError(C.ceph_rmdir(m.mount, c_path))
+}
+
+func (m *cephMount) SetXattr(path string, name string, value []byte, flags int) error {
+	c_path, c_name := C.CString(path), C.CString(name)
+	defer C.free(unsafe.Pointer(c_path))
+	defer C.free(unsafe.Pointer(c_name))
+
+	var c_value unsafe.Pointer
+	if len(value) > 0 {
+		c_value = C.CBytes(value)
+		defer C.free(c_value)
+	}
+	return cephfsError(C.ceph_setxattr(m.mount, c_path, c_name, c_value, C.size_t(len(value)), C.int(flags)))
+}
+
+func (m *cephMount) Unmount() error {
+	return cephfsError(C.ceph_unmount(m.mount))
+}
+
+func (m *cephMount) Release() error {
+	return cephfsError(C.ceph_release(m.mount))
+}
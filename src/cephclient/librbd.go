@@ -0,0 +1,90 @@
+package cephclient
+
+// #cgo LDFLAGS: -lrbd
+// #include <errno.h>
+// #include <stdlib.h>
+// #include <rados/librados.h>
+// #include <rbd/librbd.h>
+import "C"
+
+import (
+	"bytes"
+	"errors"
+	"unsafe"
+
+	"github.com/ceph/go-ceph/rados"
+	"github.com/ceph/go-ceph/rbd"
+)
+
+// The calls of librbd the rbd package of go-ceph lacks, made on the pool
+// handles it shares.
+
+// listLockers lists the locks of the image, go-ceph tells neither whether
+// they are exclusive nor an image without lock apart from an error.
+func listLockers(ioctx *rados.IOContext, imageName string) (string, []Locker, error) {
+	c_name := C.CString(imageName)
+	defer C.free(unsafe.Pointer(c_name))
+
+	// the handle stays owned by ioctx, alive as long as it is
+	pointer := ioctx.Pointer()
+	io := *(*C.rados_ioctx_t)(unsafe.Pointer(&pointer))
+
+	var image C.rbd_image_t
+	ret := C.rbd_open_read_only(io, c_name, &image, nil)
+	if ret != 0 {
+		return "", nil, rbd.RBDError(ret)
+	}
+	defer C.rbd_close(image)
+
+	var c_exclusive C.int
+	var c_tag_len, c_clients_len, c_cookies_len, c_addrs_len C.size_t
+	// the lengths come back with ERANGE, none at all when nothing is locked
+	count := C.rbd_list_lockers(image, &c_exclusive,
+		nil, &c_tag_len,
+		nil, &c_clients_len,
+		nil, &c_cookies_len,
+		nil, &c_addrs_len)
+	if count == 0 {
+		return "", nil, nil
+	}
+	if count != -C.ERANGE {
+		return "", nil, rbd.RBDError(count)
+	}
+
+	tag_buf := make([]byte, c_tag_len+1)
+	clients_buf := make([]byte, c_clients_len+1)
+	cookies_buf := make([]byte, c_cookies_len+1)
+	addrs_buf := make([]byte, c_addrs_len+1)
+	count = C.rbd_list_lockers(image, &c_exclusive,
+		(*C.char)(unsafe.Pointer(&tag_buf[0])), &c_tag_len,
+		(*C.char)(unsafe.Pointer(&clients_buf[0])), &c_clients_len,
+		(*C.char)(unsafe.Pointer(&cookies_buf[0])), &c_cookies_len,
+		(*C.char)(unsafe.Pointer(&addrs_buf[0])), &c_addrs_len)
+	if count < 0 {
+		return "", nil, rbd.RBDError(count)
+	}
+
+	clients := splitNames(clients_buf[:c_clients_len])
+	cookies := splitNames(cookies_buf[:c_cookies_len])
+	addrs := splitNames(addrs_buf[:c_addrs_len])
+	if len(clients) != int(count) || len(cookies) != len(clients) || len(addrs) != len(clients) {
+		return "", nil, errors.New("rbd: lockers list inconsistent")
+	}
+
+	lockers := make([]Locker, len(clients))
+	for i := range clients {
+		lockers[i] = Locker{Client: clients[i], Cookie: cookies[i], Addr: addrs[i], Exclusive: c_exclusive != 0}
+	}
+	return C.GoString((*C.char)(unsafe.Pointer(&tag_buf[0]))), lockers, nil
+}
+
+// splitNames splits the NUL terminated strings librbd lists.
+func splitNames(buf []byte) []string {
+	names := []string{}
+	for _, name := range bytes.Split(buf, []byte{0}) {
+		if len(name) != 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names
+}
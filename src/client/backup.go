@@ -0,0 +1,173 @@
+package client
+
+import (
+	"api"
+	"util"
+
+	"github.com/codegangsta/cli"
+)
+
+var (
+	BackupCmds = cli.Command{
+		Name:  "backup",
+		Usage: "Manage volume backups",
+		Subcommands: []cli.Command{
+			{
+				Name:  "create",
+				Usage: "Back up a volume, only the changes since its last backup unless --full",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "name",
+						Usage: "volume name",
+					},
+					cli.StringFlag{
+						Name:  "driver",
+						Usage: "volume driver",
+					},
+					cli.BoolFlag{
+						Name:  "full",
+						Usage: "back up the whole volume",
+					},
+				},
+				Action: cmdCreateBackup,
+			},
+
+			{
+				Name:  "list",
+				Usage: "List backups",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "name",
+						Usage: "only the backups of this volume",
+					},
+				},
+				Action: cmdListBackup,
+			},
+
+			{
+				Name:  "restore",
+				Usage: "Restore a backup to a new volume",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "backup",
+						Usage: "backup id",
+					},
+					cli.StringFlag{
+						Name:  "name",
+						Usage: "new volume name",
+					},
+				},
+				Action: cmdRestoreBackup,
+			},
+
+			{
+				Name:  "delete",
+				Usage: "Delete a backup, the backups based on it have to be deleted first",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "backup",
+						Usage: "backup id",
+					},
+				},
+				Action: cmdDeleteBackup,
+			},
+		},
+	}
+)
+
+func cmdCreateBackup(c *cli.Context) {
+	if err := doCreateBackup(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doCreateBackup(c *cli.Context) error {
+	var err error
+
+	name, err := util.GetFlag(c, "name", true, err)
+	driverName, err := util.GetFlag(c, "driver", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.BackupCreateRequest{
+		VolumeId:   name,
+		DriverName: driverName,
+		Full:       c.Bool("full"),
+	}
+
+	url := "/backup/create"
+
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdListBackup(c *cli.Context) {
+	if err := doListBackup(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doListBackup(c *cli.Context) error {
+	var err error
+
+	name, err := util.GetFlag(c, "name", false, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.BackupListRequest{
+		VolumeId: name,
+	}
+
+	url := "/backup/list"
+
+	return sendRequestAndPrint("GET", url, request)
+}
+
+func cmdRestoreBackup(c *cli.Context) {
+	if err := doRestoreBackup(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doRestoreBackup(c *cli.Context) error {
+	var err error
+
+	backupid, err := util.GetFlag(c, "backup", true, err)
+	name, err := util.GetFlag(c, "name", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.BackupRestoreRequest{
+		BackupId: backupid,
+		VolumeId: name,
+	}
+
+	url := "/backup/restore"
+
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdDeleteBackup(c *cli.Context) {
+	if err := doDeleteBackup(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doDeleteBackup(c *cli.Context) error {
+	var err error
+
+	backupid, err := util.GetFlag(c, "backup", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.BackupDeleteRequest{
+		BackupId: backupid,
+	}
+
+	url := "/backup/"
+
+	return sendRequestAndPrint("DELETE", url, request)
+}
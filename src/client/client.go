@@ -13,6 +13,7 @@ import (
 
 	"api"
 	"daemon"
+	"util"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
@@ -23,8 +24,17 @@ type policyClient struct {
 	addr      string
 	scheme    string
 	transport *http.Transport
+
+	// key of the first mutating request, given to retry an earlier command
+	idempotencyKey string
 }
 
+const (
+	// mutating requests left without answer are sent again with their key
+	REQUEST_RETRIES        = 3
+	REQUEST_RETRY_INTERVAL = time.Second
+)
+
 /* define the global vars */
 var (
 	verboseFlag = "verbose"
@@ -63,6 +73,9 @@ func (c *policyClient) clientRequest(method, path string, in io.Reader, headers
 	if err != nil {
 		return nil, "", -1, err
 	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
 	req.Header.Set("User-Agent", "Policy-Client/"+api.API_VERSION)
 	req.URL.Host = c.addr
 	req.URL.Scheme = c.scheme
@@ -83,6 +96,9 @@ func (c *policyClient) clientRequest(method, path string, in io.Reader, headers
 		if len(body) == 0 {
 			return nil, "", statusCode, fmt.Errorf("Incompatable version")
 		}
+		if e := decodeError(statusCode, body); e != nil {
+			return nil, "", statusCode, e
+		}
 		return nil, "", statusCode, fmt.Errorf("Error response from server, %v", string(body))
 	}
 	return resp.Body, resp.Header.Get("Context-Type"), statusCode, nil
@@ -113,6 +129,10 @@ func NewCli(version string) *cli.App {
 			Name:  "verbose",
 			Usage: "Verbose level output for client",
 		},
+		cli.StringFlag{
+			Name:  "idempotency-key",
+			Usage: "Key of the request of the command, run it again with the same key to get the first response",
+		},
 	}
 	app.CommandNotFound = cmdNotFound
 	app.Before = initClient
@@ -122,6 +142,13 @@ func NewCli(version string) *cli.App {
 		VolumeCmds,
 		DeviceCmds,
 		HostCmds,
+		ContainerCmds,
+		BackupCmds,
+		EventCmds,
+		OperationCmds,
+		ErrorsCmd,
+		FsckCmd,
+		MetaCmds,
 	}
 	return app
 }
@@ -138,6 +165,7 @@ func initClient(c *cli.Context) error {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 	client.addr = sockFile
+	client.idempotencyKey = c.GlobalString("idempotency-key")
 	client.scheme = "http"
 	client.transport = &http.Transport{
 		DisableCompression: true,
@@ -153,15 +181,40 @@ func sendRequest(method, request string, data interface{}) (io.ReadCloser, error
 	if data != nil {
 		log.Debugf("With data %+v", data)
 	}
-	rc, _, err := client.call(method, request, data, nil)
+	var headers map[string][]string
+	if method != "GET" {
+		headers = map[string][]string{api.IDEMPOTENCY_KEY_HEADER: {client.nextIdempotencyKey()}}
+	}
+	rc, statusCode, err := client.call(method, request, data, headers)
+	// without answer the request may have been run or not, the key makes
+	// sending it again safe
+	for i := 0; err != nil && statusCode == -1 && headers != nil && i < REQUEST_RETRIES; i++ {
+		log.Debugf("Retrying request %v %v: %v", method, request, err)
+		time.Sleep(REQUEST_RETRY_INTERVAL)
+		rc, statusCode, err = client.call(method, request, data, headers)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return rc, nil
 }
 
+// nextIdempotencyKey is the key given to the command for its first
+// mutating request, a new one otherwise.
+func (c *policyClient) nextIdempotencyKey() string {
+	if key := c.idempotencyKey; key != "" {
+		c.idempotencyKey = ""
+		return key
+	}
+	return util.NewUUID()
+}
+
 func sendRequestAndPrint(method, request string, data interface{}) error {
 	rc, err := sendRequest(method, request, data)
+	if e, ok := err.(*Error); ok {
+		fmt.Println(string(e.Body))
+		return nil
+	}
 	if err != nil {
 		fmt.Println("Error: ", err.Error())
 		return nil
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"api"
+	"util"
+
+	"github.com/codegangsta/cli"
+)
+
+var (
+	ContainerCmds = cli.Command{
+		Name:  "container",
+		Usage: "Manage containers",
+		Subcommands: []cli.Command{
+			{
+				Name:  "add",
+				Usage: "add container and attach volumes to it",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "cid",
+						Usage: "container id",
+					},
+					cli.StringSliceFlag{
+						Name:  "volume",
+						Value: &cli.StringSlice{},
+						Usage: "volume name to attach, can be repeated",
+					},
+					cli.StringFlag{
+						Name:  "driver",
+						Usage: "volume driver",
+					},
+					cli.StringFlag{
+						Name:  "mode",
+						Value: "rw",
+						Usage: "mode the volumes are attached with, rw or ro",
+					},
+				},
+				Action: cmdAddContainer,
+			},
+
+			{
+				Name:  "inspect",
+				Usage: "Inspect container volumes and their modes",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "cid",
+						Usage: "container id",
+					},
+				},
+				Action: cmdGetContainer,
+			},
+
+			{
+				Name:   "list",
+				Usage:  "List Container",
+				Action: cmdListContainer,
+			},
+
+			{
+				Name:  "delete",
+				Usage: "Delete Container and detach its volumes",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "cid",
+						Usage: "container id",
+					},
+				},
+				Action: cmdDeleteContainer,
+			},
+		},
+	}
+)
+
+func cmdAddContainer(c *cli.Context) {
+	if err := doAddContainer(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doAddContainer(c *cli.Context) error {
+	var err error
+
+	cid, err := util.GetFlag(c, "cid", true, err)
+	volumes := c.StringSlice("volume")
+	driverName, err := util.GetFlag(c, "driver", len(volumes) != 0, err)
+	mode, err := util.GetFlag(c, "mode", len(volumes) != 0, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.ContainerAddRequest{
+		ContainerId: cid,
+		DriverName:  driverName,
+		Mode:        mode,
+		Volumes:     volumes,
+	}
+
+	url := "/container/add"
+
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdGetContainer(c *cli.Context) {
+	if err := doGetContainer(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doGetContainer(c *cli.Context) error {
+	var err error
+
+	cid, err := util.GetFlag(c, "cid", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.ContainerGetRequest{
+		ContainerId: cid,
+	}
+
+	url := "/container/"
+
+	return sendRequestAndPrint("GET", url, request)
+}
+
+func cmdListContainer(c *cli.Context) {
+	if err := doListContainer(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doListContainer(c *cli.Context) error {
+	request := &api.ContainerListRequest{}
+
+	url := "/container/list"
+
+	return sendRequestAndPrint("GET", url, request)
+}
+
+func cmdDeleteContainer(c *cli.Context) {
+	if err := doDeleteContainer(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doDeleteContainer(c *cli.Context) error {
+	var err error
+
+	cid, err := util.GetFlag(c, "cid", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.ContainerDeleteRequest{
+		ContainerId: cid,
+	}
+
+	url := "/container/"
+
+	return sendRequestAndPrint("DELETE", url, request)
+}
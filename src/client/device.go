@@ -2,6 +2,8 @@ package client
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"api"
 	"util"
@@ -9,6 +11,10 @@ import (
 	"github.com/codegangsta/cli"
 )
 
+const (
+	DRAIN_POLL_INTERVAL = 5 * time.Second
+)
+
 var (
 	DeviceCmds = cli.Command{
 		Name:  "device",
@@ -110,6 +116,46 @@ var (
 				},
 				Action: cmdDeleteDevice,
 			},
+
+			{
+				Name:  "drain",
+				Usage: "put device in maintenance, printing the volumes left on it until there are none",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "device id or unique name",
+					},
+					cli.StringFlag{
+						Name:  "backend",
+						Usage: "device backend storage",
+					},
+					cli.BoolFlag{
+						Name:  "migrate",
+						Usage: "move the volumes to other devices",
+					},
+					cli.BoolFlag{
+						Name:  "no-wait",
+						Usage: "return at once with the volumes left",
+					},
+				},
+				Action: cmdDrainDevice,
+			},
+
+			{
+				Name:  "resume",
+				Usage: "take device out of maintenance",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "device id or unique name",
+					},
+					cli.StringFlag{
+						Name:  "backend",
+						Usage: "device backend storage",
+					},
+				},
+				Action: cmdResumeDevice,
+			},
 		},
 	}
 )
@@ -250,3 +296,78 @@ func doDeleteDevice(c *cli.Context) error {
 
 	return sendRequestAndPrint("DELETE", url, request)
 }
+
+func cmdDrainDevice(c *cli.Context) {
+	if err := doDrainDevice(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doDrainDevice(c *cli.Context) error {
+	var err error
+
+	id, err := util.GetFlag(c, "id", true, err)
+	backend, err := util.GetFlag(c, "backend", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.DeviceDrainRequest{
+		ID:      id,
+		Backend: backend,
+		Migrate: c.Bool("migrate"),
+	}
+
+	url := "/device/drain"
+
+	if c.Bool("no-wait") {
+		return sendRequestAndPrint("POST", url, request)
+	}
+	return waitDrain(url, request)
+}
+
+// waitDrain drains again until no volume is left, volumes that could not
+// be moved before, being in use, are retried each time.
+func waitDrain(url string, request interface{}) error {
+	for {
+		resp := &api.DrainResponse{}
+		if err := sendRequestAndDecode("POST", url, request, resp); err != nil {
+			return err
+		}
+		if resp.Result != "0" || len(resp.Volumes) == 0 {
+			data, err := api.ResponseOutput(*resp)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		fmt.Printf("%s: %d volumes left: %s\n", strings.Join(resp.Devices, ","), len(resp.Volumes), strings.Join(resp.Volumes, ","))
+		time.Sleep(DRAIN_POLL_INTERVAL)
+	}
+}
+
+func cmdResumeDevice(c *cli.Context) {
+	if err := doResumeDevice(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doResumeDevice(c *cli.Context) error {
+	var err error
+
+	id, err := util.GetFlag(c, "id", true, err)
+	backend, err := util.GetFlag(c, "backend", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.DeviceDrainRequest{
+		ID:      id,
+		Backend: backend,
+	}
+
+	url := "/device/resume"
+
+	return sendRequestAndPrint("POST", url, request)
+}
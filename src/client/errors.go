@@ -0,0 +1,74 @@
+package client
+
+import (
+	"api"
+	"encoding/json"
+	"fmt"
+
+	"github.com/codegangsta/cli"
+)
+
+var (
+	ErrorsCmd = cli.Command{
+		Name:   "errors",
+		Usage:  "List the error codes of the daemon",
+		Action: cmdListErrors,
+	}
+)
+
+// Error is a request the daemon refused, Code and Name being those listed
+// by GET /errors.
+type Error struct {
+	Status  int
+	Code    int
+	Name    string
+	Message string
+	Details map[string]string
+
+	// the whole response, some tell more than the error
+	Body []byte
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Name, e.Code, e.Message)
+}
+
+// ErrorCode is the code of err when the daemon refused the request, 0
+// otherwise.
+func ErrorCode(err error) int {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return 0
+}
+
+// decodeError makes the error of a failed request, nil when its body tells
+// no error, as from a daemon of an older version.
+func decodeError(status int, body []byte) *Error {
+	resp := struct {
+		Error *api.Error
+	}{}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Error == nil {
+		return nil
+	}
+	return &Error{
+		Status:  status,
+		Code:    resp.Error.Code,
+		Name:    resp.Error.Name,
+		Message: resp.Error.Message,
+		Details: resp.Error.Details,
+		Body:    body,
+	}
+}
+
+func cmdListErrors(c *cli.Context) {
+	if err := doListErrors(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doListErrors(c *cli.Context) error {
+	url := "/errors"
+
+	return sendRequestAndPrint("GET", url, nil)
+}
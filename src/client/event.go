@@ -0,0 +1,51 @@
+package client
+
+import (
+	"api"
+	"util"
+
+	"github.com/codegangsta/cli"
+)
+
+var (
+	EventCmds = cli.Command{
+		Name:  "event",
+		Usage: "Show cluster events",
+		Subcommands: []cli.Command{
+			{
+				Name:  "list",
+				Usage: "List events, oldest first",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "object",
+						Usage: "only the events of this host ip or device id",
+					},
+				},
+				Action: cmdListEvent,
+			},
+		},
+	}
+)
+
+func cmdListEvent(c *cli.Context) {
+	if err := doListEvent(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doListEvent(c *cli.Context) error {
+	var err error
+
+	object, err := util.GetFlag(c, "object", false, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.EventListRequest{
+		Object: object,
+	}
+
+	url := "/event/list"
+
+	return sendRequestAndPrint("GET", url, request)
+}
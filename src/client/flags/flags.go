@@ -24,6 +24,102 @@ var (
 			Value: &cli.StringSlice{},
 			Usage: "hosts to be scheduled, first host in the list would be treated as master node",
 		},
+		cli.StringSliceFlag{
+			Name:  "drivers",
+			Value: &cli.StringSlice{},
+			Usage: "backend drivers to be enabled, e.g. SAN, volumes of other backends only live in metadata",
+		},
+		cli.StringSliceFlag{
+			Name:  "driver-opts",
+			Value: &cli.StringSlice{},
+			Usage: "options for drivers, e.g. san.adapter=simulator",
+		},
+		cli.StringFlag{
+			Name:  "default-backend",
+			Value: "CEPH",
+			Usage: "backend of volumes created by docker without the backend option",
+		},
+		cli.StringFlag{
+			Name:  "default-volume-size",
+			Value: "10G",
+			Usage: "size of volumes created by docker without the size option",
+		},
+		cli.StringFlag{
+			Name:  "csi-socket",
+			Usage: "serve the CSI controller and node services on this unix socket, e.g. /var/run/policy/csi.sock, needs a build with -tags csi",
+		},
+		cli.StringFlag{
+			Name:  "csi-node-id",
+			Usage: "ip of this host as added by \"policy host add\", reported as the CSI node id",
+		},
+		cli.StringFlag{
+			Name:  "docker-socket",
+			Value: "/var/run/docker.sock",
+			Usage: "docker engine socket to follow container lifecycles on, empty to disable",
+		},
+		cli.IntFlag{
+			Name:  "reconcile-interval",
+			Value: 300,
+			Usage: "seconds between full resyncs of containers with the docker engine",
+		},
+		cli.StringSliceFlag{
+			Name:  "ceph-monitors",
+			Value: &cli.StringSlice{},
+			Usage: "ceph monitors as ip:port, one per cluster, whose pools are kept as CEPH devices",
+		},
+		cli.IntFlag{
+			Name:  "discovery-interval",
+			Value: 60,
+			Usage: "seconds between refreshes of the CEPH devices of ceph pools",
+		},
+		cli.StringFlag{
+			Name:  "backup-target",
+			Usage: "store volume backups are kept in, a directory or s3://bucket/prefix, <root>/backups by default",
+		},
+		cli.StringSliceFlag{
+			Name:  "backup-opts",
+			Value: &cli.StringSlice{},
+			Usage: "options for the backup store, e.g. s3.endpoint=http://10.0.0.5:9000",
+		},
+		cli.StringFlag{
+			Name:  "agent-ip",
+			Usage: "ip of this host, registers the host and its disks with the master node and sends heartbeats",
+		},
+		cli.StringSliceFlag{
+			Name:  "agent-devices",
+			Value: &cli.StringSlice{},
+			Usage: "patterns of the disks under /sys/block the agent registers as devices, e.g. sd[b-z]",
+		},
+		cli.StringFlag{
+			Name:  "agent-backend",
+			Value: "SAN",
+			Usage: "backend of the devices the agent registers",
+		},
+		cli.IntFlag{
+			Name:  "agent-port",
+			Value: 3260,
+			Usage: "port of the devices the agent registers, the iSCSI portal for SAN",
+		},
+		cli.IntFlag{
+			Name:  "heartbeat-interval",
+			Value: 30,
+			Usage: "seconds between heartbeats of the agent",
+		},
+		cli.IntFlag{
+			Name:  "heartbeat-timeout",
+			Value: 90,
+			Usage: "seconds without heartbeat after which the leader takes a host and its devices offline, 0 to disable",
+		},
+		cli.IntFlag{
+			Name:  "operation-workers",
+			Value: 4,
+			Usage: "volume operations the backend drivers run at once",
+		},
+		cli.IntFlag{
+			Name:  "idempotency-retention",
+			Value: 86400,
+			Usage: "seconds the response to a request sent with an idempotency key is kept for its retries",
+		},
 		cli.StringSliceFlag{
 			Name:  "policy-opts",
 			Value: &cli.StringSlice{},
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"api"
+
+	"github.com/codegangsta/cli"
+)
+
+var (
+	FsckCmd = cli.Command{
+		Name:  "fsck",
+		Usage: "Check the references between metadata records",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "repair",
+				Usage: "repair what can be, run it while no other daemon migrates volumes",
+			},
+		},
+		Action: cmdFsck,
+	}
+)
+
+func cmdFsck(c *cli.Context) {
+	if err := doFsck(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doFsck(c *cli.Context) error {
+	request := &api.FsckRequest{
+		Repair: c.Bool("repair"),
+	}
+
+	url := "/meta/fsck"
+
+	return sendRequestAndPrint("POST", url, request)
+}
@@ -53,6 +53,49 @@ var (
 				},
 				Action: cmdDeleteHost,
 			},
+
+			{
+				Name:  "drain",
+				Usage: "put host and its devices in maintenance, printing the volumes left until there are none",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "ip",
+						Usage: "host ip",
+					},
+					cli.BoolFlag{
+						Name:  "migrate",
+						Usage: "move the volumes to devices of other hosts",
+					},
+					cli.BoolFlag{
+						Name:  "no-wait",
+						Usage: "return at once with the volumes left",
+					},
+				},
+				Action: cmdDrainHost,
+			},
+
+			{
+				Name:  "resume",
+				Usage: "take host and its devices out of maintenance",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "ip",
+						Usage: "host ip",
+					},
+				},
+				Action: cmdResumeHost,
+			},
+			{
+				Name:  "failover",
+				Usage: "release again the volumes held by an offline host",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "ip",
+						Usage: "host ip",
+					},
+				},
+				Action: cmdFailoverHost,
+			},
 		},
 	}
 )
@@ -141,3 +184,76 @@ func doDeleteHost(c *cli.Context) error {
 
 	return sendRequestAndPrint("DELETE", url, request)
 }
+
+func cmdDrainHost(c *cli.Context) {
+	if err := doDrainHost(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doDrainHost(c *cli.Context) error {
+	var err error
+
+	ip, err := util.GetFlag(c, "ip", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.HostDrainRequest{
+		Ip:      ip,
+		Migrate: c.Bool("migrate"),
+	}
+
+	url := "/host/drain"
+
+	if c.Bool("no-wait") {
+		return sendRequestAndPrint("POST", url, request)
+	}
+	return waitDrain(url, request)
+}
+
+func cmdResumeHost(c *cli.Context) {
+	if err := doResumeHost(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doResumeHost(c *cli.Context) error {
+	var err error
+
+	ip, err := util.GetFlag(c, "ip", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.HostDrainRequest{
+		Ip: ip,
+	}
+
+	url := "/host/resume"
+
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdFailoverHost(c *cli.Context) {
+	if err := doFailoverHost(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doFailoverHost(c *cli.Context) error {
+	var err error
+
+	ip, err := util.GetFlag(c, "ip", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.HostGetRequest{
+		Ip: ip,
+	}
+
+	url := "/host/failover"
+
+	return sendRequestAndPrint("POST", url, request)
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"api"
+	"util"
+
+	"github.com/codegangsta/cli"
+)
+
+var (
+	MetaCmds = cli.Command{
+		Name:  "meta",
+		Usage: "Back up and restore the metadata",
+		Subcommands: []cli.Command{
+			{
+				Name:  "export",
+				Usage: "dump hosts, devices, volumes, containers, snapshots and backups as a JSON archive",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "file",
+						Usage: "archive file, standard output when not given",
+					},
+				},
+				Action: cmdMetaExport,
+			},
+			{
+				Name:  "import",
+				Usage: "make the metadata match an archive, records missing from it are deleted",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "file",
+						Usage: "archive file",
+					},
+					cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "only list the records the import would add, change or delete",
+					},
+				},
+				Action: cmdMetaImport,
+			},
+		},
+	}
+)
+
+func cmdMetaExport(c *cli.Context) {
+	if err := doMetaExport(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doMetaExport(c *cli.Context) error {
+	var err error
+
+	file, err := util.GetFlag(c, "file", false, err)
+	if err != nil {
+		return err
+	}
+
+	resp := &api.MetaExportResponse{}
+	if err := sendRequestAndDecode("GET", "/meta/export", nil, resp); err != nil {
+		return err
+	}
+	if resp.Result != "0" {
+		return fmt.Errorf("export failed with result %s", resp.Result)
+	}
+
+	var archive bytes.Buffer
+	if err := json.Indent(&archive, resp.Archive, "", "\t"); err != nil {
+		return err
+	}
+	archive.WriteString("\n")
+
+	if file == "" {
+		fmt.Print(archive.String())
+		return nil
+	}
+	return ioutil.WriteFile(file, archive.Bytes(), 0600)
+}
+
+func cmdMetaImport(c *cli.Context) {
+	if err := doMetaImport(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doMetaImport(c *cli.Context) error {
+	var err error
+
+	file, err := util.GetFlag(c, "file", true, err)
+	if err != nil {
+		return err
+	}
+
+	archive, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(archive) {
+		return fmt.Errorf("%s is no JSON archive", file)
+	}
+
+	request := &api.MetaImportRequest{
+		Archive: archive,
+		DryRun:  c.Bool("dry-run"),
+	}
+
+	url := "/meta/import"
+
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdMetaSchema(c *cli.Context) {
+	if err := doMetaSchema(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doMetaSchema(c *cli.Context) error {
+	url := "/meta/schema"
+
+	return sendRequestAndPrint("GET", url, nil)
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"api"
+	"fmt"
+	"time"
+	"util"
+
+	"github.com/codegangsta/cli"
+)
+
+var (
+	OperationCmds = cli.Command{
+		Name:  "operation",
+		Usage: "Follow volume operations",
+		Subcommands: []cli.Command{
+			{
+				Name:  "get",
+				Usage: "show the progress of a volume operation",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "operation id",
+					},
+				},
+				Action: cmdGetOperation,
+			},
+
+			{
+				Name:  "wait",
+				Usage: "wait for a volume operation to end",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "operation id",
+					},
+					cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "give up after this long, 0 to wait forever",
+					},
+				},
+				Action: cmdWaitOperation,
+			},
+		},
+	}
+)
+
+const (
+	OPERATION_POLL_INTERVAL = time.Second
+)
+
+func cmdGetOperation(c *cli.Context) {
+	if err := doGetOperation(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doGetOperation(c *cli.Context) error {
+	var err error
+
+	id, err := util.GetFlag(c, "id", true, err)
+	if err != nil {
+		return err
+	}
+
+	url := "/operation/" + id
+
+	return sendRequestAndPrint("GET", url, nil)
+}
+
+func cmdWaitOperation(c *cli.Context) {
+	if err := doWaitOperation(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doWaitOperation(c *cli.Context) error {
+	var err error
+
+	id, err := util.GetFlag(c, "id", true, err)
+	if err != nil {
+		return err
+	}
+
+	return waitOperation(id, c.Duration("timeout"))
+}
+
+// waitOperation polls an operation until it ends and prints it.
+func waitOperation(id string, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	url := "/operation/" + id
+	for {
+		resp := &api.OperationResponse{}
+		if err := sendRequestAndDecode("GET", url, nil, resp); err != nil {
+			return err
+		}
+		if resp.Result != "0" || resp.Status == api.OPERATION_DONE || resp.Status == api.OPERATION_FAILED {
+			data, err := api.ResponseOutput(*resp)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return fmt.Errorf("Operation %s still %s after %s", id, resp.Status, timeout)
+		}
+		time.Sleep(OPERATION_POLL_INTERVAL)
+	}
+}
+
+// sendOperation sends a volume request and, unless noWait, waits for the
+// operation it queued.
+func sendOperation(method, url string, request interface{}, noWait bool) error {
+	if noWait {
+		return sendRequestAndPrint(method, url, request)
+	}
+
+	resp := &api.VolumeResponse{}
+	if err := sendRequestAndDecode(method, url, request, resp); err != nil {
+		return err
+	}
+	if resp.Result != "0" || resp.Operation == "" {
+		data, err := api.ResponseOutput(*resp)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	return waitOperation(resp.Operation, 0)
+}
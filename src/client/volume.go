@@ -2,7 +2,10 @@ package client
 
 import (
 	"api"
+	"encoding/json"
+	"fmt"
 	"strconv"
+	"time"
 	"util"
 
 	"github.com/codegangsta/cli"
@@ -29,6 +32,10 @@ var (
 						Name:  "capacity",
 						Usage: "volume capacity in G",
 					},
+					cli.BoolFlag{
+						Name:  "no-wait",
+						Usage: "return at once with the operation id",
+					},
 				},
 				Action: cmdCreateVolume,
 			},
@@ -73,6 +80,10 @@ var (
 						Name:  "driver",
 						Usage: "volume driver",
 					},
+					cli.BoolFlag{
+						Name:  "no-wait",
+						Usage: "return at once with the operation id",
+					},
 				},
 				Action: cmdDeleteVolume,
 			},
@@ -97,6 +108,10 @@ var (
 						Name:  "mode",
 						Usage: "container id which use volume with mode",
 					},
+					cli.BoolFlag{
+						Name:  "no-wait",
+						Usage: "return at once with the operation id",
+					},
 				},
 				Action: cmdAttachVolume,
 			},
@@ -121,13 +136,85 @@ var (
 						Name:  "mode",
 						Usage: "container id which use volume with mode",
 					},
+					cli.BoolFlag{
+						Name:  "no-wait",
+						Usage: "return at once with the operation id",
+					},
 				},
 				Action: cmdDetachVolume,
 			},
+
+			{
+				Name:  "fence",
+				Usage: "break the locks a dead host holds on volume",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "name",
+						Usage: "volume name",
+					},
+					cli.StringFlag{
+						Name:  "driver",
+						Usage: "volume driver",
+					},
+					cli.StringFlag{
+						Name:  "host",
+						Usage: "dead host, as given to its daemon in ceph.node",
+					},
+				},
+				Action: cmdFenceVolume,
+			},
+
+			{
+				Name:  "migrate",
+				Usage: "move volume data to another device, printing the progress until done",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "name",
+						Usage: "volume name",
+					},
+					cli.StringFlag{
+						Name:  "driver",
+						Usage: "volume driver",
+					},
+					cli.StringFlag{
+						Name:  "to-device",
+						Usage: "free device to move the volume to",
+					},
+					cli.StringFlag{
+						Name:  "to-host",
+						Usage: "host to move the volume to, on a device scheduled there",
+					},
+					cli.BoolFlag{
+						Name:  "no-wait",
+						Usage: "return once the copy is started",
+					},
+				},
+				Action: cmdMigrateVolume,
+			},
+
+			{
+				Name:  "migration",
+				Usage: "show the progress of the last migration of volume",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "name",
+						Usage: "volume name",
+					},
+					cli.StringFlag{
+						Name:  "driver",
+						Usage: "volume driver",
+					},
+				},
+				Action: cmdGetMigration,
+			},
 		},
 	}
 )
 
+const (
+	MIGRATE_POLL_INTERVAL = time.Second
+)
+
 func cmdCreateVolume(c *cli.Context) {
 	if err := doCreateVolume(c); err != nil {
 		PrintErrorInfo(err)
@@ -160,7 +247,7 @@ func doCreateVolume(c *cli.Context) error {
 
 	url := "/volume/create"
 
-	return sendRequestAndPrint("POST", url, request)
+	return sendOperation("POST", url, request, c.Bool("no-wait"))
 }
 
 func cmdGetVolume(c *cli.Context) {
@@ -233,7 +320,7 @@ func doDeleteVolume(c *cli.Context) error {
 
 	url := "/volume/"
 
-	return sendRequestAndPrint("DELETE", url, request)
+	return sendOperation("DELETE", url, request, c.Bool("no-wait"))
 }
 
 func cmdAttachVolume(c *cli.Context) {
@@ -262,7 +349,7 @@ func doAttachVolume(c *cli.Context) error {
 
 	url := "/volume/attach"
 
-	return sendRequestAndPrint("POST", url, request)
+	return sendOperation("POST", url, request, c.Bool("no-wait"))
 }
 
 func cmdDetachVolume(c *cli.Context) {
@@ -291,5 +378,129 @@ func doDetachVolume(c *cli.Context) error {
 
 	url := "/volume/detach"
 
+	return sendOperation("POST", url, request, c.Bool("no-wait"))
+}
+
+func cmdFenceVolume(c *cli.Context) {
+	if err := doFenceVolume(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doFenceVolume(c *cli.Context) error {
+	var err error
+
+	volumeId, err := util.GetFlag(c, "name", true, err)
+	driverName, err := util.GetFlag(c, "driver", true, err)
+	host, err := util.GetFlag(c, "host", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.VolumeFenceRequest{
+		VolumeId:   volumeId,
+		DriverName: driverName,
+		Host:       host,
+	}
+
+	url := "/volume/fence"
+
 	return sendRequestAndPrint("POST", url, request)
 }
+
+func cmdMigrateVolume(c *cli.Context) {
+	if err := doMigrateVolume(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doMigrateVolume(c *cli.Context) error {
+	var err error
+
+	volumeId, err := util.GetFlag(c, "name", true, err)
+	driverName, err := util.GetFlag(c, "driver", true, err)
+	toDevice, err := util.GetFlag(c, "to-device", false, err)
+	toHost, err := util.GetFlag(c, "to-host", false, err)
+	if err != nil {
+		return err
+	}
+	if (toDevice == "") == (toHost == "") {
+		return fmt.Errorf("Exactly one of --to-device and --to-host is required")
+	}
+
+	request := &api.VolumeMigrateRequest{
+		VolumeId:   volumeId,
+		DriverName: driverName,
+		ToDevice:   toDevice,
+		ToHost:     toHost,
+	}
+
+	url := "/volume/migrate"
+
+	if c.Bool("no-wait") {
+		return sendRequestAndPrint("POST", url, request)
+	}
+
+	resp := &api.MigrateResponse{}
+	if err := sendRequestAndDecode("POST", url, request, resp); err != nil {
+		return err
+	}
+	get := &api.VolumeGetRequest{VolumeId: volumeId, DriverName: driverName}
+	for resp.Result == "0" && resp.Status == api.MIGRATE_COPYING {
+		fmt.Printf("%s: %s to %s, %s/%s MB\n", volumeId, resp.FromDevice, resp.ToDevice, megabytes(resp.Done), megabytes(resp.Total))
+		time.Sleep(MIGRATE_POLL_INTERVAL)
+		resp = &api.MigrateResponse{}
+		if err := sendRequestAndDecode("GET", url, get, resp); err != nil {
+			return err
+		}
+	}
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func megabytes(bytes string) string {
+	n, err := strconv.ParseInt(bytes, 10, 64)
+	if err != nil {
+		return bytes
+	}
+	return strconv.FormatInt(n>>20, 10)
+}
+
+func sendRequestAndDecode(method, request string, data interface{}, v interface{}) error {
+	rc, err := sendRequest(method, request, data)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(rc).Decode(v)
+}
+
+func cmdGetMigration(c *cli.Context) {
+	if err := doGetMigration(c); err != nil {
+		PrintErrorInfo(err)
+	}
+}
+
+func doGetMigration(c *cli.Context) error {
+	var err error
+
+	volumeId, err := util.GetFlag(c, "name", true, err)
+	driverName, err := util.GetFlag(c, "driver", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.VolumeGetRequest{
+		VolumeId:   volumeId,
+		DriverName: driverName,
+	}
+
+	url := "/volume/migrate"
+
+	return sendRequestAndPrint("GET", url, request)
+}
@@ -0,0 +1,288 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"api"
+	"meta"
+)
+
+const (
+	DEFAULT_HEARTBEAT_INTERVAL = 30 * time.Second
+	AGENT_REQUEST_TIMEOUT      = 10 * time.Second
+
+	SECTOR_SIZE = 512
+)
+
+var (
+	// overridden by tests
+	sysBlockDir = "/sys/block"
+)
+
+// localDisk is a whole disk of this host, its size in MB.
+type localDisk struct {
+	name string
+	size int
+}
+
+func readSysValue(dir string, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// busyDisk tells the disks holding partitions or claimed by another device
+// such as a device mapper or raid, whatever is on them is not ours.
+func busyDisk(dir string, name string) bool {
+	if holders, _ := ioutil.ReadDir(filepath.Join(dir, "holders")); len(holders) != 0 {
+		return true
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), name) {
+			if _, err := os.Stat(filepath.Join(dir, e.Name(), "partition")); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// localDisks lists the disks of sysfs whose names match one of the
+// patterns. Removable, read only, empty and busy disks are left out.
+func localDisks(sysDir string, patterns []string) ([]localDisk, error) {
+	entries, err := ioutil.ReadDir(sysDir)
+	if err != nil {
+		return nil, err
+	}
+
+	devs := []localDisk{}
+	for _, e := range entries {
+		name := e.Name()
+		if !matchAny(patterns, name) {
+			continue
+		}
+		dir := filepath.Join(sysDir, name)
+		if readSysValue(dir, "removable") == "1" || readSysValue(dir, "ro") == "1" || busyDisk(dir, name) {
+			continue
+		}
+		sectors, err := strconv.ParseInt(readSysValue(dir, "size"), 10, 64)
+		if err != nil || sectors*SECTOR_SIZE < 1<<20 {
+			continue
+		}
+		devs = append(devs, localDisk{name: name, size: int(sectors * SECTOR_SIZE >> 20)})
+	}
+	return devs, nil
+}
+
+// agent registers this host and its disks with the daemon serving the
+// api, through the same requests as "policy host add" and "policy device
+// add", and keeps the host alive with heartbeats. Disks plugged later are
+// registered on the next heartbeat.
+type agent struct {
+	ip       string
+	master   string
+	patterns []string
+	backend  string
+	port     int
+	interval time.Duration
+
+	client     *http.Client
+	registered bool
+	devices    map[string]bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newAgent(ip string, master string, patterns []string, backend string, port int, interval time.Duration) *agent {
+	if interval <= 0 {
+		interval = DEFAULT_HEARTBEAT_INTERVAL
+	}
+	return &agent{
+		ip:       ip,
+		master:   master,
+		patterns: patterns,
+		backend:  backend,
+		port:     port,
+		interval: interval,
+		client:   &http.Client{Timeout: AGENT_REQUEST_TIMEOUT},
+		devices:  make(map[string]bool),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (s *daemon) startAgent() *agent {
+	master := s.MasterNode
+	if !strings.Contains(master, ":") {
+		master = fmt.Sprintf("%s:%d", master, HTTP_PORT)
+	}
+	a := newAgent(s.AgentIP, master, s.AgentDevices, s.AgentBackend, s.AgentPort, time.Duration(s.HeartbeatInterval)*time.Second)
+
+	a.wg.Add(1)
+	go a.run()
+
+	log.Debugf("Agent of host %s reporting to %s", a.ip, master)
+	return a
+}
+
+func (a *agent) Stop() {
+	close(a.stop)
+	a.wg.Wait()
+}
+
+func (a *agent) run() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		if err := a.beat(); err != nil {
+			log.Warnf("[agent] heartbeat of host %s error: %s", a.ip, err.Error())
+		}
+
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// call sends a request of the api and returns its result code.
+func (a *agent) call(method string, path string, data interface{}) (int, error) {
+	params, err := EncodeData(data)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(method, "http://"+a.master+"/v"+api.API_VERSION+path, params)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	// a failed request tells its code with its error
+	result := struct {
+		Result string
+		Error  *api.Error
+	}{}
+	if err := json.Unmarshal(body, &result); err != nil || (resp.StatusCode != http.StatusOK && result.Error == nil) {
+		return 0, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return strconv.Atoi(result.Result)
+}
+
+func (a *agent) deviceID(name string) string {
+	return a.ip + "-" + name
+}
+
+func (a *agent) register() error {
+	result, err := a.call("GET", "/host/", &api.HostGetRequest{Ip: a.ip})
+	if err != nil {
+		return err
+	}
+	if result == metadata.EcodeHostNotFound {
+		result, err = a.call("POST", "/host/add", &api.HostAddRequest{Ip: a.ip})
+		if err != nil {
+			return err
+		}
+		log.Infof("[agent] host %s registered", a.ip)
+	}
+	if result != 0 {
+		return fmt.Errorf("register host %s failed with %d", a.ip, result)
+	}
+	a.registered = true
+	return nil
+}
+
+func (a *agent) registerDevices() error {
+	devs, err := localDisks(sysBlockDir, a.patterns)
+	if err != nil {
+		return err
+	}
+	for _, dev := range devs {
+		id := a.deviceID(dev.name)
+		if a.devices[id] {
+			continue
+		}
+		req := &api.DeviceAddRequest{
+			ID:       id,
+			Ip:       a.ip,
+			Port:     a.port,
+			Total:    dev.size,
+			Free:     dev.size,
+			Status:   metadata.DEVICE_READY,
+			Resource: "/dev/" + dev.name,
+			Backend:  a.backend,
+		}
+		result, err := a.call("POST", "/device/add", req)
+		if err != nil {
+			return err
+		}
+		if result != 0 && result != metadata.EcodeDeviceExist {
+			log.Warnf("[agent] register device %s failed with %d", id, result)
+			continue
+		}
+		if result == 0 {
+			log.Infof("[agent] device %s of %d MB registered", id, dev.size)
+		}
+		a.devices[id] = true
+	}
+	return nil
+}
+
+// beat registers what is missing and sends a heartbeat. A host deleted
+// meanwhile is registered again with its disks on the next one.
+func (a *agent) beat() error {
+	if !a.registered {
+		if err := a.register(); err != nil {
+			return err
+		}
+	}
+	if err := a.registerDevices(); err != nil {
+		log.Warnf("[agent] register devices of host %s error: %s", a.ip, err.Error())
+	}
+
+	result, err := a.call("POST", "/host/heartbeat", &api.HostGetRequest{Ip: a.ip})
+	if err != nil {
+		return err
+	}
+	if result == metadata.EcodeHostNotFound {
+		a.registered = false
+		a.devices = make(map[string]bool)
+	}
+	if result != 0 {
+		return fmt.Errorf("heartbeat failed with %d", result)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"meta"
+	"store/memory"
+)
+
+// fakeDisk adds a disk to a fake sysfs tree, its files given by name.
+func fakeDisk(t *testing.T, sysDir string, name string, files map[string]string) {
+	for file, value := range files {
+		path := filepath.Join(sysDir, name, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAgent(t *testing.T) {
+	sysDir, err := ioutil.TempDir("", "agent-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sysDir)
+	oldSysBlockDir := sysBlockDir
+	sysBlockDir = sysDir
+	defer func() { sysBlockDir = oldSysBlockDir }()
+
+	// 10 GB in 512 bytes sectors
+	size := strconv.Itoa(10 << 30 / SECTOR_SIZE)
+	fakeDisk(t, sysDir, "sda", map[string]string{"size": size, "sda1/partition": "1"})
+	fakeDisk(t, sysDir, "sdb", map[string]string{"size": size, "removable": "0", "ro": "0"})
+	fakeDisk(t, sysDir, "sdc", map[string]string{"size": size, "removable": "1"})
+	fakeDisk(t, sysDir, "sdd", map[string]string{"size": size, "ro": "1"})
+	fakeDisk(t, sysDir, "nvme0n1", map[string]string{"size": size, "holders/dm-0": ""})
+	fakeDisk(t, sysDir, "loop0", map[string]string{"size": size})
+
+	memory.NewStore()
+	server := httptest.NewServer(createRouter(&daemon{}))
+	defer server.Close()
+	a := newAgent(testNodeID, server.Listener.Addr().String(), []string{"sd*", "nvme*"}, metadata.SAN, 3260, time.Second)
+
+	devices := func() []string {
+		hs, err := metadata.GetHost(testNodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, key := range hs.Devices {
+			id, _ := metadata.ParseDeviceKey(string(key))
+			names = append(names, id)
+		}
+		return names
+	}
+
+	if err := a.beat(); err != nil {
+		t.Fatal(err)
+	}
+	if devs := devices(); len(devs) != 1 || devs[0] != testNodeID+"-sdb" {
+		t.Fatalf("only sdb should be registered, got %v", devs)
+	}
+	dv, err := metadata.GetDevice(testNodeID+"-sdb", metadata.SAN)
+	if err != nil || string(dv.Total) != "10240" || string(dv.Identify) != "/dev/sdb" {
+		t.Fatalf("unexpected device %v %v", dv, err)
+	}
+	hs, _ := metadata.GetHost(testNodeID)
+	if optime, _ := metadata.BytesToInteger(hs.Optime); time.Now().Unix()-int64(optime) > 5 {
+		t.Fatalf("heartbeat should set the optime of the host, got %s", hs.Optime)
+	}
+
+	metadata.HostHeartbeat(testNodeID, 1)
+	fakeDisk(t, sysDir, "sde", map[string]string{"size": size})
+	if err := a.beat(); err != nil {
+		t.Fatal(err)
+	}
+	if devs := devices(); len(devs) != 2 || devs[1] != testNodeID+"-sde" {
+		t.Fatalf("disk plugged later should be registered, got %v", devs)
+	}
+	if hs, _ := metadata.GetHost(testNodeID); string(hs.Optime) == "1" {
+		t.Fatal("heartbeat should update the optime of the host")
+	}
+
+	// a host deleted by hand comes back with its disks
+	for _, dev := range devices() {
+		if err := metadata.DelDevice(dev, metadata.SAN); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := metadata.DelHost(testNodeID); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.beat(); err == nil {
+		t.Fatal("heartbeat of a deleted host should fail")
+	}
+	if err := a.beat(); err != nil {
+		t.Fatal(err)
+	}
+	if devs := devices(); len(devs) != 2 {
+		t.Fatalf("disks should be registered again, got %v", devs)
+	}
+}
@@ -0,0 +1,564 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"api"
+	"backup"
+	"driver"
+	"meta"
+	"meta/proto"
+	"util"
+)
+
+const (
+	BACKUP_SNAPSHOT_PREFIX = "backup-"
+	BACKUP_EXPORT_FILE     = "export"
+)
+
+// backupTarget opens the store new backups go to, configs written before
+// backups existed keep them under the root.
+func (s *daemon) backupTarget() (*backup.Target, error) {
+	storeURL := s.BackupTarget
+	if storeURL == "" {
+		storeURL = filepath.Join(s.Root, BACKUP_TARGET)
+	}
+	return s.openTarget(storeURL)
+}
+
+func (s *daemon) openTarget(storeURL string) (*backup.Target, error) {
+	store, err := backup.NewStore(storeURL, s.BackupOpts)
+	if err != nil {
+		log.Errorf("[openTarget] open backup store %s error: %s", storeURL, err.Error())
+		return nil, err
+	}
+	return backup.NewTarget(store), nil
+}
+
+// catalogTarget opens the store a backup of the catalog was saved to, which
+// need not be the current one.
+func (s *daemon) catalogTarget(backupid string) (*metaproto.Backup, *backup.Target, error) {
+	bk, err := metadata.GetBackup(backupid)
+	if err != nil {
+		return nil, nil, err
+	}
+	target, err := s.openTarget(string(bk.Store))
+	if err != nil {
+		return nil, nil, err
+	}
+	return bk, target, nil
+}
+
+func catalogEntry(b *backup.Backup, store string) *metaproto.Backup {
+	return &metaproto.Backup{
+		Id:       []byte(b.ID),
+		Volumeid: []byte(b.VolumeID),
+		Driver:   []byte(b.Driver),
+		Parent:   []byte(b.Parent),
+		Snapshot: []byte(b.Snapshot),
+		Capacity: []byte(b.Capacity),
+		Size:     []byte(strconv.FormatInt(b.Size, 10)),
+		Store:    []byte(store),
+		Optime:   []byte(strconv.FormatInt(b.Created.Unix(), 10)),
+	}
+}
+
+func backupError(err error) *metadata.Error {
+	if e, ok := err.(*metadata.Error); ok {
+		return e
+	}
+	switch err {
+	case backup.ErrNotFound:
+		return metadata.NewError(metadata.EcodeBackupNotFound, err.Error())
+	case backup.ErrInUse:
+		return metadata.NewError(metadata.EcodeBackupInUse, err.Error())
+	}
+	return metadata.NewError(metadata.EcodeBackupError, err.Error())
+}
+
+func backupResponse(b *backup.Backup, resp *api.BackupResponse) {
+	resp.ID = b.ID
+	resp.VolumeID = b.VolumeID
+	resp.Driver = b.Driver
+	resp.Parent = b.Parent
+	resp.Size = strconv.FormatInt(b.Size, 10)
+	resp.Created = b.Created.Format(time.RubyDate)
+}
+
+func catalogResponse(bk *metaproto.Backup, resp *api.BackupResponse) {
+	resp.ID = string(bk.Id)
+	resp.VolumeID = string(bk.Volumeid)
+	resp.Driver = string(bk.Driver)
+	resp.Parent = string(bk.Parent)
+	resp.Size = string(bk.Size)
+	resp.Store = string(bk.Store)
+	if optime, err := metadata.BackupOptime(bk); err == nil {
+		resp.Created = time.Unix(int64(optime), 0).Format(time.RubyDate)
+	}
+}
+
+// backupDrivers returns the driver of a backend able to snapshot and
+// export volumes.
+func (s *daemon) backupDrivers(driverName string) (driver.VolumeDriver, driver.SnapshotDriver, driver.BackupDriver, error) {
+	d := s.getVolumeDriver(driverName)
+	sd, ok := d.(driver.SnapshotDriver)
+	bd, ok2 := d.(driver.BackupDriver)
+	if d == nil || !ok || !ok2 {
+		return nil, nil, nil, metadata.NewError(metadata.EcodeParameterError, "Backend "+driverName+" doesn't support backups.")
+	}
+	return d, sd, bd, nil
+}
+
+// exportFile gives a path in a new temporary directory, rbd refuses to
+// export to an existing file.
+func (s *daemon) exportFile() (string, func(), error) {
+	dir, err := ioutil.TempDir(s.Root, "backup-")
+	if err != nil {
+		return "", nil, err
+	}
+	return filepath.Join(dir, BACKUP_EXPORT_FILE), func() { os.RemoveAll(dir) }, nil
+}
+
+func (s *daemon) doBackupCreate(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	result := 0
+	req := &api.BackupCreateRequest{}
+	resp := &api.BackupResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		result = s.backupCreateOp(req, resp).runUnlocked()
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// backupCreateOp snapshots the volume and saves the changes since the
+// snapshot of its last backup, everything when asked for a full backup or
+// when that snapshot is gone. The export is streamed to the store without
+// the metadata lock, from the snapshot. Only the snapshot of the last
+// backup is kept on the volume. The backup is recorded in the catalog
+// along with its store.
+func (s *daemon) backupCreateOp(req *api.BackupCreateRequest, resp *api.BackupResponse) *volumeOp {
+	var sd driver.SnapshotDriver
+	var bd driver.BackupDriver
+	var vl *metaproto.Volume
+	var devs []*metaproto.Device
+	var target *backup.Target
+	var b, latest *backup.Backup
+	fromSnapshot := ""
+
+	prepare := func() int {
+		var err error
+		if _, sd, bd, err = s.backupDrivers(req.DriverName); err != nil {
+			return backupError(err).Code
+		}
+		if vl, err = metadata.GetVolume(req.VolumeId, req.DriverName); err != nil {
+			return backupError(err).Code
+		}
+		if devs, err = getVolumeDevices(vl, req.DriverName); err != nil {
+			return backupError(err).Code
+		}
+		if target, err = s.backupTarget(); err != nil {
+			return backupError(err).Code
+		}
+
+		id := util.NewUUID()
+		b = &backup.Backup{
+			ID:       id,
+			VolumeID: req.VolumeId,
+			Driver:   req.DriverName,
+			Capacity: string(vl.Capacity),
+			Snapshot: BACKUP_SNAPSHOT_PREFIX + id,
+			Created:  time.Now(),
+		}
+
+		if latest, err = target.Latest(req.VolumeId); err != nil {
+			return backupError(err).Code
+		}
+		if latest != nil {
+			if _, err := metadata.GetSnapshot(latest.Snapshot, req.DriverName); err != nil {
+				log.Warnf("[backupCreateOp] snapshot of backup %s is gone, backing up volume %s fully: %s", latest.ID, req.VolumeId, err.Error())
+				latest = nil
+			}
+		}
+		if latest != nil && !req.Full {
+			b.Parent, fromSnapshot = latest.ID, latest.Snapshot
+		}
+
+		if err := sd.CreateSnapshot(vl, devs, b.Snapshot); err != nil {
+			log.Errorf("[backupCreateOp] driver %s snapshot volume %s error: %s", req.DriverName, req.VolumeId, err.Error())
+			return metadata.EcodeDriverError
+		}
+		sp := &metaproto.Snapshot{
+			Id:       []byte(b.Snapshot),
+			Volumeid: vl.Id,
+			Size:     vl.Capacity,
+			Status:   metadata.IntegerToBytes(metadata.SNAPSHOT_READY),
+			Optime:   []byte(strconv.FormatInt(time.Now().Unix(), 10)),
+		}
+		if err := metadata.AddSnapshot(sp, req.DriverName); err != nil {
+			sd.DeleteSnapshot(vl, devs, b.Snapshot)
+			return backupError(err).Code
+		}
+		return 0
+	}
+
+	run := func() error {
+		return s.saveBackup(target, bd, vl, devs, b, fromSnapshot)
+	}
+
+	commit := func(err error) int {
+		if err != nil {
+			dropBackupSnapshot(sd, vl, devs, b.Snapshot, req.DriverName)
+			return backupError(err).Code
+		}
+
+		store := target.Store().URL()
+		if err := metadata.AddBackup(catalogEntry(b, store)); err != nil {
+			log.Errorf("[backupCreateOp] add backup %s to the catalog error: %s", b.ID, err.Error())
+			if err := target.Delete(b.ID); err != nil {
+				log.Warnf("[backupCreateOp] delete backup %s error: %s", b.ID, err.Error())
+			}
+			dropBackupSnapshot(sd, vl, devs, b.Snapshot, req.DriverName)
+			return backupError(err).Code
+		}
+
+		if latest != nil {
+			dropBackupSnapshot(sd, vl, devs, latest.Snapshot, req.DriverName)
+		}
+		backupResponse(b, resp)
+		resp.Store = store
+		log.Infof("[backupCreateOp] volume %s backed up as %s in %s, parent %q", req.VolumeId, b.ID, store, b.Parent)
+		return 0
+	}
+
+	return &volumeOp{prepare: prepare, run: run, commit: commit}
+}
+
+func (s *daemon) saveBackup(target *backup.Target, bd driver.BackupDriver, vl *metaproto.Volume, devs []*metaproto.Device, b *backup.Backup, fromSnapshot string) error {
+	file, cleanup, err := s.exportFile()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if err := bd.ExportSnapshot(vl, devs, b.Snapshot, fromSnapshot, file); err != nil {
+		log.Errorf("[saveBackup] driver %s export volume %s error: %s", b.Driver, vl.Id, err.Error())
+		return metadata.NewError(metadata.EcodeDriverError, err.Error())
+	}
+	return target.Save(b, file)
+}
+
+func dropBackupSnapshot(sd driver.SnapshotDriver, vl *metaproto.Volume, devs []*metaproto.Device, snapshotid string, driverName string) {
+	if err := sd.DeleteSnapshot(vl, devs, snapshotid); err != nil {
+		log.Warnf("[dropBackupSnapshot] driver %s delete snapshot %s error: %s", driverName, snapshotid, err.Error())
+		return
+	}
+	if err := metadata.DelSnapshot(snapshotid, driverName); err != nil && !isNotFound(err) {
+		log.Warnf("[dropBackupSnapshot] delete snapshot %s error: %s", snapshotid, err.Error())
+	}
+}
+
+// listCatalog returns the backups of a volume, all of them when volumeid is
+// empty, oldest first to the second.
+func listCatalog(volumeid string) ([]*metaproto.Backup, error) {
+	names, err := metadata.ListBackupsName()
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []*metaproto.Backup{}
+	for _, name := range names {
+		bk, err := metadata.GetBackup(name)
+		if err != nil {
+			log.Warnf("[listCatalog] get backup %s error: %s", name, err.Error())
+			continue
+		}
+		if volumeid == "" || string(bk.Volumeid) == volumeid {
+			backups = append(backups, bk)
+		}
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		ti, _ := metadata.BackupOptime(backups[i])
+		tj, _ := metadata.BackupOptime(backups[j])
+		return ti < tj
+	})
+	return backups, nil
+}
+
+func (s *daemon) doBackupList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	result := 0
+	req := &api.BackupListRequest{}
+	resp := &api.BackupListResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		backups, err := listCatalog(req.VolumeId)
+		if err != nil {
+			result = backupError(err).Code
+			break
+		}
+
+		for _, bk := range backups {
+			info := api.BackupResponse{Result: "0"}
+			catalogResponse(bk, &info)
+			resp.Backups = append(resp.Backups, info)
+		}
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doBackupRestore(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	result := 0
+	req := &api.BackupRestoreRequest{}
+	resp := &api.VolumeResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		result = s.backupRestoreOp(req, resp).runUnlocked()
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// backupRestoreOp creates a new volume of the size the backed up one had
+// and applies the chain of backups to it without the metadata lock, full
+// backup first, the volume being recorded creating meanwhile. The
+// snapshots applying leaves on the volume are removed at the end, the
+// volume is removed when applying failed.
+func (s *daemon) backupRestoreOp(req *api.BackupRestoreRequest, resp *api.VolumeResponse) *volumeOp {
+	var d driver.VolumeDriver
+	var sd driver.SnapshotDriver
+	var bd driver.BackupDriver
+	var target *backup.Target
+	var chain []*backup.Backup
+	var create *volumeOp
+	var vl *metaproto.Volume
+	var devs []*metaproto.Device
+	// applyErr is set when the volume was created but applying failed,
+	// kept when the volume could not be removed then
+	var applyErr error
+	kept := false
+
+	prepare := func() int {
+		var err error
+		if _, target, err = s.catalogTarget(req.BackupId); err != nil {
+			return backupError(err).Code
+		}
+		if chain, err = target.Chain(req.BackupId); err != nil {
+			return backupError(err).Code
+		}
+		last := chain[len(chain)-1]
+		if d, sd, bd, err = s.backupDrivers(last.Driver); err != nil {
+			return backupError(err).Code
+		}
+
+		create = s.volumeCreateOp(&api.VolumeCreateRequest{
+			VolumeId:   req.VolumeId,
+			DriverName: last.Driver,
+			Capacity:   last.Capacity,
+		}, resp)
+		if result := create.prepare(); result != 0 {
+			return result
+		}
+		if vl, err = metadata.GetVolume(req.VolumeId, last.Driver); err == nil {
+			devs, err = getVolumeDevices(vl, last.Driver)
+		}
+		if err != nil {
+			create.commit(err)
+			return backupError(err).Code
+		}
+		return 0
+	}
+
+	run := func() error {
+		if err := create.run(); err != nil {
+			return err
+		}
+		if applyErr = s.applyBackups(target, sd, bd, chain, vl, devs); applyErr != nil {
+			if err := d.DeleteVolume(vl, devs); err != nil {
+				log.Errorf("[backupRestoreOp] delete volume %s error: %s", req.VolumeId, err.Error())
+				kept = true
+			}
+		}
+		return applyErr
+	}
+
+	commit := func(err error) int {
+		if applyErr == nil {
+			if result := create.commit(err); result != 0 {
+				return result
+			}
+			log.Infof("[backupRestoreOp] backup %s restored to volume %s", req.BackupId, req.VolumeId)
+			return 0
+		}
+
+		if !kept {
+			create.commit(applyErr)
+		} else if err := metadata.SetVolumeStatus(req.VolumeId, chain[0].Driver, metadata.VOLUME_ONLINE); err != nil {
+			log.Errorf("[backupRestoreOp] set volume %s online error: %s", req.VolumeId, err.Error())
+		}
+		return backupError(applyErr).Code
+	}
+
+	return &volumeOp{prepare: prepare, run: run, commit: commit}
+}
+
+func (s *daemon) applyBackups(target *backup.Target, sd driver.SnapshotDriver, bd driver.BackupDriver, chain []*backup.Backup, vl *metaproto.Volume, devs []*metaproto.Device) error {
+	for _, b := range chain {
+		if err := s.applyBackup(target, bd, vl, devs, b); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range chain {
+		if err := sd.DeleteSnapshot(vl, devs, b.Snapshot); err != nil {
+			log.Warnf("[applyBackups] driver %s delete snapshot %s of volume %s error: %s", b.Driver, b.Snapshot, vl.Id, err.Error())
+		}
+	}
+	return nil
+}
+
+func (s *daemon) applyBackup(target *backup.Target, bd driver.BackupDriver, vl *metaproto.Volume, devs []*metaproto.Device, b *backup.Backup) error {
+	file, cleanup, err := s.exportFile()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	err = target.Restore(b, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Errorf("[applyBackup] read backup %s error: %s", b.ID, err.Error())
+		return err
+	}
+
+	if err := bd.ImportSnapshot(vl, devs, file); err != nil {
+		log.Errorf("[applyBackup] driver %s import backup %s to volume %s error: %s", b.Driver, b.ID, vl.Id, err.Error())
+		return metadata.NewError(metadata.EcodeDriverError, err.Error())
+	}
+	return nil
+}
+
+func (s *daemon) doBackupDelete(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.BackupDeleteRequest{}
+	resp := &api.BackupResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		if err := s.processBackupDelete(req); err != nil {
+			result = backupError(err).Code
+			break
+		}
+
+		resp.ID = req.BackupId
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// processBackupDelete also drops the snapshot of the backup when it is the
+// last of its volume, the next backup is then a full one. A backup already
+// gone from its store is only removed from the catalog.
+func (s *daemon) processBackupDelete(req *api.BackupDeleteRequest) error {
+	_, target, err := s.catalogTarget(req.BackupId)
+	if err != nil {
+		return err
+	}
+	b, err := target.Get(req.BackupId)
+	if err == backup.ErrNotFound {
+		log.Warnf("[processBackupDelete] backup %s is gone from %s", req.BackupId, target.Store().URL())
+		return metadata.DelBackup(req.BackupId)
+	} else if err != nil {
+		return err
+	}
+	if err := target.Delete(req.BackupId); err != nil {
+		return err
+	}
+	if err := metadata.DelBackup(req.BackupId); err != nil {
+		return err
+	}
+
+	if _, err := metadata.GetSnapshot(b.Snapshot, b.Driver); err != nil {
+		return nil
+	}
+	_, sd, _, err := s.backupDrivers(b.Driver)
+	if err != nil {
+		return nil
+	}
+	vl, err := metadata.GetVolume(b.VolumeID, b.Driver)
+	if err != nil {
+		return nil
+	}
+	devs, err := getVolumeDevices(vl, b.Driver)
+	if err != nil {
+		return nil
+	}
+	dropBackupSnapshot(sd, vl, devs, b.Snapshot, b.Driver)
+	return nil
+}
@@ -0,0 +1,154 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"api"
+	"driver"
+	"meta"
+	"meta/proto"
+	"store/memory"
+)
+
+// backupFakeDriver keeps volumes as data only ever appended to, so the
+// changes since a snapshot are the bytes written after it.
+type backupFakeDriver struct {
+	*fakeDriver
+	data  map[string]string
+	snaps map[string]string
+}
+
+func (f *backupFakeDriver) CreateSnapshot(vl *metaproto.Volume, devs []*metaproto.Device, snapshotid string) error {
+	f.snaps[snapshotid] = f.data[string(vl.Id)]
+	return f.fakeDriver.CreateSnapshot(vl, devs, snapshotid)
+}
+
+// streamed returns at once unless the metadata lock is held, which
+// streaming must not.
+func streamed() {
+	metadata.Lock()
+	metadata.Unlock()
+}
+
+func (f *backupFakeDriver) ExportSnapshot(vl *metaproto.Volume, devs []*metaproto.Device, snapshotid string, fromSnapshotid string, file string) error {
+	streamed()
+	data := f.snaps[snapshotid]
+	return ioutil.WriteFile(file, []byte(data[len(f.snaps[fromSnapshotid]):]), 0600)
+}
+
+func (f *backupFakeDriver) ImportSnapshot(vl *metaproto.Volume, devs []*metaproto.Device, file string) error {
+	streamed()
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	f.data[string(vl.Id)] += string(data)
+	return nil
+}
+
+func TestBackupChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memory.NewStore()
+	if err := metadata.AddHost(testNodeID, metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, dev := range []string{"dev001", "dev002", "dev003"} {
+		if err := metadata.AddDevice(dev, testNodeID, 6789, 10240, 10240, metadata.DEVICE_READY, "rbd", metadata.CEPH); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake := &backupFakeDriver{fakeDriver: newFakeDriver(), data: map[string]string{}, snaps: map[string]string{}}
+	s := &daemon{Drivers: map[string]driver.VolumeDriver{metadata.CEPH: fake}}
+	s.Root = dir
+	req := &api.VolumeCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Capacity: "100"}
+	if result := s.processVolumeCreate(req, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("create failed with %d", result)
+	}
+
+	backupVolume := func(full bool) *api.BackupResponse {
+		resp := &api.BackupResponse{}
+		callHandler(t, s.doBackupCreate, "POST", &api.BackupCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Full: full}, resp)
+		if resp.Result != "0" {
+			t.Fatalf("backup failed with %s", resp.Result)
+		}
+		return resp
+	}
+
+	fake.data["vol001"] = "aaa"
+	first := backupVolume(false)
+	if first.Parent != "" || first.Size != "3" {
+		t.Fatalf("first backup should be full, got %+v", first)
+	}
+	fake.data["vol001"] += "bbbb"
+	second := backupVolume(false)
+	if second.Parent != first.ID || second.Size != "4" {
+		t.Fatalf("second backup should only hold the changes, got %+v", second)
+	}
+	if _, err := metadata.GetSnapshot(BACKUP_SNAPSHOT_PREFIX+first.ID, metadata.CEPH); !isNotFound(err) {
+		t.Fatalf("snapshot of the first backup should be dropped, got %v", err)
+	}
+
+	resp := &api.BackupResponse{}
+	callHandler(t, s.doBackupDelete, "DELETE", &api.BackupDeleteRequest{BackupId: first.ID}, resp)
+	if resp.Result != strconv.Itoa(metadata.EcodeBackupInUse) {
+		t.Fatalf("parent backup should not be deleted, got %+v", resp)
+	}
+
+	vresp := &api.VolumeResponse{}
+	callHandler(t, s.doBackupRestore, "POST", &api.BackupRestoreRequest{BackupId: second.ID, VolumeId: "vol002"}, vresp)
+	if vresp.Result != "0" || fake.data["vol002"] != "aaabbbb" {
+		t.Fatalf("restore should apply the whole chain, got %+v %q", vresp, fake.data["vol002"])
+	}
+	for snap, vol := range fake.snapshots {
+		if vol == "vol002" {
+			t.Fatalf("restore should not leave snapshot %s", snap)
+		}
+	}
+
+	third := backupVolume(true)
+	if third.Parent != "" {
+		t.Fatalf("full backup should have no parent, got %+v", third)
+	}
+
+	list := &api.BackupListResponse{}
+	callHandler(t, s.doBackupList, "GET", &api.BackupListRequest{VolumeId: "vol001"}, list)
+	if len(list.Backups) != 3 {
+		t.Fatalf("unexpected backups %+v", list.Backups)
+	}
+	for _, b := range list.Backups {
+		if b.Store != "file://"+filepath.Join(dir, BACKUP_TARGET) || (b.ID != first.ID && b.ID != second.ID && b.ID != third.ID) {
+			t.Fatalf("unexpected backup in the catalog %+v", b)
+		}
+	}
+
+	// the catalog is enough to find backups once the root is lost
+	s.Root, s.BackupTarget = filepath.Join(dir, "newroot"), ""
+	if err := os.Mkdir(s.Root, 0700); err != nil {
+		t.Fatal(err)
+	}
+	vresp = &api.VolumeResponse{}
+	callHandler(t, s.doBackupRestore, "POST", &api.BackupRestoreRequest{BackupId: third.ID, VolumeId: "vol003"}, vresp)
+	if vresp.Result != "0" || fake.data["vol003"] != "aaabbbb" {
+		t.Fatalf("restore should find the store in the catalog, got %+v %q", vresp, fake.data["vol003"])
+	}
+
+	for _, id := range []string{third.ID, second.ID, first.ID} {
+		resp := &api.BackupResponse{}
+		callHandler(t, s.doBackupDelete, "DELETE", &api.BackupDeleteRequest{BackupId: id}, resp)
+		if resp.Result != "0" {
+			t.Fatalf("delete %s failed with %s", id, resp.Result)
+		}
+	}
+	if _, err := metadata.GetSnapshot(BACKUP_SNAPSHOT_PREFIX+third.ID, metadata.CEPH); !isNotFound(err) {
+		t.Fatalf("snapshot of the last backup should go with it, got %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package daemon
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"api"
+	"meta"
+	"meta/proto"
+)
+
+func isContainerNotFound(err error) bool {
+	e, ok := err.(*metadata.Error)
+	return ok && e.Code == metadata.EcodeContainerNotFound
+}
+
+func fillContainerResponse(ct *metaproto.Container, resp *api.ContainerResponse) {
+	resp.ID = string(ct.Id)
+	resp.Status = metadata.ContainerStates.NameBytes(ct.Status)
+	resp.Optime = string(ct.Optime)
+	for _, cvl := range ct.Volumes {
+		resp.Volumes = append(resp.Volumes, api.ContainerVolume{
+			ID:     string(cvl.Volumeid),
+			Driver: string(cvl.Driver),
+			Mode:   string(cvl.Mode),
+		})
+	}
+}
+
+func (s *daemon) doContainerGet(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.ContainerGetRequest{}
+	resp := &api.ContainerResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		ct, err := metadata.GetContainer(req.ContainerId)
+		if err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		fillContainerResponse(ct, resp)
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doContainerList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.ContainerListRequest{}
+	resp := &api.ContainerListResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		containers, err := metadata.ListContainersName()
+		if err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		resp.Containers = containers
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// doContainerAdd records a container and attaches the given volumes to it
+// the same way volume attach does, nothing is kept if one of them fails.
+func (s *daemon) doContainerAdd(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.ContainerAddRequest{}
+	resp := &api.ContainerResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		if _, err := metadata.GetContainer(req.ContainerId); err == nil {
+			result = metadata.EcodeContainerExist
+			break
+		} else if !isContainerNotFound(err) {
+			result = errorResult(w, err)
+			break
+		}
+		if len(req.Volumes) != 0 && !metadata.ValidBackend(req.DriverName) {
+			result = metadata.EcodeParameterError
+			break
+		}
+
+		ct := &metaproto.Container{
+			Id:     []byte(req.ContainerId),
+			Status: metadata.IntegerToBytes(metadata.CONTAINERONLINE),
+			Optime: []byte(strconv.FormatInt(time.Now().Unix(), 10)),
+		}
+		if err := metadata.AddContainer(ct); err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		for _, volumeid := range req.Volumes {
+			attachReq := &api.VolumeAttachRequest{
+				VolumeId:    volumeid,
+				DriverName:  req.DriverName,
+				ContainerId: req.ContainerId,
+				Mode:        req.Mode,
+			}
+			if result = s.processVolumeAttach(attachReq, &api.VolumeResponse{}); result != 0 {
+				log.Errorf("[doContainerAdd] attach volume %s to container %s failed with %d", volumeid, req.ContainerId, result)
+				break
+			}
+		}
+		if result != 0 {
+			// the failed attach undid itself, only the volumes attached
+			// before it are on the record; if one of them cannot be
+			// released the record stays for the reconciler to retry
+			if ct, err := metadata.GetContainer(req.ContainerId); err == nil {
+				if err := s.releaseContainerVolumes(ct); err != nil {
+					log.Errorf("[doContainerAdd] release volumes of container %s error: %s", req.ContainerId, err.Error())
+					break
+				}
+			}
+			metadata.DelContainer(req.ContainerId)
+			break
+		}
+
+		ct, err := metadata.GetContainer(req.ContainerId)
+		if err != nil {
+			result = errorResult(w, err)
+			break
+		}
+		fillContainerResponse(ct, resp)
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// doContainerDel detaches the volumes of the container before its record
+// goes away, the record stays if a volume could not be released.
+func (s *daemon) doContainerDel(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.ContainerDeleteRequest{}
+	resp := &api.ContainerResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		ct, err := metadata.GetContainer(req.ContainerId)
+		if err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		if err := s.releaseContainerVolumes(ct); err != nil {
+			result = metadata.EcodeContainerDetachError
+			break
+		}
+
+		if err := metadata.DelContainer(req.ContainerId); err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		resp.ID = req.ContainerId
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// releaseContainerVolumes detaches every volume recorded on the container,
+// the caller holds the metadata lock.
+func (s *daemon) releaseContainerVolumes(ct *metaproto.Container) error {
+	var lastErr error
+	for _, cvl := range ct.Volumes {
+		// records written before attach kept the backend carry none
+		backends := metadata.ListBackends()
+		if len(cvl.Driver) != 0 {
+			backends = []string{string(cvl.Driver)}
+		}
+
+		var err error
+		for _, backend := range backends {
+			err = s.releaseVolume(string(cvl.Volumeid), backend, string(ct.Id))
+			if e, ok := err.(*metadata.Error); ok && e.Code == metadata.EcodeVolumeNotFound {
+				err = nil
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = metadata.DelContainerVolume(string(ct.Id), cvl.Volumeid)
+		}
+		if err != nil {
+			log.Errorf("[releaseContainerVolumes] release volume %s of container %s error: %s", cvl.Volumeid, ct.Id, err.Error())
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// releaseVolume removes the container from the volume owners, the backend
+// detach only happens when it was the last one.
+func (s *daemon) releaseVolume(volumeid string, backend string, containerid string) error {
+	vl, err := metadata.GetVolume(volumeid, backend)
+	if err != nil {
+		return err
+	}
+
+	owned := false
+	for _, c := range vl.Containers {
+		if string(c.Containerid) == containerid {
+			owned = true
+			break
+		}
+	}
+	if !owned {
+		return nil
+	}
+
+	if d := s.getVolumeDriver(backend); d != nil && len(vl.Containers) == 1 {
+		if err := detachVolume(d, volumeid, backend); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Detached volume %s/%s from container %s, %d owners left",
+		backend, volumeid, containerid, len(vl.Containers)-1)
+	return metadata.DelVolumeContainer(volumeid, backend, containerid)
+}
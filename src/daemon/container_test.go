@@ -0,0 +1,168 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"api"
+	"driver"
+	"meta"
+	"store/memory"
+)
+
+func callHandler(t *testing.T, h requestHandler, method string, req interface{}, resp interface{}) {
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	if err := h("1", w, httptest.NewRequest(method, "/", bytes.NewReader(body)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatalf("decode response %s: %v", w.Body.String(), err)
+	}
+}
+
+func TestContainerRoutes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "container-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memory.NewStore()
+	if err := metadata.AddHost(testNodeID, metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, dev := range []string{"dev001", "dev002"} {
+		if err := metadata.AddDevice(dev, testNodeID, 6789, 10240, 10240, metadata.DEVICE_READY, "rbd", metadata.CEPH); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake := newFakeDriver()
+	s := &daemon{Drivers: map[string]driver.VolumeDriver{metadata.CEPH: fake}}
+	s.Root = dir
+	for _, name := range []string{"vol001", "vol002"} {
+		req := &api.VolumeCreateRequest{VolumeId: name, DriverName: metadata.CEPH, Capacity: "100"}
+		if result := s.processVolumeCreate(req, &api.VolumeResponse{}); result != 0 {
+			t.Fatalf("create %s failed with %d", name, result)
+		}
+	}
+
+	c1, c2 := containerID('a'), containerID('b')
+	resp := &api.ContainerResponse{}
+	callHandler(t, s.doContainerAdd, "POST", &api.ContainerAddRequest{
+		ContainerId: c1, DriverName: metadata.CEPH, Mode: "rw", Volumes: []string{"vol001", "vol002"},
+	}, resp)
+	if resp.Result != "0" || len(resp.Volumes) != 2 || resp.Volumes[0].Mode != metadata.RWVolume {
+		t.Fatalf("unexpected add response %+v", resp)
+	}
+	if len(fake.attached) != 2 {
+		t.Fatalf("both volumes should be attached, got %v", fake.attached)
+	}
+
+	// vol002 already has a writer, c2 must not be left half attached
+	resp = &api.ContainerResponse{}
+	callHandler(t, s.doContainerAdd, "POST", &api.ContainerAddRequest{
+		ContainerId: c2, DriverName: metadata.CEPH, Mode: "rw", Volumes: []string{"vol002"},
+	}, resp)
+	if resp.Result != strconv.Itoa(metadata.EcodeWRContainerExist) {
+		t.Fatalf("second writer should be refused, got %+v", resp)
+	}
+	if _, err := metadata.GetContainer(c2); !isContainerNotFound(err) {
+		t.Fatalf("failed add should not keep container %s", c2)
+	}
+
+	list := &api.ContainerListResponse{}
+	callHandler(t, s.doContainerList, "GET", &api.ContainerListRequest{}, list)
+	if list.Result != "0" || len(list.Containers) != 1 || list.Containers[0] != c1 {
+		t.Fatalf("unexpected list response %+v", list)
+	}
+
+	resp = &api.ContainerResponse{}
+	callHandler(t, s.doContainerGet, "GET", &api.ContainerGetRequest{ContainerId: c1}, resp)
+	if resp.Result != "0" || resp.Status != "online" || len(resp.Volumes) != 2 ||
+		resp.Volumes[1].ID != "vol002" || resp.Volumes[1].Driver != metadata.CEPH {
+		t.Fatalf("unexpected inspect response %+v", resp)
+	}
+
+	resp = &api.ContainerResponse{}
+	callHandler(t, s.doContainerDel, "DELETE", &api.ContainerDeleteRequest{ContainerId: c1}, resp)
+	if resp.Result != "0" {
+		t.Fatalf("delete failed %+v", resp)
+	}
+	if len(fake.attached) != 0 || len(volumeOwners("vol001")) != 0 || len(volumeOwners("vol002")) != 0 {
+		t.Fatalf("delete should detach every volume, attached %v", fake.attached)
+	}
+
+	resp = &api.ContainerResponse{}
+	callHandler(t, s.doContainerGet, "GET", &api.ContainerGetRequest{ContainerId: c1}, resp)
+	if resp.Result != strconv.Itoa(metadata.EcodeContainerNotFound) {
+		t.Fatalf("deleted container should not be found, got %+v", resp)
+	}
+
+	// a later volume failing releases the ones attached before it
+	resp = &api.ContainerResponse{}
+	callHandler(t, s.doContainerAdd, "POST", &api.ContainerAddRequest{
+		ContainerId: c2, DriverName: metadata.CEPH, Mode: "rw", Volumes: []string{"vol001", "vol404"},
+	}, resp)
+	if resp.Result != strconv.Itoa(metadata.EcodeVolumeNotFound) {
+		t.Fatalf("missing volume should fail the add, got %+v", resp)
+	}
+	if len(fake.attached) != 0 || len(volumeOwners("vol001")) != 0 {
+		t.Fatalf("failed add should release vol001, attached %v", fake.attached)
+	}
+	if _, err := metadata.GetContainer(c2); !isContainerNotFound(err) {
+		t.Fatalf("failed add should not keep container %s", c2)
+	}
+}
+
+func TestSharedVolumeWriters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shared-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memory.NewStore()
+	if err := metadata.AddHost(testNodeID, metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.AddDevice("fs001", testNodeID, 6789, 10240, 10240, metadata.DEVICE_READY, "/volumes", metadata.CEPHFS); err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeDriver()
+	s := &daemon{Drivers: map[string]driver.VolumeDriver{metadata.CEPHFS: fake}}
+	s.Root = dir
+	req := &api.VolumeCreateRequest{VolumeId: "share001", DriverName: metadata.CEPHFS, Capacity: "100"}
+	if result := s.processVolumeCreate(req, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("create failed with %d", result)
+	}
+
+	c1, c2 := containerID('a'), containerID('b')
+	for _, c := range []string{c1, c2} {
+		resp := &api.ContainerResponse{}
+		callHandler(t, s.doContainerAdd, "POST", &api.ContainerAddRequest{
+			ContainerId: c, DriverName: metadata.CEPHFS, Mode: "rw", Volumes: []string{"share001"},
+		}, resp)
+		if resp.Result != "0" {
+			t.Fatalf("writer %s should be accepted on a shared volume, got %+v", c, resp)
+		}
+	}
+	vl, err := metadata.GetVolume("share001", metadata.CEPHFS)
+	if err != nil || len(vl.Containers) != 2 || len(vl.Writable) != 0 {
+		t.Fatalf("both writers should own the volume without a single writable, got %v %v", vl, err)
+	}
+
+	resp := &api.ContainerResponse{}
+	callHandler(t, s.doContainerDel, "DELETE", &api.ContainerDeleteRequest{ContainerId: c1}, resp)
+	if resp.Result != "0" {
+		t.Fatalf("delete failed %+v", resp)
+	}
+	if _, ok := fake.attached["share001"]; !ok {
+		t.Fatal("volume should stay attached for the other writer")
+	}
+}
@@ -0,0 +1,219 @@
+//go:build csi
+// +build csi
+
+package daemon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"api"
+	"meta"
+	"store"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	CSI_PLUGIN_NAME = "csi.policy.comet"
+
+	CSI_PARAM_BACKEND = "backend"
+	CSI_CTX_BACKEND   = "backend"
+	CSI_CTX_MODE      = "mode"
+
+	MB = 1024 * 1024
+)
+
+type csiIdentity struct {
+	csi.UnimplementedIdentityServer
+	s *daemon
+}
+
+type csiController struct {
+	csi.UnimplementedControllerServer
+	s *daemon
+}
+
+type csiNode struct {
+	csi.UnimplementedNodeServer
+	s *daemon
+}
+
+// startCSIServer serves the CSI identity, controller and node services on
+// a unix socket, the CO talks to every daemon of the cluster the same way.
+func (s *daemon) startCSIServer(sockFile string) (*grpc.Server, error) {
+	if err := MkdirIfNotExists(filepath.Dir(sockFile)); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(sockFile); err == nil {
+		log.Warnf("Remove previous CSI sockfile at %v", sockFile)
+		if err := os.Remove(sockFile); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", sockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(csiLogInterceptor))
+	csi.RegisterIdentityServer(server, &csiIdentity{s: s})
+	csi.RegisterControllerServer(server, &csiController{s: s})
+	csi.RegisterNodeServer(server, &csiNode{s: s})
+
+	go func() {
+		if err := server.Serve(l); err != nil {
+			log.Error("CSI server error ", err.Error())
+		}
+	}()
+
+	log.Debugf("CSI server listening on %v", sockFile)
+	return server, nil
+}
+
+func csiLogInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	log.Debugf("Handle CSI %s: %v", info.FullMethod, req)
+	resp, err := handler(ctx, req)
+	if err != nil {
+		log.Errorf("CSI %s returned error: %s", info.FullMethod, err)
+	}
+	return resp, err
+}
+
+// csiError maps metadata error codes to the gRPC codes the CSI spec
+// expects, anything unknown is reported as an internal error.
+func csiError(err error) error {
+	e, ok := err.(*metadata.Error)
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	switch e.Code {
+	case metadata.EcodeVolumeNotFound, metadata.EcodeSnapshotNotFound,
+		metadata.EcodeDeviceNotFound, metadata.EcodeHostNotFound:
+		return status.Error(codes.NotFound, e.Message)
+	case metadata.EcodeVolumeExist, metadata.EcodeSnapshotExist:
+		return status.Error(codes.AlreadyExists, e.Message)
+	case metadata.EcodeWRContainerExist, metadata.EcodeVolumeInUse:
+		return status.Error(codes.FailedPrecondition, e.Message)
+	case metadata.EcodeParameterError:
+		return status.Error(codes.InvalidArgument, e.Message)
+	case metadata.EcodeSchedulerError:
+		return status.Error(codes.ResourceExhausted, e.Message)
+	}
+	return status.Error(codes.Internal, e.Error())
+}
+
+// csiVolumeID names a volume for the CO, volume names are only unique per
+// backend so the backend is part of the id.
+func csiVolumeID(backend string, name string) string {
+	return backend + "/" + name
+}
+
+func parseCSIVolumeID(id string) (string, string, error) {
+	parts := strings.SplitN(id, "/", 2)
+	if len(parts) != 2 || !metadata.ValidBackend(parts[0]) || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid volume id %s", id)
+	}
+	return parts[0], parts[1], nil
+}
+
+// csiOwnerID turns a CSI node id into the 64 characters owner id that
+// volume records keep for docker containers.
+func csiOwnerID(nodeID string) string {
+	sum := sha256.Sum256([]byte("csi:" + nodeID))
+	return hex.EncodeToString(sum[:])
+}
+
+func csiAccessMode(vc *csi.VolumeCapability, readonly bool) string {
+	switch vc.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return metadata.ROVolume
+	}
+	if readonly {
+		return metadata.ROVolume
+	}
+	return metadata.RWVolume
+}
+
+// validateCapability accepts filesystem volumes in any access mode that
+// has at most one writer, a volume record only tracks a single writable owner.
+// Shared backends take multi writer modes as well.
+func validateCapability(vc *csi.VolumeCapability, backend string) error {
+	if vc == nil || vc.GetAccessMode() == nil {
+		return fmt.Errorf("volume capability with access mode is required")
+	}
+	if vc.GetBlock() != nil {
+		return fmt.Errorf("block access is not supported")
+	}
+	switch vc.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return nil
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		if metadata.SharedBackend(backend) {
+			return nil
+		}
+	}
+	return fmt.Errorf("access mode %s is not supported", vc.GetAccessMode().GetMode())
+}
+
+func validateCapabilities(vcs []*csi.VolumeCapability, backend string) error {
+	if len(vcs) == 0 {
+		return fmt.Errorf("volume capabilities are required")
+	}
+	for _, vc := range vcs {
+		if err := validateCapability(vc, backend); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ids *csiIdentity) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{
+		Name:          CSI_PLUGIN_NAME,
+		VendorVersion: api.API_VERSION,
+	}, nil
+}
+
+func (ids *csiIdentity) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	return &csi.GetPluginCapabilitiesResponse{
+		Capabilities: []*csi.PluginCapability{
+			{
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
+					},
+				},
+			},
+			{
+				Type: &csi.PluginCapability_VolumeExpansion_{
+					VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+						Type: csi.PluginCapability_VolumeExpansion_ONLINE,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+func (ids *csiIdentity) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	return &csi.ProbeResponse{Ready: wrapperspb.Bool(store.GetDriver() != nil)}, nil
+}
@@ -0,0 +1,523 @@
+//go:build csi
+// +build csi
+
+package daemon
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"api"
+	"driver"
+	"meta"
+	"meta/proto"
+	"util"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// csiCapacity returns the volume size in MB for the requested range, the
+// daemon default size is used when the CO doesn't care.
+func (s *daemon) csiCapacity(cr *csi.CapacityRange) (int, error) {
+	required, limit := cr.GetRequiredBytes(), cr.GetLimitBytes()
+	if required < 0 || limit < 0 || (limit > 0 && required > limit) {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid capacity range %v", cr)
+	}
+
+	capacity := int((required + MB - 1) / MB)
+	if capacity == 0 {
+		size, err := util.ParseSizeInMb(s.DefaultVolumeSize)
+		if err != nil {
+			return 0, status.Errorf(codes.Internal, "invalid default volume size %s", s.DefaultVolumeSize)
+		}
+		capacity = size
+		if limit > 0 && int64(capacity)*MB > limit {
+			capacity = int(limit / MB)
+		}
+	}
+	if capacity <= 0 || (limit > 0 && int64(capacity)*MB > limit) {
+		return 0, status.Errorf(codes.OutOfRange, "capacity range %v can't be satisfied in MB", cr)
+	}
+	return capacity, nil
+}
+
+func csiVolume(vl *metaproto.Volume, backend string) *csi.Volume {
+	capacity, _ := metadata.VolumeCapacity(vl)
+	return &csi.Volume{
+		VolumeId:      csiVolumeID(backend, string(vl.Id)),
+		CapacityBytes: int64(capacity) * MB,
+		VolumeContext: map[string]string{CSI_CTX_BACKEND: backend},
+	}
+}
+
+func (cs *csiController) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	caps := []*csi.ControllerServiceCapability{}
+	for _, c := range []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+		csi.ControllerServiceCapability_RPC_PUBLISH_READONLY,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+	} {
+		caps = append(caps, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{Type: c},
+			},
+		})
+	}
+	return &csi.ControllerGetCapabilitiesResponse{Capabilities: caps}, nil
+}
+
+// CreateVolume goes through the same scheduler and driver path as
+// "policy volume create", asking again for an existing name is a no-op.
+func (cs *csiController) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	name := req.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume name is required")
+	}
+	if req.GetVolumeContentSource() != nil {
+		return nil, status.Error(codes.InvalidArgument, "creating a volume from a content source is not supported")
+	}
+
+	backend := req.GetParameters()[CSI_PARAM_BACKEND]
+	if backend == "" {
+		backend = cs.s.DefaultBackend
+	}
+	if !metadata.ValidBackend(backend) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid backend %s", backend)
+	}
+	if err := validateCapabilities(req.GetVolumeCapabilities(), backend); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	capacity, err := cs.s.csiCapacity(req.GetCapacityRange())
+	if err != nil {
+		return nil, err
+	}
+
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	vl, err := metadata.GetVolume(name, backend)
+	if err == nil {
+		existing, _ := metadata.VolumeCapacity(vl)
+		limit := req.GetCapacityRange().GetLimitBytes()
+		if existing < capacity || (limit > 0 && int64(existing)*MB > limit) {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s exists with capacity %dMB", name, existing)
+		}
+		return &csi.CreateVolumeResponse{Volume: csiVolume(vl, backend)}, nil
+	}
+	if !isNotFound(err) {
+		return nil, csiError(err)
+	}
+
+	createReq := &api.VolumeCreateRequest{
+		VolumeId:   name,
+		DriverName: backend,
+		Capacity:   strconv.Itoa(capacity),
+	}
+	if result := cs.s.processVolumeCreate(createReq, &api.VolumeResponse{}); result != 0 {
+		return nil, csiError(metadata.NewError(result, "create volume "+name+" failed"))
+	}
+
+	vl, err = metadata.GetVolume(name, backend)
+	if err != nil {
+		return nil, csiError(err)
+	}
+	return &csi.CreateVolumeResponse{Volume: csiVolume(vl, backend)}, nil
+}
+
+func (cs *csiController) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+	backend, name, err := parseCSIVolumeID(req.GetVolumeId())
+	if err != nil {
+		// never handed out by us, so it's already gone
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	if _, err := metadata.GetVolume(name, backend); err != nil {
+		if isNotFound(err) {
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+		return nil, csiError(err)
+	}
+
+	if d := cs.s.getVolumeDriver(backend); d != nil {
+		if err := deleteVolume(d, name, backend); err != nil {
+			return nil, csiError(err)
+		}
+	}
+	if err := metadata.DelVolume(name, backend); err != nil {
+		return nil, csiError(err)
+	}
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// ControllerPublishVolume records the node as an owner of the volume, the
+// storage itself is attached by NodeStageVolume on that node.
+func (cs *csiController) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetNodeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id and node id are required")
+	}
+	backend, name, err := parseCSIVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err := validateCapability(req.GetVolumeCapability(), backend); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mode := csiAccessMode(req.GetVolumeCapability(), req.GetReadonly())
+
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	vl, err := metadata.GetVolume(name, backend)
+	if err != nil {
+		return nil, csiError(err)
+	}
+	if _, err := metadata.GetHost(req.GetNodeId()); err != nil {
+		return nil, status.Errorf(codes.NotFound, "node %s not found", req.GetNodeId())
+	}
+
+	owner := csiOwnerID(req.GetNodeId())
+	resp := &csi.ControllerPublishVolumeResponse{
+		PublishContext: map[string]string{CSI_CTX_MODE: mode},
+	}
+	for _, c := range vl.Containers {
+		if string(c.Containerid) != owner {
+			continue
+		}
+		if string(c.Mode) != mode {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s is published to node %s as %s", name, req.GetNodeId(), c.Mode)
+		}
+		return resp, nil
+	}
+
+	oc := &metaproto.Volume_OwnerContainer{
+		Containerid: []byte(owner),
+		Mode:        []byte(mode),
+		Host:        []byte(req.GetNodeId()),
+	}
+	if err := metadata.SetVolumeContainer(name, oc, backend, false); err != nil {
+		return nil, csiError(err)
+	}
+	return resp, nil
+}
+
+func (cs *csiController) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+	backend, name, err := parseCSIVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	vl, err := metadata.GetVolume(name, backend)
+	if err != nil {
+		return nil, csiError(err)
+	}
+
+	owners := []string{}
+	if req.GetNodeId() == "" {
+		for _, c := range vl.Containers {
+			owners = append(owners, string(c.Containerid))
+		}
+	} else {
+		owners = append(owners, csiOwnerID(req.GetNodeId()))
+	}
+	for _, owner := range owners {
+		if err := metadata.DelVolumeContainer(name, backend, owner); err != nil {
+			return nil, csiError(err)
+		}
+	}
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
+
+func (cs *csiController) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if req.GetVolumeId() == "" || len(req.GetVolumeCapabilities()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume id and capabilities are required")
+	}
+	backend, name, err := parseCSIVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	if _, err := metadata.GetVolume(name, backend); err != nil {
+		return nil, csiError(err)
+	}
+
+	if err := validateCapabilities(req.GetVolumeCapabilities(), backend); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: req.GetVolumeCapabilities(),
+			Parameters:         req.GetParameters(),
+		},
+	}, nil
+}
+
+// ListVolumes pages through the volumes of every backend, the token is the
+// index of the next entry.
+func (cs *csiController) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "max entries can't be negative")
+	}
+	start := 0
+	if req.GetStartingToken() != "" {
+		var err error
+		start, err = strconv.Atoi(req.GetStartingToken())
+		if err != nil || start < 0 {
+			return nil, status.Errorf(codes.Aborted, "invalid starting token %s", req.GetStartingToken())
+		}
+	}
+
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	ids := []string{}
+	for _, backend := range metadata.ListBackends() {
+		names, err := metadata.ListVolumesName(backend)
+		if err != nil {
+			return nil, csiError(err)
+		}
+		for _, name := range names {
+			ids = append(ids, csiVolumeID(backend, name))
+		}
+	}
+	sort.Strings(ids)
+	if start > len(ids) {
+		return nil, status.Errorf(codes.Aborted, "invalid starting token %s", req.GetStartingToken())
+	}
+
+	end := len(ids)
+	if req.GetMaxEntries() > 0 && start+int(req.GetMaxEntries()) < end {
+		end = start + int(req.GetMaxEntries())
+	}
+
+	resp := &csi.ListVolumesResponse{}
+	for _, id := range ids[start:end] {
+		backend, name, _ := parseCSIVolumeID(id)
+		vl, err := metadata.GetVolume(name, backend)
+		if err != nil {
+			continue
+		}
+		entry := &csi.ListVolumesResponse_Entry{
+			Volume: csiVolume(vl, backend),
+			Status: &csi.ListVolumesResponse_VolumeStatus{},
+		}
+		resp.Entries = append(resp.Entries, entry)
+	}
+	if end < len(ids) {
+		resp.NextToken = strconv.Itoa(end)
+	}
+	return resp, nil
+}
+
+func csiSnapshot(sp *metaproto.Snapshot, backend string) *csi.Snapshot {
+	size, _ := metadata.SnapshotSize(sp)
+	optime, _ := metadata.SnapshotOptime(sp)
+	return &csi.Snapshot{
+		SnapshotId:     csiVolumeID(backend, string(sp.Id)),
+		SourceVolumeId: csiVolumeID(backend, string(sp.Volumeid)),
+		SizeBytes:      int64(size) * MB,
+		CreationTime:   timestamppb.New(time.Unix(int64(optime), 0)),
+		ReadyToUse:     true,
+	}
+}
+
+// CreateSnapshot asks the backend driver for the copy when there is one,
+// snapshots of metadata only backends are just recorded.
+func (cs *csiController) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	if req.GetName() == "" || req.GetSourceVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "snapshot name and source volume id are required")
+	}
+	backend, volumeid, err := parseCSIVolumeID(req.GetSourceVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	vl, err := metadata.GetVolume(volumeid, backend)
+	if err != nil {
+		return nil, csiError(err)
+	}
+
+	sp, err := metadata.GetSnapshot(req.GetName(), backend)
+	if err == nil {
+		if string(sp.Volumeid) != volumeid {
+			return nil, status.Errorf(codes.AlreadyExists, "snapshot %s exists for volume %s", req.GetName(), sp.Volumeid)
+		}
+		return &csi.CreateSnapshotResponse{Snapshot: csiSnapshot(sp, backend)}, nil
+	}
+	if !isNotFound(err) {
+		return nil, csiError(err)
+	}
+
+	var sd driver.SnapshotDriver
+	var devs []*metaproto.Device
+	if d := cs.s.getVolumeDriver(backend); d != nil {
+		var ok bool
+		if sd, ok = d.(driver.SnapshotDriver); !ok {
+			return nil, status.Errorf(codes.Unimplemented, "backend %s doesn't support snapshots", backend)
+		}
+		if devs, err = getVolumeDevices(vl, backend); err != nil {
+			return nil, csiError(err)
+		}
+		if err := sd.CreateSnapshot(vl, devs, req.GetName()); err != nil {
+			log.Errorf("[CreateSnapshot] driver %s snapshot volume %s error: %s", backend, volumeid, err.Error())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	sp = &metaproto.Snapshot{
+		Id:       []byte(req.GetName()),
+		Volumeid: []byte(volumeid),
+		Size:     vl.Capacity,
+		Status:   metadata.IntegerToBytes(metadata.SNAPSHOT_READY),
+		Optime:   []byte(strconv.FormatInt(time.Now().Unix(), 10)),
+	}
+	if err := metadata.AddSnapshot(sp, backend); err != nil {
+		if sd != nil {
+			sd.DeleteSnapshot(vl, devs, req.GetName())
+		}
+		return nil, csiError(err)
+	}
+	return &csi.CreateSnapshotResponse{Snapshot: csiSnapshot(sp, backend)}, nil
+}
+
+// DeleteSnapshot drops the snapshot by its own record, the snapshots of a
+// deleted volume went with its storage and only their records are left.
+func (cs *csiController) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	if req.GetSnapshotId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "snapshot id is required")
+	}
+	backend, snapshotid, err := parseCSIVolumeID(req.GetSnapshotId())
+	if err != nil {
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	sp, err := metadata.GetSnapshot(snapshotid, backend)
+	if err != nil {
+		if isNotFound(err) {
+			return &csi.DeleteSnapshotResponse{}, nil
+		}
+		return nil, csiError(err)
+	}
+
+	vl, err := metadata.GetVolume(string(sp.Volumeid), backend)
+	if err != nil && !isNotFound(err) {
+		return nil, csiError(err)
+	}
+	if d := cs.s.getVolumeDriver(backend); d != nil && vl != nil {
+		sd, ok := d.(driver.SnapshotDriver)
+		if !ok {
+			return nil, status.Errorf(codes.Unimplemented, "backend %s doesn't support snapshots", backend)
+		}
+		devs, err := getVolumeDevices(vl, backend)
+		if err != nil {
+			return nil, csiError(err)
+		}
+		if err := sd.DeleteSnapshot(vl, devs, snapshotid); err != nil {
+			log.Errorf("[DeleteSnapshot] driver %s delete snapshot %s error: %s", backend, snapshotid, err.Error())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	if err := metadata.DelSnapshot(snapshotid, backend); err != nil && !isNotFound(err) {
+		return nil, csiError(err)
+	}
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// ControllerExpandVolume grows the volume within the devices it was
+// scheduled on, volumes are never moved to make room.
+func (cs *csiController) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetCapacityRange() == nil {
+		return nil, status.Error(codes.InvalidArgument, "volume id and capacity range are required")
+	}
+	backend, name, err := parseCSIVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	capacity, err := cs.s.csiCapacity(req.GetCapacityRange())
+	if err != nil {
+		return nil, err
+	}
+
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	vl, err := metadata.GetVolume(name, backend)
+	if err != nil {
+		return nil, csiError(err)
+	}
+	d := cs.s.getVolumeDriver(backend)
+
+	current, _ := metadata.VolumeCapacity(vl)
+	if capacity <= current {
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         int64(current) * MB,
+			NodeExpansionRequired: false,
+		}, nil
+	}
+
+	devs, err := getVolumeDevices(vl, backend)
+	if err != nil {
+		return nil, csiError(err)
+	}
+	// a pool already gave the volume its current capacity
+	needed := capacity
+	if metadata.PoolBackend(backend) {
+		needed = capacity - current
+	}
+	for _, dev := range devs {
+		free, _ := metadata.DeviceFree(dev)
+		if free < needed {
+			return nil, status.Errorf(codes.OutOfRange, "device %s only has %dMB free", dev.Id, free)
+		}
+	}
+
+	if d != nil {
+		ed, ok := d.(driver.ExpandDriver)
+		if !ok {
+			return nil, status.Errorf(codes.Unimplemented, "backend %s doesn't support expansion", backend)
+		}
+		if err := ed.ExpandVolume(vl, devs, capacity); err != nil {
+			log.Errorf("[ControllerExpandVolume] driver %s expand volume %s error: %s", backend, name, err.Error())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	if err := metadata.UpdateVolumeCapacity(name, backend, capacity); err != nil {
+		return nil, csiError(err)
+	}
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         int64(capacity) * MB,
+		NodeExpansionRequired: d != nil,
+	}, nil
+}
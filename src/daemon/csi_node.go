@@ -0,0 +1,206 @@
+//go:build csi
+// +build csi
+
+package daemon
+
+import (
+	"context"
+	"os"
+
+	"meta"
+	"util"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (ns *csiNode) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: ns.s.CSINodeID}, nil
+}
+
+func (ns *csiNode) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	caps := []*csi.NodeServiceCapability{}
+	for _, c := range []csi.NodeServiceCapability_RPC_Type{
+		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+	} {
+		caps = append(caps, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{Type: c},
+			},
+		})
+	}
+	return &csi.NodeGetCapabilitiesResponse{Capabilities: caps}, nil
+}
+
+// NodeStageVolume attaches the volume through its backend driver and mounts
+// it at the staging path, metadata only backends get a plain directory.
+func (ns *csiNode) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetStagingTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id and staging target path are required")
+	}
+	backend, name, err := parseCSIVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err := validateCapability(req.GetVolumeCapability(), backend); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	staging := req.GetStagingTargetPath()
+	mode := req.GetPublishContext()[CSI_CTX_MODE]
+	if mode == "" {
+		mode = csiAccessMode(req.GetVolumeCapability(), false)
+	}
+
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	if _, err := metadata.GetVolume(name, backend); err != nil {
+		return nil, csiError(err)
+	}
+	if isMounted(staging) {
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	d := ns.s.getVolumeDriver(backend)
+	if d == nil {
+		dir := ns.s.volumeMountPoint(backend, name)
+		if err := util.MkdirIfNotExists(dir); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if err := bindMount(dir, staging, mode == metadata.ROVolume); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	dev, err := attachVolume(d, name, backend, mode)
+	if err != nil {
+		return nil, csiError(err)
+	}
+	if metadata.SharedBackend(backend) {
+		if err := bindMount(dev, staging, mode == metadata.ROVolume); err != nil {
+			detachVolume(d, name, backend)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+	fsType := req.GetVolumeCapability().GetMount().GetFsType()
+	if fsType == "" {
+		fsType = DEFAULT_FS_TYPE
+	}
+	if mode == metadata.RWVolume {
+		if err := formatDevice(dev, fsType); err != nil {
+			detachVolume(d, name, backend)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if err := mountDevice(dev, staging, mode == metadata.ROVolume); err != nil {
+		detachVolume(d, name, backend)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (ns *csiNode) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetStagingTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id and staging target path are required")
+	}
+	backend, name, err := parseCSIVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	staging := req.GetStagingTargetPath()
+
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	if !isMounted(staging) {
+		return &csi.NodeUnstageVolumeResponse{}, nil
+	}
+	if err := unmountDevice(staging); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if d := ns.s.getVolumeDriver(backend); d != nil {
+		if err := detachVolume(d, name, backend); err != nil {
+			return nil, csiError(err)
+		}
+	}
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+func (ns *csiNode) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetTargetPath() == "" || req.GetStagingTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id, staging and target path are required")
+	}
+	backend, _, err := parseCSIVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err := validateCapability(req.GetVolumeCapability(), backend); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !isMounted(req.GetStagingTargetPath()) {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is not staged at %s", req.GetVolumeId(), req.GetStagingTargetPath())
+	}
+
+	if isMounted(req.GetTargetPath()) {
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+	readonly := csiAccessMode(req.GetVolumeCapability(), req.GetReadonly()) == metadata.ROVolume
+	if err := bindMount(req.GetStagingTargetPath(), req.GetTargetPath(), readonly); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+func (ns *csiNode) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id and target path are required")
+	}
+
+	if isMounted(req.GetTargetPath()) {
+		if err := unmountDevice(req.GetTargetPath()); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	os.Remove(req.GetTargetPath())
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// NodeExpandVolume grows the filesystem after ControllerExpandVolume grew
+// the device underneath it.
+func (ns *csiNode) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetVolumePath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id and volume path are required")
+	}
+	backend, name, err := parseCSIVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	vl, err := metadata.GetVolume(name, backend)
+	if err != nil {
+		return nil, csiError(err)
+	}
+	if !isMounted(req.GetVolumePath()) {
+		return nil, status.Errorf(codes.NotFound, "volume %s is not mounted at %s", name, req.GetVolumePath())
+	}
+
+	if ns.s.getVolumeDriver(backend) != nil {
+		dev, err := getMountDevice(req.GetVolumePath())
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if err := resizeFilesystem(dev, req.GetVolumePath()); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	capacity, _ := metadata.VolumeCapacity(vl)
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: int64(capacity) * MB}, nil
+}
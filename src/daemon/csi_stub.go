@@ -0,0 +1,16 @@
+//go:build !csi
+// +build !csi
+
+package daemon
+
+import (
+	"fmt"
+)
+
+// startCSIServer refuses to serve CSI, its grpc dependencies are left out
+// of builds without the csi tag.
+func (s *daemon) startCSIServer(socket string) (interface {
+	Stop()
+}, error) {
+	return nil, fmt.Errorf("policy is built without CSI, build it with -tags csi to serve %s", socket)
+}
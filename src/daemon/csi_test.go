@@ -0,0 +1,330 @@
+//go:build csi
+// +build csi
+
+package daemon
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"driver"
+	"meta"
+	"store/memory"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// sanityClient plays the CO side of the CSI protocol against the daemon.
+type sanityClient struct {
+	conn       *grpc.ClientConn
+	identity   csi.IdentityClient
+	controller csi.ControllerClient
+	node       csi.NodeClient
+}
+
+func setupCSI(t *testing.T, d driver.VolumeDriver) (*sanityClient, string, func()) {
+	dir, err := ioutil.TempDir("", "csi-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memory.NewStore()
+	if err := metadata.AddHost(testNodeID, metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.AddDevice(testDevice, testNodeID, 6789, 10240, 10240, metadata.DEVICE_READY, "rbd", metadata.CEPH); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &daemon{Drivers: map[string]driver.VolumeDriver{}}
+	s.Root = dir
+	s.DefaultBackend = metadata.CEPH
+	s.DefaultVolumeSize = "1G"
+	s.CSINodeID = testNodeID
+	if d != nil {
+		s.Drivers[metadata.CEPH] = d
+	}
+
+	sock := filepath.Join(dir, "csi.sock")
+	server, err := s.startCSIServer(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.Dial("unix://"+sock, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &sanityClient{
+		conn:       conn,
+		identity:   csi.NewIdentityClient(conn),
+		controller: csi.NewControllerClient(conn),
+		node:       csi.NewNodeClient(conn),
+	}
+	return client, dir, func() {
+		conn.Close()
+		server.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func expectCode(t *testing.T, err error, code codes.Code, call string) {
+	if status.Code(err) != code {
+		t.Fatalf("%s: expected %s, got %v", call, code, err)
+	}
+}
+
+func mountCapability(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}
+}
+
+func TestCSIIdentity(t *testing.T) {
+	c, _, cleanup := setupCSI(t, nil)
+	defer cleanup()
+	ctx := context.Background()
+
+	info, err := c.identity.GetPluginInfo(ctx, &csi.GetPluginInfoRequest{})
+	if err != nil || info.GetName() != CSI_PLUGIN_NAME {
+		t.Fatalf("unexpected plugin info %v, %v", info, err)
+	}
+	probe, err := c.identity.Probe(ctx, &csi.ProbeRequest{})
+	if err != nil || !probe.GetReady().GetValue() {
+		t.Fatalf("plugin should be ready: %v, %v", probe, err)
+	}
+	node, err := c.node.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
+	if err != nil || node.GetNodeId() != testNodeID {
+		t.Fatalf("unexpected node info %v, %v", node, err)
+	}
+}
+
+func TestCSIVolumeLifecycle(t *testing.T) {
+	fake := newFakeDriver()
+	c, dir, cleanup := setupCSI(t, fake)
+	defer cleanup()
+	mounts, restore := setupFakeMounts()
+	defer restore()
+	ctx := context.Background()
+	writer := mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+
+	_, err := c.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{VolumeCapabilities: []*csi.VolumeCapability{writer}})
+	expectCode(t, err, codes.InvalidArgument, "CreateVolume without name")
+
+	createReq := &csi.CreateVolumeRequest{
+		Name:               "pvc-0001",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 100 * MB},
+		VolumeCapabilities: []*csi.VolumeCapability{writer},
+	}
+	created, err := c.controller.CreateVolume(ctx, createReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeID := created.GetVolume().GetVolumeId()
+	if volumeID != "CEPH/pvc-0001" || created.GetVolume().GetCapacityBytes() != 100*MB {
+		t.Fatalf("unexpected volume %v", created.GetVolume())
+	}
+	if _, err := c.controller.CreateVolume(ctx, createReq); err != nil {
+		t.Fatalf("CreateVolume should be idempotent: %v", err)
+	}
+	createReq.CapacityRange = &csi.CapacityRange{RequiredBytes: 200 * MB}
+	_, err = c.controller.CreateVolume(ctx, createReq)
+	expectCode(t, err, codes.AlreadyExists, "CreateVolume with a bigger size")
+
+	_, err = c.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volumeID, NodeId: "10.9.9.9", VolumeCapability: writer,
+	})
+	expectCode(t, err, codes.NotFound, "ControllerPublishVolume to an unknown node")
+
+	publishReq := &csi.ControllerPublishVolumeRequest{VolumeId: volumeID, NodeId: testNodeID, VolumeCapability: writer}
+	published, err := c.controller.ControllerPublishVolume(ctx, publishReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.controller.ControllerPublishVolume(ctx, publishReq); err != nil {
+		t.Fatalf("ControllerPublishVolume should be idempotent: %v", err)
+	}
+	writable, _ := metadata.GetVolumeWRContainer("pvc-0001", metadata.CEPH)
+	if string(writable) != csiOwnerID(testNodeID) {
+		t.Fatalf("node should own the volume rw, got %s", writable)
+	}
+
+	staging := filepath.Join(dir, "staging")
+	target := filepath.Join(dir, "target")
+	_, err = c.node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId: volumeID, StagingTargetPath: staging, TargetPath: target, VolumeCapability: writer,
+	})
+	expectCode(t, err, codes.FailedPrecondition, "NodePublishVolume before staging")
+
+	stageReq := &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: staging,
+		VolumeCapability:  writer,
+		PublishContext:    published.GetPublishContext(),
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.node.NodeStageVolume(ctx, stageReq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mounts[staging] != "/dev/fake/pvc-0001" || fake.attached["pvc-0001"] != metadata.RWVolume {
+		t.Fatalf("volume should be attached rw and mounted at staging, mounts %v", mounts)
+	}
+	if _, err := c.node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId: volumeID, StagingTargetPath: staging, TargetPath: target, VolumeCapability: writer,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if mounts[target] != staging {
+		t.Fatalf("target should be bound to staging, mounts %v", mounts)
+	}
+
+	_, err = c.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+	expectCode(t, err, codes.FailedPrecondition, "DeleteVolume while published")
+
+	expanded, err := c.controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId: volumeID, CapacityRange: &csi.CapacityRange{RequiredBytes: 2048 * MB},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expanded.GetNodeExpansionRequired() || fake.expanded["pvc-0001"] != 2048 {
+		t.Fatalf("unexpected expand result %v, driver saw %v", expanded, fake.expanded)
+	}
+	_, err = c.controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId: volumeID, CapacityRange: &csi.CapacityRange{RequiredBytes: 20480 * MB},
+	})
+	expectCode(t, err, codes.OutOfRange, "ControllerExpandVolume beyond the device")
+	// the pool only needs room for the growth
+	if err := metadata.UpdateDeviceCapacity(testDevice, 10240, 1024, metadata.CEPH); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId: volumeID, CapacityRange: &csi.CapacityRange{RequiredBytes: 4096 * MB},
+	})
+	expectCode(t, err, codes.OutOfRange, "ControllerExpandVolume beyond the free space")
+	if _, err = c.controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId: volumeID, CapacityRange: &csi.CapacityRange{RequiredBytes: 3072 * MB},
+	}); err != nil {
+		t.Fatalf("ControllerExpandVolume within the free space: %v", err)
+	}
+	if dv, _ := metadata.GetDevice(testDevice, metadata.CEPH); string(dv.Free) != "0" {
+		t.Fatalf("the growth should be taken from the pool, free %s", dv.Free)
+	}
+	nodeExpanded, err := c.node.NodeExpandVolume(ctx, &csi.NodeExpandVolumeRequest{VolumeId: volumeID, VolumePath: target})
+	if err != nil || nodeExpanded.GetCapacityBytes() != 3072*MB {
+		t.Fatalf("unexpected node expand result %v, %v", nodeExpanded, err)
+	}
+
+	snapReq := &csi.CreateSnapshotRequest{Name: "snap-0001", SourceVolumeId: volumeID}
+	snap, err := c.controller.CreateSnapshot(ctx, snapReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.GetSnapshot().GetSourceVolumeId() != volumeID || fake.snapshots["snap-0001"] != "pvc-0001" {
+		t.Fatalf("unexpected snapshot %v", snap.GetSnapshot())
+	}
+	if _, err := c.controller.CreateSnapshot(ctx, snapReq); err != nil {
+		t.Fatalf("CreateSnapshot should be idempotent: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.controller.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: snap.GetSnapshot().GetSnapshotId()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(fake.snapshots) != 0 {
+		t.Fatalf("snapshot should be deleted from the backend, left %v", fake.snapshots)
+	}
+	kept, err := c.controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap-0002", SourceVolumeId: volumeID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: volumeID, TargetPath: target}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: volumeID, StagingTargetPath: staging}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(mounts) != 0 || len(fake.attached) != 0 {
+		t.Fatalf("volume should be unmounted and detached, mounts %v, attached %v", mounts, fake.attached)
+	}
+	if _, err := c.controller.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: volumeID, NodeId: testNodeID}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+			t.Fatalf("DeleteVolume should be idempotent: %v", err)
+		}
+	}
+	list, err := c.controller.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil || len(list.GetEntries()) != 0 {
+		t.Fatalf("no volume should be left: %v, %v", list, err)
+	}
+
+	// the snapshot outlives its volume until deleted
+	if _, err := c.controller.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: kept.GetSnapshot().GetSnapshotId()}); err != nil {
+		t.Fatalf("snapshot of a deleted volume should be deleted: %v", err)
+	}
+	if _, err := metadata.GetSnapshot("snap-0002", metadata.CEPH); !isNotFound(err) {
+		t.Fatalf("snapshot record should be gone, got %v", err)
+	}
+}
+
+func TestCSISingleWriter(t *testing.T) {
+	c, _, cleanup := setupCSI(t, nil)
+	defer cleanup()
+	ctx := context.Background()
+	writer := mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+
+	_, err := c.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "pvc-0002",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+	})
+	expectCode(t, err, codes.InvalidArgument, "CreateVolume with multi writer")
+
+	created, err := c.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "pvc-0002",
+		VolumeCapabilities: []*csi.VolumeCapability{writer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.GetVolume().GetCapacityBytes() != 1024*MB {
+		t.Fatalf("default size should be used, got %d", created.GetVolume().GetCapacityBytes())
+	}
+	volumeID := created.GetVolume().GetVolumeId()
+
+	if err := metadata.AddHost("10.0.0.2", metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volumeID, NodeId: testNodeID, VolumeCapability: writer,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volumeID, NodeId: "10.0.0.2", VolumeCapability: writer,
+	})
+	expectCode(t, err, codes.FailedPrecondition, "ControllerPublishVolume of a second writer")
+	_, err = c.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volumeID, NodeId: testNodeID, VolumeCapability: writer, Readonly: true,
+	})
+	expectCode(t, err, codes.AlreadyExists, "ControllerPublishVolume with another mode")
+
+	_, err = c.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: "CEPH/missing", NodeId: testNodeID, VolumeCapability: writer,
+	})
+	expectCode(t, err, codes.NotFound, "ControllerPublishVolume of a missing volume")
+}
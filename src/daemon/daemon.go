@@ -10,10 +10,16 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"api"
+	"driver"
+	_ "driver/ceph"
+	_ "driver/cephfs"
+	_ "driver/san"
 	"meta"
 	"store/etcd"
+	"util"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
@@ -24,13 +30,25 @@ type daemon struct {
 	Router     *mux.Router
 	GlobalLock *sync.RWMutex
 	PendingOps *metadata.PendingSet
+	Drivers    map[string]driver.VolumeDriver
 	daemonConfig
+
+	migrateLock sync.Mutex
+	migrations  map[string]*migration
+
+	opLock     sync.Mutex
+	operations map[string]*operation
+	opQueue    chan *operation
 }
 
 const (
 	CFG_POSTFIX = ".json"
 	CONFIGFILE  = "policy.cfg"
 	LOCKFILE    = "lock"
+
+	BACKUP_TARGET = "backups"
+
+	HTTP_PORT = 9876
 )
 
 var (
@@ -44,6 +62,33 @@ type daemonConfig struct {
 	Root       string
 	HostList   []string
 	MasterNode string
+	DriverList []string
+	DriverOpts map[string]string
+
+	DefaultBackend    string
+	DefaultVolumeSize string
+
+	CSISocket string
+	CSINodeID string
+
+	DockerSocket      string
+	ReconcileInterval int
+
+	CephMonitors      []string
+	DiscoveryInterval int
+
+	BackupTarget string
+	BackupOpts   map[string]string
+
+	AgentIP           string
+	AgentDevices      []string
+	AgentBackend      string
+	AgentPort         int
+	HeartbeatInterval int
+	HeartbeatTimeout  int
+
+	OperationWorkers     int
+	IdempotencyRetention int
 }
 
 func (c *daemonConfig) ConfigFile() (string, error) {
@@ -71,9 +116,14 @@ func makeHandlerFunc(method string, route string, version string, f requestHandl
 				return
 			}
 		}
-		if err := f(version, w, r, mux.Vars(r)); err != nil {
+		buf := newResponseBuffer()
+		if err := f(version, buf, r, mux.Vars(r)); err != nil {
 			log.Errorf("Handler for %s %s returned error: %s", method, route, err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, metadata.EcodeParameterError, err.Error())
+			return
+		}
+		if err := writeResponse(w, r, buf); err != nil {
+			log.Errorf("Response for %s %s error: %s", method, route, err)
 		}
 	}
 }
@@ -82,30 +132,79 @@ func createRouter(s *daemon) *mux.Router {
 	router := mux.NewRouter()
 	m := map[string]map[string]requestHandler{
 		"GET": {
-			"/volume/":     s.doVolumeGet,
-			"/volume/list": s.doVolumeList,
-			"/host/":       s.doHostGet,
-			"/host/list":   s.doHostList,
-			"/device/":     s.doDeviceGet,
-			"/device/list": s.doDeviceList,
+			"/volume/":        s.doVolumeGet,
+			"/volume/list":    s.doVolumeList,
+			"/host/":          s.doHostGet,
+			"/host/list":      s.doHostList,
+			"/device/":        s.doDeviceGet,
+			"/device/list":    s.doDeviceList,
+			"/container/":     s.doContainerGet,
+			"/container/list": s.doContainerList,
+			"/backup/list":    s.doBackupList,
+			"/volume/migrate": s.doVolumeMigrateGet,
+			"/event/list":     s.doEventList,
+			"/meta/export":    s.doMetaExport,
+			"/meta/schema":    s.doMetaSchema,
+			"/operation/{id}": s.doOperationGet,
+			"/errors":         s.doErrorList,
 		},
 		"POST": {
-			"/volume/create": s.doVolumeCreate,
-			"/volume/attach": s.doVolumeAttach,
-			"/volume/detach": s.doVolumeDetach,
-			"/host/add":      s.doHostAdd,
-			"/device/add":    s.doDeviceAdd,
+			"/volume/create":  s.doVolumeCreate,
+			"/volume/attach":  s.doVolumeAttach,
+			"/volume/detach":  s.doVolumeDetach,
+			"/volume/fence":   s.doVolumeFence,
+			"/volume/migrate": s.doVolumeMigrate,
+			"/host/add":       s.doHostAdd,
+			"/host/drain":     s.doHostDrain,
+			"/host/failover":  s.doHostFailover,
+			"/host/heartbeat": s.doHostHeartbeat,
+			"/host/resume":    s.doHostResume,
+			"/device/add":     s.doDeviceAdd,
+			"/device/drain":   s.doDeviceDrain,
+			"/device/resume":  s.doDeviceResume,
+			"/container/add":  s.doContainerAdd,
+			"/backup/create":  s.doBackupCreate,
+			"/backup/restore": s.doBackupRestore,
+			"/meta/fsck":      s.doFsck,
+			"/meta/import":    s.doMetaImport,
+
+			"/Plugin.Activate":           s.dockerActivate,
+			"/VolumeDriver.Create":       s.dockerCreateVolume,
+			"/VolumeDriver.Remove":       s.dockerRemoveVolume,
+			"/VolumeDriver.Mount":        s.dockerMountVolume,
+			"/VolumeDriver.Unmount":      s.dockerUnmountVolume,
+			"/VolumeDriver.Path":         s.dockerVolumePath,
+			"/VolumeDriver.Get":          s.dockerGetVolume,
+			"/VolumeDriver.List":         s.dockerListVolume,
+			"/VolumeDriver.Capabilities": s.dockerCapabilities,
 		},
 		"DELETE": {
-			"/volume/": s.doVolumeDelete,
-			"/host/":   s.doHostDel,
-			"/device/": s.doDeviceDel,
+			"/volume/":    s.doVolumeDelete,
+			"/host/":      s.doHostDel,
+			"/device/":    s.doDeviceDel,
+			"/container/": s.doContainerDel,
+			"/backup/":    s.doBackupDelete,
 		},
 	}
 
+	// the resource api goes first, the first api taking any version
+	for _, rt := range s.v2Routes() {
+		rt := rt
+		log.Debugf("Registering %s, /v%s%s", rt.method, api.API_V2_VERSION, rt.path)
+		f := requestHandler(rt.serve)
+		if rt.method != "GET" {
+			f = s.idempotent(rt.method, rt.path, f)
+		}
+		handler := makeHandlerFunc(rt.method, rt.path, api.API_V2_VERSION, f)
+		router.Path("/v" + api.API_V2_VERSION + rt.path).Methods(rt.method).HandlerFunc(handler)
+	}
+
 	for method, routes := range m {
 		for route, f := range routes {
 			log.Debugf("Registering %s, %s", method, route)
+			if method != "GET" {
+				f = s.idempotent(method, route, f)
+			}
 			handler := makeHandlerFunc(method, route, api.API_VERSION, f)
 			router.Path("/v{version:[0-9.]+}" + route).Methods(method).HandlerFunc(handler)
 			router.Path(route).Methods(method).HandlerFunc(handler)
@@ -116,6 +215,27 @@ func createRouter(s *daemon) *mux.Router {
 	return router
 }
 
+func (s *daemon) initDrivers() error {
+	// drivers name this host the way its owner records do
+	opts := map[string]string{driver.HOST_OPT: s.hostIP()}
+	for k, v := range s.DriverOpts {
+		opts[k] = v
+	}
+
+	s.Drivers = make(map[string]driver.VolumeDriver)
+	for _, name := range s.DriverList {
+		if !metadata.ValidDriverName(name) {
+			return fmt.Errorf("Invalid driver name %v", name)
+		}
+		d, err := driver.GetDriver(name, s.Root, opts)
+		if err != nil {
+			return err
+		}
+		s.Drivers[name] = d
+	}
+	return nil
+}
+
 func daemonEnvironmentSetup(c *cli.Context) error {
 	var err error
 
@@ -204,10 +324,91 @@ func Start(sockFile string, c *cli.Context) error {
 
 		config.HostList = hostList
 		config.MasterNode = hostList[0]
+
+		driverOpts := SliceToMap(c.StringSlice("driver-opts"))
+		if driverOpts == nil {
+			return fmt.Errorf("Invalid driver options")
+		}
+		config.DriverList = c.StringSlice("drivers")
+		config.DriverOpts = driverOpts
+
+		config.DefaultBackend = c.String("default-backend")
+		if !metadata.ValidBackend(config.DefaultBackend) {
+			return fmt.Errorf("Invalid default backend %v", config.DefaultBackend)
+		}
+		config.DefaultVolumeSize = c.String("default-volume-size")
+
+		config.CSISocket = c.String("csi-socket")
+		config.CSINodeID = c.String("csi-node-id")
+		if config.CSISocket != "" && !util.ValidIPAddr(config.CSINodeID) {
+			return fmt.Errorf("csi-node-id must be the ip of this host to serve CSI")
+		}
+
+		config.DockerSocket = c.String("docker-socket")
+		config.ReconcileInterval = c.Int("reconcile-interval")
+		if config.ReconcileInterval <= 0 {
+			return fmt.Errorf("Invalid reconcile interval %v", config.ReconcileInterval)
+		}
+
+		config.CephMonitors = c.StringSlice("ceph-monitors")
+		for _, monitor := range config.CephMonitors {
+			if _, _, err := parseMonitor(monitor); err != nil {
+				return fmt.Errorf("Invalid ceph monitor %v", monitor)
+			}
+		}
+		config.DiscoveryInterval = c.Int("discovery-interval")
+		if config.DiscoveryInterval <= 0 {
+			return fmt.Errorf("Invalid discovery interval %v", config.DiscoveryInterval)
+		}
+
+		config.BackupTarget = c.String("backup-target")
+		if config.BackupTarget == "" {
+			config.BackupTarget = filepath.Join(root, BACKUP_TARGET)
+		}
+		config.BackupOpts = SliceToMap(c.StringSlice("backup-opts"))
+		if config.BackupOpts == nil {
+			return fmt.Errorf("Invalid backup options")
+		}
+
+		config.AgentIP = c.String("agent-ip")
+		if config.AgentIP != "" && !util.ValidIPAddr(config.AgentIP) {
+			return fmt.Errorf("Invalid agent ip %v", config.AgentIP)
+		}
+		config.AgentDevices = c.StringSlice("agent-devices")
+		for _, pattern := range config.AgentDevices {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("Invalid agent device pattern %v", pattern)
+			}
+		}
+		config.AgentBackend = c.String("agent-backend")
+		if !metadata.ValidBackend(config.AgentBackend) {
+			return fmt.Errorf("Invalid agent backend %v", config.AgentBackend)
+		}
+		config.AgentPort = c.Int("agent-port")
+		config.HeartbeatInterval = c.Int("heartbeat-interval")
+		if config.HeartbeatInterval <= 0 {
+			return fmt.Errorf("Invalid heartbeat interval %v", config.HeartbeatInterval)
+		}
+		config.HeartbeatTimeout = c.Int("heartbeat-timeout")
+		if config.HeartbeatTimeout < 0 {
+			return fmt.Errorf("Invalid heartbeat timeout %v", config.HeartbeatTimeout)
+		}
+		config.OperationWorkers = c.Int("operation-workers")
+		if config.OperationWorkers <= 0 {
+			return fmt.Errorf("Invalid operation workers %v", config.OperationWorkers)
+		}
+		config.IdempotencyRetention = c.Int("idempotency-retention")
+		if config.IdempotencyRetention <= 0 {
+			return fmt.Errorf("Invalid idempotency retention %v", config.IdempotencyRetention)
+		}
 	}
 
 	s.daemonConfig = *config
 
+	if err := s.initDrivers(); err != nil {
+		return err
+	}
+
 	s.GlobalLock = &sync.RWMutex{}
 
 	if err := ObjectSave(config); err != nil {
@@ -241,7 +442,40 @@ func Start(sockFile string, c *cli.Context) error {
 	daemonMetadataSetup(s)
 	log.Debug("bbbbbbbbbb")
 
-	ln, err := net.Listen("tcp", ":9876")
+	if err := metadata.CheckSchema(); err != nil {
+		return err
+	}
+	mg := s.startMigrator(metadata.SCHEMA_BATCH, DEFAULT_MIGRATOR_INTERVAL)
+	defer mg.Stop()
+
+	wp := s.startWorkers(s.OperationWorkers)
+	defer wp.Stop()
+	s.resumeOperations()
+
+	if s.CSISocket != "" {
+		csiServer, err := s.startCSIServer(s.CSISocket)
+		if err != nil {
+			return err
+		}
+		defer csiServer.Stop()
+	}
+
+	if s.DockerSocket != "" {
+		rc := s.startReconciler(s.DockerSocket, time.Duration(s.ReconcileInterval)*time.Second)
+		defer rc.Stop()
+	}
+
+	if s.HeartbeatTimeout > 0 {
+		dt := s.startDetector(time.Duration(s.HeartbeatTimeout)*time.Second, s.failover)
+		defer dt.Stop()
+	}
+
+	if len(s.CephMonitors) != 0 {
+		dc := s.startDiscovery(s.CephMonitors, time.Duration(s.DiscoveryInterval)*time.Second)
+		defer dc.Stop()
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", HTTP_PORT))
 	if err != nil {
 		fmt.Println("listen err", err)
 		return err
@@ -270,6 +504,11 @@ func Start(sockFile string, c *cli.Context) error {
 		done <- true
 	}()
 
+	if s.AgentIP != "" {
+		ag := s.startAgent()
+		defer ag.Stop()
+	}
+
 	<-done
 	return nil
 }
@@ -0,0 +1,282 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"api"
+	"meta"
+	"meta/proto"
+)
+
+const (
+	DEFAULT_DETECTOR_INTERVAL = 10 * time.Second
+	// a host changes status once this many checks in a row agree, one late
+	// heartbeat or one heartbeat of a dying host changes nothing
+	DETECTOR_HYSTERESIS = 2
+	// checks a leader can miss before another daemon takes the lead
+	LEADER_LEASE_CHECKS = 3
+)
+
+// hostTransition is a change of status made by the failure detector.
+type hostTransition struct {
+	ip     string
+	status int
+}
+
+// detector marks offline the hosts whose heartbeats are older than the
+// timeout, with their devices, and brings them back once heartbeats are
+// fresh again. Only hosts that sent a heartbeat once are watched, hosts
+// added by hand have no agent. It runs on the leader only.
+type detector struct {
+	id       string
+	timeout  time.Duration
+	interval time.Duration
+
+	// checks in a row a host was found stale, or fresh while offline
+	stale map[string]int
+	fresh map[string]int
+
+	// called out of the metadata lock with the transitions of a check
+	notify func([]hostTransition)
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newDetector(id string, timeout time.Duration, interval time.Duration) *detector {
+	if interval <= 0 {
+		interval = DEFAULT_DETECTOR_INTERVAL
+	}
+	return &detector{
+		id:       id,
+		timeout:  timeout,
+		interval: interval,
+		stale:    make(map[string]int),
+		fresh:    make(map[string]int),
+		stop:     make(chan struct{}),
+	}
+}
+
+// hostIP is the ip of the host of this daemon as known to the cluster,
+// empty when neither the agent nor CSI is configured.
+func (s *daemon) hostIP() string {
+	if s.AgentIP != "" {
+		return s.AgentIP
+	}
+	return s.CSINodeID
+}
+
+// nodeName names this daemon among the daemons of the cluster.
+func (s *daemon) nodeName() string {
+	if ip := s.hostIP(); ip != "" {
+		return ip
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+func (s *daemon) startDetector(timeout time.Duration, notify func([]hostTransition)) *detector {
+	dt := newDetector(s.nodeName(), timeout, DEFAULT_DETECTOR_INTERVAL)
+	dt.notify = notify
+
+	dt.wg.Add(1)
+	go dt.run()
+
+	log.Debugf("Detecting hosts without heartbeat for %v", timeout)
+	return dt
+}
+
+func (dt *detector) Stop() {
+	close(dt.stop)
+	dt.wg.Wait()
+}
+
+func (dt *detector) run() {
+	defer dt.wg.Done()
+
+	ticker := time.NewTicker(dt.interval)
+	defer ticker.Stop()
+	for {
+		transitions, err := dt.check(time.Now())
+		if err != nil {
+			log.Warnf("[detector] check hosts error: %s", err.Error())
+		}
+		if len(transitions) != 0 && dt.notify != nil {
+			dt.notify(transitions)
+		}
+
+		select {
+		case <-dt.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check looks at the heartbeat of every host once, if this daemon leads.
+func (dt *detector) check(now time.Time) ([]hostTransition, error) {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	lease := int(dt.interval/time.Second) * LEADER_LEASE_CHECKS
+	leader, err := metadata.AcquireLeader(dt.id, lease, int(now.Unix()))
+	if err != nil {
+		return nil, err
+	}
+	if !leader {
+		// whatever was counted is stale once the lead comes back
+		dt.stale = make(map[string]int)
+		dt.fresh = make(map[string]int)
+		return nil, nil
+	}
+
+	hosts, err := metadata.ListHostsName()
+	if err != nil {
+		return nil, err
+	}
+	transitions := []hostTransition{}
+	for _, ip := range hosts {
+		hs, err := metadata.GetHost(ip)
+		if err != nil {
+			log.Warnf("[detector] get host %s error: %s", ip, err.Error())
+			continue
+		}
+		optime, err := metadata.HostOptime(hs)
+		status, _ := metadata.HostStatus(hs)
+		if err != nil || status == metadata.HOST_MAINTENANCE {
+			delete(dt.stale, ip)
+			delete(dt.fresh, ip)
+			continue
+		}
+
+		age := now.Unix() - int64(optime)
+		stale := age > int64(dt.timeout/time.Second)
+		switch {
+		case stale && status != metadata.HOST_OFFLINE:
+			delete(dt.fresh, ip)
+			if dt.stale[ip]++; dt.stale[ip] < DETECTOR_HYSTERESIS {
+				continue
+			}
+			delete(dt.stale, ip)
+			if err := setHostOffline(hs, fmt.Sprintf("no heartbeat for %ds", age)); err != nil {
+				log.Errorf("[detector] set host %s offline error: %s", ip, err.Error())
+				continue
+			}
+			transitions = append(transitions, hostTransition{ip: ip, status: metadata.HOST_OFFLINE})
+		case !stale && status == metadata.HOST_OFFLINE:
+			delete(dt.stale, ip)
+			if dt.fresh[ip]++; dt.fresh[ip] < DETECTOR_HYSTERESIS {
+				continue
+			}
+			delete(dt.fresh, ip)
+			if err := setHostOnline(hs, fmt.Sprintf("heartbeat %ds ago", age)); err != nil {
+				log.Errorf("[detector] set host %s online error: %s", ip, err.Error())
+				continue
+			}
+			transitions = append(transitions, hostTransition{ip: ip, status: metadata.HOST_ONLINE})
+		default:
+			delete(dt.stale, ip)
+			delete(dt.fresh, ip)
+		}
+	}
+	return transitions, nil
+}
+
+// setHostOffline takes a host and its devices offline, devices in
+// maintenance stay so.
+func setHostOffline(hs *metaproto.Host, reason string) error {
+	ip := string(hs.Ip)
+	for _, key := range hs.Devices {
+		devid, backend := metadata.ParseDeviceKey(string(key))
+		status, err := metadata.GetDeviceStatus(devid, backend)
+		if err != nil || status == metadata.DEVICE_OFFLINE || status == metadata.DEVICE_MAINTENANCE {
+			continue
+		}
+		if err := metadata.UpdateDeviceStatus(devid, metadata.DEVICE_OFFLINE, backend, 0); err != nil {
+			return err
+		}
+		metadata.AddEvent(metadata.EVENT_DEVICE_OFFLINE, devid, "host "+ip+" offline")
+	}
+	if err := metadata.ModHostStatus(ip, metadata.HOST_OFFLINE); err != nil {
+		return err
+	}
+	return metadata.AddEvent(metadata.EVENT_HOST_OFFLINE, ip, reason)
+}
+
+// setHostOnline brings a host and its offline devices back.
+func setHostOnline(hs *metaproto.Host, reason string) error {
+	ip := string(hs.Ip)
+	for _, key := range hs.Devices {
+		devid, backend := metadata.ParseDeviceKey(string(key))
+		dv, err := metadata.GetDevice(devid, backend)
+		if err != nil {
+			continue
+		}
+		if status, _ := metadata.DeviceStatus(dv); status != metadata.DEVICE_OFFLINE {
+			continue
+		}
+		status := metadata.DEVICE_READY
+		if len(dv.Volumekey) != 0 {
+			status = metadata.DEVICE_INUSE
+		}
+		if err := metadata.UpdateDeviceStatus(devid, status, backend, 0); err != nil {
+			return err
+		}
+		metadata.AddEvent(metadata.EVENT_DEVICE_ONLINE, devid, "host "+ip+" online")
+	}
+	if err := metadata.ModHostStatus(ip, metadata.HOST_ONLINE); err != nil {
+		return err
+	}
+	return metadata.AddEvent(metadata.EVENT_HOST_ONLINE, ip, reason)
+}
+
+func (s *daemon) doEventList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.EventListRequest{}
+	resp := &api.EventListResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		events, err := metadata.ListEvents()
+		if err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		for _, ev := range events {
+			if req.Object != "" && string(ev.Object) != req.Object {
+				continue
+			}
+			resp.Events = append(resp.Events, api.EventResponse{
+				ID:      string(ev.Id),
+				Kind:    string(ev.Kind),
+				Object:  string(ev.Object),
+				Message: string(ev.Message),
+				Created: string(ev.Optime),
+			})
+		}
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
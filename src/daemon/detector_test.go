@@ -0,0 +1,113 @@
+package daemon
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"api"
+	"meta"
+	"store/memory"
+)
+
+func TestDetector(t *testing.T) {
+	memory.NewStore()
+	for _, host := range []string{testNodeID, "10.0.0.2"} {
+		if err := metadata.AddHost(host, metadata.HOST_ONLINE, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dev := range []string{"dev001", "dev002"} {
+		if err := metadata.AddDevice(dev, testNodeID, 3260, 10240, 10240, metadata.DEVICE_READY, "iqn", metadata.SAN); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := metadata.UseDevice("dev001", metadata.SAN, "vol001"); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	metadata.HostHeartbeat(testNodeID, int(start.Unix()))
+	dt := newDetector("node1", 90*time.Second, 10*time.Second)
+
+	check := func(dt *detector, now time.Time) []hostTransition {
+		transitions, err := dt.check(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return transitions
+	}
+	status := func(ip string) int {
+		hs, _ := metadata.GetHost(ip)
+		st, _ := metadata.BytesToInteger(hs.Status)
+		return st
+	}
+	devStatus := func(dev string) int {
+		st, _ := metadata.GetDeviceStatus(dev, metadata.SAN)
+		return st
+	}
+
+	if tr := check(dt, at(10)); len(tr) != 0 {
+		t.Fatalf("fresh host should stay online, got %v", tr)
+	}
+	// one stale check is not enough
+	if tr := check(dt, at(100)); len(tr) != 0 || status(testNodeID) != metadata.HOST_ONLINE {
+		t.Fatalf("host should not go offline at the first stale check, got %v", tr)
+	}
+	tr := check(dt, at(110))
+	if len(tr) != 1 || tr[0].ip != testNodeID || tr[0].status != metadata.HOST_OFFLINE {
+		t.Fatalf("stale host should go offline, got %v", tr)
+	}
+	if status(testNodeID) != metadata.HOST_OFFLINE || devStatus("dev001") != metadata.DEVICE_OFFLINE || devStatus("dev002") != metadata.DEVICE_OFFLINE {
+		t.Fatal("host and its devices should be offline")
+	}
+	if status("10.0.0.2") != metadata.HOST_ONLINE {
+		t.Fatal("host without heartbeat should not be watched")
+	}
+
+	// another daemon waits for the lease to end
+	other := newDetector("node2", 90*time.Second, 10*time.Second)
+	metadata.HostHeartbeat(testNodeID, int(at(120).Unix()))
+	if tr := check(other, at(125)); len(tr) != 0 {
+		t.Fatalf("only the leader should check, got %v", tr)
+	}
+	if tr := check(other, at(145)); len(tr) != 0 || status(testNodeID) != metadata.HOST_OFFLINE {
+		t.Fatalf("host should not come back at the first fresh check, got %v", tr)
+	}
+	tr = check(other, at(150))
+	if len(tr) != 1 || tr[0].status != metadata.HOST_ONLINE {
+		t.Fatalf("host with fresh heartbeats should come back, got %v", tr)
+	}
+	if status(testNodeID) != metadata.HOST_ONLINE || devStatus("dev001") != metadata.DEVICE_INUSE || devStatus("dev002") != metadata.DEVICE_READY {
+		t.Fatal("host and its devices should be back")
+	}
+	if tr := check(dt, at(160)); len(tr) != 0 {
+		t.Fatalf("old leader should not check anymore, got %v", tr)
+	}
+
+	// hosts in maintenance are left alone
+	if err := metadata.DrainHost(testNodeID); err != nil {
+		t.Fatal(err)
+	}
+	check(other, at(300))
+	check(other, at(310))
+	if status(testNodeID) != metadata.HOST_MAINTENANCE {
+		t.Fatal("host in maintenance should not go offline")
+	}
+
+	s := &daemon{}
+	resp := &api.EventListResponse{}
+	callHandler(t, s.doEventList, "GET", &api.EventListRequest{Object: testNodeID}, resp)
+	if resp.Result != "0" || len(resp.Events) != 2 || resp.Events[0].Kind != metadata.EVENT_HOST_OFFLINE || resp.Events[1].Kind != metadata.EVENT_HOST_ONLINE {
+		t.Fatalf("host should have gone offline then online, got %+v", resp)
+	}
+	resp = &api.EventListResponse{}
+	callHandler(t, s.doEventList, "GET", &api.EventListRequest{}, resp)
+	if len(resp.Events) != 6 {
+		t.Fatalf("device events should be recorded too, got %+v", resp.Events)
+	}
+	if _, err := strconv.Atoi(resp.Events[0].Created); err != nil {
+		t.Fatalf("event should carry its time, got %q", resp.Events[0].Created)
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"api"
 	"meta"
+	"meta/proto"
 )
 
 func (s *daemon) doDeviceGet(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
@@ -25,15 +26,11 @@ func (s *daemon) doDeviceGet(version string, w http.ResponseWriter, r *http.Requ
 
 		dv, err := metadata.GetDevice(req.ID, req.Backend)
 		if err != nil {
-			result = (err).(*metadata.Error).Code
+			result = errorResult(w, err)
 			break
 		}
 
-		resp.ID = string(dv.Id)
-		resp.IP = string(dv.Host)
-		resp.Capacity = string(dv.Total)
-		resp.Resource = string(dv.Identify)
-
+		deviceResponse(dv, resp)
 		break
 	}
 
@@ -48,6 +45,16 @@ func (s *daemon) doDeviceGet(version string, w http.ResponseWriter, r *http.Requ
 	return err
 }
 
+// deviceResponse tells what a device is, for the requests reading it.
+func deviceResponse(dv *metaproto.Device, resp *api.DeviceResponse) {
+	resp.ID = string(dv.Id)
+	resp.Backend = string(dv.Backend)
+	resp.IP = string(dv.Host)
+	resp.Status = metadata.DeviceStates.NameBytes(dv.Status)
+	resp.Capacity = string(dv.Total)
+	resp.Resource = string(dv.Identify)
+}
+
 func (s *daemon) doDeviceList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
 	metadata.Lock()
 	defer metadata.Unlock()
@@ -64,7 +71,7 @@ func (s *daemon) doDeviceList(version string, w http.ResponseWriter, r *http.Req
 
 		devices, err := metadata.ListDevices(req.Backend)
 		if err != nil {
-			result = (err).(*metadata.Error).Code
+			result = errorResult(w, err)
 			break
 		}
 		fmt.Println(devices)
@@ -100,7 +107,7 @@ func (s *daemon) doDeviceAdd(version string, w http.ResponseWriter, r *http.Requ
 
 		err := metadata.AddDevice(req.ID, req.Ip, req.Port, req.Total, req.Free, req.Status, req.Resource, req.Backend)
 		if err != nil {
-			result = (err).(*metadata.Error).Code
+			result = errorResult(w, err)
 			break
 		}
 
@@ -135,7 +142,7 @@ func (s *daemon) doDeviceDel(version string, w http.ResponseWriter, r *http.Requ
 
 		err := metadata.DelDevice(req.ID, req.Backend)
 		if err != nil {
-			result = (err).(*metadata.Error).Code
+			result = errorResult(w, err)
 			break
 		}
 
@@ -0,0 +1,253 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"cephclient"
+	"driver/ceph"
+	"meta"
+	"meta/proto"
+)
+
+const (
+	DEFAULT_DISCOVERY_INTERVAL = 60 * time.Second
+)
+
+var (
+	// overridden by tests
+	newCephClient = func(host string, port int) (cephclient.CephClient, error) {
+		return cephclient.NewCephClient(host, port)
+	}
+)
+
+// discoverer keeps one CEPH device per pool of every monitored cluster with
+// the capacity the cluster reports for the pool. Devices of pools that disappeared go
+// offline, they come back with their pool.
+type discoverer struct {
+	monitors []string
+	interval time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (s *daemon) startDiscovery(monitors []string, interval time.Duration) *discoverer {
+	if interval <= 0 {
+		interval = DEFAULT_DISCOVERY_INTERVAL
+	}
+	dc := &discoverer{
+		monitors: monitors,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+
+	dc.wg.Add(1)
+	go dc.run()
+
+	log.Debugf("Discovering ceph pools of monitors %v", monitors)
+	return dc
+}
+
+func (dc *discoverer) Stop() {
+	close(dc.stop)
+	dc.wg.Wait()
+}
+
+func (dc *discoverer) run() {
+	defer dc.wg.Done()
+
+	ticker := time.NewTicker(dc.interval)
+	defer ticker.Stop()
+	for {
+		for _, monitor := range dc.monitors {
+			if err := discoverPools(monitor); err != nil {
+				log.Warnf("[discoverer] discover pools of %s error: %s", monitor, err.Error())
+			}
+		}
+
+		select {
+		case <-dc.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func parseMonitor(monitor string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(monitor)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid monitor port %s", portStr)
+	}
+	return host, port, nil
+}
+
+// poolDeviceID names the device discovered for a pool, the monitor keeps
+// pools of different clusters apart.
+func poolDeviceID(pool string, host string) string {
+	return pool + "@" + host
+}
+
+// poolCapacity gives a pool what it can still take on top of what it
+// already uses, as the cluster counts it for the copies of the pool. The
+// capacity of its volumes is promised even before images are written.
+func poolCapacity(pool cephclient.PoolStat, provisioned int) (int, int) {
+	total := int(pool.Used + pool.MaxAvail)
+	free := total - provisioned
+	if free > int(pool.MaxAvail) {
+		free = int(pool.MaxAvail)
+	}
+	if free < 0 {
+		free = 0
+	}
+	return total, free
+}
+
+// provisioned sums the capacity of the volumes of every CEPH device.
+func provisioned() map[string]int {
+	sums := map[string]int{}
+	names, err := metadata.ListVolumesName(metadata.CEPH)
+	if err != nil {
+		return sums
+	}
+	for _, name := range names {
+		vl, err := metadata.GetVolume(name, metadata.CEPH)
+		if err != nil {
+			continue
+		}
+		capacity, _ := metadata.VolumeCapacity(vl)
+		for _, ad := range vl.Devices {
+			sums[string(ad.Deviceid)] += capacity
+		}
+	}
+	return sums
+}
+
+// discoverPools queries the cluster behind monitor, then updates the
+// devices of its pools under the metadata lock.
+func discoverPools(monitor string) error {
+	host, port, err := parseMonitor(monitor)
+	if err != nil {
+		return err
+	}
+
+	c, err := newCephClient(host, port)
+	if err != nil {
+		return err
+	}
+	defer c.Destroy()
+
+	pools, err := c.ListPools()
+	if err != nil {
+		return err
+	}
+	stats := map[string]cephclient.PoolStat{}
+	for _, pool := range pools {
+		stat, err := c.PoolStats(pool)
+		if err != nil {
+			// deleted meanwhile, the next round marks it offline
+			log.Warnf("[discoverPools] stat pool %s error: %s", pool, err.Error())
+			continue
+		}
+		stats[pool] = stat
+	}
+
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	return syncPoolDevices(host, port, stats)
+}
+
+func syncPoolDevices(host string, port int, stats map[string]cephclient.PoolStat) error {
+	if _, err := metadata.GetHost(host); err != nil {
+		if e, ok := err.(*metadata.Error); !ok || e.Code != metadata.EcodeHostNotFound {
+			return err
+		}
+		if err := metadata.AddHost(host, metadata.HOST_ONLINE, nil); err != nil {
+			return err
+		}
+	}
+
+	ids, err := metadata.ListDevicesName(metadata.CEPH)
+	if err != nil {
+		return err
+	}
+
+	known := map[string]bool{}
+	used := provisioned()
+	for _, id := range ids {
+		dev, err := metadata.GetDevice(id, metadata.CEPH)
+		if err != nil || !monitoredBy(dev, host, port) {
+			continue
+		}
+		pool := string(dev.Identify)
+		if pool == "" {
+			pool = ceph.DEFAULT_POOL
+		}
+		known[pool] = true
+
+		if err := syncPoolDevice(id, dev, stats, pool, used[id]); err != nil {
+			log.Warnf("[syncPoolDevices] update device %s error: %s", id, err.Error())
+		}
+	}
+
+	for pool, stat := range stats {
+		if known[pool] {
+			continue
+		}
+		total, free := poolCapacity(stat, 0)
+		if total <= 0 {
+			log.Warnf("[syncPoolDevices] pool %s of %s reports no capacity", pool, host)
+			continue
+		}
+		id := poolDeviceID(pool, host)
+		if err := metadata.AddDevice(id, host, port, total, free, metadata.DEVICE_READY, pool, metadata.CEPH); err != nil {
+			log.Warnf("[syncPoolDevices] add device %s error: %s", id, err.Error())
+			continue
+		}
+		log.Infof("[syncPoolDevices] discovered pool %s of %s as device %s", pool, host, id)
+	}
+	return nil
+}
+
+func syncPoolDevice(id string, dev *metaproto.Device, stats map[string]cephclient.PoolStat, pool string, provisioned int) error {
+	status, _ := metadata.DeviceStatus(dev)
+
+	stat, ok := stats[pool]
+	if !ok {
+		// a drained device is left as it is until it is resumed
+		if status == metadata.DEVICE_OFFLINE || status == metadata.DEVICE_MAINTENANCE {
+			return nil
+		}
+		log.Warnf("[syncPoolDevice] pool %s of device %s is gone", pool, id)
+		return metadata.UpdateDeviceStatus(id, metadata.DEVICE_OFFLINE, metadata.CEPH, 0)
+	}
+
+	total, free := poolCapacity(stat, provisioned)
+	if total > 0 {
+		if err := metadata.UpdateDeviceCapacity(id, total, free, metadata.CEPH); err != nil {
+			return err
+		}
+	}
+	if status == metadata.DEVICE_OFFLINE {
+		status = metadata.DEVICE_READY
+		if len(dev.Volumekey) != 0 {
+			status = metadata.DEVICE_INUSE
+		}
+		log.Infof("[syncPoolDevice] pool %s of device %s is back", pool, id)
+		return metadata.UpdateDeviceStatus(id, status, metadata.CEPH, 0)
+	}
+	return nil
+}
+
+func monitoredBy(dev *metaproto.Device, host string, port int) bool {
+	devPort, _ := metadata.DevicePort(dev)
+	return metadata.GetHostIpFromKey(string(dev.Host)) == host && devPort == port
+}
@@ -0,0 +1,115 @@
+package daemon
+
+import (
+	"testing"
+
+	"cephclient"
+	"meta"
+	"meta/proto"
+	"store/memory"
+)
+
+func deviceState(t *testing.T, id string) (int, int, int) {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	dev, err := metadata.GetDevice(id, metadata.CEPH)
+	if err != nil {
+		t.Fatalf("device %s: %v", id, err)
+	}
+	total, _ := metadata.BytesToInteger(dev.Total)
+	free, _ := metadata.BytesToInteger(dev.Free)
+	status, _ := metadata.BytesToInteger(dev.Status)
+	return total, free, status
+}
+
+func TestDiscoverPools(t *testing.T) {
+	memory.NewStore()
+	cluster := cephclient.NewFakeCluster()
+	cluster.SetCapacity(10240)
+	// fast keeps two copies of its data
+	cluster.SetPoolSize("fast", 2)
+	admin := cluster.Connect()
+	for _, pool := range []string{"rbd", "fast"} {
+		if err := admin.CreatePool(pool); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldNewCephClient := newCephClient
+	newCephClient = func(host string, port int) (cephclient.CephClient, error) {
+		return cluster.Connect(), nil
+	}
+	defer func() { newCephClient = oldNewCephClient }()
+
+	monitor := "10.0.0.9:6789"
+	if err := discoverPools(monitor); err != nil {
+		t.Fatal(err)
+	}
+	if total, free, status := deviceState(t, poolDeviceID("rbd", "10.0.0.9")); total != 10240 || free != 10240 || status != metadata.DEVICE_READY {
+		t.Fatalf("pool rbd should be a ready device of the whole cluster, got %d %d %d", total, free, status)
+	}
+	if total, free, _ := deviceState(t, poolDeviceID("fast", "10.0.0.9")); total != 5120 || free != 5120 {
+		t.Fatalf("pool fast should hold half the cluster, got %d %d", total, free)
+	}
+
+	if err := admin.CreateImage("rbd", "vol001", 1024, 22); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.DeletePool("fast"); err != nil {
+		t.Fatal(err)
+	}
+	if err := discoverPools(monitor); err != nil {
+		t.Fatal(err)
+	}
+	if total, free, _ := deviceState(t, poolDeviceID("rbd", "10.0.0.9")); total != 10240 || free != 9216 {
+		t.Fatalf("capacity should follow the cluster, got %d %d", total, free)
+	}
+	if _, _, status := deviceState(t, poolDeviceID("fast", "10.0.0.9")); status != metadata.DEVICE_OFFLINE {
+		t.Fatalf("device of a deleted pool should go offline, got %d", status)
+	}
+
+	if err := admin.CreatePool("fast"); err != nil {
+		t.Fatal(err)
+	}
+	if err := discoverPools(monitor); err != nil {
+		t.Fatal(err)
+	}
+	if _, free, status := deviceState(t, poolDeviceID("fast", "10.0.0.9")); status != metadata.DEVICE_READY || free != 4608 {
+		t.Fatalf("device of a recreated pool should be ready again, got %d %d", free, status)
+	}
+
+	// a pool takes any number of volumes, each promised its capacity
+	rbd := poolDeviceID("rbd", "10.0.0.9")
+	for _, name := range []string{"vol002", "vol003"} {
+		vl := &metaproto.Volume{
+			Id:       []byte(name),
+			Capacity: []byte("2048"),
+			Devices:  []*metaproto.Volume_AttachDevice{{Deviceid: []byte(rbd)}},
+		}
+		metadata.Lock()
+		err := metadata.AddVolume(vl, metadata.CEPH)
+		metadata.Unlock()
+		if err != nil {
+			t.Fatalf("add %s: %v", name, err)
+		}
+	}
+	if _, free, status := deviceState(t, rbd); free != 9216-2*2048 || status != metadata.DEVICE_READY {
+		t.Fatalf("volumes should take their capacity off the pool, got %d %d", free, status)
+	}
+	if err := discoverPools(monitor); err != nil {
+		t.Fatal(err)
+	}
+	if _, free, _ := deviceState(t, rbd); free != 10240-2*2048 {
+		t.Fatalf("discovery should keep the capacity of the volumes, got %d", free)
+	}
+	metadata.Lock()
+	err := metadata.DelVolume("vol002", metadata.CEPH)
+	metadata.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, free, _ := deviceState(t, rbd); free != 10240-2048 {
+		t.Fatalf("a deleted volume should give its capacity back, got %d", free)
+	}
+}
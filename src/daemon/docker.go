@@ -0,0 +1,383 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"api"
+	"meta"
+	"meta/proto"
+	"util"
+)
+
+const (
+	DOCKER_PLUGIN_CONTENT_TYPE = "application/vnd.docker.plugins.v1.2+json"
+
+	DOCKER_OPT_BACKEND = "backend"
+	DOCKER_OPT_SIZE    = "size"
+
+	MOUNTS_DIR      = "mounts"
+	DEFAULT_FS_TYPE = "ext4"
+)
+
+var (
+	// overridden by tests
+	formatDevice     = util.FormatDevice
+	mountDevice      = util.MountDevice
+	bindMount        = util.BindMount
+	unmountDevice    = util.UnmountDevice
+	isMounted        = util.IsMounted
+	getMountDevice   = util.GetMountDevice
+	resizeFilesystem = util.ResizeFilesystem
+)
+
+type pluginInfo struct {
+	Implements []string
+}
+
+type pluginRequest struct {
+	Name string
+	ID   string
+	Opts map[string]string
+}
+
+type pluginVolume struct {
+	Name       string
+	Mountpoint string            `json:",omitempty"`
+	Status     map[string]string `json:",omitempty"`
+}
+
+type pluginCapabilities struct {
+	Scope string
+}
+
+type pluginResponse struct {
+	Mountpoint   string              `json:",omitempty"`
+	Err          string              `json:",omitempty"`
+	Volumes      []*pluginVolume     `json:",omitempty"`
+	Volume       *pluginVolume       `json:",omitempty"`
+	Capabilities *pluginCapabilities `json:",omitempty"`
+}
+
+func writeDockerResponse(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", DOCKER_PLUGIN_CONTENT_TYPE)
+	return json.NewEncoder(w).Encode(v)
+}
+
+func dockerError(w http.ResponseWriter, err error) error {
+	log.Errorf("[docker] %s", err.Error())
+	return writeDockerResponse(w, &pluginResponse{Err: err.Error()})
+}
+
+func decodePluginRequest(r *http.Request) (*pluginRequest, error) {
+	req := &pluginRequest{}
+	if err := decodeRequest(r, req); err != nil {
+		return nil, err
+	}
+	if req.Name == "" {
+		return nil, fmt.Errorf("volume name is required")
+	}
+	return req, nil
+}
+
+// findVolume looks the Docker volume name up in every backend, Docker
+// volume names are global while metadata keys volumes by backend.
+func findVolume(name string) (*metaproto.Volume, string, error) {
+	for _, backend := range metadata.ListBackends() {
+		vl, err := metadata.GetVolume(name, backend)
+		if err == nil {
+			return vl, backend, nil
+		}
+		if e, ok := err.(*metadata.Error); ok && e.Code == metadata.EcodeVolumeNotFound {
+			continue
+		}
+		return nil, "", err
+	}
+	return nil, "", metadata.NewError(metadata.EcodeVolumeNotFound, fmt.Sprintf("volume %s not found", name))
+}
+
+func (s *daemon) volumeMountPoint(backend string, name string) string {
+	return filepath.Join(s.Root, MOUNTS_DIR, backend, name)
+}
+
+func (s *daemon) dockerActivate(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	log.Debug("Handle plugin activate")
+	return writeDockerResponse(w, &pluginInfo{Implements: []string{"VolumeDriver"}})
+}
+
+func (s *daemon) dockerCapabilities(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	return writeDockerResponse(w, &pluginResponse{Capabilities: &pluginCapabilities{Scope: "global"}})
+}
+
+func (s *daemon) dockerCreateVolume(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	req, err := decodePluginRequest(r)
+	if err != nil {
+		return dockerError(w, err)
+	}
+	log.Debugf("Handle docker create volume %v", req)
+
+	if _, _, err := findVolume(req.Name); err == nil {
+		return writeDockerResponse(w, &pluginResponse{})
+	}
+
+	backend := req.Opts[DOCKER_OPT_BACKEND]
+	if backend == "" {
+		backend = s.DefaultBackend
+	}
+	size := req.Opts[DOCKER_OPT_SIZE]
+	if size == "" {
+		size = s.DefaultVolumeSize
+	}
+	capacity, err := util.ParseSizeInMb(size)
+	if err != nil || capacity <= 0 {
+		return dockerError(w, fmt.Errorf("invalid volume size %s", size))
+	}
+
+	createReq := &api.VolumeCreateRequest{
+		VolumeId:   req.Name,
+		DriverName: backend,
+		Capacity:   strconv.Itoa(capacity),
+	}
+	if result := s.processVolumeCreate(createReq, &api.VolumeResponse{}); result != 0 {
+		return dockerError(w, fmt.Errorf("create volume %s on %s failed with code %d", req.Name, backend, result))
+	}
+
+	return writeDockerResponse(w, &pluginResponse{})
+}
+
+func (s *daemon) dockerRemoveVolume(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	req, err := decodePluginRequest(r)
+	if err != nil {
+		return dockerError(w, err)
+	}
+	log.Debugf("Handle docker remove volume %v", req)
+
+	_, backend, err := findVolume(req.Name)
+	if err != nil {
+		return dockerError(w, err)
+	}
+
+	if d := s.getVolumeDriver(backend); d != nil {
+		if err := deleteVolume(d, req.Name, backend); err != nil {
+			return dockerError(w, err)
+		}
+	}
+	if err := metadata.DelVolume(req.Name, backend); err != nil {
+		return dockerError(w, err)
+	}
+	os.Remove(s.volumeMountPoint(backend, req.Name))
+
+	return writeDockerResponse(w, &pluginResponse{})
+}
+
+// dockerMountVolume records the caller as a rw owner of the volume, then
+// attaches and mounts it unless containers of this host already hold the
+// mount, which the caller shares.
+func (s *daemon) dockerMountVolume(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	req, err := decodePluginRequest(r)
+	if err != nil {
+		return dockerError(w, err)
+	}
+	log.Debugf("Handle docker mount volume %v", req)
+
+	if len(req.ID) != metadata.CONTAINER_ID_LENGTH {
+		return dockerError(w, fmt.Errorf("invalid mount id %s, docker 1.12 or later is required", req.ID))
+	}
+
+	vl, backend, err := findVolume(req.Name)
+	if err != nil {
+		return dockerError(w, err)
+	}
+
+	mountPoint := s.volumeMountPoint(backend, req.Name)
+	attached := hostOwners(vl, s.hostIP()) != 0 && isMounted(mountPoint)
+	owner := volumeOwner(vl, req.ID)
+
+	oc := &metaproto.Volume_OwnerContainer{
+		Containerid: []byte(req.ID),
+		Mode:        []byte(metadata.RWVolume),
+		Host:        []byte(s.hostIP()),
+	}
+	// a container sharing the mount of a writer of this host leaves it the
+	// writer, any other meets the check for a second one
+	if attached && len(vl.Writable) != 0 {
+		err = metadata.ShareVolumeContainer(req.Name, oc, backend)
+	} else {
+		err = metadata.SetVolumeContainer(req.Name, oc, backend, false)
+	}
+	if err != nil {
+		return dockerError(w, err)
+	}
+
+	if !attached {
+		if err := s.mountVolume(req.Name, backend, mountPoint); err != nil {
+			if owner == nil {
+				metadata.DelVolumeContainer(req.Name, backend, req.ID)
+			}
+			return dockerError(w, err)
+		}
+	}
+
+	if err := metadata.AddContainerVolume(req.ID, []byte(req.Name), oc.Mode, backend); err != nil {
+		log.Warnf("[docker] record volume %s on container %s error: %s", req.Name, req.ID, err.Error())
+	}
+
+	return writeDockerResponse(w, &pluginResponse{Mountpoint: mountPoint})
+}
+
+func (s *daemon) mountVolume(name string, backend string, mountPoint string) error {
+	d := s.getVolumeDriver(backend)
+	if d == nil {
+		return util.MkdirIfNotExists(mountPoint)
+	}
+
+	dev, err := attachVolume(d, name, backend, metadata.RWVolume)
+	if err != nil {
+		return err
+	}
+	if metadata.SharedBackend(backend) {
+		// the driver mounted the filesystem, dev is its directory
+		if err := bindMount(dev, mountPoint, false); err != nil {
+			detachVolume(d, name, backend)
+			return err
+		}
+		return nil
+	}
+	if err := formatDevice(dev, DEFAULT_FS_TYPE); err != nil {
+		detachVolume(d, name, backend)
+		return err
+	}
+	if err := mountDevice(dev, mountPoint, false); err != nil {
+		detachVolume(d, name, backend)
+		return err
+	}
+	return nil
+}
+
+func (s *daemon) dockerUnmountVolume(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	req, err := decodePluginRequest(r)
+	if err != nil {
+		return dockerError(w, err)
+	}
+	log.Debugf("Handle docker unmount volume %v", req)
+
+	_, backend, err := findVolume(req.Name)
+	if err != nil {
+		return dockerError(w, err)
+	}
+
+	if err := metadata.DelVolumeContainer(req.Name, backend, req.ID); err != nil {
+		return dockerError(w, err)
+	}
+	if err := metadata.DelContainerVolume(req.ID, []byte(req.Name)); err != nil {
+		if e, ok := err.(*metadata.Error); !ok || e.Code != metadata.EcodeContainerNotFound {
+			log.Warnf("[docker] remove volume %s from container %s error: %s", req.Name, req.ID, err.Error())
+		}
+	}
+
+	vl, err := metadata.GetVolume(req.Name, backend)
+	if err != nil {
+		return dockerError(w, err)
+	}
+	if hostOwners(vl, s.hostIP()) != 0 {
+		return writeDockerResponse(w, &pluginResponse{})
+	}
+
+	mountPoint := s.volumeMountPoint(backend, req.Name)
+	if isMounted(mountPoint) {
+		if err := unmountDevice(mountPoint); err != nil {
+			return dockerError(w, err)
+		}
+	}
+	if d := s.getVolumeDriver(backend); d != nil {
+		if err := detachVolume(d, req.Name, backend); err != nil {
+			return dockerError(w, err)
+		}
+	}
+
+	return writeDockerResponse(w, &pluginResponse{})
+}
+
+func (s *daemon) dockerVolumePath(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	req, err := decodePluginRequest(r)
+	if err != nil {
+		return dockerError(w, err)
+	}
+
+	vl, backend, err := findVolume(req.Name)
+	if err != nil {
+		return dockerError(w, err)
+	}
+
+	resp := &pluginResponse{}
+	if len(vl.Containers) != 0 {
+		resp.Mountpoint = s.volumeMountPoint(backend, req.Name)
+	}
+	return writeDockerResponse(w, resp)
+}
+
+func (s *daemon) dockerGetVolume(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	req, err := decodePluginRequest(r)
+	if err != nil {
+		return dockerError(w, err)
+	}
+
+	vl, backend, err := findVolume(req.Name)
+	if err != nil {
+		return dockerError(w, err)
+	}
+
+	volume := &pluginVolume{
+		Name: req.Name,
+		Status: map[string]string{
+			"backend":  backend,
+			"status":   metadata.VolumeStates.Name(metadata.VolumeStatus(vl)),
+			"capacity": string(vl.Capacity),
+			"writable": string(vl.Writable),
+		},
+	}
+	if len(vl.Containers) != 0 {
+		volume.Mountpoint = s.volumeMountPoint(backend, req.Name)
+	}
+	return writeDockerResponse(w, &pluginResponse{Volume: volume})
+}
+
+func (s *daemon) dockerListVolume(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	volumes := []*pluginVolume{}
+	for _, backend := range metadata.ListBackends() {
+		names, err := metadata.ListVolumesName(backend)
+		if err != nil {
+			return dockerError(w, err)
+		}
+		for _, name := range names {
+			volumes = append(volumes, &pluginVolume{Name: name})
+		}
+	}
+
+	return writeDockerResponse(w, &pluginResponse{Volumes: volumes})
+}
@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"api"
+	"driver"
+	"meta"
+	"meta/proto"
+	"store/memory"
+)
+
+func TestDockerSharedMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mounts, restore := setupFakeMounts()
+	defer restore()
+
+	memory.NewStore()
+	if err := metadata.AddHost(testNodeID, metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.AddDevice(testDevice, testNodeID, 6789, 10240, 10240, metadata.DEVICE_READY, "rbd", metadata.CEPH); err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeDriver()
+	s := &daemon{Drivers: map[string]driver.VolumeDriver{metadata.CEPH: fake}, AgentIP: testNodeID}
+	s.Root = dir
+	if result := s.processVolumeCreate(&api.VolumeCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Capacity: "5"}, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("create failed: %d", result)
+	}
+
+	mount := func(id string) string {
+		resp := &pluginResponse{}
+		callHandler(t, s.dockerMountVolume, "POST", &pluginRequest{Name: "vol001", ID: id}, resp)
+		if resp.Err != "" {
+			t.Fatalf("mount %s failed: %s", id, resp.Err)
+		}
+		return resp.Mountpoint
+	}
+	unmount := func(id string) {
+		resp := &pluginResponse{}
+		callHandler(t, s.dockerUnmountVolume, "POST", &pluginRequest{Name: "vol001", ID: id}, resp)
+		if resp.Err != "" {
+			t.Fatalf("unmount %s failed: %s", id, resp.Err)
+		}
+	}
+
+	// the containers of a host share its mount
+	m1, m2 := strings.Repeat("1", 64), strings.Repeat("2", 64)
+	mountPoint := mount(m1)
+	if mount(m2) != mountPoint || len(mounts) != 1 {
+		t.Fatalf("second container should share the mount, got %v", mounts)
+	}
+	for _, id := range []string{m1, m2} {
+		ct, err := metadata.GetContainer(id)
+		if err != nil || len(ct.Volumes) != 1 || string(ct.Volumes[0].Volumeid) != "vol001" {
+			t.Fatalf("container %s should hold the volume, got %v %v", id, ct, err)
+		}
+	}
+
+	// the container sharing the mount leaves the writer as it is
+	vl, err := metadata.GetVolume("vol001", metadata.CEPH)
+	if err != nil || string(vl.Writable) != m1 || len(vl.Containers) != 2 {
+		t.Fatalf("first container should stay the writer, got %v %v", vl, err)
+	}
+	// a rw container of another host is refused
+	other := &metaproto.Volume_OwnerContainer{Containerid: []byte(strings.Repeat("3", 64)), Mode: []byte(metadata.RWVolume), Host: []byte("10.0.0.2")}
+	if err := metadata.ShareVolumeContainer("vol001", other, metadata.CEPH); err == nil {
+		t.Fatal("container of another host should not share the writer")
+	}
+
+	unmount(m1)
+	vl, err = metadata.GetVolume("vol001", metadata.CEPH)
+	if err != nil || string(vl.Writable) != m2 || mounts[mountPoint] == "" || fake.attached["vol001"] == "" {
+		t.Fatalf("volume should stay mounted for the other container, got %v %v", vl, mounts)
+	}
+	unmount(m2)
+	if _, ok := mounts[mountPoint]; ok || fake.attached["vol001"] != "" {
+		t.Fatalf("volume should be detached, got %v", mounts)
+	}
+	if vl, err := metadata.GetVolume("vol001", metadata.CEPH); err != nil || len(vl.Containers) != 0 || len(vl.Writable) != 0 {
+		t.Fatalf("volume should have no owner, got %v %v", vl, err)
+	}
+}
@@ -0,0 +1,218 @@
+package daemon
+
+import (
+	"net/http"
+	"strconv"
+
+	"api"
+	"meta"
+	"scheduler"
+)
+
+// drainedDevice names a device by its id and backend.
+type drainedDevice struct {
+	id      string
+	backend string
+}
+
+func hostDevices(ip string) ([]drainedDevice, error) {
+	hs, err := metadata.GetHost(ip)
+	if err != nil {
+		return nil, err
+	}
+	devs := []drainedDevice{}
+	for _, key := range hs.Devices {
+		id, backend := metadata.ParseDeviceKey(string(key))
+		devs = append(devs, drainedDevice{id: id, backend: backend})
+	}
+	return devs, nil
+}
+
+func writeDrainResponse(w http.ResponseWriter, result int, resp *api.DrainResponse) error {
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doDeviceDrain(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.DeviceDrainRequest{}
+	resp := &api.DrainResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		if err := metadata.DrainDevice(req.ID, req.Backend); err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		result = s.processDrain([]drainedDevice{{id: req.ID, backend: req.Backend}}, req.Migrate, resp)
+		break
+	}
+
+	return writeDrainResponse(w, result, resp)
+}
+
+func (s *daemon) doHostDrain(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.HostDrainRequest{}
+	resp := &api.DrainResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		if err := metadata.DrainHost(req.Ip); err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		devs, err := hostDevices(req.Ip)
+		if err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		result = s.processDrain(devs, req.Migrate, resp)
+		break
+	}
+
+	return writeDrainResponse(w, result, resp)
+}
+
+func (s *daemon) doDeviceResume(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.DeviceDrainRequest{}
+	resp := &api.DrainResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		if err := metadata.ResumeDevice(req.ID, req.Backend); err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		resp.Devices = []string{req.ID}
+		break
+	}
+
+	return writeDrainResponse(w, result, resp)
+}
+
+func (s *daemon) doHostResume(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.HostDrainRequest{}
+	resp := &api.DrainResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		if err := metadata.ResumeHost(req.Ip); err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		devs, err := hostDevices(req.Ip)
+		if err != nil {
+			result = errorResult(w, err)
+			break
+		}
+		for _, dev := range devs {
+			resp.Devices = append(resp.Devices, dev.id)
+		}
+		break
+	}
+
+	return writeDrainResponse(w, result, resp)
+}
+
+// processDrain starts moving the volumes of the drained devices away when
+// asked, and reports the volumes still on them. Volumes being copied stay
+// on their device until the copy is done, volumes in use are left alone,
+// draining again retries them. The caller holds the metadata lock.
+func (s *daemon) processDrain(devs []drainedDevice, migrate bool, resp *api.DrainResponse) int {
+	for _, dev := range devs {
+		resp.Devices = append(resp.Devices, dev.id)
+		if migrate {
+			s.drainVolumes(dev)
+		}
+	}
+
+	for _, dev := range devs {
+		volumes, err := metadata.DeviceVolumes(dev.id, dev.backend)
+		if err != nil {
+			return err.(*metadata.Error).Code
+		}
+		resp.Volumes = append(resp.Volumes, volumes...)
+	}
+	return 0
+}
+
+// drainVolumes migrates the volumes of a drained device to devices the
+// scheduler picks, drained devices are never picked.
+func (s *daemon) drainVolumes(dev drainedDevice) {
+	volumes, err := metadata.DeviceVolumes(dev.id, dev.backend)
+	if err != nil {
+		log.Warnf("[drainVolumes] volumes of device %s: %s", dev.id, err.Error())
+		return
+	}
+	for _, volumeid := range volumes {
+		s.drainVolume(dev, volumeid)
+	}
+}
+
+func (s *daemon) drainVolume(dev drainedDevice, volumeid string) {
+	vl, err := metadata.GetVolume(volumeid, dev.backend)
+	if err != nil {
+		log.Warnf("[drainVolume] volume %s of device %s: %s", volumeid, dev.id, err.Error())
+		return
+	}
+
+	opts := map[string]string{
+		scheduler.FilterCapacity: string(vl.Capacity),
+		scheduler.Backend:        dev.backend,
+		scheduler.Replica:        "1",
+	}
+	ds, err := scheduler.DoScheduler(opts)
+	if err != nil {
+		log.Warnf("[drainVolume] no device for volume %s of device %s: %s", volumeid, dev.id, err.Error())
+		return
+	}
+
+	req := &api.VolumeMigrateRequest{VolumeId: volumeid, DriverName: dev.backend, ToDevice: string(ds[0].Id)}
+	result := s.processVolumeMigrate(req, &api.MigrateResponse{})
+	if result != 0 && result != metadata.EcodeVolumeMigrating {
+		log.Warnf("[drainVolume] volume %s stays on device %s: %d", volumeid, dev.id, result)
+	}
+}
@@ -0,0 +1,119 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"api"
+	"driver"
+	"meta"
+	"store/memory"
+)
+
+func TestDrain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drain-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, cleanup := setupFakeLoop()
+	defer cleanup()
+
+	memory.NewStore()
+	for _, host := range []string{testNodeID, "10.0.0.2"} {
+		if err := metadata.AddHost(host, metadata.HOST_ONLINE, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for dev, host := range map[string]string{"dev001": testNodeID, "dev002": testNodeID, "dev003": "10.0.0.2"} {
+		if err := metadata.AddDevice(dev, host, 3260, 10240, 10240, metadata.DEVICE_READY, "iqn", metadata.SAN); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fd := &fileDriver{fakeDriver: newFakeDriver(), dir: dir}
+	s := &daemon{Drivers: map[string]driver.VolumeDriver{metadata.SAN: fd}}
+	create := func(id string) int {
+		req := &api.VolumeCreateRequest{VolumeId: id, DriverName: metadata.SAN, Capacity: "5"}
+		return s.processVolumeCreate(req, &api.VolumeResponse{})
+	}
+	deviceOf := func(id string) string {
+		vl, err := metadata.GetVolume(id, metadata.SAN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(vl.Devices[0].Deviceid)
+	}
+	status := func(dev string) int {
+		st, _ := metadata.GetDeviceStatus(dev, metadata.SAN)
+		return st
+	}
+	drain := func(id string, migrate bool) *api.DrainResponse {
+		resp := &api.DrainResponse{}
+		callHandler(t, s.doDeviceDrain, "POST", &api.DeviceDrainRequest{ID: id, Backend: metadata.SAN, Migrate: migrate}, resp)
+		if resp.Result != "0" {
+			t.Fatalf("drain of %s failed: %+v", id, resp)
+		}
+		return resp
+	}
+
+	if result := create("vol001"); result != 0 {
+		t.Fatalf("create failed with %d", result)
+	}
+	from := deviceOf("vol001")
+
+	resp := drain(from, false)
+	if len(resp.Volumes) != 1 || resp.Volumes[0] != "vol001" || status(from) != metadata.DEVICE_MAINTENANCE {
+		t.Fatalf("device %s should be in maintenance with vol001 left, got %+v", from, resp)
+	}
+
+	drain(from, true)
+	<-s.getMigration("vol001", metadata.SAN).finished
+	if resp := drain(from, true); len(resp.Volumes) != 0 {
+		t.Fatalf("drain should be complete, got %+v", resp)
+	}
+	if to := deviceOf("vol001"); to == from {
+		t.Fatalf("vol001 should be moved off %s", from)
+	}
+	if status(from) != metadata.DEVICE_MAINTENANCE {
+		t.Fatalf("drained device %s should stay in maintenance once freed", from)
+	}
+	if err := metadata.UseDevice(from, metadata.SAN, "vol009"); err == nil || err.(*metadata.Error).Code != metadata.EcodeDeviceMaintenance {
+		t.Fatalf("drained device should not be used, got %v", err)
+	}
+
+	// the one device left free is in maintenance
+	if result := create("vol002"); result != 0 || deviceOf("vol002") == from {
+		t.Fatalf("vol002 should be created off %s, got %d", from, result)
+	}
+	if result := create("vol003"); result != metadata.EcodeSchedulerError {
+		t.Fatalf("drained device should not be scheduled, got %d", result)
+	}
+
+	resume := &api.DrainResponse{}
+	callHandler(t, s.doDeviceResume, "POST", &api.DeviceDrainRequest{ID: from, Backend: metadata.SAN}, resume)
+	if resume.Result != "0" || status(from) != metadata.DEVICE_READY {
+		t.Fatalf("device %s should be ready again, got %+v", from, resume)
+	}
+	if result := create("vol003"); result != 0 || deviceOf("vol003") != from {
+		t.Fatalf("vol003 should be created on %s, got %d", from, result)
+	}
+
+	host := &api.DrainResponse{}
+	callHandler(t, s.doHostDrain, "POST", &api.HostDrainRequest{Ip: testNodeID}, host)
+	if host.Result != "0" || len(host.Devices) != 2 || len(host.Volumes) != 2 {
+		t.Fatalf("both devices of the host should be drained with their volumes, got %+v", host)
+	}
+	hs, _ := metadata.GetHost(testNodeID)
+	if string(hs.Status) != strconv.Itoa(metadata.HOST_MAINTENANCE) || status("dev001") != metadata.DEVICE_MAINTENANCE || status("dev002") != metadata.DEVICE_MAINTENANCE {
+		t.Fatalf("host and its devices should be in maintenance, got host status %s", hs.Status)
+	}
+
+	host = &api.DrainResponse{}
+	callHandler(t, s.doHostResume, "POST", &api.HostDrainRequest{Ip: testNodeID}, host)
+	hs, _ = metadata.GetHost(testNodeID)
+	if host.Result != "0" || string(hs.Status) != strconv.Itoa(metadata.HOST_ONLINE) || status("dev001") != metadata.DEVICE_INUSE || status("dev002") != metadata.DEVICE_INUSE {
+		t.Fatalf("host and its devices should be back in use, got %+v", host)
+	}
+}
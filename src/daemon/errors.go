@@ -0,0 +1,166 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"api"
+	"meta"
+)
+
+// responseBuffer keeps the response of a handler, to look at it before it
+// is sent.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	// the message of the error the handler failed with
+	message string
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header)}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *responseBuffer) Write(data []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(data)
+}
+
+func (b *responseBuffer) writeTo(w http.ResponseWriter) error {
+	for name, values := range b.header {
+		w.Header()[name] = values
+	}
+	if outer, ok := w.(*responseBuffer); ok && b.message != "" {
+		outer.message = b.message
+	}
+	b.WriteHeader(http.StatusOK)
+	w.WriteHeader(b.status)
+	_, err := w.Write(b.body.Bytes())
+	return err
+}
+
+// errorResult is the code of err, a *metadata.Error, keeping its message for
+// the error of the response.
+func errorResult(w http.ResponseWriter, err error) int {
+	e := (err).(*metadata.Error)
+	if b, ok := w.(*responseBuffer); ok {
+		b.message = e.Message
+	}
+	return e.Code
+}
+
+// requestError is the error of a request failed with code.
+func requestError(r *http.Request, code int, message string) *api.Error {
+	entry := metadata.LookupError(code)
+	if message == "" {
+		message = entry.Message
+	}
+	return &api.Error{
+		Code:    code,
+		Name:    entry.Name,
+		Message: message,
+		Details: map[string]string{
+			"method": r.Method,
+			"path":   r.URL.Path,
+		},
+	}
+}
+
+// writeResponse sends the response of a handler, with the http status of
+// its Result and the error of the Result next to it when it failed, telling
+// the message of the handler if it kept one. Responses without Result, such
+// as those of docker, and those already telling their error, such as the
+// replayed ones, are sent as they are.
+func writeResponse(w http.ResponseWriter, r *http.Request, buf *responseBuffer) error {
+	fields := map[string]json.RawMessage{}
+	if json.Unmarshal(buf.body.Bytes(), &fields) != nil {
+		return buf.writeTo(w)
+	}
+	// an error already told, not the Error string of some responses
+	told := &api.Error{}
+	if json.Unmarshal(fields["Error"], told) == nil && told.Code != 0 {
+		return buf.writeTo(w)
+	}
+	var result string
+	if json.Unmarshal(fields["Result"], &result) != nil || result == "0" {
+		return buf.writeTo(w)
+	}
+	code, err := strconv.Atoi(result)
+	if err != nil {
+		return buf.writeTo(w)
+	}
+
+	e, err := json.Marshal(requestError(r, code, buf.message))
+	if err != nil {
+		return err
+	}
+	fields["Error"] = e
+	data, err := api.ResponseOutput(fields)
+	if err != nil {
+		return err
+	}
+
+	for name, values := range buf.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(metadata.LookupError(code).Status)
+	_, err = w.Write(data)
+	return err
+}
+
+// writeError answers a request its handler failed to answer.
+func writeError(w http.ResponseWriter, r *http.Request, code int, message string) error {
+	resp := &struct {
+		Result string
+		Error  *api.Error
+	}{
+		Result: strconv.Itoa(code),
+		Error:  requestError(r, code, message),
+	}
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(metadata.LookupError(code).Status)
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doErrorList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	resp := &api.ErrorListResponse{
+		Result: "0",
+		Errors: []api.ErrorEntry{},
+	}
+
+	for _, entry := range metadata.ErrorCatalog() {
+		resp.Errors = append(resp.Errors, api.ErrorEntry{
+			Code:    entry.Code,
+			Name:    entry.Name,
+			Status:  entry.Status,
+			Message: entry.Message,
+		})
+	}
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
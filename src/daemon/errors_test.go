@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"api"
+	"meta"
+)
+
+func TestErrorResponses(t *testing.T) {
+	s := newTestDaemon(t, metadata.CEPH, "dev001")
+	wp := s.startWorkers(1)
+	defer wp.Stop()
+	router := createRouter(s)
+
+	// every code once, under a name of its own
+	catalog := &api.ErrorListResponse{}
+	if w := sendKeyed(t, router, "GET", "/errors", "", nil, catalog); w.Code != http.StatusOK || catalog.Result != "0" {
+		t.Fatalf("catalog failed: %d %+v", w.Code, catalog)
+	}
+	names := map[string]bool{}
+	for i, entry := range catalog.Errors {
+		if i > 0 && entry.Code <= catalog.Errors[i-1].Code {
+			t.Fatalf("codes should be sorted and unique, got %d after %d", entry.Code, catalog.Errors[i-1].Code)
+		}
+		if names[entry.Name] || entry.Status < 400 || entry.Message == "" {
+			t.Fatalf("unexpected entry %+v", entry)
+		}
+		names[entry.Name] = true
+	}
+
+	resp := &struct {
+		api.VolumeResponse
+		Error *api.Error
+	}{}
+	w := sendKeyed(t, router, "GET", "/v1/volume/", "", &api.VolumeGetRequest{VolumeId: "vol001", DriverName: metadata.CEPH}, resp)
+	if w.Code != http.StatusNotFound || resp.Result != strconv.Itoa(metadata.EcodeVolumeNotFound) || resp.Error == nil ||
+		resp.Error.Code != metadata.EcodeVolumeNotFound || resp.Error.Name != "VOLUME_NOT_FOUND" || resp.Error.Details["path"] != "/v1/volume/" {
+		t.Fatalf("missing volume should be 404, got %d %s", w.Code, w.Body.String())
+	}
+	if resp.Error.Message != "Volume not found." {
+		t.Fatalf("error should tell the message of the handler, got %q", resp.Error.Message)
+	}
+
+	// a successful request tells no error
+	create := &api.VolumeCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Capacity: "5"}
+	w = sendKeyed(t, router, "POST", "/volume/create", "", create, resp)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "Error") {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+	waitOperation(t, s, resp.Operation)
+
+	resp.Error = nil
+	w = sendKeyed(t, router, "POST", "/volume/attach", "", &api.VolumeAttachRequest{VolumeId: "vol001", ContainerId: strings.Repeat("1", 64), DriverName: metadata.CEPH, Mode: "rx"}, resp)
+	if w.Code != http.StatusBadRequest || resp.Error == nil || resp.Error.Code != metadata.EcodeParameterError {
+		t.Fatalf("bad mode should be a parameter error, got %d %s", w.Code, w.Body.String())
+	}
+	w = sendKeyed(t, router, "POST", "/volume/create", "", create, resp)
+	if w.Code != http.StatusConflict || resp.Error == nil || resp.Error.Name != "VOLUME_EXISTS" {
+		t.Fatalf("existing volume should be a conflict, got %d %s", w.Code, w.Body.String())
+	}
+
+	if err := metadata.NewError(metadata.EcodeVolumeNotFound, "Volume vol001 not found."); err.Error() != "Volume vol001 not found. (4000)" {
+		t.Fatalf("unexpected error string %q", err.Error())
+	}
+	if entry := metadata.LookupError(12345); entry.Status != http.StatusInternalServerError || entry.Name != "UNKNOWN" {
+		t.Fatalf("unknown code should be internal, got %+v", entry)
+	}
+}
@@ -0,0 +1,159 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"api"
+	"driver"
+	"meta"
+)
+
+// Failover takes the volumes a dead host held away from it, so that
+// containers started elsewhere can attach them, rw included. The host is
+// fenced first on every volume it wrote, drivers that lock volumes name
+// the host by its ip (ceph.node), then its containers are dropped from the
+// volumes. Each volume gets an event as audit record. Owners recorded
+// before hosts were kept on them are left alone.
+
+// failover releases the volumes of the hosts the detector took offline.
+func (s *daemon) failover(transitions []hostTransition) {
+	for _, tr := range transitions {
+		if tr.status != metadata.HOST_OFFLINE {
+			continue
+		}
+		metadata.Lock()
+		released, failed, err := s.processFailover(tr.ip)
+		metadata.Unlock()
+		if err != nil {
+			log.Errorf("[failover] host %s error: %s", tr.ip, err.Error())
+			continue
+		}
+		log.Infof("[failover] host %s: volumes %v released, %v failed", tr.ip, released, failed)
+	}
+}
+
+// processFailover releases every volume with containers on the host, the
+// host must be offline. The caller holds the metadata lock.
+func (s *daemon) processFailover(ip string) ([]string, []string, error) {
+	hs, err := metadata.GetHost(ip)
+	if err != nil {
+		return nil, nil, err
+	}
+	if status, _ := metadata.HostStatus(hs); status != metadata.HOST_OFFLINE {
+		return nil, nil, metadata.NewError(metadata.EcodeHostNotOffline, "host "+ip+" is not offline.")
+	}
+
+	released, failed := []string{}, []string{}
+	for _, backend := range metadata.ListBackends() {
+		names, err := metadata.ListVolumesName(backend)
+		if err != nil {
+			return released, failed, err
+		}
+		for _, name := range names {
+			ok, err := s.failoverVolume(name, backend, ip)
+			if err != nil {
+				log.Errorf("[processFailover] volume %s of host %s error: %s", name, ip, err.Error())
+				metadata.AddEvent(metadata.EVENT_VOLUME_FAILOVER_ERROR, name, "host "+ip+": "+err.Error())
+				failed = append(failed, name)
+				continue
+			}
+			if ok {
+				released = append(released, name)
+			}
+		}
+	}
+	return released, failed, nil
+}
+
+// failoverVolume fences the host from the volume, whatever the mode of its
+// locks, and drops its containers, it tells whether the host had any.
+func (s *daemon) failoverVolume(volumeid string, backend string, ip string) (bool, error) {
+	vl, err := metadata.GetVolume(volumeid, backend)
+	if err != nil {
+		return false, err
+	}
+	owners := []string{}
+	writer := false
+	for _, c := range vl.Containers {
+		if string(c.Host) != ip {
+			continue
+		}
+		owners = append(owners, string(c.Containerid))
+		if string(c.Containerid) == string(vl.Writable) {
+			writer = true
+		}
+	}
+	if len(owners) == 0 {
+		return false, nil
+	}
+
+	d := s.getVolumeDriver(backend)
+	if d == nil {
+		return false, fmt.Errorf("driver %s not enabled, the host can't be fenced", backend)
+	}
+	if fd, ok := d.(driver.FenceDriver); ok {
+		if err := fenceVolume(fd, volumeid, backend, ip); err != nil {
+			return false, err
+		}
+	}
+
+	for _, owner := range owners {
+		if err := metadata.DelVolumeContainer(volumeid, backend, owner); err != nil {
+			return false, err
+		}
+		// csi owners are nodes, they have no container record
+		err := metadata.DelContainerVolume(owner, []byte(volumeid))
+		if e, ok := err.(*metadata.Error); err != nil && (!ok || e.Code != metadata.EcodeContainerNotFound) {
+			log.Warnf("[failoverVolume] drop volume %s from container %s error: %s", volumeid, owner, err.Error())
+		}
+	}
+
+	message := fmt.Sprintf("host %s offline, fenced, containers %s released", ip, strings.Join(owners, ","))
+	if writer {
+		message = fmt.Sprintf("host %s offline, fenced, writer %s and containers %s released", ip, vl.Writable, strings.Join(owners, ","))
+	}
+	metadata.AddEvent(metadata.EVENT_VOLUME_FAILOVER, volumeid, message)
+	return true, nil
+}
+
+// doHostFailover runs the failover of an offline host again, for volumes
+// whose failover failed.
+func (s *daemon) doHostFailover(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.HostGetRequest{}
+	resp := &api.FailoverResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		released, failed, err := s.processFailover(req.Ip)
+		if err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		resp.IP = req.Ip
+		resp.Volumes = released
+		resp.Failed = failed
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
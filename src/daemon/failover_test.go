@@ -0,0 +1,108 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+
+	"api"
+	"driver"
+	"meta"
+	"meta/proto"
+	"store/memory"
+)
+
+// fencingDriver records the hosts fenced per volume.
+type fencingDriver struct {
+	*fakeDriver
+	fenced map[string]string
+}
+
+func (d *fencingDriver) FenceVolume(vl *metaproto.Volume, devs []*metaproto.Device, host string) error {
+	d.fenced[string(vl.Id)] = host
+	return nil
+}
+
+func TestFailover(t *testing.T) {
+	memory.NewStore()
+	for _, host := range []string{testNodeID, "10.0.0.2"} {
+		if err := metadata.AddHost(host, metadata.HOST_ONLINE, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dev := range []string{testDevice, "dev002"} {
+		if err := metadata.AddDevice(dev, testNodeID, 6789, 10240, 10240, metadata.DEVICE_READY, "rbd", metadata.CEPH); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fd := &fencingDriver{fakeDriver: newFakeDriver(), fenced: make(map[string]string)}
+	s := &daemon{Drivers: map[string]driver.VolumeDriver{metadata.CEPH: fd}, AgentIP: testNodeID}
+	other := &daemon{Drivers: s.Drivers, AgentIP: "10.0.0.2"}
+
+	if result := s.processVolumeCreate(&api.VolumeCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Capacity: "5"}, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("create failed: %d", result)
+	}
+	c1, c2, c3 := strings.Repeat("1", 64), strings.Repeat("2", 64), strings.Repeat("3", 64)
+	attach := func(s *daemon, container string, mode string) int {
+		req := &api.VolumeAttachRequest{VolumeId: "vol001", ContainerId: container, DriverName: metadata.CEPH, Mode: mode}
+		return s.processVolumeAttach(req, &api.VolumeResponse{})
+	}
+	if result := attach(other, c2, "ro"); result != 0 {
+		t.Fatalf("attach ro failed: %d", result)
+	}
+	if result := attach(s, c1, "rw"); result != 0 {
+		t.Fatalf("attach rw failed: %d", result)
+	}
+	if result := attach(other, c3, "rw"); result != metadata.EcodeWRContainerExist {
+		t.Fatalf("second writer should be refused, got %d", result)
+	}
+
+	// the host only reads vol002, its lock is taken all the same
+	if result := s.processVolumeCreate(&api.VolumeCreateRequest{VolumeId: "vol002", DriverName: metadata.CEPH, Capacity: "5"}, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("create failed: %d", result)
+	}
+	if result := s.processVolumeAttach(&api.VolumeAttachRequest{VolumeId: "vol002", ContainerId: c1, DriverName: metadata.CEPH, Mode: "ro"}, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("attach ro failed: %d", result)
+	}
+
+	if _, _, err := s.processFailover(testNodeID); err == nil || err.(*metadata.Error).Code != metadata.EcodeHostNotOffline {
+		t.Fatalf("failover of an online host should be refused, got %v", err)
+	}
+
+	hs, _ := metadata.GetHost(testNodeID)
+	if err := setHostOffline(hs, "test"); err != nil {
+		t.Fatal(err)
+	}
+	resp := &api.FailoverResponse{}
+	callHandler(t, s.doHostFailover, "POST", &api.HostGetRequest{Ip: testNodeID}, resp)
+	if resp.Result != "0" || len(resp.Volumes) != 2 || len(resp.Failed) != 0 {
+		t.Fatalf("vol001 and vol002 should be released, got %+v", resp)
+	}
+	if fd.fenced["vol001"] != testNodeID || fd.fenced["vol002"] != testNodeID {
+		t.Fatalf("the host should be fenced from both volumes, got %v", fd.fenced)
+	}
+
+	vl, _ := metadata.GetVolume("vol001", metadata.CEPH)
+	if len(vl.Writable) != 0 || len(vl.Containers) != 1 || string(vl.Containers[0].Containerid) != c2 {
+		t.Fatalf("only the owner on the live host should stay, got %+v", vl)
+	}
+	if result := attach(other, c3, "rw"); result != 0 {
+		t.Fatalf("attach rw on another host should succeed after failover: %d", result)
+	}
+
+	events, _ := metadata.ListEvents()
+	found := false
+	for _, ev := range events {
+		if string(ev.Kind) == metadata.EVENT_VOLUME_FAILOVER && string(ev.Object) == "vol001" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("failover should be recorded, got %v", events)
+	}
+
+	// nothing left to release
+	released, failed, err := s.processFailover(testNodeID)
+	if err != nil || len(released) != 0 || len(failed) != 0 {
+		t.Fatalf("second failover should release nothing, got %v %v %v", released, failed, err)
+	}
+}
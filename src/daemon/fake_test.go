@@ -0,0 +1,131 @@
+package daemon
+
+import (
+	"fmt"
+	"testing"
+
+	"driver"
+	"meta"
+	"meta/proto"
+	"store/memory"
+)
+
+const (
+	testNodeID = "10.0.0.1"
+	testDevice = "dev001"
+)
+
+// newTestDaemon starts a memory store holding the test host and the given
+// devices of backend, and a daemon running a fake driver for backend.
+func newTestDaemon(t *testing.T, backend string, devices ...string) *daemon {
+	memory.NewStore()
+	if err := metadata.AddHost(testNodeID, metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range devices {
+		addTestDevice(t, id, backend)
+	}
+	return &daemon{Drivers: map[string]driver.VolumeDriver{backend: newFakeDriver()}, AgentIP: testNodeID}
+}
+
+// addTestDevice adds a ready device of backend to the test host.
+func addTestDevice(t *testing.T, id string, backend string) {
+	port, identify := 6789, "rbd"
+	if backend == metadata.SAN {
+		port, identify = 3260, "iqn"
+	}
+	if err := metadata.AddDevice(id, testNodeID, port, 10240, 10240, metadata.DEVICE_READY, identify, backend); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeDriver records what the daemon asked from the backend.
+type fakeDriver struct {
+	attached  map[string]string
+	snapshots map[string]string
+	expanded  map[string]int
+}
+
+func newFakeDriver() *fakeDriver {
+	return &fakeDriver{
+		attached:  make(map[string]string),
+		snapshots: make(map[string]string),
+		expanded:  make(map[string]int),
+	}
+}
+
+func (f *fakeDriver) Name() string { return metadata.CEPH }
+
+func (f *fakeDriver) CreateVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	return nil
+}
+
+func (f *fakeDriver) DeleteVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	return nil
+}
+
+func (f *fakeDriver) AttachVolume(vl *metaproto.Volume, devs []*metaproto.Device, mode string) (string, error) {
+	path := "/dev/fake/" + string(vl.Id)
+	f.attached[string(vl.Id)] = mode
+	return path, nil
+}
+
+func (f *fakeDriver) DetachVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	delete(f.attached, string(vl.Id))
+	return nil
+}
+
+func (f *fakeDriver) CreateSnapshot(vl *metaproto.Volume, devs []*metaproto.Device, snapshotid string) error {
+	f.snapshots[snapshotid] = string(vl.Id)
+	return nil
+}
+
+func (f *fakeDriver) DeleteSnapshot(vl *metaproto.Volume, devs []*metaproto.Device, snapshotid string) error {
+	delete(f.snapshots, snapshotid)
+	return nil
+}
+
+func (f *fakeDriver) ExpandVolume(vl *metaproto.Volume, devs []*metaproto.Device, capacity int) error {
+	f.expanded[string(vl.Id)] = capacity
+	return nil
+}
+
+// fakeMounts replaces the mount helpers so that the node service can run
+// without root, mount point : source.
+type fakeMounts map[string]string
+
+func setupFakeMounts() (fakeMounts, func()) {
+	mounts := fakeMounts{}
+	oldFormat, oldMount, oldBind, oldUnmount, oldIsMounted, oldGetDev, oldResize :=
+		formatDevice, mountDevice, bindMount, unmountDevice, isMounted, getMountDevice, resizeFilesystem
+
+	formatDevice = func(dev, fsType string) error { return nil }
+	mountDevice = func(dev, mountPoint string, readonly bool) error {
+		mounts[mountPoint] = dev
+		return nil
+	}
+	bindMount = func(source, mountPoint string, readonly bool) error {
+		mounts[mountPoint] = source
+		return nil
+	}
+	unmountDevice = func(mountPoint string) error {
+		if _, ok := mounts[mountPoint]; !ok {
+			return fmt.Errorf("%s not mounted", mountPoint)
+		}
+		delete(mounts, mountPoint)
+		return nil
+	}
+	isMounted = func(mountPoint string) bool {
+		_, ok := mounts[mountPoint]
+		return ok
+	}
+	getMountDevice = func(mountPoint string) (string, error) {
+		return mounts[mountPoint], nil
+	}
+	resizeFilesystem = func(dev, mountPoint string) error { return nil }
+
+	return mounts, func() {
+		formatDevice, mountDevice, bindMount, unmountDevice, isMounted, getMountDevice, resizeFilesystem =
+			oldFormat, oldMount, oldBind, oldUnmount, oldIsMounted, oldGetDev, oldResize
+	}
+}
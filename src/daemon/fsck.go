@@ -0,0 +1,68 @@
+package daemon
+
+import (
+	"net/http"
+	"strconv"
+
+	"api"
+	"meta"
+)
+
+// busyVolumes names the volumes this daemon is copying, as backend/id,
+// their migration is running and not stuck.
+func (s *daemon) busyVolumes() map[string]bool {
+	s.migrateLock.Lock()
+	defer s.migrateLock.Unlock()
+
+	busy := make(map[string]bool)
+	for key, m := range s.migrations {
+		if m.status == api.MIGRATE_COPYING {
+			busy[key] = true
+		}
+	}
+	return busy
+}
+
+func (s *daemon) doFsck(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.FsckRequest{}
+	resp := &api.FsckResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		problems, err := metadata.Fsck(req.Repair, s.hostIP(), s.busyVolumes())
+		if err != nil {
+			result = errorResult(w, err)
+		}
+
+		resp.Problems = []api.FsckProblem{}
+		resp.Classes = make(map[string]int)
+		for _, p := range problems {
+			resp.Problems = append(resp.Problems, api.FsckProblem{
+				Class:    p.Class,
+				Key:      p.Key,
+				Message:  p.Message,
+				Repaired: p.Repaired,
+			})
+			resp.Classes[p.Class]++
+		}
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,147 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+
+	"api"
+	"meta"
+	"meta/proto"
+	"store"
+)
+
+func TestFsck(t *testing.T) {
+	s := newTestDaemon(t, metadata.SAN)
+	// one free device per volume, so each lands on its own
+	for _, vol := range [][2]string{{"vol001", "dev001"}, {"vol002", "dev002"}, {"vol003", "dev003"}} {
+		addTestDevice(t, vol[1], metadata.SAN)
+		if result := s.processVolumeCreate(&api.VolumeCreateRequest{VolumeId: vol[0], DriverName: metadata.SAN, Capacity: "5"}, &api.VolumeResponse{}); result != 0 {
+			t.Fatalf("create %s failed: %d", vol[0], result)
+		}
+	}
+	for _, id := range []string{"dev004", "dev005", "dev006"} {
+		addTestDevice(t, id, metadata.SAN)
+	}
+	c1 := strings.Repeat("1", 64)
+	if result := s.processVolumeAttach(&api.VolumeAttachRequest{VolumeId: "vol001", ContainerId: c1, DriverName: metadata.SAN, Mode: "rw"}, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("attach failed: %d", result)
+	}
+
+	// a device both in use and free
+	st := store.GetDriver()
+	data, err := st.Get(metadata.GenerateInuseDeviceKey("dev001", metadata.SAN), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Set(metadata.GenerateFreeDeviceKey("dev001", metadata.SAN), data, nil)
+	// references to records that are gone
+	metadata.AddVolumeDevice("vol001", metadata.SAN, &metaproto.Volume_AttachDevice{Deviceid: []byte("dev009")})
+	metadata.AddHostDevices(testNodeID, [][]byte{[]byte(metadata.GenerateFreeDeviceKey("dev008", metadata.SAN))})
+	metadata.AddContainerVolume(c1, []byte("vol404"), []byte(metadata.RWVolume), metadata.SAN)
+	metadata.UseDevice("dev006", metadata.SAN, "vol404")
+	// a migration of this host it no longer runs, and one running
+	migrate := func(vol string, dev string, host string) {
+		if err := metadata.StartVolumeMigration(vol, metadata.SAN); err != nil {
+			t.Fatal(err)
+		}
+		metadata.UseDevice(dev, metadata.SAN, vol)
+		mg := &metaproto.Migration{Volumeid: []byte(vol), Driver: []byte(metadata.SAN), Host: []byte(host), To: []byte(dev), Status: []byte(api.MIGRATE_COPYING)}
+		if err := metadata.SetMigration(mg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	migrate("vol002", "dev004", testNodeID)
+	migrate("vol003", "dev005", testNodeID)
+	s.addMigration(&migration{volumeid: "vol003", driverName: metadata.SAN, status: api.MIGRATE_COPYING})
+
+	fsck := func(repair bool) *api.FsckResponse {
+		resp := &api.FsckResponse{}
+		callHandler(t, s.doFsck, "POST", &api.FsckRequest{Repair: repair}, resp)
+		if resp.Result != "0" {
+			t.Fatalf("fsck failed: %+v", resp)
+		}
+		return resp
+	}
+	expected := map[string]int{
+		metadata.FSCK_DEVICE_DUPLICATE: 1,
+		metadata.FSCK_VOLUME_DEVICE:    1,
+		metadata.FSCK_HOST_DEVICE:      1,
+		metadata.FSCK_CONTAINER_VOLUME: 1,
+		metadata.FSCK_VOLUME_MIGRATING: 1,
+		metadata.FSCK_DEVICE_VOLUME:    2,
+	}
+	check := func(resp *api.FsckResponse, repaired bool) {
+		if len(resp.Classes) != len(expected) {
+			t.Fatalf("unexpected problems %+v", resp.Problems)
+		}
+		for class, count := range expected {
+			if resp.Classes[class] != count {
+				t.Fatalf("expected %d problems of class %s, got %+v", count, class, resp.Problems)
+			}
+		}
+		for _, p := range resp.Problems {
+			if p.Repaired != repaired {
+				t.Fatalf("problem should be repaired %v: %+v", repaired, p)
+			}
+		}
+	}
+
+	check(fsck(false), false)
+	// a check changes nothing
+	check(fsck(false), false)
+	check(fsck(true), true)
+	if resp := fsck(false); len(resp.Problems) != 0 {
+		t.Fatalf("nothing should be left after repair, got %+v", resp.Problems)
+	}
+
+	if _, err := st.Get(metadata.GenerateFreeDeviceKey("dev001", metadata.SAN), nil); err == nil {
+		t.Fatal("the free copy of dev001 should be gone")
+	}
+	vl, _ := metadata.GetVolume("vol001", metadata.SAN)
+	if len(vl.Devices) != 1 || string(vl.Devices[0].Deviceid) != "dev001" || string(vl.Writable) != c1 {
+		t.Fatalf("vol001 should keep dev001 and its writer, got %+v", vl)
+	}
+	ct, _ := metadata.GetContainer(c1)
+	if len(ct.Volumes) != 1 || string(ct.Volumes[0].Volumeid) != "vol001" {
+		t.Fatalf("c1 should keep vol001 only, got %+v", ct)
+	}
+	for dev, want := range map[string]int{"dev004": metadata.DEVICE_READY, "dev005": metadata.DEVICE_INUSE, "dev006": metadata.DEVICE_READY} {
+		if status, _ := metadata.GetDeviceStatus(dev, metadata.SAN); status != want {
+			t.Fatalf("device %s should have status %d, got %d", dev, want, status)
+		}
+	}
+	for vol, want := range map[string]bool{"vol002": false, "vol003": true} {
+		vl, _ := metadata.GetVolume(vol, metadata.SAN)
+		if status, _ := metadata.BytesToInteger(vl.Status); (status == metadata.VOLUME_MIGRATING) != want {
+			t.Fatalf("volume %s migrating should be %v, got status %q", vol, want, vl.Status)
+		}
+	}
+	if mg, _ := metadata.GetMigration("vol002", metadata.SAN); string(mg.Status) != api.MIGRATE_FAILED {
+		t.Fatalf("the migration of vol002 should be failed, got %+v", mg)
+	}
+
+	// the migration of another host is left alone until it lost its
+	// heartbeat
+	if err := metadata.AddHost("10.0.0.2", metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	addTestDevice(t, "dev007", metadata.SAN)
+	migrate("vol002", "dev007", "10.0.0.2")
+	resp := fsck(true)
+	if len(resp.Problems) != 1 || resp.Problems[0].Class != metadata.FSCK_VOLUME_MIGRATING || resp.Problems[0].Repaired {
+		t.Fatalf("migration of a live host should only be reported, got %+v", resp.Problems)
+	}
+	if status, _ := metadata.GetDeviceStatus("dev007", metadata.SAN); status != metadata.DEVICE_INUSE {
+		t.Fatalf("target of a live migration should be kept, got status %d", status)
+	}
+	if err := metadata.ModHostStatus("10.0.0.2", metadata.HOST_OFFLINE); err != nil {
+		t.Fatal(err)
+	}
+	resp = fsck(true)
+	if len(resp.Problems) != 2 || !resp.Problems[0].Repaired || !resp.Problems[1].Repaired {
+		t.Fatalf("migration of a dead host should be ended, got %+v", resp.Problems)
+	}
+	if status, _ := metadata.GetDeviceStatus("dev007", metadata.SAN); status != metadata.DEVICE_READY {
+		t.Fatalf("target of a dead migration should be freed, got status %d", status)
+	}
+}
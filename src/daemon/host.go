@@ -3,9 +3,11 @@ package daemon
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"api"
 	"meta"
+	"meta/proto"
 )
 
 func (s *daemon) doHostGet(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
@@ -24,15 +26,11 @@ func (s *daemon) doHostGet(version string, w http.ResponseWriter, r *http.Reques
 
 		hs, err := metadata.GetHost(req.Ip)
 		if err != nil {
-			result = (err).(*metadata.Error).Code
+			result = errorResult(w, err)
 			break
 		}
 
-		resp.IP = req.Ip
-		resp.Status = string(hs.Status)
-		for i := 0; i < len(hs.Devices); i++ {
-			resp.Devs = append(resp.Devs, string(hs.Devices[i]))
-		}
+		hostResponse(hs, resp)
 		break
 	}
 
@@ -47,6 +45,15 @@ func (s *daemon) doHostGet(version string, w http.ResponseWriter, r *http.Reques
 	return err
 }
 
+// hostResponse tells what a host is, for the requests reading it.
+func hostResponse(hs *metaproto.Host, resp *api.HostResponse) {
+	resp.IP = string(hs.Ip)
+	resp.Status = metadata.HostStates.NameBytes(hs.Status)
+	for i := 0; i < len(hs.Devices); i++ {
+		resp.Devs = append(resp.Devs, string(hs.Devices[i]))
+	}
+}
+
 func (s *daemon) doHostList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
 	metadata.Lock()
 	defer metadata.Unlock()
@@ -63,7 +70,7 @@ func (s *daemon) doHostList(version string, w http.ResponseWriter, r *http.Reque
 
 		hosts, err := metadata.ListHostsName()
 		if err != nil {
-			result = (err).(*metadata.Error).Code
+			result = errorResult(w, err)
 			break
 		}
 
@@ -102,7 +109,7 @@ func (s *daemon) doHostAdd(version string, w http.ResponseWriter, r *http.Reques
 		devs := [][]byte{}
 		err := metadata.AddHost(req.Ip, metadata.HOST_ONLINE, devs)
 		if err != nil {
-			result = (err).(*metadata.Error).Code
+			result = errorResult(w, err)
 			break
 		}
 		resp.IP = req.Ip
@@ -138,7 +145,42 @@ func (s *daemon) doHostDel(version string, w http.ResponseWriter, r *http.Reques
 
 		err := metadata.DelHost(req.Ip)
 		if err != nil {
-			result = (err).(*metadata.Error).Code
+			result = errorResult(w, err)
+			break
+		}
+
+		resp.IP = req.Ip
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doHostHeartbeat(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.HostGetRequest{}
+	resp := &api.HostResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		err := metadata.HostHeartbeat(req.Ip, int(time.Now().Unix()))
+		if err != nil {
+			result = errorResult(w, err)
 			break
 		}
 
@@ -0,0 +1,165 @@
+package daemon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"api"
+	"meta"
+	"meta/proto"
+)
+
+const (
+	// seconds the response to a request sent with an idempotency key is
+	// kept for its retries
+	DEFAULT_IDEMPOTENCY_RETENTION = 24 * 60 * 60
+
+	// seconds a request still running keeps its key, about as long as the
+	// longest request takes, so the key of a request whose daemon died
+	// is free again soon
+	IDEMPOTENCY_PENDING_RETENTION = 10 * 60
+)
+
+// requestFingerprint tells apart the requests a client could send by
+// mistake with the same key.
+func requestFingerprint(method string, route string, objs map[string]string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + route + "\n"))
+	names := []string{}
+	for name := range objs {
+		// a request is the same whatever the version in its path
+		if name != "version" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h.Write([]byte(name + "=" + objs[name] + "\n"))
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRetention is how long the response to a request is kept, no
+// longer than the operation it names as a retry gets the operation id.
+func responseRetention(body []byte, retention int) int {
+	resp := struct{ Operation string }{}
+	if json.Unmarshal(body, &resp) == nil && resp.Operation != "" {
+		if kept := int(OPERATION_RETENTION / time.Second); retention > kept {
+			return kept
+		}
+	}
+	return retention
+}
+
+func writeRequestResult(w http.ResponseWriter, key string, result int) error {
+	resp := &api.RequestResponse{
+		Result: strconv.Itoa(result),
+		Key:    key,
+	}
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// idempotent runs a mutating handler once per idempotency key, a request
+// sent again with the key gets the response of the first one as it was
+// sent, or is refused while the first one runs. A request without key is
+// run as is.
+func (s *daemon) idempotent(method string, route string, f requestHandler) requestHandler {
+	return func(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+		key := r.Header.Get(api.IDEMPOTENCY_KEY_HEADER)
+		if key == "" {
+			return f(version, w, r, objs)
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(method, route, objs, body)
+
+		retention := s.IdempotencyRetention
+		if retention <= 0 {
+			retention = DEFAULT_IDEMPOTENCY_RETENTION
+		}
+		now := time.Now().Unix()
+		pending := now + IDEMPOTENCY_PENDING_RETENTION
+
+		metadata.Lock()
+		rec, err := metadata.GetIdempotency(key, now)
+		if err == nil && rec == nil {
+			err = metadata.SetIdempotency(&metaproto.Idempotency{
+				Key:         []byte(key),
+				Fingerprint: []byte(fingerprint),
+				Expire:      &pending,
+			}, now)
+		}
+		metadata.Unlock()
+		if err != nil {
+			return writeRequestResult(w, key, (err).(*metadata.Error).Code)
+		}
+
+		if rec != nil {
+			if string(rec.Fingerprint) != fingerprint {
+				log.Warnf("[idempotent] key %q of another request sent to %s %s", key, method, route)
+				return writeRequestResult(w, key, metadata.EcodeRequestKeyReused)
+			}
+			if rec.GetStatus() == 0 {
+				return writeRequestResult(w, key, metadata.EcodeRequestInProgress)
+			}
+			log.Debugf("[idempotent] replaying %s %s for key %q", method, route, key)
+			w.Header().Set(api.IDEMPOTENCY_REPLAY_HEADER, "true")
+			w.WriteHeader(int(rec.GetStatus()))
+			_, err := w.Write(rec.Response)
+			return err
+		}
+
+		buf := newResponseBuffer()
+		if err := f(version, buf, r, objs); err != nil {
+			// the request was not understood, the client may send it again
+			metadata.Lock()
+			if err := metadata.DelIdempotency(key); err != nil {
+				log.Warnf("[idempotent] forget key %q error: %s", key, err.Error())
+			}
+			metadata.Unlock()
+			return err
+		}
+		// the response as sent, its error telling the message of the handler
+		out := newResponseBuffer()
+		if err := writeResponse(out, r, buf); err != nil {
+			return err
+		}
+
+		status := int64(out.status)
+		now = time.Now().Unix()
+		expire := now + int64(responseRetention(out.body.Bytes(), retention))
+		metadata.Lock()
+		err = metadata.SetIdempotency(&metaproto.Idempotency{
+			Key:         []byte(key),
+			Fingerprint: []byte(fingerprint),
+			Status:      &status,
+			Response:    out.body.Bytes(),
+			Expire:      &expire,
+		}, now)
+		metadata.Unlock()
+		if err != nil {
+			log.Errorf("[idempotent] record response of key %q error: %s", key, err.Error())
+		}
+
+		return out.writeTo(w)
+	}
+}
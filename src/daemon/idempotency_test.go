@@ -0,0 +1,154 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"api"
+	"meta"
+	"meta/proto"
+)
+
+func sendKeyed(t *testing.T, h http.Handler, method string, url string, key string, req interface{}, resp interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(method, url, bytes.NewReader(body))
+	if key != "" {
+		r.Header.Set(api.IDEMPOTENCY_KEY_HEADER, key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if resp != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+			t.Fatalf("decode response %s: %v", w.Body.String(), err)
+		}
+	}
+	return w
+}
+
+func TestIdempotentRequests(t *testing.T) {
+	s := newTestDaemon(t, metadata.CEPH, "dev001", "dev002", "dev003")
+	wp := s.startWorkers(1)
+	defer wp.Stop()
+	router := createRouter(s)
+
+	create := &api.VolumeCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Capacity: "5"}
+	first := &api.VolumeResponse{}
+	w := sendKeyed(t, router, "POST", "/v1/volume/create", "key-1", create, first)
+	if first.Result != "0" || w.Header().Get(api.IDEMPOTENCY_REPLAY_HEADER) != "" {
+		t.Fatalf("create failed: %+v", first)
+	}
+	waitOperation(t, s, first.Operation)
+
+	// a retry gets the first response, the volume is not created again
+	replay := &api.VolumeResponse{}
+	w = sendKeyed(t, router, "POST", "/volume/create", "key-1", create, replay)
+	if replay.Operation != first.Operation || replay.Status != first.Status || w.Header().Get(api.IDEMPOTENCY_REPLAY_HEADER) != "true" {
+		t.Fatalf("retry should get the first response, got %+v", replay)
+	}
+	if vls, _ := metadata.ListVolumesName(metadata.CEPH); len(vls) != 1 {
+		t.Fatalf("volume should be created once, got %d", len(vls))
+	}
+	// the response names an operation, it is kept as long as the operation
+	if rec, _ := metadata.GetIdempotency("key-1", time.Now().Unix()); rec.GetExpire() > time.Now().Add(OPERATION_RETENTION).Unix() {
+		t.Fatalf("response should be kept as long as its operation, got %v", rec)
+	}
+	again := &api.VolumeResponse{}
+	sendKeyed(t, router, "POST", "/volume/create", "", create, again)
+	if again.Result != strconv.Itoa(metadata.EcodeVolumeExist) {
+		t.Fatalf("create without key should run again, got %+v", again)
+	}
+
+	// the key of another request
+	refused := &api.RequestResponse{}
+	sendKeyed(t, router, "POST", "/volume/create", "key-1", &api.VolumeCreateRequest{VolumeId: "vol002", DriverName: metadata.CEPH, Capacity: "5"}, refused)
+	if refused.Result != strconv.Itoa(metadata.EcodeRequestKeyReused) || refused.Key != "key-1" {
+		t.Fatalf("key should not be reused, got %+v", refused)
+	}
+
+	// a request still running
+	now := time.Now().Unix()
+	expire := now + 60
+	fingerprint := func(req interface{}) string {
+		body, _ := json.Marshal(req)
+		return requestFingerprint("POST", "/volume/create", map[string]string{}, body)
+	}
+	create2 := &api.VolumeCreateRequest{VolumeId: "vol002", DriverName: metadata.CEPH, Capacity: "5"}
+	if err := metadata.SetIdempotency(&metaproto.Idempotency{Key: []byte("key-2"), Fingerprint: []byte(fingerprint(create2)), Expire: &expire}, now); err != nil {
+		t.Fatal(err)
+	}
+	refused = &api.RequestResponse{}
+	sendKeyed(t, router, "POST", "/volume/create", "key-2", create2, refused)
+	if refused.Result != strconv.Itoa(metadata.EcodeRequestInProgress) {
+		t.Fatalf("request should be in progress, got %+v", refused)
+	}
+
+	// an expired key is a new request
+	expire = now - 1
+	if err := metadata.SetIdempotency(&metaproto.Idempotency{Key: []byte("key-2"), Fingerprint: []byte(fingerprint(create2)), Expire: &expire}, now); err != nil {
+		t.Fatal(err)
+	}
+	resp := &api.VolumeResponse{}
+	sendKeyed(t, router, "POST", "/volume/create", "key-2", create2, resp)
+	if resp.Result != "0" {
+		t.Fatalf("expired key should run the request, got %+v", resp)
+	}
+	waitOperation(t, s, resp.Operation)
+
+	// a request not understood may be sent again
+	failing := s.idempotent("POST", "/test", func(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+		return errors.New("bad request")
+	})
+	r := httptest.NewRequest("POST", "/test", nil)
+	r.Header.Set(api.IDEMPOTENCY_KEY_HEADER, "key-3")
+	if err := failing("1", httptest.NewRecorder(), r, nil); err == nil {
+		t.Fatal("handler error should be returned")
+	}
+	if rec, err := metadata.GetIdempotency("key-3", now); err != nil || rec != nil {
+		t.Fatalf("key should be forgotten, got %v %v", rec, err)
+	}
+
+	// a request running keeps its key a short while, its response the
+	// whole retention
+	var running *metaproto.Idempotency
+	succeeding := s.idempotent("POST", "/test", func(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+		running, _ = metadata.GetIdempotency("key-4", time.Now().Unix())
+		return writeRequestResult(w, "key-4", 0)
+	})
+	r = httptest.NewRequest("POST", "/test", nil)
+	r.Header.Set(api.IDEMPOTENCY_KEY_HEADER, "key-4")
+	if err := succeeding("1", httptest.NewRecorder(), r, nil); err != nil {
+		t.Fatal(err)
+	}
+	if running.GetStatus() != 0 || running.GetExpire() > time.Now().Unix()+IDEMPOTENCY_PENDING_RETENTION {
+		t.Fatalf("running request should keep its key a short while, got %v", running)
+	}
+	if rec, _ := metadata.GetIdempotency("key-4", time.Now().Unix()); rec.GetStatus() == 0 || rec.GetExpire() < time.Now().Unix()+DEFAULT_IDEMPOTENCY_RETENTION-60 {
+		t.Fatalf("response should be kept the whole retention, got %v", rec)
+	}
+
+	// a failed request is replayed as sent, with the message of its handler
+	failed := makeHandlerFunc("POST", "/test", "1", s.idempotent("POST", "/test", func(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+		return writeRequestResult(w, "key-5", errorResult(w, metadata.NewError(metadata.EcodeVolumeNotFound, "volume vol009 not found")))
+	}))
+	send := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/test", nil)
+		r.Header.Set(api.IDEMPOTENCY_KEY_HEADER, "key-5")
+		w := httptest.NewRecorder()
+		failed(w, r)
+		return w
+	}
+	sent, resent := send(), send()
+	if sent.Code != http.StatusNotFound || !strings.Contains(sent.Body.String(), "volume vol009 not found") {
+		t.Fatalf("request should fail with the message of its handler, got %d %s", sent.Code, sent.Body.String())
+	}
+	if resent.Code != sent.Code || resent.Body.String() != sent.Body.String() || resent.Header().Get(api.IDEMPOTENCY_REPLAY_HEADER) != "true" {
+		t.Fatalf("retry should get the response as sent, got %d %s", resent.Code, resent.Body.String())
+	}
+}
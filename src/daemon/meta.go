@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"api"
+	"meta"
+)
+
+func (s *daemon) doMetaExport(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	resp := &api.MetaExportResponse{}
+
+	for {
+		archive, err := metadata.ExportArchive()
+		if err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		resp.Archive, err = json.Marshal(archive)
+		if err != nil {
+			result = metadata.EcodeRequestEncodeError
+			break
+		}
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doMetaImport(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.MetaImportRequest{}
+	resp := &api.MetaImportResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		archive := &metadata.Archive{}
+		if err := json.Unmarshal(req.Archive, archive); err != nil {
+			result = metadata.EcodeArchiveInvalid
+			break
+		}
+
+		changes, err := metadata.ImportArchive(archive, req.DryRun)
+		if err != nil {
+			result = errorResult(w, err)
+		}
+
+		resp.Changes = []api.MetaChange{}
+		for _, c := range changes {
+			resp.Changes = append(resp.Changes, api.MetaChange{Key: c.Key, Action: c.Action})
+		}
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
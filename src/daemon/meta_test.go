@@ -0,0 +1,127 @@
+package daemon
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"api"
+	"meta"
+	"store/memory"
+)
+
+func TestMetaArchive(t *testing.T) {
+	s := newTestDaemon(t, metadata.CEPH, "dev001", "dev002")
+	if result := s.processVolumeCreate(&api.VolumeCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Capacity: "5"}, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("create failed: %d", result)
+	}
+	c1 := strings.Repeat("1", 64)
+	if result := s.processVolumeAttach(&api.VolumeAttachRequest{VolumeId: "vol001", ContainerId: c1, DriverName: metadata.CEPH, Mode: "rw"}, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("attach failed: %d", result)
+	}
+	metadata.AddEvent(metadata.EVENT_HOST_ONLINE, testNodeID, "test")
+
+	export := func() *metadata.Archive {
+		resp := &api.MetaExportResponse{}
+		callHandler(t, s.doMetaExport, "GET", nil, resp)
+		if resp.Result != "0" {
+			t.Fatalf("export failed: %+v", resp)
+		}
+		archive := &metadata.Archive{}
+		if err := json.Unmarshal(resp.Archive, archive); err != nil {
+			t.Fatal(err)
+		}
+		return archive
+	}
+	importArchive := func(archive *metadata.Archive, dryRun bool) *api.MetaImportResponse {
+		data, _ := json.Marshal(archive)
+		resp := &api.MetaImportResponse{}
+		callHandler(t, s.doMetaImport, "POST", &api.MetaImportRequest{Archive: data, DryRun: dryRun}, resp)
+		return resp
+	}
+	changes := func(resp *api.MetaImportResponse) map[string]string {
+		if resp.Result != "0" {
+			t.Fatalf("import failed: %+v", resp)
+		}
+		m := make(map[string]string)
+		for _, c := range resp.Changes {
+			m[c.Key] = c.Action
+		}
+		return m
+	}
+
+	archive := export()
+	kinds := make(map[string]int)
+	for _, r := range archive.Records {
+		kinds[r.Kind]++
+		if r.Kind == "host" && r.Value["ip"] != testNodeID {
+			t.Fatalf("host record should be readable, got %v", r.Value)
+		}
+	}
+	if archive.Version != metadata.ARCHIVE_VERSION || kinds["host"] != 1 || kinds["device"] != 2 || kinds["volume"] != 1 || kinds["container"] != 1 || len(archive.Records) != 5 {
+		t.Fatalf("unexpected archive %+v", archive)
+	}
+	if c := changes(importArchive(archive, true)); len(c) != 0 {
+		t.Fatalf("store matching the archive should not change, got %v", c)
+	}
+
+	// the store diverges
+	volumekey := metadata.GenerateVolumeKey("vol001", metadata.CEPH)
+	metadata.DelVolumeContainer("vol001", metadata.CEPH, c1)
+	if err := metadata.DelVolume("vol001", metadata.CEPH); err != nil {
+		t.Fatal(err)
+	}
+	metadata.AddHost("10.0.0.9", metadata.HOST_ONLINE, nil)
+	metadata.ModHostStatus(testNodeID, metadata.HOST_OFFLINE)
+
+	expected := map[string]string{
+		volumekey:                            metadata.ARCHIVE_ADD,
+		metadata.GenerateHostKey("10.0.0.9"): metadata.ARCHIVE_DELETE,
+		metadata.GenerateHostKey(testNodeID): metadata.ARCHIVE_CHANGE,
+	}
+	dry := changes(importArchive(archive, true))
+	for key, action := range expected {
+		if dry[key] != action {
+			t.Fatalf("%s should be %s, got %v", key, action, dry)
+		}
+	}
+	if _, err := metadata.GetVolume("vol001", metadata.CEPH); err == nil {
+		t.Fatal("dry run should change nothing")
+	}
+	if c := changes(importArchive(archive, false)); len(c) != len(dry) {
+		t.Fatalf("import should make the changes of the dry run, got %v and %v", c, dry)
+	}
+	if c := changes(importArchive(archive, true)); len(c) != 0 {
+		t.Fatalf("store should match the archive after import, got %v", c)
+	}
+	vl, err := metadata.GetVolume("vol001", metadata.CEPH)
+	if err != nil || string(vl.Writable) != c1 {
+		t.Fatalf("vol001 should be back with its writer, got %v %v", vl, err)
+	}
+	if events, _ := metadata.ListEvents(); len(events) != 1 {
+		t.Fatalf("events should be left alone, got %v", events)
+	}
+
+	// into an empty store
+	memory.NewStore()
+	if c := changes(importArchive(archive, false)); len(c) != len(archive.Records) {
+		t.Fatalf("every record should be added, got %v", c)
+	}
+	again := export()
+	a, _ := json.Marshal(archive.Records)
+	b, _ := json.Marshal(again.Records)
+	if string(a) != string(b) || again.Checksum != archive.Checksum {
+		t.Fatal("export of the restored store should match the archive")
+	}
+
+	// archives that don't validate
+	archive.Records[0].Value["status"] = "99"
+	if resp := importArchive(archive, true); resp.Result != strconv.Itoa(metadata.EcodeArchiveChecksum) {
+		t.Fatalf("edited archive should fail its checksum, got %+v", resp)
+	}
+	archive.Version = metadata.ARCHIVE_VERSION + 1
+	if resp := importArchive(archive, true); resp.Result != strconv.Itoa(metadata.EcodeArchiveVersion) {
+		t.Fatalf("newer archive should be refused, got %+v", resp)
+	}
+}
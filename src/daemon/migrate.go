@@ -0,0 +1,477 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"api"
+	"driver"
+	"meta"
+	"meta/proto"
+	"scheduler"
+	"util"
+)
+
+const (
+	BLOCK_COPY_SIZE = 4 << 20
+	// the progress of a copy is recorded at most that often
+	MIGRATE_SAVE_INTERVAL = time.Second
+)
+
+var (
+	// overridden by tests
+	attachLoopbackDevice = util.AttachLoopbackDevice
+	detachLoopbackDevice = util.DetachLoopbackDevice
+)
+
+// migration is the progress of the copy of a volume to another device,
+// kept by the daemon running it until the next migration of the volume.
+// It is recorded in the metadata as it goes, the status of a migration is
+// read from there.
+type migration struct {
+	volumeid   string
+	driverName string
+	host       string
+	from       string
+	to         string
+
+	status string
+	done   int64
+	total  int64
+	err    string
+	saved  time.Time
+
+	finished chan struct{}
+}
+
+func migrationKey(volumeid string, driverName string) string {
+	return driverName + "/" + volumeid
+}
+
+func (s *daemon) getMigration(volumeid string, driverName string) *migration {
+	s.migrateLock.Lock()
+	defer s.migrateLock.Unlock()
+	return s.migrations[migrationKey(volumeid, driverName)]
+}
+
+func (s *daemon) addMigration(m *migration) {
+	s.migrateLock.Lock()
+	defer s.migrateLock.Unlock()
+	if s.migrations == nil {
+		s.migrations = make(map[string]*migration)
+	}
+	s.migrations[migrationKey(m.volumeid, m.driverName)] = m
+}
+
+// record is the migration as recorded in the metadata, the caller holds
+// the migrate lock.
+func (m *migration) record() *metaproto.Migration {
+	done, total := m.done, m.total
+	return &metaproto.Migration{
+		Volumeid: []byte(m.volumeid),
+		Driver:   []byte(m.driverName),
+		Host:     []byte(m.host),
+		From:     []byte(m.from),
+		To:       []byte(m.to),
+		Status:   []byte(m.status),
+		Done:     &done,
+		Total:    &total,
+		Error:    []byte(m.err),
+		Optime:   []byte(strconv.FormatInt(time.Now().Unix(), 10)),
+	}
+}
+
+// saveMigration records the migration, the caller holds the metadata lock.
+func (s *daemon) saveMigration(m *migration) error {
+	s.migrateLock.Lock()
+	mg := m.record()
+	m.saved = time.Now()
+	s.migrateLock.Unlock()
+	return metadata.SetMigration(mg)
+}
+
+func (s *daemon) progress(m *migration) func(done int64, total int64) {
+	return func(done int64, total int64) {
+		s.migrateLock.Lock()
+		m.done, m.total = done, total
+		save := time.Since(m.saved) >= MIGRATE_SAVE_INTERVAL
+		s.migrateLock.Unlock()
+		if !save {
+			return
+		}
+
+		metadata.Lock()
+		defer metadata.Unlock()
+		if err := s.saveMigration(m); err != nil {
+			log.Warnf("[progress] record migration of volume %s error: %s", m.volumeid, err.Error())
+		}
+	}
+}
+
+func (s *daemon) finish(m *migration, err error) {
+	s.migrateLock.Lock()
+	if err != nil {
+		m.status, m.err = api.MIGRATE_FAILED, err.Error()
+	} else {
+		m.status, m.done = api.MIGRATE_DONE, m.total
+	}
+	s.migrateLock.Unlock()
+
+	metadata.Lock()
+	if err := s.saveMigration(m); err != nil {
+		log.Errorf("[finish] record migration of volume %s error: %s", m.volumeid, err.Error())
+	}
+	metadata.Unlock()
+	close(m.finished)
+}
+
+func migrateResponse(mg *metaproto.Migration, resp *api.MigrateResponse) {
+	resp.ID = string(mg.Volumeid)
+	resp.Status = string(mg.Status)
+	resp.FromDevice = string(mg.From)
+	resp.ToDevice = string(mg.To)
+	resp.Done = strconv.FormatInt(mg.GetDone(), 10)
+	resp.Total = strconv.FormatInt(mg.GetTotal(), 10)
+	resp.Error = string(mg.Error)
+}
+
+func (s *daemon) doVolumeMigrate(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.VolumeMigrateRequest{}
+	resp := &api.MigrateResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		result = s.processVolumeMigrate(req, resp)
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// processVolumeMigrate reserves the target device and starts copying the
+// volume to it, the copy goes on after the response. The caller holds the
+// metadata lock.
+func (s *daemon) processVolumeMigrate(req *api.VolumeMigrateRequest, resp *api.MigrateResponse) int {
+	if (req.ToDevice == "") == (req.ToHost == "") {
+		return metadata.EcodeParameterError
+	}
+	d := s.getVolumeDriver(req.DriverName)
+	if d == nil || metadata.SharedBackend(req.DriverName) {
+		return metadata.EcodeParameterError
+	}
+
+	vl, err := metadata.GetVolume(req.VolumeId, req.DriverName)
+	if err != nil {
+		return err.(*metadata.Error).Code
+	}
+	// volumes are scheduled on one device, replicas are not moved
+	if len(vl.Devices) != 1 {
+		return metadata.EcodeParameterError
+	}
+	devs, err := getVolumeDevices(vl, req.DriverName)
+	if err != nil {
+		return err.(*metadata.Error).Code
+	}
+	target, err := migrateTarget(req, vl)
+	if err != nil {
+		return err.(*metadata.Error).Code
+	}
+
+	if err := metadata.StartVolumeMigration(req.VolumeId, req.DriverName); err != nil {
+		return err.(*metadata.Error).Code
+	}
+	if err := metadata.UseDevice(string(target.Id), req.DriverName, req.VolumeId); err != nil {
+		metadata.FinishVolumeMigration(req.VolumeId, req.DriverName, nil)
+		return err.(*metadata.Error).Code
+	}
+
+	m := &migration{
+		volumeid:   req.VolumeId,
+		driverName: req.DriverName,
+		host:       s.hostIP(),
+		from:       string(devs[0].Id),
+		to:         string(target.Id),
+		status:     api.MIGRATE_COPYING,
+		finished:   make(chan struct{}),
+	}
+	if err := s.saveMigration(m); err != nil {
+		metadata.FreeDevice(string(target.Id), req.DriverName, req.VolumeId)
+		metadata.FinishVolumeMigration(req.VolumeId, req.DriverName, nil)
+		return err.(*metadata.Error).Code
+	}
+	s.addMigration(m)
+	log.Infof("[processVolumeMigrate] migrating volume %s from device %s to %s", req.VolumeId, m.from, m.to)
+	go s.migrateVolume(m, d, vl, devs, target)
+
+	migrateResponse(m.record(), resp)
+	return 0
+}
+
+// migrateTarget checks the device asked for, or schedules one on the host
+// asked for. Devices in use, the current one included, are never taken.
+func migrateTarget(req *api.VolumeMigrateRequest, vl *metaproto.Volume) (*metaproto.Device, error) {
+	if req.ToHost != "" {
+		opts := map[string]string{
+			scheduler.FilterCapacity: string(vl.Capacity),
+			scheduler.FilterHost:     req.ToHost,
+			scheduler.Backend:        req.DriverName,
+			scheduler.Replica:        "1",
+		}
+		ds, err := scheduler.DoScheduler(opts)
+		if err != nil {
+			return nil, metadata.NewError(metadata.EcodeSchedulerError, err.Error())
+		}
+		// a pool the volume is on has room for it already
+		for _, dv := range ds {
+			if !onDevice(vl, string(dv.Id)) {
+				return dv, nil
+			}
+		}
+		return nil, metadata.NewError(metadata.EcodeSchedulerError, "no other device on host "+req.ToHost)
+	}
+
+	dv, err := metadata.GetDevice(req.ToDevice, req.DriverName)
+	if err != nil {
+		return nil, err
+	}
+	if dv == nil {
+		return nil, metadata.NewError(metadata.EcodeDeviceNotFound, req.ToDevice)
+	}
+	// a pool takes the volume into its free space, unless it holds it
+	// already
+	size, _ := metadata.DeviceTotal(dv)
+	if metadata.PoolBackend(req.DriverName) {
+		if onDevice(vl, req.ToDevice) {
+			return nil, metadata.NewError(metadata.EcodeDeviceInUse, "volume already on the device.")
+		}
+		size, _ = metadata.DeviceFree(dv)
+	} else if len(dv.Volumekey) != 0 {
+		return nil, metadata.NewError(metadata.EcodeDeviceInUse, "device already in use.")
+	}
+	status, _ := metadata.DeviceStatus(dv)
+	capacity, _ := metadata.VolumeCapacity(vl)
+	if status != metadata.DEVICE_READY || size < capacity {
+		return nil, metadata.NewError(metadata.EcodeSchedulerError, "Device "+req.ToDevice+" can't take the volume.")
+	}
+	return dv, nil
+}
+
+func onDevice(vl *metaproto.Volume, devid string) bool {
+	for _, ad := range vl.Devices {
+		if string(ad.Deviceid) == devid {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateVolume copies the volume without the metadata lock, the volume
+// being marked as migrating meanwhile. The record is switched to the new
+// device at once, only then is the old copy deleted and its device freed.
+func (s *daemon) migrateVolume(m *migration, d driver.VolumeDriver, vl *metaproto.Volume, devs []*metaproto.Device, target *metaproto.Device) {
+	to := &metaproto.Volume{
+		Id:       vl.Id,
+		Capacity: vl.Capacity,
+		Devices:  []*metaproto.Volume_AttachDevice{{Deviceid: target.Id, Status: target.Status}},
+	}
+	toDevs := []*metaproto.Device{target}
+
+	err := s.copyVolume(m, d, vl, devs, to, toDevs)
+	if err != nil {
+		log.Errorf("[migrateVolume] copy volume %s to device %s error: %s", m.volumeid, m.to, err.Error())
+		metadata.Lock()
+		if _, err := metadata.FinishVolumeMigration(m.volumeid, m.driverName, nil); err != nil {
+			log.Errorf("[migrateVolume] end migration of volume %s error: %s", m.volumeid, err.Error())
+		}
+		if err := metadata.FreeDevice(m.to, m.driverName, m.volumeid); err != nil {
+			log.Errorf("[migrateVolume] free device %s error: %s", m.to, err.Error())
+		}
+		metadata.Unlock()
+		s.finish(m, err)
+		return
+	}
+
+	metadata.Lock()
+	old, err := metadata.FinishVolumeMigration(m.volumeid, m.driverName, to.Devices)
+	metadata.Unlock()
+	if err != nil {
+		log.Errorf("[migrateVolume] switch volume %s to device %s error: %s", m.volumeid, m.to, err.Error())
+		d.DeleteVolume(to, toDevs)
+		s.finish(m, err)
+		return
+	}
+
+	// a copy that can't be deleted keeps its device, so it isn't lost
+	if err := d.DeleteVolume(old, devs); err != nil {
+		log.Errorf("[migrateVolume] driver %s delete volume %s from device %s error: %s", m.driverName, m.volumeid, m.from, err.Error())
+	} else {
+		metadata.Lock()
+		if err := metadata.FreeDevice(m.from, m.driverName, m.volumeid); err != nil {
+			log.Errorf("[migrateVolume] free device %s error: %s", m.from, err.Error())
+		}
+		metadata.Unlock()
+	}
+
+	log.Infof("[migrateVolume] volume %s migrated from device %s to %s", m.volumeid, m.from, m.to)
+	s.finish(m, nil)
+}
+
+// copyVolume creates the volume on the new device and fills it, by the
+// driver when it can copy, block by block otherwise. A failed copy is
+// deleted.
+func (s *daemon) copyVolume(m *migration, d driver.VolumeDriver, vl *metaproto.Volume, devs []*metaproto.Device, to *metaproto.Volume, toDevs []*metaproto.Device) error {
+	if err := d.CreateVolume(to, toDevs); err != nil {
+		return err
+	}
+
+	var err error
+	if cd, ok := d.(driver.CopyDriver); ok {
+		err = cd.CopyVolume(vl, devs, to, toDevs, s.progress(m))
+	} else {
+		err = blockCopyVolume(d, vl, devs, to, toDevs, s.progress(m))
+	}
+	if err != nil {
+		if err := d.DeleteVolume(to, toDevs); err != nil {
+			log.Warnf("[copyVolume] driver %s delete copy of volume %s error: %s", d.Name(), vl.Id, err.Error())
+		}
+	}
+	return err
+}
+
+// blockCopyVolume attaches both volumes on this host and copies the first
+// capacity MB of one to the other.
+func blockCopyVolume(d driver.VolumeDriver, vl *metaproto.Volume, devs []*metaproto.Device, to *metaproto.Volume, toDevs []*metaproto.Device, progress func(done int64, total int64)) error {
+	capacity, err := metadata.VolumeCapacity(vl)
+	if err != nil {
+		return err
+	}
+
+	src, err := d.AttachVolume(vl, devs, metadata.ROVolume)
+	if err != nil {
+		return err
+	}
+	defer d.DetachVolume(vl, devs)
+	dst, err := d.AttachVolume(to, toDevs, metadata.RWVolume)
+	if err != nil {
+		return err
+	}
+	defer d.DetachVolume(to, toDevs)
+
+	srcDev, releaseSrc, err := blockDevice(src, true)
+	if err != nil {
+		return err
+	}
+	defer releaseSrc()
+	dstDev, releaseDst, err := blockDevice(dst, false)
+	if err != nil {
+		return err
+	}
+	defer releaseDst()
+
+	return copyBlocks(srcDev, dstDev, int64(capacity)<<20, progress)
+}
+
+// blockDevice returns the device of an attachment, file-backed volumes
+// are set up on a loop device.
+func blockDevice(path string, readonly bool) (string, func(), error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return path, func() {}, nil
+	}
+
+	dev, err := attachLoopbackDevice(path, readonly)
+	if err != nil {
+		return "", nil, err
+	}
+	return dev, func() {
+		if err := detachLoopbackDevice(path, dev); err != nil {
+			log.Warnf("[blockDevice] detach loop device %s of %s error: %s", dev, path, err.Error())
+		}
+	}, nil
+}
+
+func copyBlocks(src string, dst string, total int64, progress func(done int64, total int64)) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	buf := make([]byte, BLOCK_COPY_SIZE)
+	for done := int64(0); done < total; {
+		size := int64(len(buf))
+		if total-done < size {
+			size = total - done
+		}
+		n, err := io.ReadFull(in, buf[:size])
+		if err != nil {
+			return fmt.Errorf("read %s at %d: %s", src, done, err.Error())
+		}
+		if _, err := out.Write(buf[:n]); err != nil {
+			return err
+		}
+		done += int64(n)
+		progress(done, total)
+	}
+	return out.Sync()
+}
+
+func (s *daemon) doVolumeMigrateGet(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.VolumeGetRequest{}
+	resp := &api.MigrateResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		mg, err := metadata.GetMigration(req.VolumeId, req.DriverName)
+		if err != nil {
+			result = errorResult(w, err)
+			break
+		}
+		migrateResponse(mg, resp)
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"api"
+	"driver"
+	"meta"
+	"meta/proto"
+	"store/memory"
+)
+
+// fileDriver keeps volumes as files named after their device, attaching
+// gives the file.
+type fileDriver struct {
+	*fakeDriver
+	dir string
+}
+
+func (f *fileDriver) path(vl *metaproto.Volume) string {
+	return filepath.Join(f.dir, string(vl.Devices[0].Deviceid)+"-"+string(vl.Id))
+}
+
+func (f *fileDriver) CreateVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	capacity, _ := strconv.Atoi(string(vl.Capacity))
+	return ioutil.WriteFile(f.path(vl), make([]byte, capacity<<20), 0600)
+}
+
+func (f *fileDriver) DeleteVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	return os.Remove(f.path(vl))
+}
+
+func (f *fileDriver) AttachVolume(vl *metaproto.Volume, devs []*metaproto.Device, mode string) (string, error) {
+	return f.path(vl), nil
+}
+
+func setupFakeLoop() (map[string]string, func()) {
+	loops := map[string]string{}
+	oldAttach, oldDetach := attachLoopbackDevice, detachLoopbackDevice
+	// the file stands for its loop device
+	attachLoopbackDevice = func(file string, readonly bool) (string, error) {
+		loops[file] = file
+		return file, nil
+	}
+	detachLoopbackDevice = func(file, dev string) error {
+		delete(loops, file)
+		return nil
+	}
+	return loops, func() {
+		attachLoopbackDevice, detachLoopbackDevice = oldAttach, oldDetach
+	}
+}
+
+func TestVolumeMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	loops, cleanup := setupFakeLoop()
+	defer cleanup()
+
+	memory.NewStore()
+	for _, host := range []string{testNodeID, "10.0.0.2"} {
+		if err := metadata.AddHost(host, metadata.HOST_ONLINE, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for dev, host := range map[string]string{"dev001": testNodeID, "dev002": testNodeID, "dev003": "10.0.0.2"} {
+		if err := metadata.AddDevice(dev, host, 6789, 10240, 10240, metadata.DEVICE_READY, "rbd", metadata.CEPH); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fd := &fileDriver{fakeDriver: newFakeDriver(), dir: dir}
+	s := &daemon{Drivers: map[string]driver.VolumeDriver{metadata.CEPH: fd}}
+	req := &api.VolumeCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Capacity: "5"}
+	if result := s.processVolumeCreate(req, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("create failed with %d", result)
+	}
+	vl, _ := metadata.GetVolume("vol001", metadata.CEPH)
+	data := bytes.Repeat([]byte("data"), 1<<20+1)
+	if err := ioutil.WriteFile(fd.path(vl), append(data, make([]byte, 5<<20-len(data))...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	migrate := func(req *api.VolumeMigrateRequest) *api.MigrateResponse {
+		resp := &api.MigrateResponse{}
+		callHandler(t, s.doVolumeMigrate, "POST", req, resp)
+		if resp.Result != "0" {
+			return resp
+		}
+		<-s.getMigration(req.VolumeId, req.DriverName).finished
+		resp = &api.MigrateResponse{}
+		callHandler(t, s.doVolumeMigrateGet, "GET", &api.VolumeGetRequest{VolumeId: req.VolumeId, DriverName: req.DriverName}, resp)
+		return resp
+	}
+	checkMoved := func(from string, to string) {
+		vl, err := metadata.GetVolume("vol001", metadata.CEPH)
+		if err != nil || len(vl.Devices) != 1 || string(vl.Devices[0].Deviceid) != to || metadata.VolumeStatus(vl) != metadata.VOLUME_ONLINE {
+			t.Fatalf("volume should be on %s only, got %v %v", to, vl, err)
+		}
+		moved, err := ioutil.ReadFile(fd.path(vl))
+		if err != nil || !bytes.Equal(moved[:len(data)], data) || len(moved) != 5<<20 {
+			t.Fatalf("data should be copied to %s: %v", to, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, from+"-vol001")); !os.IsNotExist(err) {
+			t.Fatalf("copy on %s should be deleted, got %v", from, err)
+		}
+		if dv, _ := metadata.GetDevice(from, metadata.CEPH); len(dv.Volumekey) != 0 {
+			t.Fatalf("device %s should be freed", from)
+		}
+		if len(loops) != 0 {
+			t.Fatalf("loop devices should be released, got %v", loops)
+		}
+	}
+
+	if resp := migrate(&api.VolumeMigrateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, ToDevice: "dev001"}); resp.Result != strconv.Itoa(metadata.EcodeDeviceInUse) {
+		t.Fatalf("device in use should be refused, got %+v", resp)
+	}
+
+	from := string(vl.Devices[0].Deviceid)
+	to := "dev001"
+	if from == to {
+		to = "dev002"
+	}
+	resp := migrate(&api.VolumeMigrateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, ToDevice: to})
+	if resp.Status != api.MIGRATE_DONE || resp.Done != strconv.Itoa(5<<20) || resp.FromDevice != from {
+		t.Fatalf("migration should be done, got %+v", resp)
+	}
+	checkMoved(from, to)
+	// any daemon tells how the migration went
+	other := &daemon{}
+	resp = &api.MigrateResponse{}
+	callHandler(t, other.doVolumeMigrateGet, "GET", &api.VolumeGetRequest{VolumeId: "vol001", DriverName: metadata.CEPH}, resp)
+	if resp.Status != api.MIGRATE_DONE || resp.ToDevice != to {
+		t.Fatalf("migration should be recorded, got %+v", resp)
+	}
+
+	resp = migrate(&api.VolumeMigrateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, ToHost: "10.0.0.2"})
+	if resp.Status != api.MIGRATE_DONE || resp.ToDevice != "dev003" {
+		t.Fatalf("migration should pick the device of the host, got %+v", resp)
+	}
+	checkMoved(to, "dev003")
+
+	// volumes in use stay where they are
+	attach := &api.VolumeAttachRequest{VolumeId: "vol001", DriverName: metadata.CEPH, ContainerId: "c1", Mode: "rw"}
+	if result := s.processVolumeAttach(attach, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("attach failed with %d", result)
+	}
+	if resp := migrate(&api.VolumeMigrateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, ToDevice: from}); resp.Result != strconv.Itoa(metadata.EcodeVolumeInUse) {
+		t.Fatalf("volume in use should be refused, got %+v", resp)
+	}
+	if dv, _ := metadata.GetDevice(from, metadata.CEPH); len(dv.Volumekey) != 0 {
+		t.Fatalf("refused migration should not keep device %s", from)
+	}
+}
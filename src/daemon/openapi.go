@@ -0,0 +1,158 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"api"
+)
+
+var pathParam = regexp.MustCompile(`{([^}]+)}`)
+
+// schemaOf is the JSON schema of the values of t, as encoded by
+// encoding/json.
+func schemaOf(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := field.Name
+			if tag := field.Tag.Get("json"); tag != "" {
+				if tag == "-" {
+					continue
+				}
+				if n := strings.Split(tag, ",")[0]; n != "" {
+					name = n
+				}
+			}
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				for n, p := range schemaOf(field.Type)["properties"].(map[string]interface{}) {
+					properties[n] = p
+				}
+				continue
+			}
+			properties[name] = schemaOf(field.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	return map[string]interface{}{}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// openAPI is the OpenAPI document of the routes.
+func openAPI(routes []route) map[string]interface{} {
+	errorSchema := schemaOf(reflect.TypeOf(struct {
+		Result string
+		Error  api.Error
+	}{}))
+
+	paths := map[string]interface{}{}
+	for _, rt := range routes {
+		path := "/v" + api.API_V2_VERSION + rt.path
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+
+		success := map[string]interface{}{"description": http.StatusText(rt.status)}
+		if rt.response != nil {
+			success["content"] = jsonContent(schemaOf(reflect.TypeOf(rt.response)))
+		}
+		op := map[string]interface{}{
+			"summary": rt.summary,
+			"responses": map[string]interface{}{
+				strconv.Itoa(rt.status): success,
+				"default": map[string]interface{}{
+					"description": "Error, its code listed by /v" + api.API_V2_VERSION + "/errors",
+					"content":     jsonContent(errorSchema),
+				},
+			},
+		}
+
+		params := []interface{}{}
+		for _, m := range pathParam.FindAllStringSubmatch(rt.path, -1) {
+			params = append(params, map[string]interface{}{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		names := []string{}
+		for name := range rt.query {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			params = append(params, map[string]interface{}{
+				"name":        name,
+				"in":          "query",
+				"description": rt.query[name],
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+		if len(params) != 0 {
+			op["parameters"] = params
+		}
+		if rt.request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemaOf(reflect.TypeOf(rt.request))),
+			}
+		}
+		item[strings.ToLower(rt.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   "policy",
+			"version": api.API_V2_VERSION,
+		},
+		"paths": paths,
+	}
+}
+
+func (s *daemon) doOpenAPI(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	data, err := json.MarshalIndent(openAPI(s.v2Routes()), "", "\t")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	return err
+}
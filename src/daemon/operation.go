@@ -0,0 +1,450 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"api"
+	"meta"
+	"meta/proto"
+	"util"
+)
+
+const (
+	DEFAULT_OPERATION_WORKERS = 4
+	// operations waiting for a worker, requests beyond are refused
+	OPERATION_QUEUE_SIZE = 1024
+	// an ended operation is kept this long for its result to be read
+	OPERATION_RETENTION = time.Hour
+)
+
+// volumeOp is a volume operation in three steps, each giving the result
+// code of the operation. prepare checks the request and records the
+// change under the metadata lock, run calls the backend driver without
+// the lock, as real backends take minutes, and commit records the outcome
+// under the lock again, undoing what prepare did when run failed.
+type volumeOp struct {
+	prepare func() int
+	run     func() error
+	commit  func(err error) int
+
+	// resume takes the place of prepare for an operation a restart
+	// interrupted, finding in the records what prepare left, owner being
+	// the container record prepare replaced or dropped. Nil for the
+	// operations never queued.
+	resume func(owner *metaproto.Volume_OwnerContainer) int
+	// owner returns the container record prepare replaced or dropped, kept
+	// with the operation to resume it. Nil for the operations without.
+	owner func() *metaproto.Volume_OwnerContainer
+}
+
+// runLocked runs the operation at once, the caller holds the metadata
+// lock all along.
+func (op *volumeOp) runLocked() int {
+	if result := op.prepare(); result != 0 {
+		return result
+	}
+	return op.commit(op.run())
+}
+
+// runUnlocked runs the operation at once, the metadata lock only held by
+// prepare and commit. The caller does not hold it.
+func (op *volumeOp) runUnlocked() int {
+	metadata.Lock()
+	result := op.prepare()
+	metadata.Unlock()
+	if result != 0 {
+		return result
+	}
+
+	err := op.run()
+
+	metadata.Lock()
+	defer metadata.Unlock()
+	return op.commit(err)
+}
+
+// driverResult is the result code of a failed driver call.
+func driverResult(err error) int {
+	if e, ok := err.(*metadata.Error); ok {
+		return e.Code
+	}
+	return metadata.EcodeDriverError
+}
+
+// operation is a volume operation queued by a request, kept by the daemon
+// running it until OPERATION_RETENTION after it ended. Its record in the
+// metadata tells the other daemons and the next start of this one.
+type operation struct {
+	id         string
+	kind       string
+	volumeid   string
+	driverName string
+	host       string
+	request    []byte
+
+	status  string
+	result  int
+	resp    *api.VolumeResponse
+	created time.Time
+	ended   time.Time
+
+	op       *volumeOp
+	finished chan struct{}
+}
+
+func (s *daemon) getOperation(id string) *operation {
+	s.opLock.Lock()
+	defer s.opLock.Unlock()
+	return s.operations[id]
+}
+
+// addOperation records a new operation, forgetting those ended long ago.
+func (s *daemon) addOperation(o *operation) {
+	s.opLock.Lock()
+	defer s.opLock.Unlock()
+	if s.operations == nil {
+		s.operations = make(map[string]*operation)
+	}
+	for id, old := range s.operations {
+		if !old.ended.IsZero() && time.Since(old.ended) > OPERATION_RETENTION {
+			delete(s.operations, id)
+		}
+	}
+	s.operations[o.id] = o
+}
+
+func (s *daemon) setOperationStatus(o *operation, status string) {
+	s.opLock.Lock()
+	defer s.opLock.Unlock()
+	o.status = status
+}
+
+// endOperation records the result of the operation. The caller holds the
+// metadata lock.
+func (s *daemon) endOperation(o *operation, result int) {
+	s.opLock.Lock()
+	o.result = result
+	o.status = api.OPERATION_DONE
+	if result != 0 {
+		o.status = api.OPERATION_FAILED
+	}
+	o.ended = time.Now()
+	close(o.finished)
+	s.opLock.Unlock()
+
+	s.saveOperation(o)
+}
+
+// saveOperation records the operation as it stands, the record of an ended
+// one kept OPERATION_RETENTION. The caller holds the metadata lock.
+func (s *daemon) saveOperation(o *operation) {
+	s.opLock.Lock()
+	created := o.created.Unix()
+	rec := &metaproto.Operation{
+		Id:       []byte(o.id),
+		Kind:     []byte(o.kind),
+		Volumeid: []byte(o.volumeid),
+		Driver:   []byte(o.driverName),
+		Host:     []byte(o.host),
+		Status:   []byte(o.status),
+		Request:  o.request,
+		Created:  &created,
+	}
+	if o.op.owner != nil {
+		rec.Owner = o.op.owner()
+	}
+	ttl := int64(0)
+	if !o.ended.IsZero() {
+		result, ended := int64(o.result), o.ended.Unix()
+		rec.Result = &result
+		rec.Ended = &ended
+		rec.Response, _ = json.Marshal(o.resp)
+		ttl = int64(OPERATION_RETENTION / time.Second)
+	}
+	s.opLock.Unlock()
+
+	if err := metadata.SetOperation(rec, ttl); err != nil {
+		log.Warnf("[saveOperation] record operation %s error: %s", o.id, err.Error())
+	}
+}
+
+func (s *daemon) operationResponse(o *operation, resp *api.OperationResponse) {
+	s.opLock.Lock()
+	defer s.opLock.Unlock()
+	resp.ID = o.id
+	resp.Type = o.kind
+	resp.VolumeId = o.volumeid
+	resp.DriverName = o.driverName
+	resp.Status = o.status
+	resp.Created = strconv.FormatInt(o.created.Unix(), 10)
+	if !o.ended.IsZero() {
+		resp.OpResult = strconv.Itoa(o.result)
+		resp.Ended = strconv.FormatInt(o.ended.Unix(), 10)
+		resp.Volume = *o.resp
+	}
+}
+
+// recordResponse tells an operation from its record, as kept for those
+// another daemon or an earlier start of this one queued.
+func recordResponse(rec *metaproto.Operation, resp *api.OperationResponse) {
+	resp.ID = string(rec.Id)
+	resp.Type = string(rec.Kind)
+	resp.VolumeId = string(rec.Volumeid)
+	resp.DriverName = string(rec.Driver)
+	resp.Status = string(rec.Status)
+	resp.Created = strconv.FormatInt(rec.GetCreated(), 10)
+	if rec.GetEnded() != 0 {
+		resp.OpResult = strconv.FormatInt(rec.GetResult(), 10)
+		resp.Ended = strconv.FormatInt(rec.GetEnded(), 10)
+		json.Unmarshal(rec.Response, &resp.Volume)
+	}
+}
+
+// queueOperation prepares the operation of the request and queues the
+// rest of it for the workers, resp tells the volume as prepared and the
+// operation id. The caller holds the metadata lock.
+func (s *daemon) queueOperation(kind string, volumeid string, driverName string, req interface{}, op *volumeOp, opResp *api.VolumeResponse, resp *api.VolumeResponse) int {
+	if s.opQueue == nil {
+		return metadata.EcodeOperationQueueFull
+	}
+	request, err := json.Marshal(req)
+	if err != nil {
+		return metadata.EcodeRequestEncodeError
+	}
+	if result := op.prepare(); result != 0 {
+		return result
+	}
+
+	o := &operation{
+		id:         util.NewUUID(),
+		kind:       kind,
+		volumeid:   volumeid,
+		driverName: driverName,
+		host:       s.hostIP(),
+		request:    request,
+		status:     api.OPERATION_QUEUED,
+		resp:       opResp,
+		created:    time.Now(),
+		op:         op,
+		finished:   make(chan struct{}),
+	}
+	// the workers fill opResp from now on
+	*resp = *opResp
+	resp.Operation = o.id
+
+	if result := s.enqueue(o); result != 0 {
+		return result
+	}
+	log.Infof("[queueOperation] %s volume %s queued as operation %s", kind, volumeid, o.id)
+	return 0
+}
+
+// enqueue hands a prepared operation to the workers and records it, it is
+// undone when the queue is full. The caller holds the metadata lock, so
+// the workers record it running only once it is recorded queued.
+func (s *daemon) enqueue(o *operation) int {
+	select {
+	case s.opQueue <- o:
+	default:
+		// waiting would hold the lock the workers need to commit
+		o.op.commit(errors.New("operation queue full"))
+		s.endOperation(o, metadata.EcodeOperationQueueFull)
+		return metadata.EcodeOperationQueueFull
+	}
+	s.addOperation(o)
+	s.saveOperation(o)
+	return 0
+}
+
+// resumeOperations queues again the operations of this host a restart
+// interrupted, their change recorded by prepare being neither committed
+// nor undone. An interrupted create is undone, as its storage may be half
+// made, the others run their backend step again, the drivers taking a
+// step already done for done.
+func (s *daemon) resumeOperations() {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	recs, err := metadata.ListOperations()
+	if err != nil {
+		log.Errorf("[resumeOperations] list operations error: %s", err.Error())
+		return
+	}
+	for _, rec := range recs {
+		if string(rec.Host) != s.hostIP() || rec.GetEnded() != 0 {
+			continue
+		}
+
+		o := &operation{
+			id:         string(rec.Id),
+			kind:       string(rec.Kind),
+			volumeid:   string(rec.Volumeid),
+			driverName: string(rec.Driver),
+			host:       string(rec.Host),
+			request:    rec.Request,
+			status:     api.OPERATION_QUEUED,
+			resp:       &api.VolumeResponse{},
+			created:    time.Unix(rec.GetCreated(), 0),
+			finished:   make(chan struct{}),
+		}
+		o.op = s.requestOp(o.kind, o.request, o.resp)
+		if o.op == nil {
+			log.Errorf("[resumeOperations] operation %s: not a %s request", o.id, o.kind)
+			o.op = &volumeOp{}
+			s.endOperation(o, metadata.EcodeRequestDecodeError)
+			continue
+		}
+		if result := o.op.resume(rec.Owner); result != 0 {
+			log.Errorf("[resumeOperations] %s volume %s of operation %s can't be resumed: %d", o.kind, o.volumeid, o.id, result)
+			s.endOperation(o, result)
+			continue
+		}
+
+		if result := s.enqueue(o); result == 0 {
+			log.Infof("[resumeOperations] %s volume %s resumed as operation %s", o.kind, o.volumeid, o.id)
+		}
+	}
+}
+
+// requestOp builds the operation of kind from its request body, nil when
+// the body is no request of kind.
+func (s *daemon) requestOp(kind string, request []byte, resp *api.VolumeResponse) *volumeOp {
+	switch kind {
+	case api.OPERATION_CREATE:
+		req := &api.VolumeCreateRequest{}
+		if json.Unmarshal(request, req) == nil {
+			return s.volumeCreateOp(req, resp)
+		}
+	case api.OPERATION_ATTACH:
+		req := &api.VolumeAttachRequest{}
+		if json.Unmarshal(request, req) == nil {
+			return s.volumeAttachOp(req, resp)
+		}
+	case api.OPERATION_DETACH:
+		req := &api.VolumeDetachRequest{}
+		if json.Unmarshal(request, req) == nil {
+			return s.volumeDetachOp(req, resp)
+		}
+	case api.OPERATION_DELETE:
+		req := &api.VolumeDeleteRequest{}
+		if json.Unmarshal(request, req) == nil {
+			return s.volumeDeleteOp(req, resp)
+		}
+	}
+	return nil
+}
+
+// execute runs the backend step of an operation and commits it.
+func (s *daemon) execute(o *operation) {
+	s.setOperationStatus(o, api.OPERATION_RUNNING)
+	metadata.Lock()
+	s.saveOperation(o)
+	metadata.Unlock()
+
+	err := o.op.run()
+
+	metadata.Lock()
+	defer metadata.Unlock()
+	s.endOperation(o, o.op.commit(err))
+}
+
+// workerPool runs the queued volume operations, a few at a time.
+type workerPool struct {
+	s     *daemon
+	queue chan *operation
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (s *daemon) startWorkers(workers int) *workerPool {
+	if workers <= 0 {
+		workers = DEFAULT_OPERATION_WORKERS
+	}
+	if s.opQueue == nil {
+		s.opQueue = make(chan *operation, OPERATION_QUEUE_SIZE)
+	}
+	wp := &workerPool{
+		s:     s,
+		queue: s.opQueue,
+		stop:  make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		wp.wg.Add(1)
+		go wp.run()
+	}
+
+	log.Debugf("Running volume operations with %d workers", workers)
+	return wp
+}
+
+// Stop waits for the running operations, those still queued are undone.
+// The operations a crash interrupted are resumed on the next start.
+func (wp *workerPool) Stop() {
+	close(wp.stop)
+	wp.wg.Wait()
+
+	for {
+		select {
+		case o := <-wp.queue:
+			metadata.Lock()
+			wp.s.endOperation(o, o.op.commit(errors.New("daemon stopped")))
+			metadata.Unlock()
+		default:
+			return
+		}
+	}
+}
+
+func (wp *workerPool) run() {
+	defer wp.wg.Done()
+
+	for {
+		select {
+		case <-wp.stop:
+			return
+		case o := <-wp.queue:
+			wp.s.execute(o)
+		}
+	}
+}
+
+func (s *daemon) doOperationGet(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	result := 0
+	resp := &api.OperationResponse{}
+
+	for {
+		if o := s.getOperation(objs["id"]); o != nil {
+			s.operationResponse(o, resp)
+			break
+		}
+
+		// queued by another daemon or before a restart
+		metadata.Lock()
+		rec, err := metadata.GetOperation(objs["id"])
+		metadata.Unlock()
+		if err != nil {
+			result = errorResult(w, err)
+			break
+		}
+
+		recordResponse(rec, resp)
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,207 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"api"
+	"driver"
+	"meta"
+	"meta/proto"
+	"store/memory"
+)
+
+// slowDriver creates volumes once released, as a backend taking minutes.
+type slowDriver struct {
+	*fakeDriver
+	release chan struct{}
+}
+
+func (d slowDriver) CreateVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	<-d.release
+	return d.fakeDriver.CreateVolume(vl, devs)
+}
+
+func getOperation(t *testing.T, s *daemon, id string) *api.OperationResponse {
+	w := httptest.NewRecorder()
+	if err := s.doOperationGet("1", w, httptest.NewRequest("GET", "/operation/"+id, nil), map[string]string{"id": id}); err != nil {
+		t.Fatal(err)
+	}
+	resp := &api.OperationResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatalf("decode response %s: %v", w.Body.String(), err)
+	}
+	return resp
+}
+
+// waitOperation waits for an operation to end and gives it as read by a
+// client.
+func waitOperation(t *testing.T, s *daemon, id string) *api.OperationResponse {
+	o := s.getOperation(id)
+	if o == nil {
+		t.Fatalf("unknown operation %q", id)
+	}
+	select {
+	case <-o.finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for operation %s", id)
+	}
+	return getOperation(t, s, id)
+}
+
+func TestVolumeOperations(t *testing.T) {
+	memory.NewStore()
+	if err := metadata.AddHost(testNodeID, metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, dev := range []string{"dev001", "dev002"} {
+		if err := metadata.AddDevice(dev, testNodeID, 6789, 10240, 10240, metadata.DEVICE_READY, "rbd", metadata.CEPH); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slow := slowDriver{newFakeDriver(), make(chan struct{})}
+	s := &daemon{Drivers: map[string]driver.VolumeDriver{metadata.CEPH: slow}, AgentIP: testNodeID}
+
+	// without workers nothing is queued
+	resp := &api.VolumeResponse{}
+	callHandler(t, s.doVolumeCreate, "POST", &api.VolumeCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Capacity: "5"}, resp)
+	if resp.Result != strconv.Itoa(metadata.EcodeOperationQueueFull) {
+		t.Fatalf("create should be refused without workers, got %+v", resp)
+	}
+
+	wp := s.startWorkers(2)
+	defer wp.Stop()
+
+	// the request returns while the backend creates the volume, the lock
+	// being free for other requests meanwhile
+	resp = &api.VolumeResponse{}
+	callHandler(t, s.doVolumeCreate, "POST", &api.VolumeCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Capacity: "5"}, resp)
+	if resp.Result != "0" || resp.Status != "creating" || resp.Operation == "" {
+		t.Fatalf("create should be queued, got %+v", resp)
+	}
+	create := resp.Operation
+	if op := getOperation(t, s, create); op.Result != "0" || op.Type != api.OPERATION_CREATE || op.VolumeId != "vol001" || op.OpResult != "" {
+		t.Fatalf("create should be pending, got %+v", op)
+	}
+	attach := &api.VolumeResponse{}
+	callHandler(t, s.doVolumeAttach, "POST", &api.VolumeAttachRequest{VolumeId: "vol001", ContainerId: strings.Repeat("1", 64), DriverName: metadata.CEPH, Mode: "rw"}, attach)
+	if attach.Result != strconv.Itoa(metadata.EcodeIllegalTransition) {
+		t.Fatalf("volume being created should not be attached, got %+v", attach)
+	}
+
+	close(slow.release)
+	if op := waitOperation(t, s, create); op.Status != api.OPERATION_DONE || op.OpResult != "0" || op.Volume.Status != "online" || op.Ended == "" {
+		t.Fatalf("create should be done, got %+v", op)
+	}
+
+	// a failed operation is undone and tells why
+	s.Drivers[metadata.CEPH] = brokenDriver{newFakeDriver()}
+	resp = &api.VolumeResponse{}
+	callHandler(t, s.doVolumeDelete, "POST", &api.VolumeDeleteRequest{VolumeId: "vol001", DriverName: metadata.CEPH}, resp)
+	if resp.Result != "0" || resp.Status != "deleting" {
+		t.Fatalf("delete should be queued, got %+v", resp)
+	}
+	if op := waitOperation(t, s, resp.Operation); op.Status != api.OPERATION_FAILED || op.OpResult != strconv.Itoa(metadata.EcodeDriverError) {
+		t.Fatalf("delete should fail, got %+v", op)
+	}
+	vl, err := metadata.GetVolume("vol001", metadata.CEPH)
+	if err != nil || metadata.VolumeStatus(vl) != metadata.VOLUME_ONLINE {
+		t.Fatalf("volume should be back online, got %v %v", vl, err)
+	}
+
+	s.Drivers[metadata.CEPH] = newFakeDriver()
+	resp = &api.VolumeResponse{}
+	callHandler(t, s.doVolumeDelete, "POST", &api.VolumeDeleteRequest{VolumeId: "vol001", DriverName: metadata.CEPH}, resp)
+	if op := waitOperation(t, s, resp.Operation); op.Status != api.OPERATION_DONE {
+		t.Fatalf("delete should be done, got %+v", op)
+	}
+	if _, err := metadata.GetVolume("vol001", metadata.CEPH); err == nil {
+		t.Fatal("volume should be deleted")
+	}
+
+	if op := getOperation(t, s, "nosuchop"); op.Result != strconv.Itoa(metadata.EcodeOperationNotFound) {
+		t.Fatalf("unknown operation should not be found, got %+v", op)
+	}
+}
+
+// TestResumeOperations starts a daemon again over the operations queued by
+// one that stopped before running them, as after a crash.
+func TestResumeOperations(t *testing.T) {
+	memory.NewStore()
+	if err := metadata.AddHost(testNodeID, metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, dev := range []string{"dev001", "dev002"} {
+		if err := metadata.AddDevice(dev, testNodeID, 6789, 10240, 10240, metadata.DEVICE_READY, "rbd", metadata.CEPH); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &daemon{Drivers: map[string]driver.VolumeDriver{metadata.CEPH: newFakeDriver()}, AgentIP: testNodeID}
+	metadata.Lock()
+	for _, name := range []string{"vol002", "vol003"} {
+		if result := s.processVolumeCreate(&api.VolumeCreateRequest{VolumeId: name, DriverName: metadata.CEPH, Capacity: "1"}, &api.VolumeResponse{}); result != 0 {
+			metadata.Unlock()
+			t.Fatalf("create %s: %d", name, result)
+		}
+	}
+	metadata.Unlock()
+
+	// no worker ever takes them
+	s.opQueue = make(chan *operation, 8)
+	create, remove, attach := &api.VolumeResponse{}, &api.VolumeResponse{}, &api.VolumeResponse{}
+	callHandler(t, s.doVolumeCreate, "POST", &api.VolumeCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Capacity: "5"}, create)
+	callHandler(t, s.doVolumeDelete, "POST", &api.VolumeDeleteRequest{VolumeId: "vol002", DriverName: metadata.CEPH}, remove)
+	callHandler(t, s.doVolumeAttach, "POST", &api.VolumeAttachRequest{VolumeId: "vol003", ContainerId: strings.Repeat("1", 64), DriverName: metadata.CEPH, Mode: "rw"}, attach)
+	for _, resp := range []*api.VolumeResponse{create, remove, attach} {
+		if resp.Result != "0" || resp.Operation == "" {
+			t.Fatalf("operation should be queued, got %+v", resp)
+		}
+	}
+
+	// the operations of another host are left to it
+	metadata.Lock()
+	err := metadata.SetOperation(&metaproto.Operation{Id: []byte("otherop"), Host: []byte("10.0.0.2"), Status: []byte(api.OPERATION_QUEUED)}, 0)
+	metadata.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := newFakeDriver()
+	restarted := &daemon{Drivers: map[string]driver.VolumeDriver{metadata.CEPH: fake}, AgentIP: testNodeID}
+	if op := getOperation(t, restarted, attach.Operation); op.Result != "0" || op.Status != api.OPERATION_QUEUED || op.Type != api.OPERATION_ATTACH || op.VolumeId != "vol003" {
+		t.Fatalf("attach should be read from its record, got %+v", op)
+	}
+
+	wp := restarted.startWorkers(2)
+	defer wp.Stop()
+	restarted.resumeOperations()
+
+	// a half made volume is removed
+	if op := waitOperation(t, restarted, create.Operation); op.Status != api.OPERATION_FAILED || op.OpResult != strconv.Itoa(metadata.EcodeDriverError) {
+		t.Fatalf("create should be undone, got %+v", op)
+	}
+	if _, err := metadata.GetVolume("vol001", metadata.CEPH); err == nil {
+		t.Fatal("volume being created should be removed")
+	}
+	if op := waitOperation(t, restarted, remove.Operation); op.Status != api.OPERATION_DONE {
+		t.Fatalf("delete should be done, got %+v", op)
+	}
+	if _, err := metadata.GetVolume("vol002", metadata.CEPH); err == nil {
+		t.Fatal("volume being deleted should be deleted")
+	}
+	if op := waitOperation(t, restarted, attach.Operation); op.Status != api.OPERATION_DONE || op.Volume.Path != "/dev/fake/vol003" || fake.attached["vol003"] != "rw" {
+		t.Fatalf("attach should be done, got %+v", op)
+	}
+	if op := getOperation(t, restarted, "otherop"); op.Status != api.OPERATION_QUEUED {
+		t.Fatalf("operation of another host should be left, got %+v", op)
+	}
+
+	// any daemon tells the result
+	if op := getOperation(t, &daemon{}, attach.Operation); op.Status != api.OPERATION_DONE || op.OpResult != "0" || op.Volume.Path != "/dev/fake/vol003" {
+		t.Fatalf("ended attach should be read from its record, got %+v", op)
+	}
+}
@@ -0,0 +1,215 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+
+	"dockerclient"
+	"meta"
+)
+
+const (
+	DEFAULT_RECONCILE_INTERVAL = 300 * time.Second
+
+	reconcileRetryInterval = 10 * time.Second
+)
+
+// reconciler follows the docker engine so that volumes of containers that
+// died do not stay attached. Only containers recorded by a volume attach
+// are touched, owners the engine never reported (docker plugin mount ids,
+// CSI nodes) are left alone.
+type reconciler struct {
+	s        *daemon
+	client   *dockerclient.Client
+	interval time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (s *daemon) startReconciler(sockFile string, interval time.Duration) *reconciler {
+	if interval <= 0 {
+		interval = DEFAULT_RECONCILE_INTERVAL
+	}
+	rc := &reconciler{
+		s:        s,
+		client:   dockerclient.NewClient(sockFile),
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+
+	rc.wg.Add(1)
+	go rc.run()
+
+	log.Debugf("Reconciling containers with docker engine at %v", sockFile)
+	return rc
+}
+
+func (rc *reconciler) Stop() {
+	close(rc.stop)
+	rc.wg.Wait()
+}
+
+func (rc *reconciler) run() {
+	defer rc.wg.Done()
+
+	for {
+		// resync first, events that happened while we were not listening
+		// are only caught by it
+		since := time.Now().Unix()
+		if err := rc.resync(); err != nil {
+			log.Warnf("[reconciler] resync with docker engine error: %s", err.Error())
+		} else {
+			rc.follow(since)
+		}
+
+		select {
+		case <-rc.stop:
+			return
+		case <-time.After(reconcileRetryInterval):
+		}
+	}
+}
+
+// follow handles events until the stream breaks or the daemon stops, a
+// full resync runs every interval meanwhile.
+func (rc *reconciler) follow(since int64) {
+	events, errc := rc.client.Events(since, rc.stop)
+	ticker := time.NewTicker(rc.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				select {
+				case err := <-errc:
+					log.Warnf("[reconciler] docker event stream error: %s", err.Error())
+				default:
+				}
+				return
+			}
+			rc.handleEvent(ev)
+		case <-ticker.C:
+			if err := rc.resync(); err != nil {
+				log.Warnf("[reconciler] resync with docker engine error: %s", err.Error())
+			}
+		}
+	}
+}
+
+func (rc *reconciler) handleEvent(ev *dockerclient.Event) {
+	id := ev.ContainerID()
+	if len(id) != metadata.CONTAINER_ID_LENGTH {
+		return
+	}
+
+	var err error
+	switch ev.ContainerAction() {
+	case dockerclient.EVENT_START:
+		err = rc.containerOnline(id)
+	case dockerclient.EVENT_DIE:
+		err = rc.containerOffline(id)
+	case dockerclient.EVENT_DESTROY:
+		err = rc.containerGone(id)
+	default:
+		return
+	}
+	if err != nil {
+		log.Errorf("[reconciler] handle %s of container %s error: %s", ev.ContainerAction(), id, err.Error())
+	}
+}
+
+// resync compares the container records with what the engine knows, the
+// records of containers removed from the engine are deleted.
+func (rc *reconciler) resync() error {
+	containers, err := rc.client.ListContainers()
+	if err != nil {
+		return err
+	}
+	states := map[string]string{}
+	for _, c := range containers {
+		states[c.Id] = c.State
+	}
+
+	metadata.Lock()
+	ids, err := metadata.ListContainersName()
+	metadata.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		state, ok := states[id]
+		switch {
+		case !ok:
+			err = rc.containerGone(id)
+		case state == dockerclient.STATE_RUNNING:
+			err = rc.containerOnline(id)
+		default:
+			err = rc.containerOffline(id)
+		}
+		if err != nil {
+			log.Errorf("[reconciler] reconcile container %s error: %s", id, err.Error())
+		}
+	}
+	return nil
+}
+
+func (rc *reconciler) containerOnline(id string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	ct, err := metadata.GetContainer(id)
+	if err != nil {
+		if isContainerNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if status, _ := metadata.ContainerStatus(ct); status == metadata.CONTAINERONLINE {
+		return nil
+	}
+	return metadata.SetContainerStatus(id, metadata.CONTAINERONLINE)
+}
+
+// containerOffline marks the container offline and detaches its volumes,
+// a restarted container attaches them again through the api.
+func (rc *reconciler) containerOffline(id string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	ct, err := metadata.GetContainer(id)
+	if err != nil {
+		if isContainerNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	status, _ := metadata.ContainerStatus(ct)
+	if status != metadata.CONTAINEROFFLINE {
+		if err := metadata.SetContainerStatus(id, metadata.CONTAINEROFFLINE); err != nil {
+			return err
+		}
+	}
+	return rc.s.releaseContainerVolumes(ct)
+}
+
+func (rc *reconciler) containerGone(id string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	ct, err := metadata.GetContainer(id)
+	if err != nil {
+		if isContainerNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := rc.s.releaseContainerVolumes(ct); err != nil {
+		return err
+	}
+	return metadata.DelContainer(id)
+}
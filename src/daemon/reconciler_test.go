@@ -0,0 +1,243 @@
+package daemon
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"api"
+	"dockerclient"
+	"driver"
+	"meta"
+	"meta/proto"
+	"store/memory"
+)
+
+// fakeEngine serves the part of the docker engine api the reconciler uses
+// on a unix socket, events are pushed by the test.
+type fakeEngine struct {
+	sync.Mutex
+	containers map[string]string // id : state
+	events     chan *dockerclient.Event
+	subscribed chan struct{}
+	server     *httptest.Server
+}
+
+func newFakeEngine(t *testing.T, sock string) *fakeEngine {
+	e := &fakeEngine{
+		containers: map[string]string{},
+		events:     make(chan *dockerclient.Event),
+		subscribed: make(chan struct{}, 10),
+	}
+
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", e.listContainers)
+	mux.HandleFunc("/events", e.streamEvents)
+	e.server = httptest.NewUnstartedServer(mux)
+	e.server.Listener = l
+	e.server.Start()
+	return e
+}
+
+func (e *fakeEngine) setState(id string, state string) {
+	e.Lock()
+	defer e.Unlock()
+	if state == "" {
+		delete(e.containers, id)
+		return
+	}
+	e.containers[id] = state
+}
+
+func (e *fakeEngine) listContainers(w http.ResponseWriter, r *http.Request) {
+	e.Lock()
+	defer e.Unlock()
+	containers := []*dockerclient.Container{}
+	for id, state := range e.containers {
+		containers = append(containers, &dockerclient.Container{Id: id, State: state})
+	}
+	json.NewEncoder(w).Encode(containers)
+}
+
+func (e *fakeEngine) streamEvents(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	e.subscribed <- struct{}{}
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case ev := <-e.events:
+			enc.Encode(ev)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (e *fakeEngine) send(t *testing.T, action string, id string) {
+	ev := &dockerclient.Event{Type: "container", Action: action, Actor: dockerclient.EventActor{ID: id}}
+	select {
+	case e.events <- ev:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reconciler did not read %s event", action)
+	}
+}
+
+func containerID(c byte) string {
+	return strings.Repeat(string(c), metadata.CONTAINER_ID_LENGTH)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for i := 0; i < 500; i++ {
+		metadata.Lock()
+		ok := cond()
+		metadata.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func volumeOwners(name string) []string {
+	vl, err := metadata.GetVolume(name, metadata.CEPH)
+	if err != nil {
+		return nil
+	}
+	owners := []string{}
+	for _, c := range vl.Containers {
+		owners = append(owners, string(c.Containerid))
+	}
+	return owners
+}
+
+func containerStatus(id string) int {
+	ct, err := metadata.GetContainer(id)
+	if err != nil {
+		return -1
+	}
+	status, _ := metadata.BytesToInteger(ct.Status)
+	return status
+}
+
+func apiAttach(t *testing.T, s *daemon, volume string, container string, mode string) {
+	req := &api.VolumeAttachRequest{VolumeId: volume, DriverName: metadata.CEPH, ContainerId: container, Mode: mode}
+	resp := &api.VolumeResponse{}
+	callHandler(t, s.doVolumeAttach, "POST", req, resp)
+	if resp.Result != "0" {
+		t.Fatalf("attach %s to %s failed: %+v", volume, container, resp)
+	}
+	if op := waitOperation(t, s, resp.Operation); op.OpResult != "0" {
+		t.Fatalf("attach %s to %s failed: %+v", volume, container, op)
+	}
+}
+
+func TestReconcileContainers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconcile-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memory.NewStore()
+	if err := metadata.AddHost(testNodeID, metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, dev := range []string{"dev001", "dev002"} {
+		if err := metadata.AddDevice(dev, testNodeID, 6789, 10240, 10240, metadata.DEVICE_READY, "rbd", metadata.CEPH); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fake := newFakeDriver()
+	s := &daemon{Drivers: map[string]driver.VolumeDriver{metadata.CEPH: fake}}
+	s.Root = dir
+	wp := s.startWorkers(1)
+	defer wp.Stop()
+	for _, name := range []string{"vol001", "vol002"} {
+		req := &api.VolumeCreateRequest{VolumeId: name, DriverName: metadata.CEPH, Capacity: "100"}
+		if result := s.processVolumeCreate(req, &api.VolumeResponse{}); result != 0 {
+			t.Fatalf("create %s failed with %d", name, result)
+		}
+	}
+
+	c1, c2, mountID := containerID('a'), containerID('b'), containerID('c')
+	apiAttach(t, s, "vol001", c1, "rw")
+	apiAttach(t, s, "vol002", c2, "ro")
+	// a docker plugin mount id, unknown to the engine as a container
+	oc := &metaproto.Volume_OwnerContainer{Containerid: []byte(mountID), Mode: []byte(metadata.RWVolume)}
+	if err := metadata.SetVolumeContainer("vol002", oc, metadata.CEPH, false); err != nil {
+		t.Fatal(err)
+	}
+	if containerStatus(c1) != metadata.CONTAINERONLINE {
+		t.Fatalf("attach should record container %s online", c1)
+	}
+
+	engine := newFakeEngine(t, filepath.Join(dir, "docker.sock"))
+	defer engine.server.Close()
+	engine.setState(c1, dockerclient.STATE_RUNNING)
+	engine.setState(c2, dockerclient.STATE_RUNNING)
+
+	rc := s.startReconciler(filepath.Join(dir, "docker.sock"), time.Hour)
+	defer rc.Stop()
+	select {
+	case <-engine.subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reconciler did not subscribe to events")
+	}
+
+	engine.setState(c1, "exited")
+	engine.send(t, dockerclient.EVENT_DIE, c1)
+	waitFor(t, "volume of dead container detached", func() bool {
+		return len(volumeOwners("vol001")) == 0 && containerStatus(c1) == metadata.CONTAINEROFFLINE
+	})
+	metadata.Lock()
+	if _, ok := fake.attached["vol001"]; ok {
+		t.Fatal("driver should detach vol001")
+	}
+	metadata.Unlock()
+
+	engine.setState(c1, dockerclient.STATE_RUNNING)
+	engine.send(t, dockerclient.EVENT_START, c1)
+	waitFor(t, "restarted container online", func() bool {
+		return containerStatus(c1) == metadata.CONTAINERONLINE
+	})
+
+	// c2 vanished while nobody listened, only a resync notices
+	engine.setState(c2, "")
+	if err := rc.resync(); err != nil {
+		t.Fatal(err)
+	}
+	metadata.Lock()
+	if containerStatus(c2) != -1 {
+		t.Fatalf("container %s unknown to the engine should be removed", c2)
+	}
+	if owners := volumeOwners("vol002"); len(owners) != 1 || owners[0] != mountID {
+		t.Fatalf("only the plugin mount should own vol002, got %v", owners)
+	}
+	if _, ok := fake.attached["vol002"]; !ok {
+		t.Fatal("vol002 is still mounted by the plugin and must stay attached")
+	}
+	metadata.Unlock()
+
+	engine.setState(c1, "")
+	engine.send(t, dockerclient.EVENT_DESTROY, c1)
+	waitFor(t, "destroyed container removed", func() bool {
+		return containerStatus(c1) == -1
+	})
+}
@@ -0,0 +1,111 @@
+package daemon
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"api"
+	"meta"
+)
+
+const (
+	// pause between two batches of records, for the other requests to
+	// take the lock
+	DEFAULT_MIGRATOR_INTERVAL = time.Second
+)
+
+// migrator upgrades the records of the store to the schema version of
+// this daemon, a batch under the lock at a time, and stops once the store
+// is upgraded. Every daemon runs one, the cursor kept in the store lets
+// them share the work.
+type migrator struct {
+	batch    int
+	interval time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (s *daemon) startMigrator(batch int, interval time.Duration) *migrator {
+	if interval <= 0 {
+		interval = DEFAULT_MIGRATOR_INTERVAL
+	}
+	mg := &migrator{
+		batch:    batch,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+
+	mg.wg.Add(1)
+	go mg.run()
+
+	log.Debugf("Migrating metadata to schema %d", metadata.SCHEMA_VERSION)
+	return mg
+}
+
+func (mg *migrator) Stop() {
+	close(mg.stop)
+	mg.wg.Wait()
+}
+
+func (mg *migrator) run() {
+	defer mg.wg.Done()
+
+	ticker := time.NewTicker(mg.interval)
+	defer ticker.Stop()
+	for {
+		done, err := mg.migrate()
+		if err != nil {
+			log.Warnf("[migrator] migrate error: %s", err.Error())
+		}
+		if done {
+			return
+		}
+
+		select {
+		case <-mg.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (mg *migrator) migrate() (bool, error) {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	return metadata.MigrateSchema(mg.batch)
+}
+
+func (s *daemon) doMetaSchema(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	resp := &api.SchemaResponse{
+		Target: metadata.SCHEMA_VERSION,
+	}
+
+	for {
+		current, cursor, err := metadata.GetSchema()
+		if err != nil {
+			result = errorResult(w, err)
+			break
+		}
+		resp.Version = current
+		resp.Cursor = cursor
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
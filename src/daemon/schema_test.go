@@ -0,0 +1,168 @@
+package daemon
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"api"
+	"meta"
+	"meta/proto"
+	"store"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// downgradeRecord writes a record back the way a daemon of schema 1 wrote
+// it, without the typed fields.
+func downgradeRecord(t *testing.T, key string, msg proto.Message) {
+	st := store.GetDriver()
+	data, err := st.Get(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := proto.Unmarshal([]byte(data), msg); err != nil {
+		t.Fatal(err)
+	}
+	v := reflect.ValueOf(msg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if strings.HasSuffix(v.Type().Field(i).Name, "V2") {
+			v.Field(i).Set(reflect.Zero(v.Field(i).Type()))
+		}
+	}
+	value, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Set(key, string(value), nil)
+}
+
+func TestSchemaMigration(t *testing.T) {
+	s := newTestDaemon(t, metadata.CEPH, "dev001", "dev002", "dev003")
+	if result := s.processVolumeCreate(&api.VolumeCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Capacity: "5"}, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("create failed: %d", result)
+	}
+
+	// records are written with their typed fields
+	dv, err := metadata.GetDevice("dev002", metadata.CEPH)
+	if err != nil || dv.GetStatusV2() != metaproto.DeviceStatus_DEVICE_READY || dv.GetTotalV2() != 10240 || dv.GetPortV2() != 6789 {
+		t.Fatalf("device should be written with typed fields, got %v %v", dv, err)
+	}
+
+	schema := func() *api.SchemaResponse {
+		resp := &api.SchemaResponse{}
+		callHandler(t, s.doMetaSchema, "GET", nil, resp)
+		if resp.Result != "0" {
+			t.Fatalf("schema failed: %+v", resp)
+		}
+		return resp
+	}
+	if resp := schema(); resp.Version != 1 || resp.Target != metadata.SCHEMA_VERSION {
+		t.Fatalf("store without schema key should be at 1, got %+v", resp)
+	}
+
+	// the records of a store written by daemons of schema 1
+	archive, err := metadata.ExportArchive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range archive.Records {
+		switch r.Kind {
+		case "host":
+			downgradeRecord(t, r.Key, &metaproto.Host{})
+		case "device":
+			downgradeRecord(t, r.Key, &metaproto.Device{})
+		case "volume":
+			downgradeRecord(t, r.Key, &metaproto.Volume{})
+		default:
+			t.Fatalf("unexpected record %s", r.Key)
+		}
+	}
+	if dv, _ := metadata.GetDevice("dev002", metadata.CEPH); dv.StatusV2 != nil || dv.TotalV2 != nil {
+		t.Fatalf("device should have lost its typed fields, got %v", dv)
+	}
+	// records not upgraded yet are read from their bytes fields
+	if status, err := metadata.GetDeviceStatus("dev002", metadata.CEPH); err != nil || status != metadata.DEVICE_READY {
+		t.Fatalf("device status should come from the bytes field, got %d %v", status, err)
+	}
+
+	// 5 records, 2 a batch
+	cursor := ""
+	for i := 0; i < 2; i++ {
+		done, err := metadata.MigrateSchema(2)
+		if err != nil || done {
+			t.Fatalf("migration should go on after batch %d, got %v %v", i, done, err)
+		}
+		resp := schema()
+		if resp.Version != 1 || resp.Cursor <= cursor {
+			t.Fatalf("cursor should move forward, got %+v after %q", resp, cursor)
+		}
+		cursor = resp.Cursor
+	}
+	if done, err := metadata.MigrateSchema(2); err != nil || !done {
+		t.Fatalf("migration should be done, got %v %v", done, err)
+	}
+	if resp := schema(); resp.Version != metadata.SCHEMA_VERSION || resp.Cursor != "" {
+		t.Fatalf("store should be upgraded, got %+v", resp)
+	}
+	if done, err := metadata.MigrateSchema(2); err != nil || !done {
+		t.Fatalf("upgraded store should stay so, got %v %v", done, err)
+	}
+
+	dv, _ = metadata.GetDevice("dev002", metadata.CEPH)
+	if dv.GetStatusV2() != metaproto.DeviceStatus_DEVICE_READY || dv.GetTotalV2() != 10240 || dv.GetFreeV2() != 10240 {
+		t.Fatalf("device should have its typed fields back, got %v", dv)
+	}
+	// a daemon of schema 1 updates the bytes fields only, keeping the typed
+	// ones it doesn't know as they were
+	dv.Free = metadata.IntegerToBytes(5120)
+	dv.Status = metadata.IntegerToBytes(metadata.DEVICE_INUSE)
+	if free, _ := metadata.DeviceFree(dv); free != 5120 {
+		t.Fatalf("device free should come from the bytes field, got %d", free)
+	}
+	if status, _ := metadata.DeviceStatus(dv); status != metadata.DEVICE_INUSE {
+		t.Fatalf("device status should come from the bytes field, got %d", status)
+	}
+	// the typed field stands for a bytes field holding no integer
+	dv.Total = nil
+	if total, err := metadata.DeviceTotal(dv); err != nil || total != 10240 {
+		t.Fatalf("device total should come from the typed field, got %d %v", total, err)
+	}
+	hs, _ := metadata.GetHost(testNodeID)
+	if hs.GetStatusV2() != metaproto.HostStatus_HOST_ONLINE {
+		t.Fatalf("host should have its typed fields back, got %v", hs)
+	}
+	vl, _ := metadata.GetVolume("vol001", metadata.CEPH)
+	if vl.GetCapacityV2() != 5 {
+		t.Fatalf("volume should have its typed fields back, got %v", vl)
+	}
+
+	// typed fields go by name in archives and come back from them
+	archive, err = metadata.ExportArchive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range archive.Records {
+		if r.Kind == "device" && r.Value["status_v2"] != "DEVICE_READY" && r.Value["status_v2"] != "DEVICE_INUSE" {
+			t.Fatalf("device status should be named, got %v", r.Value)
+		}
+	}
+	data, _ := json.Marshal(archive)
+	archive = &metadata.Archive{}
+	if err := json.Unmarshal(data, archive); err != nil {
+		t.Fatal(err)
+	}
+	if changes, err := metadata.ImportArchive(archive, true); err != nil || len(changes) != 0 {
+		t.Fatalf("archive should match the store, got %v %v", changes, err)
+	}
+
+	// a store upgraded by a newer daemon
+	version := int64(metadata.SCHEMA_VERSION + 1)
+	data, _ = proto.Marshal(&metaproto.Schema{Version: &version})
+	store.GetDriver().Set(metadata.SCHEMAKEY, string(data), nil)
+	err = metadata.CheckSchema()
+	if e, ok := err.(*metadata.Error); !ok || e.Code != metadata.EcodeSchemaVersion {
+		t.Fatalf("newer store should be refused, got %v", err)
+	}
+}
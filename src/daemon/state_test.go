@@ -0,0 +1,246 @@
+package daemon
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"api"
+	"driver"
+	"meta"
+	"meta/proto"
+	"store/memory"
+)
+
+// brokenDriver fails to create, attach, detach and delete volumes.
+type brokenDriver struct {
+	*fakeDriver
+}
+
+func (b brokenDriver) CreateVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	return errors.New("no space")
+}
+
+func (b brokenDriver) AttachVolume(vl *metaproto.Volume, devs []*metaproto.Device, mode string) (string, error) {
+	return "", errors.New("no path")
+}
+
+func (b brokenDriver) DetachVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	return errors.New("device busy")
+}
+
+func (b brokenDriver) DeleteVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	return errors.New("busy")
+}
+
+func TestVolumeStates(t *testing.T) {
+	memory.NewStore()
+	if err := metadata.AddHost(testNodeID, metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, dev := range []string{"dev001", "dev002"} {
+		if err := metadata.AddDevice(dev, testNodeID, 6789, 10240, 10240, metadata.DEVICE_READY, "rbd", metadata.CEPH); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &daemon{Drivers: map[string]driver.VolumeDriver{metadata.CEPH: newFakeDriver()}, AgentIP: testNodeID}
+	status := func() string {
+		resp := &api.VolumeResponse{}
+		callHandler(t, s.doVolumeGet, "GET", &api.VolumeGetRequest{VolumeId: "vol001", DriverName: metadata.CEPH}, resp)
+		return resp.Status
+	}
+
+	resp := &api.VolumeResponse{}
+	if result := s.processVolumeCreate(&api.VolumeCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Capacity: "5"}, resp); result != 0 || resp.Status != "online" {
+		t.Fatalf("create should make an online volume, got %d %+v", result, resp)
+	}
+	c1 := strings.Repeat("1", 64)
+	resp = &api.VolumeResponse{}
+	if result := s.processVolumeAttach(&api.VolumeAttachRequest{VolumeId: "vol001", ContainerId: c1, DriverName: metadata.CEPH, Mode: "rw"}, resp); result != 0 || resp.Status != "inuse" || status() != "inuse" {
+		t.Fatalf("attached volume should be inuse, got %d %+v", result, resp)
+	}
+
+	// an inuse volume neither migrates nor goes to deleting
+	for _, to := range []int{metadata.VOLUME_MIGRATING, metadata.VOLUME_DELETING, metadata.VOLUME_CREATING} {
+		err := metadata.SetVolumeStatus("vol001", metadata.CEPH, to)
+		if e, ok := err.(*metadata.Error); !ok || e.Code != metadata.EcodeIllegalTransition {
+			t.Fatalf("inuse volume should not go to %s, got %v", metadata.VolumeStates.Name(to), err)
+		}
+	}
+	if err := metadata.SetVolumeStatus("vol001", metadata.CEPH, 99); err == nil {
+		t.Fatal("unknown status should be refused")
+	}
+
+	wp := s.startWorkers(1)
+	defer wp.Stop()
+	// a container not owning the volume detaches nothing
+	detach := &api.VolumeResponse{}
+	callHandler(t, s.doVolumeDetach, "POST", &api.VolumeDetachRequest{VolumeId: "vol001", ContainerId: strings.Repeat("9", 64), DriverName: metadata.CEPH}, detach)
+	if detach.Result != strconv.Itoa(metadata.EcodeContainerNotFound) || s.Drivers[metadata.CEPH].(*fakeDriver).attached["vol001"] == "" {
+		t.Fatalf("detach of another container should be refused, got %+v", detach)
+	}
+	detach = &api.VolumeResponse{}
+	callHandler(t, s.doVolumeDetach, "POST", &api.VolumeDetachRequest{VolumeId: "vol001", ContainerId: c1, DriverName: metadata.CEPH}, detach)
+	if op := waitOperation(t, s, detach.Operation); op.OpResult != "0" || op.Volume.Status != "online" {
+		t.Fatalf("detached volume should be online, got %+v", op)
+	}
+
+	// a failed attach keeps the attachment made before it
+	c2 := strings.Repeat("2", 64)
+	if result := s.processVolumeAttach(&api.VolumeAttachRequest{VolumeId: "vol001", ContainerId: c2, DriverName: metadata.CEPH, Mode: "ro"}, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("attach failed: %d", result)
+	}
+	s.Drivers[metadata.CEPH] = brokenDriver{newFakeDriver()}
+	if result := s.processVolumeAttach(&api.VolumeAttachRequest{VolumeId: "vol001", ContainerId: c2, DriverName: metadata.CEPH, Mode: "ro"}, &api.VolumeResponse{}); result != metadata.EcodeDriverError {
+		t.Fatalf("attach should fail, got %d", result)
+	}
+	if vl, err := metadata.GetVolume("vol001", metadata.CEPH); err != nil || volumeOwner(vl, c2) == nil || status() != "inuse" {
+		t.Fatalf("container should still own the volume, got %v %v", vl, err)
+	}
+	s.Drivers[metadata.CEPH] = newFakeDriver()
+	if err := metadata.DelVolumeContainer("vol001", metadata.CEPH, c2); err != nil {
+		t.Fatal(err)
+	}
+
+	// a deleting volume takes no container, and is back online when the
+	// backend fails to delete it
+	if err := metadata.SetVolumeStatus("vol001", metadata.CEPH, metadata.VOLUME_DELETING); err != nil {
+		t.Fatal(err)
+	}
+	if result := s.processVolumeAttach(&api.VolumeAttachRequest{VolumeId: "vol001", ContainerId: c1, DriverName: metadata.CEPH, Mode: "rw"}, &api.VolumeResponse{}); result != metadata.EcodeIllegalTransition {
+		t.Fatalf("deleting volume should not be attached, got %d", result)
+	}
+	broken := brokenDriver{newFakeDriver()}
+	if err := metadata.SetVolumeStatus("vol001", metadata.CEPH, metadata.VOLUME_ONLINE); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteVolume(broken, "vol001", metadata.CEPH); err == nil || status() != "online" {
+		t.Fatalf("failed delete should leave the volume online, got %v %s", err, status())
+	}
+
+	// a volume the backend fails to create is gone with its device
+	s.Drivers[metadata.CEPH] = broken
+	if result := s.processVolumeCreate(&api.VolumeCreateRequest{VolumeId: "vol002", DriverName: metadata.CEPH, Capacity: "5"}, &api.VolumeResponse{}); result != metadata.EcodeDriverError {
+		t.Fatalf("create should fail, got %d", result)
+	}
+	if _, err := metadata.GetVolume("vol002", metadata.CEPH); err == nil {
+		t.Fatal("volume should be removed")
+	}
+	if status, _ := metadata.GetDeviceStatus("dev002", metadata.CEPH); status != metadata.DEVICE_READY {
+		t.Fatalf("device should be free again, got %d", status)
+	}
+}
+
+func TestDetachLastOwner(t *testing.T) {
+	memory.NewStore()
+	if err := metadata.AddHost(testNodeID, metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.AddDevice(testDevice, testNodeID, 6789, 10240, 10240, metadata.DEVICE_READY, "rbd", metadata.CEPH); err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeDriver()
+	s := &daemon{Drivers: map[string]driver.VolumeDriver{metadata.CEPH: fake}, AgentIP: testNodeID}
+	if result := s.processVolumeCreate(&api.VolumeCreateRequest{VolumeId: "vol001", DriverName: metadata.CEPH, Capacity: "5"}, &api.VolumeResponse{}); result != 0 {
+		t.Fatalf("create failed: %d", result)
+	}
+	// the operations are queued before any worker runs them
+	s.opQueue = make(chan *operation, OPERATION_QUEUE_SIZE)
+
+	c1, c2 := strings.Repeat("1", 64), strings.Repeat("2", 64)
+	attach := func(c string) {
+		if result := s.processVolumeAttach(&api.VolumeAttachRequest{VolumeId: "vol001", ContainerId: c, DriverName: metadata.CEPH, Mode: "ro"}, &api.VolumeResponse{}); result != 0 {
+			t.Fatalf("attach failed: %d", result)
+		}
+	}
+	detach := func(c string) string {
+		resp := &api.VolumeResponse{}
+		callHandler(t, s.doVolumeDetach, "POST", &api.VolumeDetachRequest{VolumeId: "vol001", ContainerId: c, DriverName: metadata.CEPH}, resp)
+		if resp.Result != "0" {
+			t.Fatalf("detach should be queued, got %+v", resp)
+		}
+		return resp.Operation
+	}
+	attach(c1)
+	attach(c2)
+
+	// the first detach leaves the volume to the other container, which
+	// is the last one when its detach is queued right after
+	op1 := detach(c1)
+	op2 := detach(c2)
+	wp := s.startWorkers(1)
+	defer wp.Stop()
+	for _, id := range []string{op1, op2} {
+		if op := waitOperation(t, s, id); op.OpResult != "0" {
+			t.Fatalf("detach failed: %+v", op)
+		}
+	}
+	if fake.attached["vol001"] != "" {
+		t.Fatal("the last container should detach the volume")
+	}
+
+	// a failed detach leaves the container attached
+	attach(c1)
+	s.Drivers[metadata.CEPH] = brokenDriver{fake}
+	if op := waitOperation(t, s, detach(c1)); op.OpResult != strconv.Itoa(metadata.EcodeDriverError) {
+		t.Fatalf("detach should fail, got %+v", op)
+	}
+	if vl, err := metadata.GetVolume("vol001", metadata.CEPH); err != nil || volumeOwner(vl, c1) == nil || metadata.VolumeStatus(vl) != metadata.VOLUME_INUSE {
+		t.Fatalf("container should still own the volume, got %v %v", vl, err)
+	}
+}
+
+func TestHostDeviceStates(t *testing.T) {
+	memory.NewStore()
+	if err := metadata.AddHost(testNodeID, metadata.HOST_ONLINE, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.AddDevice("dev001", testNodeID, 6789, 10240, 10240, metadata.DEVICE_READY, "rbd", metadata.CEPH); err != nil {
+		t.Fatal(err)
+	}
+	s := &daemon{AgentIP: testNodeID}
+
+	host := &api.HostResponse{}
+	callHandler(t, s.doHostGet, "GET", &api.HostGetRequest{Ip: testNodeID}, host)
+	if host.Result != "0" || host.Status != "online" {
+		t.Fatalf("host status should be named, got %+v", host)
+	}
+	device := &api.DeviceResponse{}
+	callHandler(t, s.doDeviceGet, "GET", &api.DeviceGetRequest{ID: "dev001", Backend: metadata.CEPH}, device)
+	if device.Result != "0" || device.Status != "ready" {
+		t.Fatalf("device status should be named, got %+v", device)
+	}
+
+	// maintenance is only left by a resume
+	if err := metadata.DrainHost(testNodeID); err != nil {
+		t.Fatal(err)
+	}
+	err := metadata.ModHostStatus(testNodeID, metadata.HOST_OFFLINE)
+	if e, ok := err.(*metadata.Error); !ok || e.Code != metadata.EcodeIllegalTransition {
+		t.Fatalf("host in maintenance should not go offline, got %v", err)
+	}
+	err = metadata.UseDevice("dev001", metadata.CEPH, "vol001")
+	if e, ok := err.(*metadata.Error); !ok || e.Code != metadata.EcodeDeviceMaintenance {
+		t.Fatalf("device in maintenance should not be used, got %v", err)
+	}
+	err = metadata.UpdateDeviceStatus("dev001", metadata.DEVICE_OFFLINE, metadata.CEPH, 0)
+	if e, ok := err.(*metadata.Error); !ok || e.Code != metadata.EcodeIllegalTransition {
+		t.Fatalf("device in maintenance should not go offline, got %v", err)
+	}
+	if err := metadata.ResumeHost(testNodeID); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := metadata.GetDeviceStatus("dev001", metadata.CEPH); status != metadata.DEVICE_READY {
+		t.Fatalf("device should be resumed, got %s", metadata.DeviceStates.Name(status))
+	}
+	if name := metadata.HostStates.Name(metadata.HOST_MAINTENANCE); name != "maintenance" {
+		t.Fatalf("unexpected name %s", name)
+	}
+	if name := metadata.VolumeStates.Name(metadata.VOLUME_UNKNOWN); name != "unknown" {
+		t.Fatalf("unexpected name %s", name)
+	}
+	if name := metadata.DeviceStates.NameBytes([]byte(strconv.Itoa(99))); name != "invalid(99)" {
+		t.Fatalf("unexpected name %s", name)
+	}
+}
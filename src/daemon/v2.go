@@ -0,0 +1,388 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"api"
+	"meta"
+)
+
+// route is a route of the resource api. The table of routes makes both the
+// router and the OpenAPI document, so that one can't miss what the other
+// serves.
+type route struct {
+	method  string
+	path    string
+	summary string
+	handler requestHandler
+	// http status of a successful request
+	status int
+	// query parameters and what they filter, others are refused
+	query map[string]string
+	// body of the request, nil for none, and of the response
+	request  interface{}
+	response interface{}
+}
+
+// v2Routes is the resource api. Its requests name the records in their
+// path, most of them run through the handlers of the first api.
+func (s *daemon) v2Routes() []route {
+	return []route{
+		{
+			method: "GET", path: "/volumes", summary: "List the volumes",
+			handler: s.doVolumeQuery, status: http.StatusOK,
+			query: map[string]string{
+				"driver":    "volumes of this driver only",
+				"status":    "volumes in this status only, as named by the api",
+				"container": "volumes attached to this container only",
+			},
+			response: api.VolumeQueryResponse{},
+		},
+		{
+			method: "POST", path: "/volumes/{driver}", summary: "Create a volume",
+			handler: fromPath(s.doVolumeCreate, func(r *http.Request, objs map[string]string) (interface{}, error) {
+				req := &api.VolumeCreateRequest{}
+				if err := decodeRequest(r, req); err != nil {
+					return nil, err
+				}
+				req.DriverName = objs["driver"]
+				return req, nil
+			}),
+			status:  http.StatusAccepted,
+			request: api.VolumeCreateRequest{}, response: api.VolumeResponse{},
+		},
+		{
+			method: "GET", path: "/volumes/{driver}/{id}", summary: "Show a volume",
+			handler: fromPath(s.doVolumeGet, func(r *http.Request, objs map[string]string) (interface{}, error) {
+				return &api.VolumeGetRequest{VolumeId: objs["id"], DriverName: objs["driver"]}, nil
+			}),
+			status:   http.StatusOK,
+			response: api.VolumeResponse{},
+		},
+		{
+			method: "DELETE", path: "/volumes/{driver}/{id}", summary: "Delete a volume",
+			handler: fromPath(s.doVolumeDelete, func(r *http.Request, objs map[string]string) (interface{}, error) {
+				return &api.VolumeDeleteRequest{VolumeId: objs["id"], DriverName: objs["driver"]}, nil
+			}),
+			status:   http.StatusAccepted,
+			response: api.VolumeResponse{},
+		},
+		{
+			method: "POST", path: "/volumes/{driver}/{id}/attachments", summary: "Attach a volume to a container",
+			handler: fromPath(s.doVolumeAttach, func(r *http.Request, objs map[string]string) (interface{}, error) {
+				req := &api.VolumeAttachRequest{}
+				if err := decodeRequest(r, req); err != nil {
+					return nil, err
+				}
+				req.VolumeId = objs["id"]
+				req.DriverName = objs["driver"]
+				return req, nil
+			}),
+			status:  http.StatusAccepted,
+			request: api.VolumeAttachRequest{}, response: api.VolumeResponse{},
+		},
+		{
+			method: "DELETE", path: "/volumes/{driver}/{id}/attachments/{container}", summary: "Detach a volume from a container",
+			handler: fromPath(s.doVolumeDetach, func(r *http.Request, objs map[string]string) (interface{}, error) {
+				return &api.VolumeDetachRequest{VolumeId: objs["id"], DriverName: objs["driver"], ContainerId: objs["container"]}, nil
+			}),
+			status:   http.StatusAccepted,
+			response: api.VolumeResponse{},
+		},
+
+		{
+			method: "GET", path: "/hosts", summary: "List the hosts",
+			handler: s.doHostQuery, status: http.StatusOK,
+			query: map[string]string{
+				"status": "hosts in this status only, as named by the api",
+			},
+			response: api.HostQueryResponse{},
+		},
+		{
+			method: "POST", path: "/hosts", summary: "Add a host",
+			handler: s.doHostAdd, status: http.StatusCreated,
+			request: api.HostAddRequest{}, response: api.HostResponse{},
+		},
+		{
+			method: "GET", path: "/hosts/{ip}", summary: "Show a host",
+			handler: fromPath(s.doHostGet, func(r *http.Request, objs map[string]string) (interface{}, error) {
+				return &api.HostGetRequest{Ip: objs["ip"]}, nil
+			}),
+			status:   http.StatusOK,
+			response: api.HostResponse{},
+		},
+		{
+			method: "DELETE", path: "/hosts/{ip}", summary: "Delete a host",
+			handler: fromPath(s.doHostDel, func(r *http.Request, objs map[string]string) (interface{}, error) {
+				return &api.HostDeleteRequest{Ip: objs["ip"]}, nil
+			}),
+			status:   http.StatusOK,
+			response: api.HostResponse{},
+		},
+
+		{
+			method: "GET", path: "/devices", summary: "List the devices",
+			handler: s.doDeviceQuery, status: http.StatusOK,
+			query: map[string]string{
+				"backend": "devices of this backend only",
+				"host":    "devices of the host of this ip only",
+				"status":  "devices in this status only, as named by the api",
+			},
+			response: api.DeviceQueryResponse{},
+		},
+		{
+			method: "POST", path: "/devices", summary: "Add a device",
+			handler: s.doDeviceAdd, status: http.StatusCreated,
+			request: api.DeviceAddRequest{}, response: api.DeviceResponse{},
+		},
+		{
+			method: "GET", path: "/devices/{backend}/{id}", summary: "Show a device",
+			handler: fromPath(s.doDeviceGet, func(r *http.Request, objs map[string]string) (interface{}, error) {
+				return &api.DeviceGetRequest{ID: objs["id"], Backend: objs["backend"]}, nil
+			}),
+			status:   http.StatusOK,
+			response: api.DeviceResponse{},
+		},
+		{
+			method: "DELETE", path: "/devices/{backend}/{id}", summary: "Delete a device",
+			handler: fromPath(s.doDeviceDel, func(r *http.Request, objs map[string]string) (interface{}, error) {
+				return &api.DeviceDelRequest{ID: objs["id"], Backend: objs["backend"]}, nil
+			}),
+			status:   http.StatusOK,
+			response: api.DeviceResponse{},
+		},
+
+		{
+			method: "GET", path: "/operations/{id}", summary: "Show a volume operation",
+			handler: s.doOperationGet, status: http.StatusOK,
+			response: api.OperationResponse{},
+		},
+		{
+			method: "GET", path: "/errors", summary: "List the error codes",
+			handler: s.doErrorList, status: http.StatusOK,
+			response: api.ErrorListResponse{},
+		},
+		{
+			method: "GET", path: "/openapi.json", summary: "Show this document",
+			handler: s.doOpenAPI, status: http.StatusOK,
+		},
+	}
+}
+
+// serve checks the query of a request and answers it with the status of
+// the route when it succeeds.
+func (rt *route) serve(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	for name := range r.URL.Query() {
+		if _, ok := rt.query[name]; !ok {
+			return writeResult(w, metadata.EcodeParameterError)
+		}
+	}
+	// a failed request gets the status of its result instead
+	w.WriteHeader(rt.status)
+	return rt.handler(version, w, r, objs)
+}
+
+// fromPath runs a handler of the first api, its request made by build of
+// the body, if any, and the path naming the record.
+func fromPath(f requestHandler, build func(r *http.Request, objs map[string]string) (interface{}, error)) requestHandler {
+	return func(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+		req, err := build(r, objs)
+		if err != nil {
+			return writeResult(w, metadata.EcodeRequestDecodeError)
+		}
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(data))
+		return f(version, w, r, objs)
+	}
+}
+
+// writeResult answers a request failed before its handler ran.
+func writeResult(w http.ResponseWriter, result int) error {
+	resp := &struct{ Result string }{strconv.Itoa(result)}
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// queryStatus is the status named by the query parameter, 0 for none.
+func queryStatus(query url.Values, lookup func(name string) (int, bool)) (int, bool) {
+	name := query.Get("status")
+	if name == "" {
+		return 0, true
+	}
+	return lookup(name)
+}
+
+// queryVolumes returns the volumes matching the query and the result code.
+func queryVolumes(query url.Values) ([]api.VolumeResponse, int) {
+	drivers := metadata.ListBackends()
+	if driver := query.Get("driver"); driver != "" {
+		if !metadata.ValidDriverName(driver) {
+			return nil, metadata.EcodeParameterError
+		}
+		drivers = []string{driver}
+	}
+	status, ok := queryStatus(query, metadata.VolumeStates.Lookup)
+	if !ok {
+		return nil, metadata.EcodeParameterError
+	}
+	container := query.Get("container")
+
+	volumes := []api.VolumeResponse{}
+	for _, driver := range drivers {
+		names, err := metadata.ListVolumesName(driver)
+		if err != nil {
+			return nil, (err).(*metadata.Error).Code
+		}
+		for _, name := range names {
+			vl, err := metadata.GetVolume(name, driver)
+			if err != nil {
+				return nil, (err).(*metadata.Error).Code
+			}
+			if status != 0 && metadata.VolumeStatus(vl) != status {
+				continue
+			}
+			if container != "" && volumeOwner(vl, container) == nil {
+				continue
+			}
+			resp := api.VolumeResponse{}
+			volumeResponse(vl, driver, &resp)
+			volumes = append(volumes, resp)
+		}
+	}
+	return volumes, 0
+}
+
+// queryHosts returns the hosts matching the query and the result code.
+func queryHosts(query url.Values) ([]api.HostResponse, int) {
+	status, ok := queryStatus(query, metadata.HostStates.Lookup)
+	if !ok {
+		return nil, metadata.EcodeParameterError
+	}
+
+	names, err := metadata.ListHostsName()
+	if err != nil {
+		return nil, (err).(*metadata.Error).Code
+	}
+	hosts := []api.HostResponse{}
+	for _, name := range names {
+		hs, err := metadata.GetHost(name)
+		if err != nil {
+			return nil, (err).(*metadata.Error).Code
+		}
+		if current, _ := metadata.HostStatus(hs); status != 0 && current != status {
+			continue
+		}
+		resp := api.HostResponse{}
+		hostResponse(hs, &resp)
+		hosts = append(hosts, resp)
+	}
+	return hosts, 0
+}
+
+// queryDevices returns the devices matching the query and the result code.
+func queryDevices(query url.Values) ([]api.DeviceResponse, int) {
+	backends := metadata.ListBackends()
+	if backend := query.Get("backend"); backend != "" {
+		if !metadata.ValidBackend(backend) {
+			return nil, metadata.EcodeParameterError
+		}
+		backends = []string{backend}
+	}
+	status, ok := queryStatus(query, metadata.DeviceStates.Lookup)
+	if !ok {
+		return nil, metadata.EcodeParameterError
+	}
+	host := query.Get("host")
+
+	devices := []api.DeviceResponse{}
+	for _, backend := range backends {
+		names, err := metadata.ListDevicesName(backend)
+		if err != nil {
+			return nil, (err).(*metadata.Error).Code
+		}
+		for _, name := range names {
+			dv, err := metadata.GetDevice(name, backend)
+			if err != nil {
+				return nil, (err).(*metadata.Error).Code
+			}
+			if current, _ := metadata.DeviceStatus(dv); status != 0 && current != status {
+				continue
+			}
+			if host != "" && string(dv.Host) != host {
+				continue
+			}
+			resp := api.DeviceResponse{}
+			deviceResponse(dv, &resp)
+			devices = append(devices, resp)
+		}
+	}
+	return devices, 0
+}
+
+func (s *daemon) doVolumeQuery(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	resp := &api.VolumeQueryResponse{}
+	resp.Volumes, result = queryVolumes(r.URL.Query())
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doHostQuery(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	resp := &api.HostQueryResponse{}
+	resp.Hosts, result = queryHosts(r.URL.Query())
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doDeviceQuery(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	resp := &api.DeviceQueryResponse{}
+	resp.Devices, result = queryDevices(r.URL.Query())
+	resp.Result = strconv.Itoa(result)
+
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
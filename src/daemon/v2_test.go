@@ -0,0 +1,117 @@
+package daemon
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"api"
+	"meta"
+)
+
+func TestV2Resources(t *testing.T) {
+	s := newTestDaemon(t, metadata.CEPH, "dev001")
+	wp := s.startWorkers(1)
+	defer wp.Stop()
+	router := createRouter(s)
+
+	resp := &api.VolumeResponse{}
+	w := sendKeyed(t, router, "POST", "/v2/volumes/CEPH", "", &api.VolumeCreateRequest{VolumeId: "vol001", Capacity: "5"}, resp)
+	if w.Code != http.StatusAccepted || resp.Operation == "" {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+	waitOperation(t, s, resp.Operation)
+
+	resp = &api.VolumeResponse{}
+	w = sendKeyed(t, router, "GET", "/v2/volumes/CEPH/vol001", "", nil, resp)
+	if w.Code != http.StatusOK || resp.ID != "vol001" || resp.DriverName != metadata.CEPH || resp.Status != "online" {
+		t.Fatalf("get failed: %d %s", w.Code, w.Body.String())
+	}
+
+	query := func(url string, count int) {
+		list := &api.VolumeQueryResponse{}
+		if w := sendKeyed(t, router, "GET", url, "", nil, list); w.Code != http.StatusOK || len(list.Volumes) != count {
+			t.Fatalf("%s should find %d volumes, got %d %s", url, count, w.Code, w.Body.String())
+		}
+	}
+	query("/v2/volumes", 1)
+	query("/v2/volumes?status=online&driver=CEPH", 1)
+	query("/v2/volumes?status=inuse", 0)
+	for _, url := range []string{"/v2/volumes?status=bogus", "/v2/volumes?driver=bogus", "/v2/volumes?foo=1"} {
+		list := &api.VolumeQueryResponse{}
+		if w := sendKeyed(t, router, "GET", url, "", nil, list); w.Code != http.StatusBadRequest || list.Result != strconv.Itoa(metadata.EcodeParameterError) {
+			t.Fatalf("%s should be refused, got %d %s", url, w.Code, w.Body.String())
+		}
+	}
+
+	container := strings.Repeat("1", 64)
+	w = sendKeyed(t, router, "POST", "/v2/volumes/CEPH/vol001/attachments", "", &api.VolumeAttachRequest{ContainerId: container, Mode: "rw"}, resp)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("attach failed: %d %s", w.Code, w.Body.String())
+	}
+	waitOperation(t, s, resp.Operation)
+	query("/v2/volumes?container="+container, 1)
+	query("/v2/volumes?status=inuse", 1)
+
+	w = sendKeyed(t, router, "DELETE", "/v2/volumes/CEPH/vol001/attachments/"+container, "", nil, resp)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("detach failed: %d %s", w.Code, w.Body.String())
+	}
+	waitOperation(t, s, resp.Operation)
+	query("/v2/volumes?container="+container, 0)
+
+	w = sendKeyed(t, router, "DELETE", "/v2/volumes/CEPH/vol001", "key-1", nil, resp)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("delete failed: %d %s", w.Code, w.Body.String())
+	}
+	waitOperation(t, s, resp.Operation)
+	// a retry gets the first answer
+	if w = sendKeyed(t, router, "DELETE", "/v2/volumes/CEPH/vol001", "key-1", nil, nil); w.Code != http.StatusAccepted || w.Header().Get(api.IDEMPOTENCY_REPLAY_HEADER) != "true" {
+		t.Fatalf("retry should be replayed, got %d %s", w.Code, w.Body.String())
+	}
+	if w = sendKeyed(t, router, "GET", "/v2/volumes/CEPH/vol001", "", nil, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("deleted volume should be missing, got %d %s", w.Code, w.Body.String())
+	}
+
+	host := &api.HostResponse{}
+	if w = sendKeyed(t, router, "GET", "/v2/hosts/"+testNodeID, "", nil, host); w.Code != http.StatusOK || host.IP != testNodeID {
+		t.Fatalf("get host failed: %d %s", w.Code, w.Body.String())
+	}
+	hosts := &api.HostQueryResponse{}
+	if w = sendKeyed(t, router, "GET", "/v2/hosts?status=online", "", nil, hosts); w.Code != http.StatusOK || len(hosts.Hosts) != 1 {
+		t.Fatalf("query hosts failed: %d %s", w.Code, w.Body.String())
+	}
+
+	device := &api.DeviceResponse{}
+	if w = sendKeyed(t, router, "GET", "/v2/devices/CEPH/dev001", "", nil, device); w.Code != http.StatusOK || device.Backend != metadata.CEPH {
+		t.Fatalf("get device failed: %d %s", w.Code, w.Body.String())
+	}
+	devices := &api.DeviceQueryResponse{}
+	if w = sendKeyed(t, router, "GET", "/v2/devices?host="+testNodeID+"&backend=CEPH", "", nil, devices); w.Code != http.StatusOK || len(devices.Devices) != 1 {
+		t.Fatalf("query devices failed: %d %s", w.Code, w.Body.String())
+	}
+	if w = sendKeyed(t, router, "GET", "/v2/devices?host=10.0.0.2", "", nil, devices); w.Code != http.StatusOK || len(devices.Devices) != 0 {
+		t.Fatalf("query devices of another host failed: %d %s", w.Code, w.Body.String())
+	}
+
+	// the document tells every route
+	doc := &struct {
+		OpenAPI string
+		Paths   map[string]map[string]struct {
+			Parameters []struct{ Name, In string }
+		}
+	}{}
+	if w = sendKeyed(t, router, "GET", "/v2/openapi.json", "", nil, doc); w.Code != http.StatusOK || doc.OpenAPI != "3.0.0" {
+		t.Fatalf("openapi failed: %d %s", w.Code, w.Body.String())
+	}
+	for _, rt := range s.v2Routes() {
+		op, ok := doc.Paths["/v2"+rt.path][strings.ToLower(rt.method)]
+		if !ok {
+			t.Fatalf("%s %s missing from the document", rt.method, rt.path)
+		}
+		if len(op.Parameters) != strings.Count(rt.path, "{")+len(rt.query) {
+			t.Fatalf("%s %s has parameters %+v", rt.method, rt.path, op.Parameters)
+		}
+	}
+}
@@ -1,16 +1,39 @@
 package daemon
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 
 	"api"
+	"driver"
 	"meta"
 	"meta/proto"
 	"scheduler"
 )
 
+// getVolumeDriver returns nil when the backend has no driver loaded, such
+// volumes only live in metadata.
+func (s *daemon) getVolumeDriver(driverName string) driver.VolumeDriver {
+	return s.Drivers[driverName]
+}
+
+func getVolumeDevices(vl *metaproto.Volume, backend string) ([]*metaproto.Device, error) {
+	devs := []*metaproto.Device{}
+	for i := 0; i < len(vl.Devices); i++ {
+		dv, err := metadata.GetDevice(string(vl.Devices[i].Deviceid), backend)
+		if err != nil {
+			return nil, err
+		}
+		if dv == nil {
+			return nil, metadata.NewError(metadata.EcodeDeviceNotFound, string(vl.Devices[i].Deviceid))
+		}
+		devs = append(devs, dv)
+	}
+	return devs, nil
+}
+
 func (s *daemon) doVolumeList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
 	metadata.Lock()
 	defer metadata.Unlock()
@@ -27,7 +50,7 @@ func (s *daemon) doVolumeList(version string, w http.ResponseWriter, r *http.Req
 
 		volumes, err := metadata.ListVolumes(req.DriverName)
 		if err != nil {
-			result = (err).(*metadata.Error).Code
+			result = errorResult(w, err)
 			break
 		}
 
@@ -61,23 +84,13 @@ func (s *daemon) doVolumeGet(version string, w http.ResponseWriter, r *http.Requ
 
 		vl, err := metadata.GetVolume(req.VolumeId, req.DriverName)
 		if err != nil {
-			result = (err).(*metadata.Error).Code
+			result = errorResult(w, err)
 			break
 		}
 
-		var ownerContainers []string
-		for i := 0; i < len(vl.Containers); i++ {
-			ownerContainers = append(ownerContainers, string(vl.Containers[i].Containerid))
-		}
-
 		fmt.Println(vl)
 
-		resp.ID = string(vl.Id)
-		resp.Status = string(vl.Status)
-		resp.Capacity = string(vl.Capacity)
-		resp.Writable = string(vl.Writable)
-		resp.Containers = ownerContainers
-
+		volumeResponse(vl, req.DriverName, resp)
 		break
 	}
 
@@ -107,12 +120,66 @@ func (s *daemon) doVolumeCreate(version string, w http.ResponseWriter, r *http.R
 			break
 		}
 
+		opResp := &api.VolumeResponse{}
+		result = s.queueOperation(api.OPERATION_CREATE, req.VolumeId, req.DriverName, req, s.volumeCreateOp(req, opResp), opResp, resp)
+		break
+	}
+
+	fmt.Println("Response: ", resp)
+	resp.Result = strconv.Itoa(result)
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// volumeResponse tells what a volume is, for the requests reading it.
+func volumeResponse(vl *metaproto.Volume, driverName string, resp *api.VolumeResponse) {
+	var ownerContainers []string
+	for i := 0; i < len(vl.Containers); i++ {
+		ownerContainers = append(ownerContainers, string(vl.Containers[i].Containerid))
+	}
+
+	resp.ID = string(vl.Id)
+	resp.DriverName = driverName
+	resp.Status = metadata.VolumeStates.Name(metadata.VolumeStatus(vl))
+	resp.Capacity = string(vl.Capacity)
+	resp.Writable = string(vl.Writable)
+	resp.Containers = ownerContainers
+}
+
+// processVolumeCreate creates the volume at once, returning the result
+// code. The caller holds the metadata lock.
+func (s *daemon) processVolumeCreate(req *api.VolumeCreateRequest, resp *api.VolumeResponse) int {
+	return s.volumeCreateOp(req, resp).runLocked()
+}
+
+// volumeCreateOp schedules devices for the volume and records it creating,
+// so its devices are taken and nothing attaches to it meanwhile, lets the
+// backend driver allocate storage and records the volume online, or
+// removes it when the driver failed. An interrupted create is removed.
+func (s *daemon) volumeCreateOp(req *api.VolumeCreateRequest, resp *api.VolumeResponse) *volumeOp {
+	d := s.getVolumeDriver(req.DriverName)
+	var vl *metaproto.Volume
+	var ds []*metaproto.Device
+	// resumed after a restart, the storage may be half made
+	interrupted := false
+
+	prepare := func() int {
+		// a retry without idempotency key must not take the volume over
+		if _, err := metadata.GetVolume(req.VolumeId, req.DriverName); err == nil {
+			return metadata.EcodeVolumeExist
+		}
+
 		//TODO 通过scheduler返回合适的devices， 记录到Volume结构中，并返回
 		var opts = map[string]string{"FilterCapacity": req.Capacity, "WeigherCapacity": "100", "Backend": req.DriverName, "Replica": "1"}
-		ds, err := scheduler.DoScheduler(opts)
+		var err error
+		ds, err = scheduler.DoScheduler(opts)
 		if err != nil {
-			result = metadata.EcodeSchedulerError
-			break
+			return metadata.EcodeSchedulerError
 		}
 
 		devs := []api.DeviceIdentify{}                //存在Device结构中的后端信息
@@ -134,14 +201,13 @@ func (s *daemon) doVolumeCreate(version string, w http.ResponseWriter, r *http.R
 
 		fmt.Println("[daemon] ", req)
 
-		vl := &metaproto.Volume{
+		vl = &metaproto.Volume{
 			Id:       []byte(req.VolumeId),
 			Capacity: []byte(req.Capacity),
+			Status:   metadata.IntegerToBytes(metadata.VOLUME_CREATING),
 			Devices:  devices,
 		}
 
-		fmt.Println("[daemon] ", vl)
-
 		cons := []*metaproto.Volume_OwnerContainer{}
 		oc := &metaproto.Volume_OwnerContainer{Containerid: []byte(req.ContainerId)}
 
@@ -161,27 +227,79 @@ func (s *daemon) doVolumeCreate(version string, w http.ResponseWriter, r *http.R
 		}
 
 		fmt.Println("Volume struct: ", vl)
-		err = metadata.AddVolume(vl, req.DriverName)
-		if err != nil {
-			result = (err).(*metadata.Error).Code
-			break
+		if err := metadata.AddVolume(vl, req.DriverName); err != nil {
+			return (err).(*metadata.Error).Code
 		}
 
 		resp.ID = req.VolumeId
-		resp.Status = string(metadata.VOLUME_UNKNOW)
+		resp.Status = metadata.VolumeStates.Name(metadata.VOLUME_CREATING)
 		resp.Devices = devs
-		break
+		return 0
 	}
 
-	fmt.Println("Response: ", resp)
-	resp.Result = strconv.Itoa(result)
-	data, err := api.ResponseOutput(*resp)
-	if err != nil {
-		return err
+	resume := func(*metaproto.Volume_OwnerContainer) int {
+		var err error
+		if vl, err = metadata.GetVolume(req.VolumeId, req.DriverName); err != nil {
+			return (err).(*metadata.Error).Code
+		}
+		if metadata.VolumeStatus(vl) != metadata.VOLUME_CREATING {
+			return metadata.EcodeIllegalTransition
+		}
+		if d != nil {
+			if ds, err = getVolumeDevices(vl, req.DriverName); err != nil {
+				return (err).(*metadata.Error).Code
+			}
+		}
+		interrupted = true
+
+		resp.ID = req.VolumeId
+		resp.Status = metadata.VolumeStates.Name(metadata.VOLUME_CREATING)
+		return 0
 	}
 
-	_, err = w.Write(data)
-	return err
+	run := func() error {
+		if interrupted {
+			if d != nil {
+				if err := d.DeleteVolume(vl, ds); err != nil {
+					log.Warnf("[volumeCreateOp] driver %s remove volume %s error: %s", req.DriverName, req.VolumeId, err.Error())
+				}
+			}
+			return errors.New("daemon restarted")
+		}
+		if d == nil {
+			return nil
+		}
+		return d.CreateVolume(vl, ds)
+	}
+
+	commit := func(err error) int {
+		if err != nil {
+			log.Errorf("[volumeCreateOp] driver %s create volume %s error: %s", req.DriverName, req.VolumeId, err.Error())
+			if len(vl.Containers) != 0 {
+				metadata.DelVolumeContainer(req.VolumeId, req.DriverName, req.ContainerId)
+			}
+			if err := metadata.DelVolume(req.VolumeId, req.DriverName); err != nil {
+				log.Errorf("[volumeCreateOp] remove volume %s error: %s", req.VolumeId, err.Error())
+			}
+			return metadata.EcodeDriverError
+		}
+
+		// a volume created for a container is online, then held by it
+		statuses := []int{metadata.VOLUME_ONLINE}
+		if len(vl.Containers) != 0 {
+			statuses = append(statuses, metadata.VOLUME_INUSE)
+		}
+		for _, status := range statuses {
+			if err := metadata.SetVolumeStatus(req.VolumeId, req.DriverName, status); err != nil {
+				return (err).(*metadata.Error).Code
+			}
+		}
+
+		resp.Status = metadata.VolumeStates.Name(statuses[len(statuses)-1])
+		return 0
+	}
+
+	return &volumeOp{prepare: prepare, run: run, commit: commit, resume: resume}
 }
 
 func (s *daemon) doVolumeAttach(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
@@ -198,29 +316,170 @@ func (s *daemon) doVolumeAttach(version string, w http.ResponseWriter, r *http.R
 			break
 		}
 
-		mode := []byte(metadata.ROVolume)
+		opResp := &api.VolumeResponse{}
+		result = s.queueOperation(api.OPERATION_ATTACH, req.VolumeId, req.DriverName, req, s.volumeAttachOp(req, opResp), opResp, resp)
+		break
+	}
+
+	resp.Result = strconv.Itoa(result)
+	data, err := api.ResponseOutput(*resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// processVolumeAttach attaches the volume at once, returning the result
+// code. The caller holds the metadata lock.
+func (s *daemon) processVolumeAttach(req *api.VolumeAttachRequest, resp *api.VolumeResponse) int {
+	return s.volumeAttachOp(req, resp).runLocked()
+}
+
+// volumeAttachOp records the container as owner of the volume and
+// attaches it through the backend driver, the owner record is back as it
+// was before the request when the driver failed. An interrupted attach is
+// attached again.
+func (s *daemon) volumeAttachOp(req *api.VolumeAttachRequest, resp *api.VolumeResponse) *volumeOp {
+	d := s.getVolumeDriver(req.DriverName)
+	mode := []byte(metadata.ROVolume)
+	var vl *metaproto.Volume
+	var devs []*metaproto.Device
+	var path string
+	// the record of a container attached before the request
+	var owner *metaproto.Volume_OwnerContainer
+
+	undo := func() {
+		var err error
+		if owner == nil {
+			err = metadata.DelVolumeContainer(req.VolumeId, req.DriverName, req.ContainerId)
+		} else if string(owner.Mode) != string(mode) {
+			err = metadata.SetVolumeContainer(req.VolumeId, owner, req.DriverName, true)
+		}
+		if err != nil {
+			log.Errorf("[volumeAttachOp] undo attach of volume %s to container %s error: %s", req.VolumeId, req.ContainerId, err.Error())
+		}
+	}
+
+	setMode := func() bool {
 		if req.Mode == "rw" || req.Mode == "RW" {
 			mode = []byte(metadata.RWVolume)
 		} else if req.Mode == "ro" || req.Mode == "RO" {
 			mode = []byte(metadata.ROVolume)
 		} else {
-			result = 9999
-			break
+			return false
+		}
+		return true
+	}
+
+	prepare := func() int {
+		if !setMode() {
+			return metadata.EcodeParameterError
 		}
 
 		oc := &metaproto.Volume_OwnerContainer{
 			Containerid: []byte(req.ContainerId),
 			Mode:        mode,
+			Host:        []byte(s.hostIP()),
 		}
 
+		if current, err := metadata.GetVolume(req.VolumeId, req.DriverName); err == nil {
+			owner = volumeOwner(current, req.ContainerId)
+		}
 		err := metadata.SetVolumeContainer(req.VolumeId, oc, req.DriverName, false)
 		if err != nil {
-			result = (err).(*metadata.Error).Code
-			break
+			return (err).(*metadata.Error).Code
+		}
+
+		if d != nil {
+			if vl, err = metadata.GetVolume(req.VolumeId, req.DriverName); err == nil {
+				devs, err = getVolumeDevices(vl, req.DriverName)
+			}
+			if err != nil {
+				undo()
+				return (err).(*metadata.Error).Code
+			}
+		}
+
+		resp.ID = req.VolumeId
+		resp.Status = metadata.VolumeStates.Name(metadata.VOLUME_INUSE)
+		return 0
+	}
+
+	resume := func(before *metaproto.Volume_OwnerContainer) int {
+		if !setMode() {
+			return metadata.EcodeParameterError
+		}
+		owner = before
+
+		var err error
+		if vl, err = metadata.GetVolume(req.VolumeId, req.DriverName); err != nil {
+			return (err).(*metadata.Error).Code
+		}
+		if volumeOwner(vl, req.ContainerId) == nil {
+			// detached since
+			return metadata.EcodeContainerNotFound
+		}
+		if d != nil {
+			if devs, err = getVolumeDevices(vl, req.DriverName); err != nil {
+				undo()
+				return (err).(*metadata.Error).Code
+			}
 		}
 
 		resp.ID = req.VolumeId
-		resp.Status = string(metadata.VOLUME_INUSE)
+		resp.Status = metadata.VolumeStates.Name(metadata.VOLUME_INUSE)
+		return 0
+	}
+
+	run := func() error {
+		if d == nil {
+			return nil
+		}
+		var err error
+		path, err = d.AttachVolume(vl, devs, string(mode))
+		return err
+	}
+
+	commit := func(err error) int {
+		if err != nil {
+			log.Errorf("[volumeAttachOp] driver %s attach volume %s error: %s", req.DriverName, req.VolumeId, err.Error())
+			undo()
+			return driverResult(err)
+		}
+
+		if err := metadata.AddContainerVolume(req.ContainerId, []byte(req.VolumeId), mode, req.DriverName); err != nil {
+			log.Warnf("[volumeAttachOp] record volume %s on container %s error: %s", req.VolumeId, req.ContainerId, err.Error())
+		}
+
+		resp.Path = path
+		return 0
+	}
+
+	ownerOf := func() *metaproto.Volume_OwnerContainer {
+		return owner
+	}
+
+	return &volumeOp{prepare: prepare, run: run, commit: commit, resume: resume, owner: ownerOf}
+}
+
+func (s *daemon) doVolumeDetach(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	metadata.Lock()
+	defer metadata.Unlock()
+
+	result := 0
+	req := &api.VolumeDetachRequest{}
+	resp := &api.VolumeResponse{}
+
+	for {
+		if err := decodeRequest(r, req); err != nil {
+			result = metadata.EcodeRequestDecodeError
+			break
+		}
+
+		opResp := &api.VolumeResponse{}
+		result = s.queueOperation(api.OPERATION_DETACH, req.VolumeId, req.DriverName, req, s.volumeDetachOp(req, opResp), opResp, resp)
 		break
 	}
 
@@ -234,12 +493,125 @@ func (s *daemon) doVolumeAttach(version string, w http.ResponseWriter, r *http.R
 	return err
 }
 
-func (s *daemon) doVolumeDetach(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+// volumeDetachOp removes the container from the owners of the volume, the
+// backend driver detaches it only when no other container of the host
+// still holds it. A container not owning the volume detaches nothing, an
+// interrupted detach is detached again.
+func (s *daemon) volumeDetachOp(req *api.VolumeDetachRequest, resp *api.VolumeResponse) *volumeOp {
+	d := s.getVolumeDriver(req.DriverName)
+	var vl *metaproto.Volume
+	var devs []*metaproto.Device
+	// the record of the container, dropped by prepare while detaching
+	var owner *metaproto.Volume_OwnerContainer
+
+	prepare := func() int {
+		var err error
+		if vl, err = metadata.GetVolume(req.VolumeId, req.DriverName); err != nil {
+			return (err).(*metadata.Error).Code
+		}
+
+		if owner = volumeOwner(vl, req.ContainerId); owner == nil {
+			return metadata.EcodeContainerNotFound
+		}
+		host := string(owner.Host)
+		if others := hostOwners(vl, host) - 1; others != 0 {
+			log.Infof("[volumeDetachOp] volume %s stays attached for %d containers of host %s", req.VolumeId, others, host)
+			d = nil
+		}
+
+		if d != nil {
+			if devs, err = getVolumeDevices(vl, req.DriverName); err != nil {
+				return (err).(*metadata.Error).Code
+			}
+		}
+
+		// a detach of the other containers of the host queued meanwhile
+		// finds itself the last one
+		if err := metadata.DelVolumeContainer(req.VolumeId, req.DriverName, req.ContainerId); err != nil {
+			return (err).(*metadata.Error).Code
+		}
+
+		resp.ID = req.VolumeId
+		resp.Status = metadata.VolumeStates.Name(metadata.VolumeStatus(vl))
+		return 0
+	}
+
+	resume := func(dropped *metaproto.Volume_OwnerContainer) int {
+		var err error
+		if vl, err = metadata.GetVolume(req.VolumeId, req.DriverName); err != nil {
+			return (err).(*metadata.Error).Code
+		}
+		if owner = dropped; owner == nil {
+			return metadata.EcodeContainerNotFound
+		}
+		// the container is no longer counted
+		if hostOwners(vl, string(owner.Host)) != 0 {
+			d = nil
+		}
+		if d != nil {
+			if devs, err = getVolumeDevices(vl, req.DriverName); err != nil {
+				return (err).(*metadata.Error).Code
+			}
+		}
+
+		resp.ID = req.VolumeId
+		resp.Status = metadata.VolumeStates.Name(metadata.VolumeStatus(vl))
+		return 0
+	}
+
+	run := func() error {
+		if d == nil {
+			return nil
+		}
+		return d.DetachVolume(vl, devs)
+	}
+
+	commit := func(err error) int {
+		if err != nil {
+			log.Errorf("[volumeDetachOp] driver %s detach volume %s error: %s", req.DriverName, req.VolumeId, err.Error())
+			// the volume is still attached, so is the container
+			if err := metadata.SetVolumeContainer(req.VolumeId, owner, req.DriverName, false); err != nil {
+				log.Errorf("[volumeDetachOp] restore container %s on volume %s error: %s", req.ContainerId, req.VolumeId, err.Error())
+			}
+			return driverResult(err)
+		}
+
+		current, err := metadata.GetVolume(req.VolumeId, req.DriverName)
+		if err != nil {
+			return (err).(*metadata.Error).Code
+		}
+		if volumeOwner(current, req.ContainerId) != nil {
+			// attached again while detaching, the new attachment is kept
+			log.Warnf("[volumeDetachOp] container %s attached volume %s again while detaching", req.ContainerId, req.VolumeId)
+			resp.Status = metadata.VolumeStates.Name(metadata.VolumeStatus(current))
+			return 0
+		}
+
+		if err := metadata.DelContainerVolume(req.ContainerId, []byte(req.VolumeId)); err != nil {
+			if e, ok := err.(*metadata.Error); !ok || e.Code != metadata.EcodeContainerNotFound {
+				log.Warnf("[volumeDetachOp] remove volume %s from container %s error: %s", req.VolumeId, req.ContainerId, err.Error())
+			}
+		}
+
+		resp.Status = metadata.VolumeStates.Name(metadata.VolumeStatus(current))
+		return 0
+	}
+
+	ownerOf := func() *metaproto.Volume_OwnerContainer {
+		return owner
+	}
+
+	return &volumeOp{prepare: prepare, run: run, commit: commit, resume: resume, owner: ownerOf}
+}
+
+// doVolumeFence breaks the locks a dead host holds on the volume, the
+// ownership records are left to the caller.
+func (s *daemon) doVolumeFence(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
 	metadata.Lock()
 	defer metadata.Unlock()
 
 	result := 0
-	req := &api.VolumeDetachRequest{}
+	req := &api.VolumeFenceRequest{}
 	resp := &api.VolumeResponse{}
 
 	for {
@@ -248,16 +620,24 @@ func (s *daemon) doVolumeDetach(version string, w http.ResponseWriter, r *http.R
 			break
 		}
 
-		err := metadata.DelVolumeContainer(req.VolumeId, req.DriverName, req.ContainerId)
-		if err != nil {
-			result = (err).(*metadata.Error).Code
+		if req.Host == "" {
+			result = metadata.EcodeParameterError
+			break
+		}
+
+		d, ok := s.getVolumeDriver(req.DriverName).(driver.FenceDriver)
+		if !ok {
+			result = metadata.EcodeParameterError
+			break
+		}
+
+		if err := fenceVolume(d, req.VolumeId, req.DriverName, req.Host); err != nil {
+			result = errorResult(w, err)
 			break
 		}
 
 		resp.ID = req.VolumeId
-		resp.Status = string(metadata.VOLUME_INUSE)
 		break
-
 	}
 
 	resp.Result = strconv.Itoa(result)
@@ -285,15 +665,8 @@ func (s *daemon) doVolumeDelete(version string, w http.ResponseWriter, r *http.R
 			break
 		}
 
-		//TODO  设置volume的devices为READY状态，释放devices空间
-
-		err := metadata.DelVolume(req.VolumeId, req.DriverName)
-		if err != nil {
-			result = (err).(*metadata.Error).Code
-			break
-		}
-
-		resp.ID = req.VolumeId
+		opResp := &api.VolumeResponse{}
+		result = s.queueOperation(api.OPERATION_DELETE, req.VolumeId, req.DriverName, req, s.volumeDeleteOp(req, opResp), opResp, resp)
 		break
 
 	}
@@ -308,3 +681,188 @@ func (s *daemon) doVolumeDelete(version string, w http.ResponseWriter, r *http.R
 	_, err = w.Write(data)
 	return err
 }
+
+// volumeDeleteOp records the volume deleting, so nothing attaches to it
+// meanwhile, lets the backend driver release its storage and removes the
+// record, which is back online when the driver failed. An interrupted
+// delete is deleted again.
+func (s *daemon) volumeDeleteOp(req *api.VolumeDeleteRequest, resp *api.VolumeResponse) *volumeOp {
+	d := s.getVolumeDriver(req.DriverName)
+	var vl *metaproto.Volume
+	var devs []*metaproto.Device
+
+	prepare := func() int {
+		var err error
+		vl, err = metadata.GetVolume(req.VolumeId, req.DriverName)
+		if err != nil {
+			return (err).(*metadata.Error).Code
+		}
+		if len(vl.Containers) != 0 {
+			return metadata.EcodeVolumeInUse
+		}
+		if d != nil {
+			if devs, err = getVolumeDevices(vl, req.DriverName); err != nil {
+				return (err).(*metadata.Error).Code
+			}
+		}
+		if err := metadata.SetVolumeStatus(req.VolumeId, req.DriverName, metadata.VOLUME_DELETING); err != nil {
+			return (err).(*metadata.Error).Code
+		}
+
+		resp.ID = req.VolumeId
+		resp.Status = metadata.VolumeStates.Name(metadata.VOLUME_DELETING)
+		return 0
+	}
+
+	resume := func(*metaproto.Volume_OwnerContainer) int {
+		var err error
+		if vl, err = metadata.GetVolume(req.VolumeId, req.DriverName); err != nil {
+			return (err).(*metadata.Error).Code
+		}
+		if metadata.VolumeStatus(vl) != metadata.VOLUME_DELETING {
+			return metadata.EcodeIllegalTransition
+		}
+		if d != nil {
+			if devs, err = getVolumeDevices(vl, req.DriverName); err != nil {
+				return (err).(*metadata.Error).Code
+			}
+		}
+
+		resp.ID = req.VolumeId
+		resp.Status = metadata.VolumeStates.Name(metadata.VOLUME_DELETING)
+		return 0
+	}
+
+	run := func() error {
+		if d == nil {
+			return nil
+		}
+		return d.DeleteVolume(vl, devs)
+	}
+
+	commit := func(err error) int {
+		if err != nil {
+			log.Errorf("[volumeDeleteOp] driver %s delete volume %s error: %s", req.DriverName, req.VolumeId, err.Error())
+			if err := metadata.SetVolumeStatus(req.VolumeId, req.DriverName, metadata.VOLUME_ONLINE); err != nil {
+				log.Errorf("[volumeDeleteOp] set volume %s online error: %s", req.VolumeId, err.Error())
+			}
+			return driverResult(err)
+		}
+
+		if err := metadata.DelVolume(req.VolumeId, req.DriverName); err != nil {
+			return (err).(*metadata.Error).Code
+		}
+
+		resp.Status = ""
+		return 0
+	}
+
+	return &volumeOp{prepare: prepare, run: run, commit: commit, resume: resume}
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*metadata.Error)
+	return ok && (e.Code == metadata.EcodeVolumeNotFound || e.Code == metadata.EcodeSnapshotNotFound)
+}
+
+// volumeOwner is the owner record of the container, nil when the
+// container holds no attachment of the volume.
+func volumeOwner(vl *metaproto.Volume, containerid string) *metaproto.Volume_OwnerContainer {
+	for _, c := range vl.Containers {
+		if string(c.Containerid) == containerid {
+			return c
+		}
+	}
+	return nil
+}
+
+// hostOwners counts the containers of the host holding the volume.
+func hostOwners(vl *metaproto.Volume, host string) int {
+	n := 0
+	for _, c := range vl.Containers {
+		if string(c.Host) == host {
+			n++
+		}
+	}
+	return n
+}
+
+func attachVolume(d driver.VolumeDriver, volumeid string, driverName string, mode string) (string, error) {
+	vl, err := metadata.GetVolume(volumeid, driverName)
+	if err != nil {
+		return "", err
+	}
+	devs, err := getVolumeDevices(vl, driverName)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := d.AttachVolume(vl, devs, mode)
+	if err != nil {
+		log.Errorf("[attachVolume] driver %s attach volume %s error: %s", driverName, volumeid, err.Error())
+		return "", metadata.NewError(metadata.EcodeDriverError, err.Error())
+	}
+	return path, nil
+}
+
+func detachVolume(d driver.VolumeDriver, volumeid string, driverName string) error {
+	vl, err := metadata.GetVolume(volumeid, driverName)
+	if err != nil {
+		return err
+	}
+	devs, err := getVolumeDevices(vl, driverName)
+	if err != nil {
+		return err
+	}
+
+	if err := d.DetachVolume(vl, devs); err != nil {
+		log.Errorf("[detachVolume] driver %s detach volume %s error: %s", driverName, volumeid, err.Error())
+		return metadata.NewError(metadata.EcodeDriverError, err.Error())
+	}
+	return nil
+}
+
+func fenceVolume(d driver.FenceDriver, volumeid string, driverName string, host string) error {
+	vl, err := metadata.GetVolume(volumeid, driverName)
+	if err != nil {
+		return err
+	}
+	devs, err := getVolumeDevices(vl, driverName)
+	if err != nil {
+		return err
+	}
+
+	if err := d.FenceVolume(vl, devs, host); err != nil {
+		log.Errorf("[fenceVolume] driver %s fence volume %s of host %s error: %s", driverName, volumeid, host, err.Error())
+		return metadata.NewError(metadata.EcodeDriverError, err.Error())
+	}
+	return nil
+}
+
+// deleteVolume releases the backend storage, volumes still held by
+// containers are refused before anything is touched.
+func deleteVolume(d driver.VolumeDriver, volumeid string, driverName string) error {
+	vl, err := metadata.GetVolume(volumeid, driverName)
+	if err != nil {
+		return err
+	}
+	if len(vl.Containers) != 0 {
+		return metadata.NewError(metadata.EcodeVolumeInUse, "Volume in use")
+	}
+	devs, err := getVolumeDevices(vl, driverName)
+	if err != nil {
+		return err
+	}
+	if err := metadata.SetVolumeStatus(volumeid, driverName, metadata.VOLUME_DELETING); err != nil {
+		return err
+	}
+
+	if err := d.DeleteVolume(vl, devs); err != nil {
+		log.Errorf("[deleteVolume] driver %s delete volume %s error: %s", driverName, volumeid, err.Error())
+		if err := metadata.SetVolumeStatus(volumeid, driverName, metadata.VOLUME_ONLINE); err != nil {
+			log.Errorf("[deleteVolume] set volume %s online error: %s", volumeid, err.Error())
+		}
+		return metadata.NewError(metadata.EcodeDriverError, err.Error())
+	}
+	return nil
+}
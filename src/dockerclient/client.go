@@ -0,0 +1,193 @@
+package dockerclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	DEFAULT_SOCKET = "/var/run/docker.sock"
+
+	// the engine only looks at the host header, any name will do
+	engineHost = "docker"
+
+	STATE_RUNNING = "running"
+
+	EVENT_START   = "start"
+	EVENT_DIE     = "die"
+	EVENT_DESTROY = "destroy"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "dockerclient"})
+)
+
+// Client talks to the local Docker engine over its unix socket, only the
+// calls the daemon needs to follow container lifecycles are implemented.
+type Client struct {
+	sockFile string
+	http     *http.Client
+}
+
+type Container struct {
+	Id    string
+	Names []string
+	State string
+}
+
+type EventActor struct {
+	ID         string
+	Attributes map[string]string
+}
+
+type Event struct {
+	Type   string
+	Action string
+	Actor  EventActor
+	Time   int64
+
+	// engines before API 1.22 only fill these
+	Status string `json:"status"`
+	ID     string `json:"id"`
+}
+
+// ContainerID returns the id of the container the event is about, old and
+// new engines put it at different places.
+func (e *Event) ContainerID() string {
+	if e.Actor.ID != "" {
+		return e.Actor.ID
+	}
+	return e.ID
+}
+
+func (e *Event) ContainerAction() string {
+	if e.Action != "" {
+		return e.Action
+	}
+	return e.Status
+}
+
+func NewClient(sockFile string) *Client {
+	if sockFile == "" {
+		sockFile = DEFAULT_SOCKET
+	}
+	transport := &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout("unix", sockFile, 10*time.Second)
+		},
+	}
+	return &Client{
+		sockFile: sockFile,
+		http:     &http.Client{Transport: transport},
+	}
+}
+
+func (c *Client) get(path string, query url.Values) (*http.Response, error) {
+	u := url.URL{Scheme: "http", Host: engineHost, Path: path, RawQuery: query.Encode()}
+	resp, err := c.http.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("docker engine %s returned %d: %s", path, resp.StatusCode, string(body))
+	}
+	return resp, nil
+}
+
+func (c *Client) Ping() error {
+	resp, err := c.get("/_ping", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ListContainers returns every container the engine knows, stopped ones
+// included.
+func (c *Client) ListContainers() ([]*Container, error) {
+	resp, err := c.get("/containers/json", url.Values{"all": {"1"}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	containers := []*Container{}
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// Events streams container events from since (unix seconds, 0 for now) on,
+// the returned channel is closed when the stream ends or stop is closed.
+// The error channel gets at most one value.
+func (c *Client) Events(since int64, stop <-chan struct{}) (<-chan *Event, <-chan error) {
+	events := make(chan *Event)
+	errc := make(chan error, 1)
+
+	query := url.Values{"filters": {`{"type":["container"]}`}}
+	if since != 0 {
+		query.Set("since", strconv.FormatInt(since, 10))
+	}
+
+	go func() {
+		defer close(events)
+
+		resp, err := c.get("/events", query)
+		if err != nil {
+			errc <- err
+			return
+		}
+
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-stop:
+				resp.Body.Close()
+			case <-done:
+			}
+		}()
+		defer resp.Body.Close()
+
+		dec := json.NewDecoder(resp.Body)
+		for {
+			ev := &Event{}
+			if err := dec.Decode(ev); err != nil {
+				select {
+				case <-stop:
+				default:
+					if err == io.EOF {
+						err = fmt.Errorf("docker engine closed the event stream")
+					}
+					errc <- err
+				}
+				return
+			}
+			if ev.Type != "" && ev.Type != "container" {
+				continue
+			}
+
+			log.Debugf("Docker event %s for container %s", ev.ContainerAction(), ev.ContainerID())
+			select {
+			case events <- ev:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return events, errc
+}
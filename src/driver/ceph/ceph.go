@@ -0,0 +1,431 @@
+package ceph
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cephclient"
+	"driver"
+	"meta"
+	"meta/proto"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	CEPH_NODE = "ceph.node"
+	CEPH_ID   = "ceph.id"
+
+	DEFAULT_POOL = "rbd"
+	IMAGE_ORDER  = 22
+
+	LOCK_COOKIE_PREFIX = "policy:"
+	SHARED_LOCK_TAG    = "policy-ro"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "ceph"})
+)
+
+var (
+	// overridden by tests
+	newClient = func(host string, port int) (cephclient.CephClient, error) {
+		return cephclient.NewCephClient(host, port)
+	}
+)
+
+// Driver keeps CEPH volumes as RBD images, one per volume in the pool named
+// by the identify of its device. Every attach locks the image with a cookie
+// naming this host, rw attachments exclusively and ro ones shared, so two
+// hosts can never map the same image writable.
+type Driver struct {
+	root   string
+	node   string
+	id     string
+	cookie string
+
+	lock    sync.Mutex
+	clients map[string]cephclient.CephClient
+}
+
+func init() {
+	driver.Register(metadata.CEPH, Init)
+}
+
+// Init names the locks after CEPH_NODE, by default the address the owner
+// records give this host, which failover fences a dead host by.
+func Init(root string, opts map[string]string) (driver.VolumeDriver, error) {
+	node := opts[CEPH_NODE]
+	if node == "" {
+		node = opts[driver.HOST_OPT]
+	}
+	if node == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		log.Warnf("CEPH driver has no host address, locks named %s cannot be fenced", hostname)
+		node = hostname
+	}
+
+	log.Debugf("CEPH driver initialized for node %s", node)
+
+	return &Driver{
+		root:    root,
+		node:    node,
+		id:      opts[CEPH_ID],
+		cookie:  lockCookie(node),
+		clients: make(map[string]cephclient.CephClient),
+	}, nil
+}
+
+func (d *Driver) Name() string {
+	return metadata.CEPH
+}
+
+// lockCookie names the locks a node takes, fencing finds the locks of a
+// dead node by it.
+func lockCookie(node string) string {
+	return LOCK_COOKIE_PREFIX + node
+}
+
+func monitorAddr(dev *metaproto.Device) (string, int) {
+	port, err := metadata.DevicePort(dev)
+	if err != nil {
+		port = 0
+	}
+	return metadata.GetHostIpFromKey(string(dev.Host)), port
+}
+
+func devicePool(dev *metaproto.Device) string {
+	if len(dev.Identify) == 0 {
+		return DEFAULT_POOL
+	}
+	return string(dev.Identify)
+}
+
+func (d *Driver) getClient(dev *metaproto.Device) (cephclient.CephClient, error) {
+	host, port := monitorAddr(dev)
+	key := host + ":" + strconv.Itoa(port)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if c, ok := d.clients[key]; ok {
+		return c, nil
+	}
+	c, err := newClient(host, port)
+	if err != nil {
+		log.Errorf("[getClient] connect to monitor %s error: %s", key, err.Error())
+		return nil, err
+	}
+	d.clients[key] = c
+	return c, nil
+}
+
+func (d *Driver) volumeImage(vl *metaproto.Volume, devs []*metaproto.Device) (cephclient.CephClient, *metaproto.Device, error) {
+	if len(vl.Devices) != len(devs) {
+		return nil, nil, fmt.Errorf("volume %s has %d devices, %d given", vl.Id, len(vl.Devices), len(devs))
+	}
+	if len(devs) == 0 {
+		return nil, nil, fmt.Errorf("volume %s has no device", vl.Id)
+	}
+	c, err := d.getClient(devs[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, devs[0], nil
+}
+
+func (d *Driver) CreateVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	c, dev, err := d.volumeImage(vl, devs)
+	if err != nil {
+		return err
+	}
+	size, err := metadata.VolumeCapacity(vl)
+	if err != nil || size <= 0 {
+		return fmt.Errorf("invalid volume capacity %s", vl.Capacity)
+	}
+
+	return c.CreateImage(devicePool(dev), string(vl.Id), uint64(size), IMAGE_ORDER)
+}
+
+// DeleteVolume refuses images still locked by any host, their lock would
+// be the only trace of a forgotten mapping.
+func (d *Driver) DeleteVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	c, dev, err := d.volumeImage(vl, devs)
+	if err != nil {
+		return err
+	}
+	pool := devicePool(dev)
+
+	_, lockers, err := c.ListLockers(pool, string(vl.Id))
+	if err == cephclient.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(lockers) != 0 {
+		return fmt.Errorf("image %s/%s is locked by %s", pool, vl.Id, lockerNames(lockers))
+	}
+
+	return c.DeleteImage(pool, string(vl.Id))
+}
+
+func lockerNames(lockers []cephclient.Locker) string {
+	names := []string{}
+	for _, l := range lockers {
+		names = append(names, strings.TrimPrefix(l.Cookie, LOCK_COOKIE_PREFIX))
+	}
+	return strings.Join(names, ",")
+}
+
+// lockImage takes the lock matching mode and returns how to undo what it
+// did, nil when it took nothing. An exclusive lock this node holds already
+// covers a reader, it is never downgraded as the node may map the image rw.
+// A shared lock of this node is upgraded for a writer.
+func (d *Driver) lockImage(c cephclient.CephClient, pool string, image string, mode string) (func(), error) {
+	_, lockers, err := c.ListLockers(pool, image)
+	if err != nil {
+		return nil, err
+	}
+
+	others := []cephclient.Locker{}
+	mine := []cephclient.Locker{}
+	for _, l := range lockers {
+		if l.Cookie == d.cookie {
+			mine = append(mine, l)
+		} else {
+			others = append(others, l)
+		}
+	}
+
+	exclusive := len(lockers) != 0 && lockers[0].Exclusive
+	release := func() {
+		if err := d.unlockImage(c, pool, image); err != nil {
+			log.Errorf("[lockImage] release lock of image %s/%s error: %s", pool, image, err.Error())
+		}
+	}
+	if mode != metadata.RWVolume {
+		if len(others) != 0 && exclusive {
+			return nil, fmt.Errorf("image %s/%s is locked rw by %s", pool, image, lockerNames(others))
+		}
+		if len(mine) != 0 {
+			return nil, nil
+		}
+		if err := c.LockShared(pool, image, d.cookie, SHARED_LOCK_TAG); err != nil {
+			return nil, err
+		}
+		return release, nil
+	}
+
+	if len(others) != 0 {
+		return nil, fmt.Errorf("image %s/%s is locked by %s", pool, image, lockerNames(others))
+	}
+	if len(mine) != 0 && exclusive {
+		return nil, nil
+	}
+	for _, l := range mine {
+		if err := c.BreakLock(pool, image, l.Client, l.Cookie); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.LockExclusive(pool, image, d.cookie); err != nil {
+		return nil, err
+	}
+	if len(mine) == 0 {
+		return release, nil
+	}
+	// the readers of this node keep their shared lock
+	return func() {
+		release()
+		if err := c.LockShared(pool, image, d.cookie, SHARED_LOCK_TAG); err != nil {
+			log.Errorf("[lockImage] restore shared lock of image %s/%s error: %s", pool, image, err.Error())
+		}
+	}, nil
+}
+
+// unlockImage breaks rather than unlocks, the lock may have been taken by
+// a previous connection of this node.
+func (d *Driver) unlockImage(c cephclient.CephClient, pool string, image string) error {
+	_, lockers, err := c.ListLockers(pool, image)
+	if err != nil {
+		return err
+	}
+	for _, l := range lockers {
+		if l.Cookie != d.cookie {
+			continue
+		}
+		if err := c.BreakLock(pool, image, l.Client, l.Cookie); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AttachVolume locks the image for this node and maps it, the mapping of
+// an earlier attach is reused.
+func (d *Driver) AttachVolume(vl *metaproto.Volume, devs []*metaproto.Device, mode string) (string, error) {
+	c, dev, err := d.volumeImage(vl, devs)
+	if err != nil {
+		return "", err
+	}
+	pool, image := devicePool(dev), string(vl.Id)
+
+	undo, err := d.lockImage(c, pool, image, mode)
+	if err != nil {
+		log.Errorf("[AttachVolume] lock image %s/%s error: %s", pool, image, err.Error())
+		return "", err
+	}
+
+	path, err := d.rbdMap(dev, pool, image, mode == metadata.ROVolume)
+	if err != nil {
+		// only what this attach took, the locks of earlier ones stay
+		if undo != nil {
+			undo()
+		}
+		return "", err
+	}
+
+	log.Debugf("[AttachVolume] volume %s attached at %s", vl.Id, path)
+	return path, nil
+}
+
+func (d *Driver) DetachVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	c, dev, err := d.volumeImage(vl, devs)
+	if err != nil {
+		return err
+	}
+	pool, image := devicePool(dev), string(vl.Id)
+
+	if err := rbdUnmap(pool, image); err != nil {
+		return err
+	}
+	return d.unlockImage(c, pool, image)
+}
+
+// FenceVolume blacklists the clients a dead node locked the image with and
+// breaks their locks, the node cannot write the image any more even if it
+// comes back before noticing. Finding no lock of the node is an error, the
+// node may hold the image under a name fencing does not know.
+func (d *Driver) FenceVolume(vl *metaproto.Volume, devs []*metaproto.Device, node string) error {
+	c, dev, err := d.volumeImage(vl, devs)
+	if err != nil {
+		return err
+	}
+	pool, image := devicePool(dev), string(vl.Id)
+	cookie := lockCookie(node)
+
+	_, lockers, err := c.ListLockers(pool, image)
+	if err != nil {
+		return err
+	}
+	fenced := 0
+	for _, l := range lockers {
+		if l.Cookie != cookie {
+			continue
+		}
+		fenced++
+		if err := d.blacklist(dev, l.Addr); err != nil {
+			return err
+		}
+		if err := c.BreakLock(pool, image, l.Client, l.Cookie); err != nil {
+			return err
+		}
+		log.Infof("[FenceVolume] broke lock of %s (%s) on image %s/%s", node, l.Client, pool, image)
+	}
+	if fenced == 0 {
+		return fmt.Errorf("image %s/%s holds no lock of %s", pool, image, node)
+	}
+	return nil
+}
+
+func (d *Driver) CreateSnapshot(vl *metaproto.Volume, devs []*metaproto.Device, snapshotid string) error {
+	c, dev, err := d.volumeImage(vl, devs)
+	if err != nil {
+		return err
+	}
+	return c.CreateSnapshot(devicePool(dev), string(vl.Id), snapshotid)
+}
+
+// DeleteSnapshot takes a missing snapshot as deleted.
+func (d *Driver) DeleteSnapshot(vl *metaproto.Volume, devs []*metaproto.Device, snapshotid string) error {
+	c, dev, err := d.volumeImage(vl, devs)
+	if err != nil {
+		return err
+	}
+	err = c.RemoveSnapshot(devicePool(dev), string(vl.Id), snapshotid)
+	if err == cephclient.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// ExportSnapshot writes the snapshot in the rbd diff format, only the
+// extents changed since fromSnapshotid when given.
+func (d *Driver) ExportSnapshot(vl *metaproto.Volume, devs []*metaproto.Device, snapshotid string, fromSnapshotid string, file string) error {
+	_, dev, err := d.volumeImage(vl, devs)
+	if err != nil {
+		return err
+	}
+	return d.rbdExportDiff(dev, devicePool(dev), string(vl.Id), snapshotid, fromSnapshotid, file)
+}
+
+func (d *Driver) ImportSnapshot(vl *metaproto.Volume, devs []*metaproto.Device, file string) error {
+	_, dev, err := d.volumeImage(vl, devs)
+	if err != nil {
+		return err
+	}
+	return d.rbdImportDiff(dev, devicePool(dev), string(vl.Id), file)
+}
+
+// CopyVolume reads the image and writes it to the image of to, which may
+// be in another pool or cluster, one object at a time. Objects reading as
+// zeroes are skipped, the new image is sparse.
+func (d *Driver) CopyVolume(vl *metaproto.Volume, devs []*metaproto.Device, to *metaproto.Volume, toDevs []*metaproto.Device, progress func(done int64, total int64)) error {
+	src, dev, err := d.volumeImage(vl, devs)
+	if err != nil {
+		return err
+	}
+	dst, toDev, err := d.volumeImage(to, toDevs)
+	if err != nil {
+		return err
+	}
+	stat, err := src.ImageStat(devicePool(dev), string(vl.Id))
+	if err != nil {
+		return err
+	}
+
+	total := int64(stat["size"]) << 20
+	buf := make([]byte, 1<<IMAGE_ORDER)
+	for done := int64(0); done < total; {
+		n, err := src.ReadImage(devicePool(dev), string(vl.Id), buf, uint64(done))
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		if !isZero(buf[:n]) {
+			if err := dst.WriteImage(devicePool(toDev), string(to.Id), buf[:n], uint64(done)); err != nil {
+				return err
+			}
+		}
+		done += int64(n)
+		progress(done, total)
+	}
+	return nil
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
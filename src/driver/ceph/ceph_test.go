@@ -0,0 +1,310 @@
+package ceph
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cephclient"
+	"driver"
+	"meta"
+	"meta/proto"
+)
+
+func setupFake(t *testing.T) (cephclient.CephClient, *[]string, func()) {
+	dir, err := ioutil.TempDir("", "ceph-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := cephclient.NewFakeCluster()
+	admin := cluster.Connect()
+	if err := admin.CreatePool(DEFAULT_POOL); err != nil {
+		t.Fatal(err)
+	}
+	commands := []string{}
+	oldNewClient, oldExecute, oldDevDir, oldBlockDir := newClient, execute, rbdDevDir, sysBlockDir
+	newClient = func(host string, port int) (cephclient.CephClient, error) {
+		return cluster.Connect(), nil
+	}
+	execute = func(binary string, args []string) (string, error) {
+		commands = append(commands, binary+" "+strings.Join(args, " "))
+		if binary == RBD && args[0] == "map" {
+			return "/dev/rbd0\n", nil
+		}
+		return "", nil
+	}
+	rbdDevDir = dir
+	sysBlockDir = filepath.Join(dir, "sys")
+
+	return admin, &commands, func() {
+		newClient, execute, rbdDevDir, sysBlockDir = oldNewClient, oldExecute, oldDevDir, oldBlockDir
+		os.RemoveAll(dir)
+	}
+}
+
+func newTestDriver(t *testing.T, node string) *Driver {
+	d, err := Init("/tmp", map[string]string{CEPH_NODE: node})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.(*Driver)
+}
+
+func TestLocks(t *testing.T) {
+	admin, commands, cleanup := setupFake(t)
+	defer cleanup()
+
+	a, b := newTestDriver(t, "10.0.0.1"), newTestDriver(t, "10.0.0.2")
+	dev := &metaproto.Device{Id: []byte("dev001"), Host: []byte("/comet/hosts/10.0.0.9"), Port: []byte("6789")}
+	vl := &metaproto.Volume{
+		Id:       []byte("vol001"),
+		Capacity: []byte("100"),
+		Devices:  []*metaproto.Volume_AttachDevice{{Deviceid: dev.Id}},
+	}
+	devs := []*metaproto.Device{dev}
+
+	if err := a.CreateVolume(vl, devs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.ImageStat(DEFAULT_POOL, "vol001"); err != nil {
+		t.Fatalf("image should be created in the default pool: %v", err)
+	}
+
+	path, err := a.AttachVolume(vl, devs, metadata.RWVolume)
+	if err != nil || path != "/dev/rbd0" {
+		t.Fatalf("rw attach failed: %s, %v", path, err)
+	}
+	if (*commands)[0] != "rbd map rbd/vol001 -m 10.0.0.9:6789" {
+		t.Fatalf("unexpected map command %s", (*commands)[0])
+	}
+	if _, err := b.AttachVolume(vl, devs, metadata.RWVolume); err == nil {
+		t.Fatal("second rw attach from another host should fail")
+	}
+	if _, err := b.AttachVolume(vl, devs, metadata.ROVolume); err == nil {
+		t.Fatal("ro attach should fail while another host holds it rw")
+	}
+	if err := a.DeleteVolume(vl, devs); err == nil {
+		t.Fatal("locked image should not be deleted")
+	}
+
+	// a reader on the host of the writer keeps the exclusive lock, even
+	// when its mapping fails
+	if _, err := a.AttachVolume(vl, devs, metadata.ROVolume); err != nil {
+		t.Fatalf("ro attach next to the writer failed: %v", err)
+	}
+	oldExecute := execute
+	execute = func(binary string, args []string) (string, error) {
+		return "", errors.New("device busy")
+	}
+	if _, err := a.AttachVolume(vl, devs, metadata.ROVolume); err == nil {
+		t.Fatal("ro attach should fail when the mapping fails")
+	}
+	execute = oldExecute
+	if _, lockers, _ := admin.ListLockers(DEFAULT_POOL, "vol001"); len(lockers) != 1 || !lockers[0].Exclusive || lockers[0].Cookie != lockCookie("10.0.0.1") {
+		t.Fatalf("the writer should keep its exclusive lock, got %v", lockers)
+	}
+
+	if err := a.DetachVolume(vl, devs); err != nil {
+		t.Fatal(err)
+	}
+	if _, lockers, _ := admin.ListLockers(DEFAULT_POOL, "vol001"); len(lockers) != 0 {
+		t.Fatalf("detach should release the lock, got %v", lockers)
+	}
+
+	if _, err := b.AttachVolume(vl, devs, metadata.ROVolume); err != nil {
+		t.Fatalf("ro attach failed: %v", err)
+	}
+	if _, err := a.AttachVolume(vl, devs, metadata.ROVolume); err != nil {
+		t.Fatalf("ro attaches should share the image: %v", err)
+	}
+	if !strings.HasSuffix((*commands)[len(*commands)-1], "--read-only") {
+		t.Fatalf("ro attach should map read-only, got %s", (*commands)[len(*commands)-1])
+	}
+	if _, err := a.AttachVolume(vl, devs, metadata.RWVolume); err == nil {
+		t.Fatal("rw attach should fail while another host reads the image")
+	}
+
+	// b died, fencing blacklists it and frees the image for a writer
+	if err := a.FenceVolume(vl, devs, "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	blacklist := "ceph osd blacklist add 10.0.0.3:0/3 -m 10.0.0.9:6789"
+	found := false
+	for _, cmd := range *commands {
+		if cmd == blacklist {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("fencing should blacklist the dead client, commands %v", *commands)
+	}
+	if _, err := a.AttachVolume(vl, devs, metadata.RWVolume); err != nil {
+		t.Fatalf("rw attach after fencing failed: %v", err)
+	}
+	tag, lockers, err := admin.ListLockers(DEFAULT_POOL, "vol001")
+	if err != nil || len(lockers) != 1 || lockers[0].Cookie != lockCookie("10.0.0.1") || tag != "" {
+		t.Fatalf("node should hold the only exclusive lock, got %v", lockers)
+	}
+	if err := a.FenceVolume(vl, devs, "10.0.0.2"); err == nil {
+		t.Fatal("fencing a node without a lock should fail")
+	}
+}
+
+func TestLockNode(t *testing.T) {
+	d, err := Init("/tmp", map[string]string{driver.HOST_OPT: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie := d.(*Driver).cookie; cookie != lockCookie("10.0.0.1") {
+		t.Fatalf("locks should be named after the host address, got %s", cookie)
+	}
+	d, err = Init("/tmp", map[string]string{driver.HOST_OPT: "10.0.0.1", CEPH_NODE: "node1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie := d.(*Driver).cookie; cookie != lockCookie("node1") {
+		t.Fatalf("%s should name the locks, got %s", CEPH_NODE, cookie)
+	}
+}
+
+func TestMapMode(t *testing.T) {
+	_, commands, cleanup := setupFake(t)
+	defer cleanup()
+
+	d := newTestDriver(t, "10.0.0.1")
+	dev := &metaproto.Device{Id: []byte("dev001"), Host: []byte("/comet/hosts/10.0.0.9"), Port: []byte("6789")}
+
+	// an earlier attach left a read-only mapping behind
+	link := mappedPath(DEFAULT_POOL, "vol001")
+	block := filepath.Join(sysBlockDir, "rbd0")
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(block, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(block, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(block, "ro"), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if path, err := d.rbdMap(dev, DEFAULT_POOL, "vol001", true); err != nil || path != link || len(*commands) != 0 {
+		t.Fatalf("a mapping of the same mode should be reused, got %s %v %v", path, err, *commands)
+	}
+	if _, err := d.rbdMap(dev, DEFAULT_POOL, "vol001", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(*commands) != 2 || (*commands)[0] != "rbd unmap "+link || strings.HasSuffix((*commands)[1], "--read-only") {
+		t.Fatalf("a read-only mapping should be replaced for a writer, got %v", *commands)
+	}
+
+	// the mapping in use cannot be replaced
+	execute = func(binary string, args []string) (string, error) {
+		return "", errors.New("device busy")
+	}
+	if _, err := d.rbdMap(dev, DEFAULT_POOL, "vol001", false); err == nil {
+		t.Fatal("a busy mapping of another mode should be refused")
+	}
+
+	// the rw mapping serves a reader as it is
+	if err := ioutil.WriteFile(filepath.Join(block, "ro"), []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := d.rbdMap(dev, DEFAULT_POOL, "vol001", true); err != nil || path != link {
+		t.Fatalf("a rw mapping should be reused for a reader, got %s %v %v", path, err, *commands)
+	}
+}
+
+func TestBackupCommands(t *testing.T) {
+	admin, commands, cleanup := setupFake(t)
+	defer cleanup()
+
+	d := newTestDriver(t, "10.0.0.1")
+	dev := &metaproto.Device{Id: []byte("dev001"), Host: []byte("/comet/hosts/10.0.0.9"), Port: []byte("6789")}
+	vl := &metaproto.Volume{
+		Id:       []byte("vol001"),
+		Capacity: []byte("100"),
+		Devices:  []*metaproto.Volume_AttachDevice{{Deviceid: dev.Id}},
+	}
+	devs := []*metaproto.Device{dev}
+	if err := d.CreateVolume(vl, devs); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.CreateSnapshot(vl, devs, "snap2"); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := admin.GetSnapshotNames(DEFAULT_POOL, "vol001"); len(names) != 1 || names[0] != "snap2" {
+		t.Fatalf("unexpected snapshots %v", names)
+	}
+	if err := d.ExportSnapshot(vl, devs, "snap2", "snap1", "/tmp/export"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ImportSnapshot(vl, devs, "/tmp/export"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"rbd export-diff rbd/vol001@snap2 /tmp/export --from-snap snap1 -m 10.0.0.9:6789",
+		"rbd import-diff /tmp/export rbd/vol001 -m 10.0.0.9:6789",
+	}
+	for i, command := range expected {
+		if (*commands)[i] != command {
+			t.Fatalf("unexpected command %s", (*commands)[i])
+		}
+	}
+
+	if err := d.DeleteSnapshot(vl, devs, "snap2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteSnapshot(vl, devs, "snap2"); err != nil {
+		t.Fatalf("missing snapshot should count as deleted: %v", err)
+	}
+}
+
+func TestCopyVolume(t *testing.T) {
+	admin, _, cleanup := setupFake(t)
+	defer cleanup()
+	if err := admin.CreatePool("fast"); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDriver(t, "10.0.0.1")
+	from := &metaproto.Device{Id: []byte("dev001"), Host: []byte("/comet/hosts/10.0.0.9"), Port: []byte("6789")}
+	to := &metaproto.Device{Id: []byte("dev002"), Host: []byte("/comet/hosts/10.0.0.9"), Port: []byte("6789"), Identify: []byte("fast")}
+	vl := &metaproto.Volume{Id: []byte("vol001"), Capacity: []byte("8"), Devices: []*metaproto.Volume_AttachDevice{{Deviceid: from.Id}}}
+	moved := &metaproto.Volume{Id: []byte("vol001"), Capacity: []byte("8"), Devices: []*metaproto.Volume_AttachDevice{{Deviceid: to.Id}}}
+	if err := d.CreateVolume(vl, []*metaproto.Device{from}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateVolume(moved, []*metaproto.Device{to}); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("written past the first object")
+	if err := admin.WriteImage(DEFAULT_POOL, "vol001", data, 5<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []int64{}
+	err := d.CopyVolume(vl, []*metaproto.Device{from}, moved, []*metaproto.Device{to}, func(done int64, total int64) {
+		if total != 8<<20 {
+			t.Fatalf("unexpected total %d", total)
+		}
+		steps = append(steps, done)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[1] != 8<<20 {
+		t.Fatalf("progress should be told once per object, got %v", steps)
+	}
+	out := make([]byte, len(data))
+	if _, err := admin.ReadImage("fast", "vol001", out, 5<<20); err != nil || string(out) != string(data) {
+		t.Fatalf("copy should hold the data, got %q %v", out, err)
+	}
+}
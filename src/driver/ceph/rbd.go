@@ -0,0 +1,121 @@
+package ceph
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"meta/proto"
+	"util"
+)
+
+const (
+	RBD  = "rbd"
+	CEPH = "ceph"
+)
+
+var (
+	// overridden by tests
+	execute     = util.Execute
+	rbdDevDir   = "/dev/rbd"
+	sysBlockDir = "/sys/block"
+)
+
+func (d *Driver) commonArgs(dev *metaproto.Device) []string {
+	host, port := monitorAddr(dev)
+	args := []string{"-m", host + ":" + strconv.Itoa(port)}
+	if d.id != "" {
+		args = append(args, "--id", d.id)
+	}
+	return args
+}
+
+// mappedPath is the udev link of a mapped image, it exists as long as the
+// image is mapped on this host.
+func mappedPath(pool string, image string) string {
+	return filepath.Join(rbdDevDir, pool, image)
+}
+
+// mappedReadonly tells whether the block device behind a mapping refuses
+// writes.
+func mappedReadonly(path string) (bool, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(sysBlockDir, filepath.Base(target), "ro"))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(data)) == "1", nil
+}
+
+// rbdMap reuses a mapping of the same mode or a rw one for a reader, a
+// read-only mapping is replaced for a writer, which fails while it is
+// still in use.
+func (d *Driver) rbdMap(dev *metaproto.Device, pool string, image string, readonly bool) (string, error) {
+	if path := mappedPath(pool, image); isMapped(path) {
+		ro, err := mappedReadonly(path)
+		if err != nil {
+			return "", err
+		}
+		if readonly || !ro {
+			return path, nil
+		}
+		if _, err := execute(RBD, []string{"unmap", path}); err != nil {
+			return "", fmt.Errorf("image %s/%s is mapped with another mode at %s: %s", pool, image, path, err.Error())
+		}
+	}
+
+	args := append([]string{"map", pool + "/" + image}, d.commonArgs(dev)...)
+	if readonly {
+		args = append(args, "--read-only")
+	}
+	output, err := execute(RBD, args)
+	if err != nil {
+		return "", err
+	}
+	path := strings.TrimSpace(output)
+	if path == "" {
+		return "", fmt.Errorf("rbd map %s/%s returned no device", pool, image)
+	}
+	return path, nil
+}
+
+func rbdUnmap(pool string, image string) error {
+	path := mappedPath(pool, image)
+	if !isMapped(path) {
+		return nil
+	}
+	_, err := execute(RBD, []string{"unmap", path})
+	return err
+}
+
+func isMapped(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func (d *Driver) blacklist(dev *metaproto.Device, addr string) error {
+	args := append([]string{"osd", "blacklist", "add", addr}, d.commonArgs(dev)...)
+	_, err := execute(CEPH, args)
+	return err
+}
+
+func (d *Driver) rbdExportDiff(dev *metaproto.Device, pool string, image string, snapshot string, fromSnapshot string, file string) error {
+	args := []string{"export-diff", pool + "/" + image + "@" + snapshot, file}
+	if fromSnapshot != "" {
+		args = append(args, "--from-snap", fromSnapshot)
+	}
+	_, err := execute(RBD, append(args, d.commonArgs(dev)...))
+	return err
+}
+
+func (d *Driver) rbdImportDiff(dev *metaproto.Device, pool string, image string, file string) error {
+	args := append([]string{"import-diff", file, pool + "/" + image}, d.commonArgs(dev)...)
+	_, err := execute(RBD, args)
+	return err
+}
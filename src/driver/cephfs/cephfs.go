@@ -0,0 +1,198 @@
+package cephfs
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"cephclient"
+	"driver"
+	"meta"
+	"meta/proto"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	CEPHFS_ID         = "cephfs.id"
+	CEPHFS_SECRETFILE = "cephfs.secretfile"
+
+	DEFAULT_VOLUMES_DIR = "/volumes"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "cephfs"})
+)
+
+var (
+	// overridden by tests
+	newClient = func(host string, port int) (cephclient.CephFSClient, error) {
+		return cephclient.NewCephFSClient(host, port)
+	}
+)
+
+// Driver keeps CEPHFS volumes as directories of a ceph filesystem, one per
+// volume below the directory named by the identify of its device, sized by
+// a quota. Attaching mounts the directory on the host, every container
+// using the volume shares that mount, writers included.
+type Driver struct {
+	root       string
+	id         string
+	secretFile string
+
+	lock    sync.Mutex
+	clients map[string]cephclient.CephFSClient
+}
+
+func init() {
+	driver.Register(metadata.CEPHFS, Init)
+}
+
+func Init(root string, opts map[string]string) (driver.VolumeDriver, error) {
+	log.Debugf("CEPHFS driver initialized with id %s", opts[CEPHFS_ID])
+
+	return &Driver{
+		root:       root,
+		id:         opts[CEPHFS_ID],
+		secretFile: opts[CEPHFS_SECRETFILE],
+		clients:    make(map[string]cephclient.CephFSClient),
+	}, nil
+}
+
+func (d *Driver) Name() string {
+	return metadata.CEPHFS
+}
+
+func monitorAddr(dev *metaproto.Device) (string, int) {
+	port, err := metadata.DevicePort(dev)
+	if err != nil {
+		port = 0
+	}
+	return metadata.GetHostIpFromKey(string(dev.Host)), port
+}
+
+func volumesDir(dev *metaproto.Device) string {
+	if len(dev.Identify) == 0 {
+		return DEFAULT_VOLUMES_DIR
+	}
+	return string(dev.Identify)
+}
+
+func volumeDir(dev *metaproto.Device, vl *metaproto.Volume) string {
+	return volumesDir(dev) + "/" + string(vl.Id)
+}
+
+func (d *Driver) getClient(dev *metaproto.Device) (cephclient.CephFSClient, error) {
+	host, port := monitorAddr(dev)
+	key := host + ":" + strconv.Itoa(port)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if c, ok := d.clients[key]; ok {
+		return c, nil
+	}
+	c, err := newClient(host, port)
+	if err != nil {
+		log.Errorf("[getClient] mount cephfs of monitor %s error: %s", key, err.Error())
+		return nil, err
+	}
+	d.clients[key] = c
+	return c, nil
+}
+
+func (d *Driver) volumeFS(vl *metaproto.Volume, devs []*metaproto.Device) (cephclient.CephFSClient, *metaproto.Device, error) {
+	if len(vl.Devices) != len(devs) {
+		return nil, nil, fmt.Errorf("volume %s has %d devices, %d given", vl.Id, len(vl.Devices), len(devs))
+	}
+	if len(devs) == 0 {
+		return nil, nil, fmt.Errorf("volume %s has no device", vl.Id)
+	}
+	c, err := d.getClient(devs[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, devs[0], nil
+}
+
+func (d *Driver) CreateVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	c, dev, err := d.volumeFS(vl, devs)
+	if err != nil {
+		return err
+	}
+	size, err := metadata.VolumeCapacity(vl)
+	if err != nil || size <= 0 {
+		return fmt.Errorf("invalid volume capacity %s", vl.Capacity)
+	}
+
+	if err := c.MakeDir(volumesDir(dev)); err != nil && err != cephclient.ErrExist {
+		return err
+	}
+	dir := volumeDir(dev, vl)
+	if err := c.MakeDir(dir); err != nil {
+		return err
+	}
+	if err := c.SetQuota(dir, uint64(size)); err != nil {
+		c.RemoveDir(dir)
+		return err
+	}
+	return nil
+}
+
+// DeleteVolume removes the directory of the volume, the files written in it
+// are purged through a mount of their own first.
+func (d *Driver) DeleteVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	c, dev, err := d.volumeFS(vl, devs)
+	if err != nil {
+		return err
+	}
+	dir := volumeDir(dev, vl)
+
+	err = c.RemoveDir(dir)
+	if err == cephclient.ErrBusy {
+		if err := d.purge(dev, vl); err != nil {
+			log.Errorf("[DeleteVolume] purge %s error: %s", dir, err.Error())
+			return err
+		}
+		err = c.RemoveDir(dir)
+	}
+	if err == cephclient.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// AttachVolume mounts the directory of the volume on the host and returns
+// the mount point, the mount of an earlier attach is reused. The mount is
+// always writable, read only users get a read only bind of it.
+func (d *Driver) AttachVolume(vl *metaproto.Volume, devs []*metaproto.Device, mode string) (string, error) {
+	_, dev, err := d.volumeFS(vl, devs)
+	if err != nil {
+		return "", err
+	}
+
+	mountPoint := d.mountPoint(string(vl.Id))
+	if err := d.mount(dev, volumeDir(dev, vl), mountPoint); err != nil {
+		log.Errorf("[AttachVolume] mount volume %s error: %s", vl.Id, err.Error())
+		return "", err
+	}
+
+	log.Debugf("[AttachVolume] volume %s mounted at %s", vl.Id, mountPoint)
+	return mountPoint, nil
+}
+
+func (d *Driver) DetachVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	if _, _, err := d.volumeFS(vl, devs); err != nil {
+		return err
+	}
+	return d.unmount(d.mountPoint(string(vl.Id)))
+}
+
+// ExpandVolume raises the quota of the volume, capacity is in MB.
+func (d *Driver) ExpandVolume(vl *metaproto.Volume, devs []*metaproto.Device, capacity int) error {
+	c, dev, err := d.volumeFS(vl, devs)
+	if err != nil {
+		return err
+	}
+	return c.SetQuota(volumeDir(dev, vl), uint64(capacity))
+}
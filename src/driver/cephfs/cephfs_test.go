@@ -0,0 +1,107 @@
+package cephfs
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"cephclient"
+	"meta/proto"
+)
+
+func setupFake(t *testing.T) (*cephclient.FakeFilesystem, *Driver, *[]string, func()) {
+	dir, err := ioutil.TempDir("", "cephfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := cephclient.NewFakeFilesystem()
+	commands := []string{}
+	mounts := map[string]bool{}
+	oldNewClient, oldExecute, oldIsMounted := newClient, execute, isMounted
+	newClient = func(host string, port int) (cephclient.CephFSClient, error) {
+		return fs.Connect(), nil
+	}
+	execute = func(binary string, args []string) (string, error) {
+		commands = append(commands, binary+" "+strings.Join(args, " "))
+		switch binary {
+		case "mount":
+			mounts[args[3]] = true
+		case "umount":
+			delete(mounts, args[0])
+		}
+		return "", nil
+	}
+	isMounted = func(mountPoint string) bool {
+		return mounts[mountPoint]
+	}
+
+	d, err := Init(dir, map[string]string{CEPHFS_ID: "policy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs, d.(*Driver), &commands, func() {
+		newClient, execute, isMounted = oldNewClient, oldExecute, oldIsMounted
+		os.RemoveAll(dir)
+	}
+}
+
+func TestVolumeLifecycle(t *testing.T) {
+	fs, d, commands, cleanup := setupFake(t)
+	defer cleanup()
+
+	dev := &metaproto.Device{Id: []byte("fs001"), Host: []byte("/comet/hosts/10.0.0.9"), Port: []byte("6789")}
+	vl := &metaproto.Volume{
+		Id:       []byte("share001"),
+		Capacity: []byte("100"),
+		Devices:  []*metaproto.Volume_AttachDevice{{Deviceid: dev.Id}},
+	}
+	devs := []*metaproto.Device{dev}
+
+	if err := d.CreateVolume(vl, devs); err != nil {
+		t.Fatal(err)
+	}
+	if quota, ok := fs.Quota("/volumes/share001"); !ok || quota != 100 {
+		t.Fatalf("volume directory should be created with its quota, got %d %v", quota, ok)
+	}
+	if err := d.CreateVolume(vl, devs); err != cephclient.ErrExist {
+		t.Fatalf("volume directory should exist, got %v", err)
+	}
+
+	path, err := d.AttachVolume(vl, devs, "rw")
+	if err != nil || path != d.mountPoint("share001") {
+		t.Fatalf("attach failed: %s, %v", path, err)
+	}
+	mount := "mount -t ceph 10.0.0.9:6789:/volumes/share001 " + path + " -o name=policy"
+	if len(*commands) != 1 || (*commands)[0] != mount {
+		t.Fatalf("unexpected mount commands %v", *commands)
+	}
+	if again, err := d.AttachVolume(vl, devs, "ro"); err != nil || again != path || len(*commands) != 1 {
+		t.Fatalf("a second attach should share the mount, got %s %v %v", again, err, *commands)
+	}
+
+	if err := d.ExpandVolume(vl, devs, 200); err != nil {
+		t.Fatal(err)
+	}
+	if quota, _ := fs.Quota("/volumes/share001"); quota != 200 {
+		t.Fatalf("expand should raise the quota, got %d", quota)
+	}
+
+	if err := d.DetachVolume(vl, devs); err != nil {
+		t.Fatal(err)
+	}
+	if (*commands)[len(*commands)-1] != "umount "+path {
+		t.Fatalf("detach should unmount, got %v", *commands)
+	}
+
+	if err := d.DeleteVolume(vl, devs); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fs.Quota("/volumes/share001"); ok {
+		t.Fatal("volume directory should be removed")
+	}
+	if err := d.DeleteVolume(vl, devs); err != nil {
+		t.Fatalf("deleting a removed volume should succeed: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package cephfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"meta/proto"
+	"util"
+)
+
+const (
+	MOUNTS_DIR = "cephfs"
+	PURGE_DIR  = ".purge"
+)
+
+var (
+	// overridden by tests
+	execute   = util.Execute
+	isMounted = util.IsMounted
+)
+
+func (d *Driver) mountPoint(name string) string {
+	return filepath.Join(d.root, MOUNTS_DIR, name)
+}
+
+func (d *Driver) mountOptions() string {
+	opts := []string{}
+	if d.id != "" {
+		opts = append(opts, "name="+d.id)
+	}
+	if d.secretFile != "" {
+		opts = append(opts, "secretfile="+d.secretFile)
+	}
+	return strings.Join(opts, ",")
+}
+
+// mount mounts dir of the filesystem served by the monitor of dev with the
+// kernel client.
+func (d *Driver) mount(dev *metaproto.Device, dir string, mountPoint string) error {
+	if isMounted(mountPoint) {
+		return nil
+	}
+	if err := util.MkdirIfNotExists(mountPoint); err != nil {
+		return err
+	}
+
+	host, port := monitorAddr(dev)
+	args := []string{"-t", "ceph", host + ":" + strconv.Itoa(port) + ":" + dir, mountPoint}
+	if opts := d.mountOptions(); opts != "" {
+		args = append(args, "-o", opts)
+	}
+	if _, err := execute("mount", args); err != nil {
+		os.Remove(mountPoint)
+		return err
+	}
+	return nil
+}
+
+func (d *Driver) unmount(mountPoint string) error {
+	if isMounted(mountPoint) {
+		if _, err := execute("umount", []string{mountPoint}); err != nil {
+			return err
+		}
+	}
+	os.Remove(mountPoint)
+	return nil
+}
+
+// purge empties the directory of a volume, libcephfs only removes empty
+// directories.
+func (d *Driver) purge(dev *metaproto.Device, vl *metaproto.Volume) error {
+	mountPoint := d.mountPoint(filepath.Join(PURGE_DIR, string(vl.Id)))
+	if err := d.mount(dev, volumeDir(dev, vl), mountPoint); err != nil {
+		return err
+	}
+	defer d.unmount(mountPoint)
+
+	entries, err := ioutil.ReadDir(mountPoint)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(mountPoint, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package driver
+
+import (
+	"fmt"
+
+	"meta/proto"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// VolumeDriver is implemented by every storage backend that turns a
+// scheduled volume record into real storage and exposes it to the host.
+// devs always follows the order of vl.Devices.
+type VolumeDriver interface {
+	Name() string
+	CreateVolume(vl *metaproto.Volume, devs []*metaproto.Device) error
+	DeleteVolume(vl *metaproto.Volume, devs []*metaproto.Device) error
+	AttachVolume(vl *metaproto.Volume, devs []*metaproto.Device, mode string) (string, error)
+	DetachVolume(vl *metaproto.Volume, devs []*metaproto.Device) error
+}
+
+// SnapshotDriver is implemented by drivers able to take point-in-time
+// copies of a volume.
+type SnapshotDriver interface {
+	CreateSnapshot(vl *metaproto.Volume, devs []*metaproto.Device, snapshotid string) error
+	DeleteSnapshot(vl *metaproto.Volume, devs []*metaproto.Device, snapshotid string) error
+}
+
+// ExpandDriver is implemented by drivers able to grow a volume in place,
+// capacity is the new size in MB.
+type ExpandDriver interface {
+	ExpandVolume(vl *metaproto.Volume, devs []*metaproto.Device, capacity int) error
+}
+
+// FenceDriver is implemented by drivers that lock volumes to the host
+// using them, FenceVolume takes the volume away from a dead host.
+type FenceDriver interface {
+	FenceVolume(vl *metaproto.Volume, devs []*metaproto.Device, host string) error
+}
+
+// BackupDriver is implemented by drivers able to export a snapshot to a
+// file, whole or as the changes since an older snapshot, and to apply such
+// a file to a volume. Applying recreates the exported snapshot on the
+// volume, so the changes of the next export can be applied on top.
+type BackupDriver interface {
+	ExportSnapshot(vl *metaproto.Volume, devs []*metaproto.Device, snapshotid string, fromSnapshotid string, file string) error
+	ImportSnapshot(vl *metaproto.Volume, devs []*metaproto.Device, file string) error
+}
+
+// CopyDriver is implemented by drivers able to copy the data of a volume
+// themselves, to the devices of another record of it. to is created
+// beforehand, progress is told the bytes copied and the bytes to copy.
+// Drivers without it are copied block by block through attachments.
+type CopyDriver interface {
+	CopyVolume(vl *metaproto.Volume, devs []*metaproto.Device, to *metaproto.Volume, toDevs []*metaproto.Device, progress func(done int64, total int64)) error
+}
+
+type InitFunc func(root string, opts map[string]string) (VolumeDriver, error)
+
+// HOST_OPT is set by the daemon to the address its owner records name this
+// host by, drivers locking volumes to a host use it so fencing finds them.
+const HOST_OPT = "host"
+
+var (
+	initializers map[string]InitFunc
+
+	log = logrus.WithFields(logrus.Fields{"pkg": "driver"})
+)
+
+func init() {
+	initializers = make(map[string]InitFunc)
+}
+
+// Register makes a backend driver available by name, normally called from
+// the init() of the driver package.
+func Register(name string, initFunc InitFunc) error {
+	if _, exists := initializers[name]; exists {
+		return fmt.Errorf("Driver %s has already been registered", name)
+	}
+	initializers[name] = initFunc
+	return nil
+}
+
+func GetDriver(name, root string, opts map[string]string) (VolumeDriver, error) {
+	if _, exists := initializers[name]; !exists {
+		return nil, fmt.Errorf("Driver %v is not supported!", name)
+	}
+	log.Debugf("Initializing driver %s", name)
+	return initializers[name](root, opts)
+}
+
+func ListDrivers() []string {
+	names := []string{}
+	for name := range initializers {
+		names = append(names, name)
+	}
+	return names
+}
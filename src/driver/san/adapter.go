@@ -0,0 +1,43 @@
+package san
+
+import (
+	"fmt"
+
+	"meta/proto"
+)
+
+// Lun is the address of a volume exported by a SAN array.
+type Lun struct {
+	Target string
+	Lun    int
+}
+
+// ArrayAdapter hides the management interface of one storage array model.
+// Every SAN device registered in metadata is one array (or one array port)
+// that volumes get carved out of.
+type ArrayAdapter interface {
+	CreateLun(dev *metaproto.Device, name string, size int) (*Lun, error)
+	DeleteLun(dev *metaproto.Device, lun *Lun) error
+}
+
+type AdapterInitFunc func(opts map[string]string) (ArrayAdapter, error)
+
+var (
+	adapters = map[string]AdapterInitFunc{}
+)
+
+func RegisterAdapter(name string, initFunc AdapterInitFunc) error {
+	if _, exists := adapters[name]; exists {
+		return fmt.Errorf("SAN adapter %s has already been registered", name)
+	}
+	adapters[name] = initFunc
+	return nil
+}
+
+func getAdapter(name string, opts map[string]string) (ArrayAdapter, error) {
+	initFunc, exists := adapters[name]
+	if !exists {
+		return nil, fmt.Errorf("SAN adapter %v is not supported", name)
+	}
+	return initFunc(opts)
+}
@@ -0,0 +1,83 @@
+package san
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"util"
+)
+
+const (
+	ISCSIADM = "iscsiadm"
+
+	DEFAULT_ISCSI_PORT = 3260
+)
+
+var (
+	// overridden by tests
+	execute           = util.Execute
+	diskByPath        = "/dev/disk/by-path"
+	sysBlock          = "/sys/block"
+	deviceWaitTimeout = 30 * time.Second
+)
+
+func iscsiPortal(ip string, port int) string {
+	if port == 0 {
+		port = DEFAULT_ISCSI_PORT
+	}
+	return fmt.Sprintf("%s:%d", ip, port)
+}
+
+func iscsiDiscovery(portal string) error {
+	_, err := execute(ISCSIADM, []string{"-m", "discovery", "-t", "sendtargets", "-p", portal})
+	return err
+}
+
+func iscsiLogin(target, portal, iface string) error {
+	_, err := execute(ISCSIADM, []string{"-m", "node", "-T", target, "-p", portal, "-I", iface, "--login"})
+	if err != nil && strings.Contains(err.Error(), "already present") {
+		return nil
+	}
+	return err
+}
+
+func iscsiLogout(target, portal string) error {
+	_, err := execute(ISCSIADM, []string{"-m", "node", "-T", target, "-p", portal, "--logout"})
+	return err
+}
+
+func lunDevicePath(portal, target string, lun int) string {
+	return filepath.Join(diskByPath, fmt.Sprintf("ip-%s-iscsi-%s-lun-%d", portal, target, lun))
+}
+
+func targetLunPaths(portal, target string) ([]string, error) {
+	return filepath.Glob(filepath.Join(diskByPath, fmt.Sprintf("ip-%s-iscsi-%s-lun-*", portal, target)))
+}
+
+func waitForDevice(path string) error {
+	deadline := time.Now().Add(deviceWaitTimeout)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timeout waiting for device %v", path)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// deleteLunDevice removes a single SCSI disk without tearing down the
+// session, used while other LUNs of the same target are still attached.
+func deleteLunDevice(path string) error {
+	dev, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	deleteFile := filepath.Join(sysBlock, filepath.Base(dev), "device", "delete")
+	return ioutil.WriteFile(deleteFile, []byte("1"), 0200)
+}
@@ -0,0 +1,206 @@
+package san
+
+import (
+	"fmt"
+
+	"driver"
+	"meta"
+	"meta/proto"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	SAN_ADAPTER = "san.adapter"
+	SAN_IFACE   = "san.iface"
+
+	DEFAULT_IFACE = "default"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "san"})
+)
+
+type Driver struct {
+	root    string
+	iface   string
+	adapter ArrayAdapter
+}
+
+func init() {
+	driver.Register(metadata.SAN, Init)
+}
+
+func Init(root string, opts map[string]string) (driver.VolumeDriver, error) {
+	// the adapter is never guessed, the simulator would fake the luns of a
+	// daemon missing its option
+	adapterName := opts[SAN_ADAPTER]
+	if adapterName == "" {
+		return nil, fmt.Errorf("SAN driver needs %s to name the array adapter", SAN_ADAPTER)
+	}
+	adapter, err := getAdapter(adapterName, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	iface := opts[SAN_IFACE]
+	if iface == "" {
+		iface = DEFAULT_IFACE
+	}
+
+	log.Debugf("SAN driver initialized with adapter %s, iface %s", adapterName, iface)
+
+	return &Driver{
+		root:    root,
+		iface:   iface,
+		adapter: adapter,
+	}, nil
+}
+
+func (d *Driver) Name() string {
+	return metadata.SAN
+}
+
+func devicePortal(dev *metaproto.Device) string {
+	port, err := metadata.DevicePort(dev)
+	if err != nil {
+		port = 0
+	}
+	return iscsiPortal(metadata.GetHostIpFromKey(string(dev.Host)), port)
+}
+
+func volumeLun(vad *metaproto.Volume_AttachDevice) (*Lun, error) {
+	if len(vad.Target) == 0 {
+		return nil, fmt.Errorf("device %s has no lun recorded", vad.Deviceid)
+	}
+	lun, err := metadata.BytesToInteger(vad.Lun)
+	if err != nil {
+		return nil, err
+	}
+	return &Lun{Target: string(vad.Target), Lun: lun}, nil
+}
+
+func checkDevices(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	if len(vl.Devices) != len(devs) {
+		return fmt.Errorf("volume %s has %d devices, %d given", vl.Id, len(vl.Devices), len(devs))
+	}
+	return nil
+}
+
+// CreateVolume allocates one LUN per scheduled device and records its
+// target/lun in the volume so that any host can log in to it later.
+func (d *Driver) CreateVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	if err := checkDevices(vl, devs); err != nil {
+		return err
+	}
+	size, err := metadata.VolumeCapacity(vl)
+	if err != nil {
+		return fmt.Errorf("invalid volume capacity %s", vl.Capacity)
+	}
+
+	for i := 0; i < len(devs); i++ {
+		lun, err := d.adapter.CreateLun(devs[i], string(vl.Id), size)
+		if err != nil {
+			log.Errorf("[CreateVolume] create lun for volume %s on device %s error: %s", vl.Id, devs[i].Id, err.Error())
+			for j := 0; j < i; j++ {
+				l, _ := volumeLun(vl.Devices[j])
+				if l != nil {
+					d.adapter.DeleteLun(devs[j], l)
+				}
+				vl.Devices[j].Target = nil
+				vl.Devices[j].Lun = nil
+			}
+			return err
+		}
+		vl.Devices[i].Target = []byte(lun.Target)
+		vl.Devices[i].Lun = metadata.IntegerToBytes(lun.Lun)
+	}
+
+	return nil
+}
+
+func (d *Driver) DeleteVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	if err := checkDevices(vl, devs); err != nil {
+		return err
+	}
+
+	for i := 0; i < len(devs); i++ {
+		lun, err := volumeLun(vl.Devices[i])
+		if err != nil {
+			return err
+		}
+		if err := d.adapter.DeleteLun(devs[i], lun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AttachVolume logs in to the target of the first device of the volume and
+// returns the block device of its LUN.
+func (d *Driver) AttachVolume(vl *metaproto.Volume, devs []*metaproto.Device, mode string) (string, error) {
+	if err := checkDevices(vl, devs); err != nil {
+		return "", err
+	}
+	if len(devs) == 0 {
+		return "", fmt.Errorf("volume %s has no device", vl.Id)
+	}
+
+	lun, err := volumeLun(vl.Devices[0])
+	if err != nil {
+		return "", err
+	}
+
+	portal := devicePortal(devs[0])
+	if err := iscsiDiscovery(portal); err != nil {
+		return "", err
+	}
+	if err := iscsiLogin(lun.Target, portal, d.iface); err != nil {
+		return "", err
+	}
+
+	path := lunDevicePath(portal, lun.Target, lun.Lun)
+	if err := waitForDevice(path); err != nil {
+		return "", err
+	}
+
+	if mode == metadata.ROVolume {
+		if _, err := execute("blockdev", []string{"--setro", path}); err != nil {
+			return "", err
+		}
+	}
+
+	log.Debugf("[AttachVolume] volume %s attached at %s", vl.Id, path)
+	return path, nil
+}
+
+// DetachVolume logs out of the target unless other LUNs of the same target
+// are still in use on this host, in which case only the disk is removed.
+func (d *Driver) DetachVolume(vl *metaproto.Volume, devs []*metaproto.Device) error {
+	if err := checkDevices(vl, devs); err != nil {
+		return err
+	}
+	if len(devs) == 0 {
+		return fmt.Errorf("volume %s has no device", vl.Id)
+	}
+
+	lun, err := volumeLun(vl.Devices[0])
+	if err != nil {
+		return err
+	}
+
+	portal := devicePortal(devs[0])
+	path := lunDevicePath(portal, lun.Target, lun.Lun)
+
+	paths, err := targetLunPaths(portal, lun.Target)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if p != path {
+			return deleteLunDevice(path)
+		}
+	}
+
+	return iscsiLogout(lun.Target, portal)
+}
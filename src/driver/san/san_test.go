@@ -0,0 +1,187 @@
+package san
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"meta"
+	"meta/proto"
+)
+
+type fakeIscsiadm struct {
+	commands []string
+	luns     map[string][]int // target : luns exported to this host
+}
+
+func (f *fakeIscsiadm) execute(binary string, args []string) (string, error) {
+	cmd := binary + " " + strings.Join(args, " ")
+	f.commands = append(f.commands, cmd)
+
+	if binary != ISCSIADM || args[len(args)-1] != "--login" {
+		return "", nil
+	}
+	target, portal := args[3], args[5]
+	for _, lun := range f.luns[target] {
+		path := lunDevicePath(portal, target, lun)
+		if err := ioutil.WriteFile(path, []byte{}, 0600); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+func setupFake(t *testing.T) (*fakeIscsiadm, func()) {
+	dir, err := ioutil.TempDir("", "san-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeIscsiadm{luns: make(map[string][]int)}
+	oldExecute, oldDiskByPath, oldTimeout := execute, diskByPath, deviceWaitTimeout
+	execute = fake.execute
+	diskByPath = dir
+	deviceWaitTimeout = time.Second
+
+	return fake, func() {
+		execute, diskByPath, deviceWaitTimeout = oldExecute, oldDiskByPath, oldTimeout
+		os.RemoveAll(dir)
+	}
+}
+
+func newTestVolume(id string, capacity string, devs []*metaproto.Device) *metaproto.Volume {
+	vl := &metaproto.Volume{Id: []byte(id), Capacity: []byte(capacity)}
+	for _, dev := range devs {
+		vl.Devices = append(vl.Devices, &metaproto.Volume_AttachDevice{Deviceid: dev.Id})
+	}
+	return vl
+}
+
+func TestInitAdapter(t *testing.T) {
+	if _, err := Init("/tmp", map[string]string{}); err == nil {
+		t.Fatal("driver without adapter should not be initialized")
+	}
+	if _, err := Init("/tmp", map[string]string{SAN_ADAPTER: "bogus"}); err == nil {
+		t.Fatal("driver with unknown adapter should not be initialized")
+	}
+}
+
+func TestCreateAttachDetachDelete(t *testing.T) {
+	fake, cleanup := setupFake(t)
+	defer cleanup()
+
+	d, err := Init("/tmp", map[string]string{SAN_ADAPTER: SIMULATOR_ADAPTER})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dev := &metaproto.Device{
+		Id:       []byte("array01"),
+		Host:     []byte("192.168.1.10"),
+		Port:     metadata.IntegerToBytes(3260),
+		Identify: []byte("iqn.2016-04.com.example:array01"),
+	}
+	devs := []*metaproto.Device{dev}
+
+	vl := newTestVolume("vol01", "1024", devs)
+	if err := d.CreateVolume(vl, devs); err != nil {
+		t.Fatal(err)
+	}
+	if string(vl.Devices[0].Target) != "iqn.2016-04.com.example:array01" || string(vl.Devices[0].Lun) != "0" {
+		t.Fatalf("unexpected lun recorded: %s/%s", vl.Devices[0].Target, vl.Devices[0].Lun)
+	}
+
+	vl2 := newTestVolume("vol02", "1024", devs)
+	if err := d.CreateVolume(vl2, devs); err != nil {
+		t.Fatal(err)
+	}
+	if string(vl2.Devices[0].Lun) != "1" {
+		t.Fatalf("second volume should get lun 1, got %s", vl2.Devices[0].Lun)
+	}
+
+	fake.luns["iqn.2016-04.com.example:array01"] = []int{0}
+	path, err := d.AttachVolume(vl, devs, metadata.RWVolume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := filepath.Join(diskByPath, "ip-192.168.1.10:3260-iscsi-iqn.2016-04.com.example:array01-lun-0")
+	if path != expected {
+		t.Fatalf("attach returned %s, expected %s", path, expected)
+	}
+	if len(fake.commands) != 2 ||
+		fake.commands[0] != "iscsiadm -m discovery -t sendtargets -p 192.168.1.10:3260" ||
+		fake.commands[1] != "iscsiadm -m node -T iqn.2016-04.com.example:array01 -p 192.168.1.10:3260 -I default --login" {
+		t.Fatalf("unexpected commands %v", fake.commands)
+	}
+
+	if err := d.DetachVolume(vl, devs); err != nil {
+		t.Fatal(err)
+	}
+	last := fake.commands[len(fake.commands)-1]
+	if last != "iscsiadm -m node -T iqn.2016-04.com.example:array01 -p 192.168.1.10:3260 --logout" {
+		t.Fatalf("detach of the last lun should log out, got %s", last)
+	}
+
+	if err := d.DeleteVolume(vl, devs); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteVolume(vl, devs); err == nil {
+		t.Fatal("deleting a volume twice should fail")
+	}
+}
+
+func TestAttachReadOnly(t *testing.T) {
+	fake, cleanup := setupFake(t)
+	defer cleanup()
+
+	d, err := Init("/tmp", map[string]string{SAN_ADAPTER: SIMULATOR_ADAPTER})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dev := &metaproto.Device{Id: []byte("array02"), Host: []byte("10.0.0.2")}
+	devs := []*metaproto.Device{dev}
+	vl := newTestVolume("vol03", "512", devs)
+	if err := d.CreateVolume(vl, devs); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.luns[string(vl.Devices[0].Target)] = []int{0}
+	path, err := d.AttachVolume(vl, devs, metadata.ROVolume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.commands[len(fake.commands)-1] != "blockdev --setro "+path {
+		t.Fatalf("read only attach should set the device read only, got %v", fake.commands)
+	}
+}
+
+func TestCreateVolumeRollback(t *testing.T) {
+	d, err := Init("/tmp", map[string]string{SAN_ADAPTER: SIMULATOR_ADAPTER})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devs := []*metaproto.Device{
+		{Id: []byte("array03"), Host: []byte("10.0.0.3")},
+		{Id: []byte("array04"), Host: []byte("10.0.0.4")},
+	}
+	// occupy the name on the second array so that its lun creation fails
+	if err := d.CreateVolume(newTestVolume("vol04", "100", devs[1:]), devs[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	vl := newTestVolume("vol04", "100", devs)
+	if err := d.CreateVolume(vl, devs); err == nil {
+		t.Fatal("create should fail when one array refuses the lun")
+	}
+	if len(vl.Devices[0].Target) != 0 {
+		t.Fatal("lun of the first array should be rolled back")
+	}
+	if err := d.CreateVolume(newTestVolume("vol04", "100", devs[:1]), devs[:1]); err != nil {
+		t.Fatalf("rolled back lun should be reusable: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package san
+
+import (
+	"fmt"
+	"sync"
+
+	"meta/proto"
+)
+
+const (
+	SIMULATOR_ADAPTER = "simulator"
+
+	SIMULATOR_IQN_PREFIX = "iqn.2016-04.com.cloudsoar.simulator"
+)
+
+// simulatorAdapter keeps LUNs in memory. It is used by tests and for
+// trying the SAN workflow without an array.
+type simulatorAdapter struct {
+	mutex sync.Mutex
+	luns  map[string]map[int]string // target : lun : volume name
+}
+
+func init() {
+	RegisterAdapter(SIMULATOR_ADAPTER, newSimulatorAdapter)
+}
+
+func newSimulatorAdapter(opts map[string]string) (ArrayAdapter, error) {
+	return &simulatorAdapter{
+		luns: make(map[string]map[int]string),
+	}, nil
+}
+
+func simulatorTarget(dev *metaproto.Device) string {
+	if len(dev.Identify) != 0 {
+		return string(dev.Identify)
+	}
+	return fmt.Sprintf("%s:%s", SIMULATOR_IQN_PREFIX, dev.Id)
+}
+
+func (sa *simulatorAdapter) CreateLun(dev *metaproto.Device, name string, size int) (*Lun, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid lun size %d", size)
+	}
+
+	sa.mutex.Lock()
+	defer sa.mutex.Unlock()
+
+	target := simulatorTarget(dev)
+	luns, ok := sa.luns[target]
+	if !ok {
+		luns = make(map[int]string)
+		sa.luns[target] = luns
+	}
+
+	for _, v := range luns {
+		if v == name {
+			return nil, fmt.Errorf("lun %s already exists on %s", name, target)
+		}
+	}
+
+	lun := 0
+	for {
+		if _, used := luns[lun]; !used {
+			break
+		}
+		lun++
+	}
+	luns[lun] = name
+
+	return &Lun{Target: target, Lun: lun}, nil
+}
+
+func (sa *simulatorAdapter) DeleteLun(dev *metaproto.Device, lun *Lun) error {
+	sa.mutex.Lock()
+	defer sa.mutex.Unlock()
+
+	luns, ok := sa.luns[lun.Target]
+	if !ok {
+		return fmt.Errorf("target %s not found", lun.Target)
+	}
+	if _, ok := luns[lun.Lun]; !ok {
+		return fmt.Errorf("lun %d not found on %s", lun.Lun, lun.Target)
+	}
+
+	delete(luns, lun.Lun)
+	if len(luns) == 0 {
+		delete(sa.luns, lun.Target)
+	}
+	return nil
+}
@@ -0,0 +1,412 @@
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"meta/proto"
+	"store"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// An archive is a dump of the records of the store, made to rebuild it
+// after a disaster. Records are kept with their key, decoded to JSON with
+// their bytes fields as strings so the archive can be read and edited by
+// hand. Events and the leader lease are left out, they belong to the
+// running cluster. The checksum covers the records, so an archive edited
+// by hand fails to import until it is updated.
+
+const (
+	ARCHIVE_VERSION = 1
+
+	ARCHIVE_ADD    = "add"
+	ARCHIVE_CHANGE = "change"
+	ARCHIVE_DELETE = "delete"
+)
+
+type Archive struct {
+	Version  int
+	Created  string
+	Checksum string
+	Records  []ArchiveRecord
+}
+
+type ArchiveRecord struct {
+	Key   string
+	Kind  string
+	Value map[string]interface{}
+}
+
+// ArchiveChange is what an import does to a key of the store.
+type ArchiveChange struct {
+	Key    string
+	Action string
+}
+
+// archiveKinds makes the empty record of each kind archived.
+var archiveKinds = map[string]func() proto.Message{
+	"host":      func() proto.Message { return &metaproto.Host{} },
+	"device":    func() proto.Message { return &metaproto.Device{} },
+	"volume":    func() proto.Message { return &metaproto.Volume{} },
+	"container": func() proto.Message { return &metaproto.Container{} },
+	"snapshot":  func() proto.Message { return &metaproto.Snapshot{} },
+	"backup":    func() proto.Message { return &metaproto.Backup{} },
+	"migration": func() proto.Message { return &metaproto.Migration{} },
+}
+
+// archiveDirs maps the directories holding records to their kind.
+func archiveDirs() map[string]string {
+	dirs := map[string]string{
+		filepath.Clean(HOSTROOT):      "host",
+		filepath.Clean(CONTAINERROOT): "container",
+		filepath.Clean(BACKUPROOT):    "backup",
+	}
+	for _, backend := range ListBackends() {
+		dirs[GenerateInuseDeviceDriverKey(backend)] = "device"
+		dirs[GenerateFreeDeviceDriverKey(backend)] = "device"
+		dirs[GenerateVolumeDriverKey(backend)] = "volume"
+		dirs[GenerateSnapshotDriverKey(backend)] = "snapshot"
+		dirs[GenerateMigrationDriverKey(backend)] = "migration"
+	}
+	return dirs
+}
+
+// listArchived returns the keys of the archived records, sorted.
+func listArchived() ([]string, error) {
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"recursive": "false",
+		"sorted":    "false",
+		"quorum":    "false",
+	}
+
+	keys := []string{}
+	for dir := range archiveDirs() {
+		dirKeys, err := driver.List(dir, opts)
+		if err != nil {
+			if ValidKeyNotFoundError(err) == true {
+				continue
+			}
+			return nil, NewError(EcodeBackendError, err.Error())
+		}
+		for _, key := range dirKeys {
+			keys = append(keys, filepath.Clean(key))
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// readArchived returns the raw records of the store by key.
+func readArchived() (map[string]string, error) {
+	keys, err := listArchived()
+	if err != nil {
+		return nil, err
+	}
+
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"sorted": "false",
+		"qurum":  "false",
+	}
+	records := make(map[string]string)
+	for _, key := range keys {
+		data, err := driver.Get(key, opts)
+		if err != nil {
+			if ValidKeyNotFoundError(err) == true {
+				continue
+			}
+			return nil, NewError(EcodeBackendError, err.Error())
+		}
+		records[key] = data
+	}
+	return records, nil
+}
+
+// protoTag returns an option of the protobuf tag of the field, such as
+// its name or enum.
+func protoTag(f reflect.StructField, option string) string {
+	for _, part := range strings.Split(f.Tag.Get("protobuf"), ",") {
+		if strings.HasPrefix(part, option+"=") {
+			return strings.TrimPrefix(part, option+"=")
+		}
+	}
+	return ""
+}
+
+// recordToMap turns a record into a map of its field names, every field
+// being bytes, repeated bytes, repeated messages or, since schema 2, an
+// integer or enum, enums given by name.
+func recordToMap(v reflect.Value) map[string]interface{} {
+	v = v.Elem()
+	m := make(map[string]interface{})
+	for i := 0; i < v.NumField(); i++ {
+		name := protoTag(v.Type().Field(i), "name")
+		f := v.Field(i)
+		if name == "" || f.IsNil() {
+			continue
+		}
+		switch value := f.Interface().(type) {
+		case []byte:
+			m[name] = string(value)
+		case [][]byte:
+			list := []string{}
+			for _, b := range value {
+				list = append(list, string(b))
+			}
+			m[name] = list
+		case fmt.Stringer:
+			m[name] = value.String()
+		case *int32, *int64:
+			m[name] = f.Elem().Int()
+		default:
+			list := []map[string]interface{}{}
+			for j := 0; j < f.Len(); j++ {
+				list = append(list, recordToMap(f.Index(j)))
+			}
+			m[name] = list
+		}
+	}
+	return m
+}
+
+// recordFromMap fills a record from a map made by recordToMap and decoded
+// from JSON, unknown fields are refused.
+func recordFromMap(m map[string]interface{}, v reflect.Value) error {
+	v = v.Elem()
+	known := 0
+	for i := 0; i < v.NumField(); i++ {
+		name := protoTag(v.Type().Field(i), "name")
+		value, ok := m[name]
+		if name == "" || !ok {
+			continue
+		}
+		known++
+		f := v.Field(i)
+		switch f.Interface().(type) {
+		case []byte:
+			s, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("field %s is no string", name)
+			}
+			f.SetBytes([]byte(s))
+		case [][]byte:
+			list, ok := value.([]interface{})
+			if !ok {
+				return fmt.Errorf("field %s is no list", name)
+			}
+			values := [][]byte{}
+			for _, item := range list {
+				s, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("field %s holds no string", name)
+				}
+				values = append(values, []byte(s))
+			}
+			f.Set(reflect.ValueOf(values))
+		case fmt.Stringer:
+			s, ok := value.(string)
+			enum, known := proto.EnumValueMap(protoTag(v.Type().Field(i), "enum"))[s]
+			if !ok || !known {
+				return fmt.Errorf("field %s is no %s", name, f.Type().Elem().Name())
+			}
+			f.Set(reflect.New(f.Type().Elem()))
+			f.Elem().SetInt(int64(enum))
+		case *int32, *int64:
+			// float64 once through JSON
+			var n int64
+			switch number := value.(type) {
+			case int64:
+				n = number
+			case float64:
+				n = int64(number)
+				if number != float64(n) {
+					return fmt.Errorf("field %s is no integer", name)
+				}
+			default:
+				return fmt.Errorf("field %s is no integer", name)
+			}
+			f.Set(reflect.New(f.Type().Elem()))
+			f.Elem().SetInt(n)
+		default:
+			list, ok := value.([]interface{})
+			if !ok {
+				return fmt.Errorf("field %s is no list", name)
+			}
+			values := reflect.MakeSlice(f.Type(), 0, len(list))
+			for _, item := range list {
+				im, ok := item.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("field %s holds no record", name)
+				}
+				elem := reflect.New(f.Type().Elem().Elem())
+				if err := recordFromMap(im, elem); err != nil {
+					return fmt.Errorf("field %s: %s", name, err.Error())
+				}
+				values = reflect.Append(values, elem)
+			}
+			f.Set(values)
+		}
+	}
+	if known != len(m) {
+		return fmt.Errorf("unknown fields")
+	}
+	return nil
+}
+
+// sum is the checksum of the records, over their JSON encoding which
+// sorts the fields.
+func (a *Archive) sum() (string, error) {
+	data, err := json.Marshal(a.Records)
+	if err != nil {
+		return "", NewError(EcodeRequestEncodeError, err.Error())
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ExportArchive dumps the records of the store. The caller holds the
+// metadata lock.
+func ExportArchive() (*Archive, error) {
+	records, err := readArchived()
+	if err != nil {
+		return nil, err
+	}
+	dirs := archiveDirs()
+
+	a := &Archive{
+		Version: ARCHIVE_VERSION,
+		Created: strconv.FormatInt(time.Now().Unix(), 10),
+		Records: []ArchiveRecord{},
+	}
+	for key, data := range records {
+		kind := dirs[filepath.Dir(key)]
+		msg := archiveKinds[kind]()
+		if err := proto.Unmarshal([]byte(data), msg); err != nil {
+			return nil, NewError(EcodeRequestDecodeError, key+": "+err.Error())
+		}
+		a.Records = append(a.Records, ArchiveRecord{Key: key, Kind: kind, Value: recordToMap(reflect.ValueOf(msg))})
+	}
+	sort.Slice(a.Records, func(i, j int) bool { return a.Records[i].Key < a.Records[j].Key })
+
+	if a.Checksum, err = a.sum(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// decode checks the archive and returns its records encoded by key.
+func (a *Archive) decode() (map[string]proto.Message, error) {
+	if a.Version <= 0 || a.Version > ARCHIVE_VERSION {
+		return nil, NewError(EcodeArchiveVersion, fmt.Sprintf("archive version %d not supported", a.Version))
+	}
+	sum, err := a.sum()
+	if err != nil {
+		return nil, err
+	}
+	if sum != a.Checksum {
+		return nil, NewError(EcodeArchiveChecksum, "archive checksum mismatch")
+	}
+
+	dirs := archiveDirs()
+	records := make(map[string]proto.Message)
+	for _, r := range a.Records {
+		key := filepath.Clean(r.Key)
+		if dirs[filepath.Dir(key)] != r.Kind || archiveKinds[r.Kind] == nil {
+			return nil, NewError(EcodeArchiveInvalid, fmt.Sprintf("%s is no %s key", r.Key, r.Kind))
+		}
+		if _, ok := records[key]; ok {
+			return nil, NewError(EcodeArchiveInvalid, r.Key+" archived twice")
+		}
+		msg := archiveKinds[r.Kind]()
+		if err := recordFromMap(r.Value, reflect.ValueOf(msg)); err != nil {
+			return nil, NewError(EcodeArchiveInvalid, r.Key+": "+err.Error())
+		}
+		upgradeRecord(msg)
+		records[key] = msg
+	}
+	return records, nil
+}
+
+// ImportArchive makes the store hold the records of the archive, adding,
+// changing and deleting records, and returns the changes. Nothing is
+// written on a dry run. The caller holds the metadata lock.
+func ImportArchive(a *Archive, dryRun bool) ([]ArchiveChange, error) {
+	records, err := a.decode()
+	if err != nil {
+		return nil, err
+	}
+	current, err := readArchived()
+	if err != nil {
+		return nil, err
+	}
+	dirs := archiveDirs()
+
+	changes := []ArchiveChange{}
+	values := make(map[string]string)
+	for key, msg := range records {
+		data, err := proto.Marshal(msg)
+		if err != nil {
+			return nil, NewError(EcodeRequestEncodeError, err.Error())
+		}
+		values[key] = string(data)
+
+		old, ok := current[key]
+		if !ok {
+			changes = append(changes, ArchiveChange{Key: key, Action: ARCHIVE_ADD})
+			continue
+		}
+		oldMsg := archiveKinds[dirs[filepath.Dir(key)]]()
+		if err := proto.Unmarshal([]byte(old), oldMsg); err != nil || !proto.Equal(oldMsg, msg) {
+			changes = append(changes, ArchiveChange{Key: key, Action: ARCHIVE_CHANGE})
+		}
+	}
+	for key := range current {
+		if _, ok := records[key]; !ok {
+			changes = append(changes, ArchiveChange{Key: key, Action: ARCHIVE_DELETE})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+
+	if dryRun {
+		return changes, nil
+	}
+
+	driver := store.GetDriver()
+	setOpts := map[string]string{
+		"ttl":       "0",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	removeOpts := map[string]string{
+		"recursive": "false",
+		"dir":       "false",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	for _, c := range changes {
+		if c.Action == ARCHIVE_DELETE {
+			err = driver.Remove(c.Key, removeOpts)
+			if err != nil && ValidKeyNotFoundError(err) == true {
+				err = nil
+			}
+		} else {
+			err = driver.Set(c.Key, values[c.Key], setOpts)
+		}
+		if err != nil {
+			log.Errorf("[ImportArchive] %s %s error: %s", c.Action, c.Key, err.Error())
+			return changes, NewError(EcodeBackendError, err.Error())
+		}
+	}
+	log.Infof("[ImportArchive] archive of %s imported, %d changes", a.Created, len(changes))
+	return changes, nil
+}
@@ -0,0 +1,135 @@
+package metadata
+
+import (
+	"meta/proto"
+	"path/filepath"
+	"store"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// The backup catalog records every backup with the store keeping its data,
+// so backups can be found and restored by any daemon, even one that lost
+// its root.
+
+func getAndDecodeBackup(backupid string) (*metaproto.Backup, error) {
+	driver := store.GetDriver()
+
+	backupkey := GenerateBackupKey(backupid)
+	opts := map[string]string{
+		"sorted": "false",
+		"qurum":  "false",
+	}
+	data, err := driver.Get(backupkey, opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return nil, NewError(EcodeBackupNotFound, "Backup not found.")
+		}
+		log.Errorf("[getAndDecodeBackup] driver.Get error: %s, key: %s", err.Error(), backupkey)
+		return nil, NewError(EcodeBackendError, err.Error())
+	}
+
+	bk := &metaproto.Backup{}
+	err = proto.Unmarshal([]byte(data), bk)
+	if err != nil {
+		log.Errorf("[getAndDecodeBackup] proto.Unmarshal error: %s, key: %s", err.Error(), backupkey)
+		return nil, NewError(EcodeRequestDecodeError, err.Error())
+	}
+
+	return bk, nil
+}
+
+func setAndEncodeBackup(bk *metaproto.Backup) error {
+	upgradeRecord(bk)
+	data, err := proto.Marshal(bk)
+	if err != nil {
+		log.Errorf("[setAndEncodeBackup] proto.marshal error: %s", err.Error())
+		return NewError(EcodeRequestEncodeError, err.Error())
+	}
+
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"ttl":       "0",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	err = driver.Set(GenerateBackupKey(string(bk.Id)), string(data), opts)
+	if err != nil {
+		log.Errorf("[setAndEncodeBackup] driver.Set error: %s", err.Error())
+		return NewError(EcodeBackendError, err.Error())
+	}
+
+	return nil
+}
+
+func AddBackup(bk *metaproto.Backup) error {
+	if bk == nil || validVolumeID(string(bk.Id)) == false {
+		return NewError(EcodeParameterError, "Not Valid Backup Struct.")
+	}
+
+	_, err := getAndDecodeBackup(string(bk.Id))
+	if err == nil {
+		return NewError(EcodeBackupExist, "Backup already exists.")
+	}
+	if err.(*Error).Code != EcodeBackupNotFound {
+		return err
+	}
+
+	return setAndEncodeBackup(bk)
+}
+
+func GetBackup(backupid string) (*metaproto.Backup, error) {
+	if validVolumeID(backupid) == false {
+		return nil, NewError(EcodeParameterError, "Not Valid Backup ID.")
+	}
+
+	return getAndDecodeBackup(backupid)
+}
+
+func ListBackupsName() ([]string, error) {
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"recursive": "false",
+		"sorted":    "false",
+		"quorum":    "false",
+	}
+	backups, err := driver.List(GenerateBackupKey(""), opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return nil, nil
+		}
+		return nil, NewError(EcodeBackendError, err.Error())
+	}
+
+	names := []string{}
+	for i := 0; i < len(backups); i++ {
+		if name := filepath.Base(backups[i]); len(name) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+func DelBackup(backupid string) error {
+	if validVolumeID(backupid) == false {
+		return NewError(EcodeParameterError, "Not Valid Backup ID.")
+	}
+
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"recursive": "false",
+		"dir":       "false",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	err := driver.Remove(GenerateBackupKey(backupid), opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return NewError(EcodeBackupNotFound, "Backup not found.")
+		}
+		return NewError(EcodeBackendError, err.Error())
+	}
+
+	return nil
+}
@@ -22,6 +22,14 @@ const (
 	DEVICEROOT    = ROOT + "/devices/"
 	CONTAINERROOT = ROOT + "/containers/"
 	VOLUMEROOT    = ROOT + "/volumes/"
+	SNAPSHOTROOT  = ROOT + "/snapshots/"
+	BACKUPROOT    = ROOT + "/backups/"
+	EVENTROOT     = ROOT + "/events/"
+	LEADERKEY     = ROOT + "/leader"
+	SCHEMAKEY     = ROOT + "/schema"
+	REQUESTROOT   = ROOT + "/requests/"
+	MIGRATIONROOT = ROOT + "/migrations/"
+	OPERATIONROOT = ROOT + "/operations/"
 
 	INUSE = "/inuse/"
 	FREE  = "/free/"
@@ -33,8 +41,10 @@ const (
 	HOST_OFFLINE = 2
 	HOST_DEGRADE = 3
 	HOST_ERROR   = 4
+	// drained, nothing new is placed on the host
+	HOST_MAINTENANCE = 5
 
-	HOST_MAX = 5
+	HOST_MAX = 6
 )
 
 const (
@@ -43,7 +53,9 @@ const (
 	DEVICE_READY   = 12
 	DEVICE_OFFLINE = 13
 	DEVICE_UNKNOWN = 14
-	DEVICE_MAX     = 15
+	// drained, nothing new is placed on the device
+	DEVICE_MAINTENANCE = 15
+	DEVICE_MAX         = 16
 
 	DEVICE_ID_MIN_LENGTH = 2
 )
@@ -56,27 +68,57 @@ const (
 )
 
 const (
-	VOLUME_ONLINE = 30
-	VOLUME_UNKNOW = 31
-	VOLUME_INUSE  = 32
+	VOLUME_ONLINE    = 30
+	VOLUME_UNKNOWN   = 31
+	VOLUME_INUSE     = 32
+	VOLUME_MIGRATING = 33
+	VOLUME_CREATING  = 34
+	VOLUME_DELETING  = 35
 
 	VOLUME_ID_MIN_LENGTH = 2
 )
 
+const (
+	SNAPSHOT_READY = 40
+)
+
 const (
 	RWVolume = "rw"
 	ROVolume = "ro"
 )
 
 const (
-	SAN  = "SAN"
-	NFS  = "NFS"
-	CEPH = "CEPH"
-	GFS  = "GLUSTERFS"
+	SAN    = "SAN"
+	NFS    = "NFS"
+	CEPH   = "CEPH"
+	CEPHFS = "CEPHFS"
+	GFS    = "GLUSTERFS"
 )
 
+func ListBackends() []string {
+	return []string{SAN, NFS, CEPH, CEPHFS, GFS}
+}
+
+// SharedBackend tells the backends whose volumes are filesystems mounted by
+// every user at once, they take any number of rw containers.
+func SharedBackend(backend string) bool {
+	return backend == CEPHFS
+}
+
+// PoolBackend tells the backends whose devices are pools holding any number
+// of volumes, each volume takes its capacity off the free space of the
+// device instead of the whole device.
+func PoolBackend(backend string) bool {
+	return backend == CEPH
+}
+
 func ValidBackend(backend string) bool {
-	return backend == SAN || backend == NFS || backend == CEPH || backend == GFS
+	for _, b := range ListBackends() {
+		if backend == b {
+			return true
+		}
+	}
+	return false
 }
 
 func ValidDriverName(driverName string) bool {
@@ -161,7 +203,7 @@ func GenerateVolumeKey(volumeid string, driverName string) string {
 
 func ParseVolumekey(volumekey string) (string, string) {
 	volumeid := filepath.Base(volumekey)
-	driverName := filepath.Base(filepath.Dir(filepath.Dir(volumekey)))
+	driverName := filepath.Base(filepath.Dir(volumekey))
 
 	return volumeid, driverName
 }
@@ -175,6 +217,76 @@ func GenerateVolumeDriverKey(driverName string) string {
 	return volumekey
 }
 
+func GenerateSnapshotKey(snapshotid string, driverName string) string {
+	snapshotkey, err := filepath.Abs(SNAPSHOTROOT + driverName + "/" + snapshotid)
+	if err != nil {
+		return ""
+	}
+
+	return snapshotkey
+}
+
+func GenerateSnapshotDriverKey(driverName string) string {
+	snapshotkey, err := filepath.Abs(SNAPSHOTROOT + driverName + "/")
+	if err != nil {
+		return ""
+	}
+
+	return snapshotkey
+}
+
+func GenerateBackupKey(backupid string) string {
+	backupkey, err := filepath.Abs(BACKUPROOT + backupid)
+	if err != nil {
+		return ""
+	}
+
+	return backupkey
+}
+
+func GenerateMigrationKey(volumeid string, driverName string) string {
+	migrationkey, err := filepath.Abs(MIGRATIONROOT + driverName + "/" + volumeid)
+	if err != nil {
+		return ""
+	}
+
+	return migrationkey
+}
+
+func GenerateMigrationDriverKey(driverName string) string {
+	migrationkey, err := filepath.Abs(MIGRATIONROOT + driverName + "/")
+	if err != nil {
+		return ""
+	}
+
+	return migrationkey
+}
+
+func GenerateEventKey(eventid string) string {
+	eventkey, err := filepath.Abs(EVENTROOT + eventid)
+	if err != nil {
+		return ""
+	}
+
+	return eventkey
+}
+
+func GenerateRequestKey(requestid string) string {
+	requestkey, err := filepath.Abs(REQUESTROOT + requestid)
+	if err != nil {
+		return ""
+	}
+	return requestkey
+}
+
+func GenerateOperationKey(operationid string) string {
+	operationkey, err := filepath.Abs(OPERATIONROOT + operationid)
+	if err != nil {
+		return ""
+	}
+	return operationkey
+}
+
 func GetHostIpFromKey(hostkey string) string {
 	return filepath.Base(hostkey)
 }
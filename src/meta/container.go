@@ -1,9 +1,12 @@
 package metadata
 
 import (
+	"fmt"
 	"meta/proto"
 	"path/filepath"
 	"store"
+	"strconv"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 )
@@ -24,7 +27,7 @@ func getAndDecodeContainer(containerid string) (*metaproto.Container, error) {
 	data, err := driver.Get(containerkey, opts)
 	if err != nil {
 		if ValidKeyNotFoundError(err) == true {
-			return nil, nil
+			return nil, NewError(EcodeContainerNotFound, fmt.Sprintf("container %s not found", containerid))
 		}
 		return nil, NewError(EcodeBackendError, err.Error())
 	}
@@ -64,6 +67,7 @@ func setAndEncodeContainer(ct *metaproto.Container) error {
 		return NewError(EcodeParameterError, "Container struct not valid.")
 	}
 
+	upgradeRecord(ct)
 	data, err := proto.Marshal(ct)
 	if err != nil {
 		return NewError(EcodeRequestEncodeError, err.Error())
@@ -159,28 +163,29 @@ func DelContainer(containerid string) error {
 		return NewError(EcodeParameterError, "container id not valid.")
 	}
 
-	containerkey := GenerateContainerKey(containerid)
-
-	/*
-		ct, err := getAndDecodeContainer(containerid)
-		if err != nil {
-			log.Errorf("[AddContainer] DelContainer id: %s error: %s", containerid, err.Error())
-			return err
+	ct, err := getAndDecodeContainer(containerid)
+	if err != nil {
+		if e, ok := err.(*Error); ok && e.Code == EcodeContainerNotFound {
+			return nil
 		}
+		log.Errorf("[DelContainer] DelContainer id: %s error: %s", containerid, err.Error())
+		return err
+	}
 
-		var cvl *metaproto.Container_AttachVolume
-
-
-			for i := 0; i < len(ct.Volumes); i++ {
-				cvl = ct.Volumes[i]
-
-				err = DelVolumeContainer(string(cvl.Volumeid), containerid)
-
-				//TODO record delete fail operation
-
-			}
-	*/
+	// keep the record while a volume still names the container as owner,
+	// the next reconcile retries the volumes left behind
+	failed := 0
+	for _, cvl := range ct.Volumes {
+		if err := delContainerFromVolume(string(cvl.Volumeid), string(cvl.Driver), containerid); err != nil {
+			log.Errorf("[DelContainer] detach volume %s from container %s error: %s", cvl.Volumeid, containerid, err.Error())
+			failed++
+		}
+	}
+	if failed != 0 {
+		return NewError(EcodeContainerDetachError, fmt.Sprintf("container %s has %d volumes not detached", containerid, failed))
+	}
 
+	containerkey := GenerateContainerKey(containerid)
 	driver := store.GetDriver()
 
 	opts := map[string]string{
@@ -189,7 +194,7 @@ func DelContainer(containerid string) error {
 		"prevValue": "",
 		"prevIndex": "0",
 	}
-	err := driver.Remove(containerkey, opts)
+	err = driver.Remove(containerkey, opts)
 	if err != nil {
 		if ValidKeyNotFoundError(err) == true {
 			return nil
@@ -199,26 +204,71 @@ func DelContainer(containerid string) error {
 	return nil
 }
 
-func AddContainerVolume(containerid string, volumeid []byte, mode []byte) error {
+// delContainerFromVolume drops the container from the volume owners, old
+// records carry no driver so every backend is tried.
+func delContainerFromVolume(volumeid string, driverName string, containerid string) error {
+	backends := ListBackends()
+	if driverName != "" {
+		backends = []string{driverName}
+	}
+
+	for _, backend := range backends {
+		err := DelVolumeContainer(volumeid, backend, containerid)
+		if err == nil {
+			continue
+		}
+		if e, ok := err.(*Error); ok && e.Code == EcodeVolumeNotFound {
+			continue
+		}
+		return err
+	}
+	return nil
+}
+
+func SetContainerStatus(containerid string, status int) error {
+	ct, err := GetContainer(containerid)
+	if err != nil {
+		return err
+	}
+
+	ct.Status = IntegerToBytes(status)
+	ct.Optime = []byte(strconv.FormatInt(time.Now().Unix(), 10))
+
+	return setAndEncodeContainer(ct)
+}
+
+// AddContainerVolume records the volume on the container, the container
+// record is created online on its first attach.
+func AddContainerVolume(containerid string, volumeid []byte, mode []byte, driverName string) error {
 	if len(containerid) != CONTAINER_ID_LENGTH {
 		return NewError(EcodeParameterError, "container id not valid.")
 	}
-	//TODO check volume exist
 
 	ct, err := GetContainer(containerid)
 	if err != nil {
-		return err
+		if e, ok := err.(*Error); !ok || e.Code != EcodeContainerNotFound {
+			return err
+		}
+		ct = &metaproto.Container{
+			Id:     []byte(containerid),
+			Status: IntegerToBytes(CONTAINERONLINE),
+			Optime: []byte(strconv.FormatInt(time.Now().Unix(), 10)),
+		}
 	}
 
 	var cvl *metaproto.Container_AttachVolume
 	for i := 0; i < len(ct.Volumes); i++ {
 		cvl = ct.Volumes[i]
-		if string(cvl.Volumeid) == string(volumeid) {
-			return nil
+		if string(cvl.Volumeid) == string(volumeid) && string(cvl.Driver) == driverName {
+			if string(cvl.Mode) == string(mode) {
+				return nil
+			}
+			cvl.Mode = mode
+			return setAndEncodeContainer(ct)
 		}
 	}
 
-	avl := &metaproto.Container_AttachVolume{Volumeid: volumeid, Mode: []byte(RWVolume)}
+	avl := &metaproto.Container_AttachVolume{Volumeid: volumeid, Mode: mode, Driver: []byte(driverName)}
 
 	ct.Volumes = append(ct.Volumes, avl)
 	return setAndEncodeContainer(ct)
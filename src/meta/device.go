@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"meta/proto"
 	"store"
@@ -20,6 +21,8 @@ type UpdateStatusEvent struct {
 	Status  int
 	Backend string
 	Time    string
+	// the capacity of the volume, given back to a pool
+	Capacity int
 }
 
 func ExecuteUpdateDeviceStatus(evstr []byte) error {
@@ -35,11 +38,11 @@ func ExecuteUpdateDeviceStatus(evstr []byte) error {
 	}
 
 	if ev.Status == DEVICE_READY {
-		return FreeDevice(ev.Devid, ev.Backend, ev.Volid)
+		return freeDevice(ev.Devid, ev.Backend, ev.Volid, ev.Capacity)
 	}
 
 	if ev.Status == DEVICE_INUSE {
-		return UseDevice(ev.Devid, ev.Backend, ev.Volid)
+		return useDevice(ev.Devid, ev.Backend, ev.Volid, ev.Capacity)
 	}
 
 	return nil
@@ -144,10 +147,7 @@ func listDevices(backend string) ([]string, error) {
 	fmt.Println("key: ", devicekey)
 	devices, err := driver.List(devicekey, opts)
 	fmt.Println("err: ", err)
-	if err != nil {
-		if ValidKeyNotFoundError(err) == true {
-			return nil, nil
-		}
+	if err != nil && ValidKeyNotFoundError(err) == false {
 		return nil, NewError(EcodeBackendError, err.Error())
 	}
 
@@ -155,6 +155,9 @@ func listDevices(backend string) ([]string, error) {
 
 	freedevices, err := driver.List(devicekey, opts)
 	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return devices, nil
+		}
 		return nil, NewError(EcodeBackendError, err.Error())
 	}
 
@@ -166,6 +169,7 @@ func listDevices(backend string) ([]string, error) {
 }
 
 func setAndEncodeDevice(devid string, dv *metaproto.Device, backend string, free bool) error {
+	upgradeRecord(dv)
 	data, err := proto.Marshal(dv)
 	if err != nil {
 		return NewError(EcodeRequestDecodeError, err.Error())
@@ -235,7 +239,7 @@ func AddDevice(devid string, ip string, port int, total int, free int, status in
 		log.Debugf("[AddDevice]Not Valid Capacity.")
 		return NewError(EcodeParameterError, "Not Valid Capacity.")
 	}
-	if status <= DEVICE_MIN && status >= DEVICE_MAX {
+	if DeviceStates.Valid(status) == false {
 		log.Debugf("[AddDevice]Not Valid Device Status.")
 		return NewError(EcodeParameterError, "Not Valid Device Status.")
 	}
@@ -427,7 +431,7 @@ func GetDeviceNet(devid string, backend string) (string, int, error) {
 	if err != nil {
 		return "", 0, err
 	}
-	port, err := BytesToInteger(dv.Port)
+	port, err := DevicePort(dv)
 	if err != nil {
 		port = 0
 	}
@@ -446,13 +450,48 @@ func GetDeviceStatus(devid string, backend string) (int, error) {
 	if err != nil {
 		return DEVICE_UNKNOWN, err
 	}
-	status, err := BytesToInteger(dv.Status)
+	status, err := DeviceStatus(dv)
 	if err != nil {
 		status = DEVICE_UNKNOWN
 	}
 	return status, err
 }
 
+// DeviceVolumes lists the volumes on the device, those naming a pool among
+// their devices or the one volume of another device.
+func DeviceVolumes(devid string, backend string) ([]string, error) {
+	if !PoolBackend(backend) {
+		dv, err := GetDevice(devid, backend)
+		if err != nil {
+			return nil, err
+		}
+		if len(dv.Volumekey) == 0 {
+			return nil, nil
+		}
+		volumeid, _ := ParseVolumekey(string(dv.Volumekey))
+		return []string{volumeid}, nil
+	}
+
+	names, err := ListVolumesName(backend)
+	if err != nil {
+		return nil, err
+	}
+	volumes := []string{}
+	for _, name := range names {
+		vl, err := getAndDecodeVolume(name, backend)
+		if err != nil {
+			continue
+		}
+		for _, ad := range vl.Devices {
+			if string(ad.Deviceid) == devid {
+				volumes = append(volumes, name)
+				break
+			}
+		}
+	}
+	return volumes, nil
+}
+
 func DelDevice(devid string, backend string) error {
 	if len(devid) <= DEVICE_ID_MIN_LENGTH {
 		return NewError(EcodeParameterError, "devid length can not shorter than 2.")
@@ -468,13 +507,20 @@ func DelDevice(devid string, backend string) error {
 	}
 	log.Debugf("device = %v", dv)
 	var free = false
-	status, err := BytesToInteger(dv.Status)
+	status, err := DeviceStatus(dv)
 	if err != nil {
 		return err
 	}
 	if status == DEVICE_INUSE {
 		return NewError(EcodeDeviceInUse, devid)
 	}
+	if PoolBackend(backend) {
+		if volumes, err := DeviceVolumes(devid, backend); err != nil {
+			return err
+		} else if len(volumes) != 0 {
+			return NewError(EcodeDeviceInUse, devid)
+		}
+	}
 	free = true
 
 	// delete volume device information
@@ -535,7 +581,7 @@ func UpdateDevice(devid string, ip string, port int, total int, free int, status
 	if total <= 0 || free < 0 {
 		return NewError(EcodeParameterError, "Not Valid Capacity.")
 	}
-	if status <= DEVICE_MIN && status >= DEVICE_MAX {
+	if DeviceStates.Valid(status) == false {
 		return NewError(EcodeParameterError, "Not Valid Device Status.")
 	}
 	if ValidBackend(backend) == false {
@@ -572,7 +618,7 @@ func UpdateDeviceNet(devid string, ip string, port int, backend string) error {
 		flag = true
 	}
 
-	dvPort, err := BytesToInteger(dv.GetPort())
+	dvPort, err := DevicePort(dv)
 	if err != nil {
 		return err
 	}
@@ -583,15 +629,7 @@ func UpdateDeviceNet(devid string, ip string, port int, backend string) error {
 	}
 
 	if flag == true {
-		free := false
-		status, err := BytesToInteger(dv.Status)
-		if err != nil {
-			return err
-		}
-		if status != DEVICE_INUSE {
-			free = true
-		}
-		return setAndEncodeDevice(devid, dv, backend, free)
+		return setAndEncodeDevice(devid, dv, backend, len(dv.Volumekey) == 0)
 	}
 
 	return nil
@@ -615,7 +653,7 @@ func UpdateDeviceCapacity(devid string, total int, free int, backend string) err
 	}
 
 	flag := false
-	dvTotal, err := BytesToInteger(dv.GetTotal())
+	dvTotal, err := DeviceTotal(dv)
 	if err != nil {
 		return err
 	}
@@ -624,7 +662,7 @@ func UpdateDeviceCapacity(devid string, total int, free int, backend string) err
 		flag = true
 	}
 
-	dvFree, err := BytesToInteger(dv.GetFree())
+	dvFree, err := DeviceFree(dv)
 	if err != nil {
 		return err
 	}
@@ -635,15 +673,7 @@ func UpdateDeviceCapacity(devid string, total int, free int, backend string) err
 	}
 
 	if flag == true {
-		freedev := false
-		status, err := BytesToInteger(dv.Status)
-		if err != nil {
-			return err
-		}
-		if status != DEVICE_INUSE {
-			freedev = true
-		}
-		return setAndEncodeDevice(devid, dv, backend, freedev)
+		return setAndEncodeDevice(devid, dv, backend, len(dv.Volumekey) == 0)
 	}
 
 	return nil
@@ -653,7 +683,7 @@ func UpdateDeviceStatus(devid string, status int, backend string, optime int) er
 	if len(devid) <= DEVICE_ID_MIN_LENGTH {
 		return NewError(EcodeParameterError, "devid length can not shorter than 2.")
 	}
-	if status <= DEVICE_MIN && status >= DEVICE_MAX {
+	if DeviceStates.Valid(status) == false {
 		return NewError(EcodeParameterError, "Not Valid Device Status.")
 	}
 	if ValidBackend(backend) == false {
@@ -666,42 +696,59 @@ func UpdateDeviceStatus(devid string, status int, backend string, optime int) er
 		return err
 	}
 
-	t, err := strconv.Atoi(string(dv.Optime))
-	if err != nil {
-		return NewError(EcodeMetaTimeInvalid, "meta Device Optime Invalid.")
+	// devices added by hand carry no optime until their first update
+	t := 0
+	if len(dv.Optime) != 0 || dv.OptimeV2 != nil {
+		t, err = DeviceOptime(dv)
+		if err != nil {
+			return NewError(EcodeMetaTimeInvalid, "meta Device Optime Invalid.")
+		}
 	}
 
 	if optime != 0 && t > optime {
 		return NewError(EcodeEventTimeExipre, "Event time expire.")
 	}
 
-	flag := false
-
-	dvStatus, err := BytesToInteger(dv.GetStatus())
+	dvStatus, err := DeviceStatus(dv)
 	if err != nil {
 		return err
 	}
 	if dvStatus != status {
-		dv.Status = IntegerToBytes(status)
-		flag = true
-	}
-
-	if flag == true {
-		freedev := false
-		status, err := BytesToInteger(dv.Status)
-		if err != nil {
+		if err := DeviceStates.Check(dvStatus, status); err != nil {
 			return err
 		}
-		if status != DEVICE_INUSE {
-			freedev = true
-		}
-		return setAndEncodeDevice(devid, dv, backend, freedev)
+		dv.Status = IntegerToBytes(status)
+		dv.Optime = []byte(strconv.FormatInt(time.Now().Unix(), 10))
+
+		// the record stays under the key it was read from, only UseDevice
+		// and FreeDevice move a device between free and inuse
+		return setAndEncodeDevice(devid, dv, backend, len(dv.Volumekey) == 0)
 	}
 
 	return nil
 }
 
+// volumeCapacity is the capacity of the volume in MB, 0 once it is gone.
+func volumeCapacity(volumeid string, backend string) int {
+	vl, err := getAndDecodeVolume(volumeid, backend)
+	if err != nil {
+		return 0
+	}
+	return capacityOf(vl)
+}
+
+func capacityOf(vl *metaproto.Volume) int {
+	capacity, _ := VolumeCapacity(vl)
+	return capacity
+}
+
+// FreeDevice gives the device back once its volume is gone, a pool only
+// gets the capacity of the volume back.
 func FreeDevice(devid string, backend string, volumeid string) error {
+	return freeDevice(devid, backend, volumeid, volumeCapacity(volumeid, backend))
+}
+
+func freeDevice(devid string, backend string, volumeid string, capacity int) error {
 	if len(devid) <= DEVICE_ID_MIN_LENGTH {
 		return NewError(EcodeParameterError, "devid length can not shorter than 2.")
 	}
@@ -715,16 +762,35 @@ func FreeDevice(devid string, backend string, volumeid string) error {
 		return err
 	}
 
-	dvStatus, err := BytesToInteger(dv.GetStatus())
+	if PoolBackend(backend) {
+		total, err := DeviceTotal(dv)
+		if err != nil {
+			return err
+		}
+		free, err := DeviceFree(dv)
+		if err != nil {
+			return err
+		}
+		if free += capacity; free > total {
+			free = total
+		}
+		dv.Free = IntegerToBytes(free)
+		return setAndEncodeDevice(devid, dv, backend, len(dv.Volumekey) == 0)
+	}
+
+	dvStatus, err := DeviceStatus(dv)
 	if err != nil {
 		return err
 	}
 
-	if dvStatus != DEVICE_INUSE {
+	if dvStatus != DEVICE_INUSE && len(dv.Volumekey) == 0 {
 		return nil
 	}
 
-	dv.Status = IntegerToBytes(DEVICE_READY)
+	// an offline or drained device keeps its status after its volume is gone
+	if dvStatus == DEVICE_INUSE {
+		dv.Status = IntegerToBytes(DEVICE_READY)
+	}
 	dv.Volumekey = []byte{}
 
 	driver := store.GetDriver()
@@ -747,7 +813,13 @@ func FreeDevice(devid string, backend string, volumeid string) error {
 	return setAndEncodeDevice(devid, dv, backend, true)
 }
 
+// UseDevice gives the device to the volume, a pool only gives the capacity
+// of the volume and takes further volumes.
 func UseDevice(devid string, backend string, volumeid string) error {
+	return useDevice(devid, backend, volumeid, volumeCapacity(volumeid, backend))
+}
+
+func useDevice(devid string, backend string, volumeid string, capacity int) error {
 	if len(devid) <= DEVICE_ID_MIN_LENGTH {
 		return NewError(EcodeParameterError, "devid length can not shorter than 2.")
 	}
@@ -761,14 +833,31 @@ func UseDevice(devid string, backend string, volumeid string) error {
 		return err
 	}
 
-	dvStatus, err := BytesToInteger(dv.GetStatus())
+	dvStatus, err := DeviceStatus(dv)
 	if err != nil {
 		return err
 	}
 
+	if dvStatus == DEVICE_MAINTENANCE {
+		return NewError(EcodeDeviceMaintenance, "device in maintenance.")
+	}
+	if PoolBackend(backend) {
+		free, err := DeviceFree(dv)
+		if err != nil {
+			return err
+		}
+		if free < capacity {
+			return NewError(EcodeDeviceNoSpace, fmt.Sprintf("device %s only has %dMB free.", devid, free))
+		}
+		dv.Free = IntegerToBytes(free - capacity)
+		return setAndEncodeDevice(devid, dv, backend, len(dv.Volumekey) == 0)
+	}
 	if dvStatus == DEVICE_INUSE {
 		return NewError(EcodeDeviceInUse, "device already in use.")
 	}
+	if err := DeviceStates.Check(dvStatus, DEVICE_INUSE); err != nil {
+		return err
+	}
 
 	dv.Status = IntegerToBytes(DEVICE_INUSE)
 	dv.Volumekey = []byte(GenerateVolumeKey(volumeid, backend))
@@ -791,3 +880,39 @@ func UseDevice(devid string, backend string, volumeid string) error {
 
 	return setAndEncodeDevice(devid, dv, backend, false)
 }
+
+// DrainDevice puts a device in maintenance, it keeps its volume but is
+// never given a new one.
+func DrainDevice(devid string, backend string) error {
+	return UpdateDeviceStatus(devid, DEVICE_MAINTENANCE, backend, 0)
+}
+
+// ResumeDevice takes a device out of maintenance.
+func ResumeDevice(devid string, backend string) error {
+	if len(devid) <= DEVICE_ID_MIN_LENGTH {
+		return NewError(EcodeParameterError, "devid length can not shorter than 2.")
+	}
+	if ValidBackend(backend) == false {
+		return NewError(EcodeParameterError, "Not Valid Backend.")
+	}
+
+	dv, err := getAndDecodeDevice(devid, backend)
+	if err != nil {
+		log.Errorf("[ResumeDevice] getAndDecodeDevice error: %s", err.Error())
+		return err
+	}
+
+	dvStatus, err := DeviceStatus(dv)
+	if err != nil {
+		return err
+	}
+	if dvStatus != DEVICE_MAINTENANCE {
+		return nil
+	}
+
+	status := DEVICE_READY
+	if len(dv.Volumekey) != 0 {
+		status = DEVICE_INUSE
+	}
+	return UpdateDeviceStatus(devid, status, backend, 0)
+}
@@ -2,12 +2,16 @@ package metadata
 
 import (
 	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
 )
 
 const (
 	// Host
-	EcodeHostNotFound = 1000
-	EcodeHostExist    = 1001
+	EcodeHostNotFound   = 1000
+	EcodeHostExist      = 1001
+	EcodeHostNotOffline = 1002
 
 	// Device
 	EcodeDeviceNotFound     = 2000
@@ -15,17 +19,42 @@ const (
 	EcodeDeviceToHostsError = 2002
 	EcodeDeviceAddError     = 2003
 	EcodeDeviceInUse        = 2004
+	EcodeDeviceMaintenance  = 2005
+	EcodeDeviceNoSpace      = 2006
 
 	// Container
-	EcodeContainerNotFound = 3000
-	EcodeVolumeExist       = 3001
-	EcodeVolumeConflict    = 3002
+	EcodeContainerNotFound    = 3000
+	EcodeVolumeExist          = 3001
+	EcodeVolumeConflict       = 3002
+	EcodeContainerDetachError = 3003
+	EcodeContainerExist       = 3004
 
 	// Volume
 	EcodeVolumeNotFound   = 4000
 	EcodeWRContainerExist = 4001
 	EcodeVolumeInUse      = 4002
 	EcodeVolumeDeviceMiss = 4003
+	EcodeVolumeMigrating  = 4004
+	EcodeMigrateNotFound  = 4005
+
+	// Snapshot
+	EcodeSnapshotNotFound = 6000
+	EcodeSnapshotExist    = 6001
+
+	// Backup
+	EcodeBackupNotFound = 7000
+	EcodeBackupInUse    = 7001
+	EcodeBackupError    = 7002
+	EcodeBackupExist    = 7003
+
+	// Archive
+	EcodeArchiveVersion  = 8000
+	EcodeArchiveChecksum = 8001
+	EcodeArchiveInvalid  = 8002
+
+	// Operation
+	EcodeOperationNotFound  = 9000
+	EcodeOperationQueueFull = 9001
 
 	//Common
 	EcodeParameterError     = 5000
@@ -36,6 +65,11 @@ const (
 	EcodeEventTimeExipre    = 5005
 	EcodeEventTimeInvalid   = 5006
 	EcodeMetaTimeInvalid    = 5007
+	EcodeDriverError        = 5008
+	EcodeSchemaVersion      = 5009
+	EcodeIllegalTransition  = 5010
+	EcodeRequestInProgress  = 5011
+	EcodeRequestKeyReused   = 5012
 )
 
 type Error struct {
@@ -52,10 +86,96 @@ func NewError(errorCode int, msg string) *Error {
 
 // Error is for the error interface
 func (e Error) Error() string {
-	return e.Message + " (" + string(e.Code) + ")"
+	return e.Message + " (" + strconv.Itoa(e.Code) + ")"
 }
 
 func (e Error) toJsonString() string {
 	b, _ := json.Marshal(e)
 	return string(b)
 }
+
+// ErrorEntry tells what a code means, its stable name and the http status
+// the daemon answers it with.
+type ErrorEntry struct {
+	Code    int
+	Name    string
+	Status  int
+	Message string
+}
+
+// errorCatalog holds every code, clients match on codes and names so
+// neither changes once released.
+var errorCatalog = []ErrorEntry{
+	{EcodeHostNotFound, "HOST_NOT_FOUND", http.StatusNotFound, "host not found"},
+	{EcodeHostExist, "HOST_EXISTS", http.StatusConflict, "host already exists"},
+	{EcodeHostNotOffline, "HOST_NOT_OFFLINE", http.StatusConflict, "host is not offline"},
+
+	{EcodeDeviceNotFound, "DEVICE_NOT_FOUND", http.StatusNotFound, "device not found"},
+	{EcodeDeviceExist, "DEVICE_EXISTS", http.StatusConflict, "device already exists"},
+	{EcodeDeviceToHostsError, "DEVICE_TO_HOSTS_ERROR", http.StatusInternalServerError, "device could not be added to its host"},
+	{EcodeDeviceAddError, "DEVICE_ADD_ERROR", http.StatusInternalServerError, "device could not be added"},
+	{EcodeDeviceInUse, "DEVICE_IN_USE", http.StatusConflict, "device holds a volume"},
+	{EcodeDeviceMaintenance, "DEVICE_MAINTENANCE", http.StatusConflict, "device is in maintenance"},
+	{EcodeDeviceNoSpace, "DEVICE_NO_SPACE", http.StatusInsufficientStorage, "device has not enough free space"},
+
+	{EcodeContainerNotFound, "CONTAINER_NOT_FOUND", http.StatusNotFound, "container not found"},
+	{EcodeVolumeExist, "VOLUME_EXISTS", http.StatusConflict, "volume already exists"},
+	{EcodeVolumeConflict, "VOLUME_CONFLICT", http.StatusConflict, "volume is attached otherwise"},
+	{EcodeContainerDetachError, "CONTAINER_DETACH_ERROR", http.StatusInternalServerError, "volume could not be detached from the container"},
+	{EcodeContainerExist, "CONTAINER_EXISTS", http.StatusConflict, "container already exists"},
+
+	{EcodeVolumeNotFound, "VOLUME_NOT_FOUND", http.StatusNotFound, "volume not found"},
+	{EcodeWRContainerExist, "WRITER_EXISTS", http.StatusConflict, "volume is already attached rw"},
+	{EcodeVolumeInUse, "VOLUME_IN_USE", http.StatusConflict, "volume is attached"},
+	{EcodeVolumeDeviceMiss, "VOLUME_DEVICE_MISSING", http.StatusInternalServerError, "device of the volume not found"},
+	{EcodeVolumeMigrating, "VOLUME_MIGRATING", http.StatusConflict, "volume is being migrated"},
+	{EcodeMigrateNotFound, "MIGRATION_NOT_FOUND", http.StatusNotFound, "volume was never migrated"},
+
+	{EcodeParameterError, "INVALID_PARAMETER", http.StatusBadRequest, "invalid parameter"},
+	{EcodeRequestDecodeError, "INVALID_REQUEST", http.StatusBadRequest, "request could not be decoded"},
+	{EcodeRequestEncodeError, "ENCODE_ERROR", http.StatusInternalServerError, "record could not be encoded"},
+	{EcodeBackendError, "STORE_ERROR", http.StatusServiceUnavailable, "metadata store failed"},
+	{EcodeSchedulerError, "NO_DEVICE", http.StatusInsufficientStorage, "no device fits the volume"},
+	{EcodeEventTimeExipre, "EVENT_TIME_EXPIRED", http.StatusBadRequest, "event time expired"},
+	{EcodeEventTimeInvalid, "EVENT_TIME_INVALID", http.StatusBadRequest, "invalid event time"},
+	{EcodeMetaTimeInvalid, "META_TIME_INVALID", http.StatusBadRequest, "invalid record time"},
+	{EcodeDriverError, "DRIVER_ERROR", http.StatusBadGateway, "backend driver failed"},
+	{EcodeSchemaVersion, "SCHEMA_VERSION", http.StatusServiceUnavailable, "metadata schema newer than the daemon"},
+	{EcodeIllegalTransition, "ILLEGAL_TRANSITION", http.StatusConflict, "status change not allowed"},
+	{EcodeRequestInProgress, "REQUEST_IN_PROGRESS", http.StatusConflict, "request of the idempotency key still runs"},
+	{EcodeRequestKeyReused, "REQUEST_KEY_REUSED", http.StatusUnprocessableEntity, "idempotency key of another request"},
+
+	{EcodeSnapshotNotFound, "SNAPSHOT_NOT_FOUND", http.StatusNotFound, "snapshot not found"},
+	{EcodeSnapshotExist, "SNAPSHOT_EXISTS", http.StatusConflict, "snapshot already exists"},
+
+	{EcodeBackupNotFound, "BACKUP_NOT_FOUND", http.StatusNotFound, "backup not found"},
+	{EcodeBackupInUse, "BACKUP_IN_USE", http.StatusConflict, "backup is the base of another"},
+	{EcodeBackupError, "BACKUP_ERROR", http.StatusInternalServerError, "backup failed"},
+	{EcodeBackupExist, "BACKUP_EXISTS", http.StatusConflict, "backup already exists"},
+
+	{EcodeArchiveVersion, "ARCHIVE_VERSION", http.StatusBadRequest, "archive of an unknown version"},
+	{EcodeArchiveChecksum, "ARCHIVE_CHECKSUM", http.StatusBadRequest, "archive checksum mismatch"},
+	{EcodeArchiveInvalid, "ARCHIVE_INVALID", http.StatusBadRequest, "invalid archive"},
+
+	{EcodeOperationNotFound, "OPERATION_NOT_FOUND", http.StatusNotFound, "operation not found"},
+	{EcodeOperationQueueFull, "OPERATION_QUEUE_FULL", http.StatusServiceUnavailable, "too many operations queued"},
+}
+
+// ErrorCatalog returns every code, by code.
+func ErrorCatalog() []ErrorEntry {
+	entries := make([]ErrorEntry, len(errorCatalog))
+	copy(entries, errorCatalog)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Code < entries[j].Code })
+	return entries
+}
+
+// LookupError returns the entry of code, an internal error for a code not
+// in the catalog.
+func LookupError(code int) ErrorEntry {
+	for _, entry := range errorCatalog {
+		if entry.Code == code {
+			return entry
+		}
+	}
+	return ErrorEntry{code, "UNKNOWN", http.StatusInternalServerError, "unknown error"}
+}
@@ -0,0 +1,157 @@
+package metadata
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"meta/proto"
+	"store"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Events record the status changes nobody asked for, such as a host found
+// dead, so operators can tell what happened and when. Only the latest
+// EVENT_MAX_COUNT are kept.
+
+const (
+	EVENT_MAX_COUNT = 1000
+
+	EVENT_HOST_OFFLINE   = "HOST_OFFLINE"
+	EVENT_HOST_ONLINE    = "HOST_ONLINE"
+	EVENT_DEVICE_OFFLINE = "DEVICE_OFFLINE"
+	EVENT_DEVICE_ONLINE  = "DEVICE_ONLINE"
+
+	EVENT_VOLUME_FAILOVER       = "VOLUME_FAILOVER"
+	EVENT_VOLUME_FAILOVER_ERROR = "VOLUME_FAILOVER_ERROR"
+)
+
+var (
+	eventSeqLock sync.Mutex
+	eventSeq     int64
+)
+
+// newEventID orders events by time, the sequence keeps apart the events
+// of one nanosecond.
+func newEventID(t time.Time) string {
+	eventSeqLock.Lock()
+	defer eventSeqLock.Unlock()
+	eventSeq++
+	return fmt.Sprintf("%020d-%06d", t.UnixNano(), eventSeq%1000000)
+}
+
+func listEventsName() ([]string, error) {
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"recursive": "false",
+		"sorted":    "false",
+		"quorum":    "false",
+	}
+	events, err := driver.List(GenerateEventKey(""), opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return nil, nil
+		}
+		return nil, NewError(EcodeBackendError, err.Error())
+	}
+
+	names := []string{}
+	for i := 0; i < len(events); i++ {
+		if name := filepath.Base(events[i]); len(name) != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func delEvent(eventid string) error {
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"recursive": "false",
+		"dir":       "false",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	err := driver.Remove(GenerateEventKey(eventid), opts)
+	if err != nil && ValidKeyNotFoundError(err) == false {
+		return NewError(EcodeBackendError, err.Error())
+	}
+	return nil
+}
+
+// AddEvent records an event of kind about a host or device, dropping the
+// oldest events beyond EVENT_MAX_COUNT.
+func AddEvent(kind string, object string, message string) error {
+	now := time.Now()
+	ev := &metaproto.Event{
+		Id:      []byte(newEventID(now)),
+		Kind:    []byte(kind),
+		Object:  []byte(object),
+		Message: []byte(message),
+		Optime:  []byte(strconv.FormatInt(now.Unix(), 10)),
+	}
+	data, err := proto.Marshal(ev)
+	if err != nil {
+		return NewError(EcodeRequestEncodeError, err.Error())
+	}
+
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"ttl":       "0",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	if err := driver.Set(GenerateEventKey(string(ev.Id)), string(data), opts); err != nil {
+		log.Errorf("[AddEvent] driver.Set error: %s", err.Error())
+		return NewError(EcodeBackendError, err.Error())
+	}
+	log.Infof("[AddEvent] %s %s: %s", kind, object, message)
+
+	names, err := listEventsName()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(names)-EVENT_MAX_COUNT; i++ {
+		if err := delEvent(names[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListEvents returns the events, oldest first.
+func ListEvents() ([]*metaproto.Event, error) {
+	names, err := listEventsName()
+	if err != nil {
+		return nil, err
+	}
+
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"sorted": "false",
+		"qurum":  "false",
+	}
+	events := []*metaproto.Event{}
+	for _, name := range names {
+		data, err := driver.Get(GenerateEventKey(name), opts)
+		if err != nil {
+			// pruned meanwhile
+			if ValidKeyNotFoundError(err) == true {
+				continue
+			}
+			return nil, NewError(EcodeBackendError, err.Error())
+		}
+		ev := &metaproto.Event{}
+		if err := proto.Unmarshal([]byte(data), ev); err != nil {
+			return nil, NewError(EcodeRequestDecodeError, err.Error())
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
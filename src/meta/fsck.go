@@ -0,0 +1,667 @@
+package metadata
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"meta/proto"
+	"store"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Fsck cross-checks the references between the records, a change spanning
+// several records is not atomic and they drift apart when it stops half
+// way. Volume records are trusted over the devices and containers naming
+// them, as volumes are written first. Problems are solved on a copy of
+// the records, then written to the store when repairing, so a check
+// reports what a repair would do. Records that don't decode are reported,
+// never touched.
+
+const (
+	FSCK_UNDECODABLE      = "UNDECODABLE"
+	FSCK_DEVICE_DUPLICATE = "DEVICE_DUPLICATE"
+	FSCK_DEVICE_HOST      = "DEVICE_HOST"
+	FSCK_DEVICE_VOLUME    = "DEVICE_VOLUME"
+	FSCK_HOST_DEVICE      = "HOST_DEVICE"
+	FSCK_VOLUME_MIGRATING = "VOLUME_MIGRATING"
+	FSCK_VOLUME_DEVICE    = "VOLUME_DEVICE"
+	FSCK_VOLUME_CONTAINER = "VOLUME_CONTAINER"
+	FSCK_CONTAINER_VOLUME = "CONTAINER_VOLUME"
+)
+
+// FsckProblem is an inconsistency found by Fsck, Key is the store key of
+// the record at fault.
+type FsckProblem struct {
+	Class    string
+	Key      string
+	Message  string
+	Repaired bool
+}
+
+type fsckDevice struct {
+	dv      *metaproto.Device
+	backend string
+	free    bool
+}
+
+func (d *fsckDevice) key() string {
+	if d.free {
+		return GenerateFreeDeviceKey(string(d.dv.Id), d.backend)
+	}
+	return GenerateInuseDeviceKey(string(d.dv.Id), d.backend)
+}
+
+type fsck struct {
+	repair   bool
+	problems []FsckProblem
+	// the host of the caller and the volumes it migrates, by backend/id
+	host string
+	busy map[string]bool
+	// volumes whose migration may still run, by backend/id
+	running map[string]bool
+
+	// volumes and devices by backend/id
+	hosts      map[string]*metaproto.Host
+	devices    map[string]*fsckDevice
+	volumes    map[string]*metaproto.Volume
+	containers map[string]*metaproto.Container
+}
+
+func fsckName(backend string, id string) string {
+	return backend + "/" + id
+}
+
+// Fsck checks every record, and repairs what can be when repair is set.
+// host is the host of the caller and busy names the volumes it migrates,
+// as backend/id. The caller holds the metadata lock.
+func Fsck(repair bool, host string, busy map[string]bool) ([]FsckProblem, error) {
+	f := &fsck{
+		repair:     repair,
+		problems:   []FsckProblem{},
+		host:       host,
+		busy:       busy,
+		running:    make(map[string]bool),
+		hosts:      make(map[string]*metaproto.Host),
+		devices:    make(map[string]*fsckDevice),
+		volumes:    make(map[string]*metaproto.Volume),
+		containers: make(map[string]*metaproto.Container),
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+
+	checks := []func() error{
+		f.checkMigrations,
+		f.checkVolumeDevices,
+		f.checkDeviceVolumes,
+		f.checkHostDevices,
+		f.checkDeviceHosts,
+		f.checkVolumeContainers,
+		f.checkContainerVolumes,
+	}
+	for _, check := range checks {
+		if err := check(); err != nil {
+			return f.problems, err
+		}
+	}
+	return f.problems, nil
+}
+
+func (f *fsck) report(class string, key string, repairable bool, format string, args ...interface{}) {
+	p := FsckProblem{
+		Class:    class,
+		Key:      key,
+		Message:  fmt.Sprintf(format, args...),
+		Repaired: repairable && f.repair,
+	}
+	log.Infof("[Fsck] %s %s: %s", p.Class, p.Key, p.Message)
+	f.problems = append(f.problems, p)
+}
+
+func sortedNames(names map[string]bool) []string {
+	sorted := []string{}
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// list returns the names under the directory key, none when it is missing.
+func (f *fsck) list(dirkey string) ([]string, error) {
+	opts := map[string]string{
+		"recursive": "false",
+		"sorted":    "false",
+		"quorum":    "false",
+	}
+	keys, err := store.GetDriver().List(dirkey, opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return nil, nil
+		}
+		return nil, NewError(EcodeBackendError, err.Error())
+	}
+	names := []string{}
+	for _, key := range keys {
+		if name := filepath.Base(key); len(name) != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// get decodes the record at key into msg, it tells whether it did.
+func (f *fsck) get(key string, msg proto.Message) (bool, error) {
+	opts := map[string]string{
+		"sorted": "false",
+		"qurum":  "false",
+	}
+	data, err := store.GetDriver().Get(key, opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return false, nil
+		}
+		return false, NewError(EcodeBackendError, err.Error())
+	}
+	if err := proto.Unmarshal([]byte(data), msg); err != nil {
+		f.report(FSCK_UNDECODABLE, key, false, "%s", err.Error())
+		return false, nil
+	}
+	return true, nil
+}
+
+func (f *fsck) remove(key string) error {
+	if !f.repair {
+		return nil
+	}
+	opts := map[string]string{
+		"recursive": "false",
+		"dir":       "false",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	err := store.GetDriver().Remove(key, opts)
+	if err != nil && ValidKeyNotFoundError(err) == false {
+		return NewError(EcodeBackendError, err.Error())
+	}
+	return nil
+}
+
+func (f *fsck) load() error {
+	hosts, err := f.list(HOSTROOT)
+	if err != nil {
+		return err
+	}
+	for _, ip := range hosts {
+		hs := &metaproto.Host{}
+		if ok, err := f.get(GenerateHostKey(ip), hs); err != nil {
+			return err
+		} else if ok {
+			f.hosts[ip] = hs
+		}
+	}
+
+	containers, err := f.list(CONTAINERROOT)
+	if err != nil {
+		return err
+	}
+	for _, id := range containers {
+		ct := &metaproto.Container{}
+		if ok, err := f.get(GenerateContainerKey(id), ct); err != nil {
+			return err
+		} else if ok {
+			f.containers[id] = ct
+		}
+	}
+
+	for _, backend := range ListBackends() {
+		volumes, err := f.list(GenerateVolumeDriverKey(backend))
+		if err != nil {
+			return err
+		}
+		for _, id := range volumes {
+			vl := &metaproto.Volume{}
+			if ok, err := f.get(GenerateVolumeKey(id, backend), vl); err != nil {
+				return err
+			} else if ok {
+				f.volumes[fsckName(backend, id)] = vl
+			}
+		}
+	}
+
+	// volumes first, they tell which copy of a duplicate device is right
+	for _, backend := range ListBackends() {
+		if err := f.loadDevices(backend); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadDevices reads the devices of the backend, a device both free and
+// in use keeps the copy its volume agrees with.
+func (f *fsck) loadDevices(backend string) error {
+	inuse, err := f.list(GenerateInuseDeviceDriverKey(backend))
+	if err != nil {
+		return err
+	}
+	free, err := f.list(GenerateFreeDeviceDriverKey(backend))
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for _, id := range append(inuse, free...) {
+		names[id] = true
+	}
+
+	for _, id := range sortedNames(names) {
+		used := &fsckDevice{dv: &metaproto.Device{}, backend: backend}
+		ok, err := f.get(GenerateInuseDeviceKey(id, backend), used.dv)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			used = nil
+		}
+		unused := &fsckDevice{dv: &metaproto.Device{}, backend: backend, free: true}
+		ok, err = f.get(GenerateFreeDeviceKey(id, backend), unused.dv)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			unused = nil
+		}
+
+		switch {
+		case used != nil && unused != nil:
+			keep, drop := unused, used
+			if f.volumeListsDevice(string(used.dv.Volumekey), id) {
+				keep, drop = used, unused
+			}
+			f.report(FSCK_DEVICE_DUPLICATE, drop.key(), true, "device both free and in use, %s kept", keep.key())
+			if err := f.remove(drop.key()); err != nil {
+				return err
+			}
+			f.devices[fsckName(backend, id)] = keep
+		case used != nil:
+			f.devices[fsckName(backend, id)] = used
+		case unused != nil:
+			f.devices[fsckName(backend, id)] = unused
+		}
+	}
+	return nil
+}
+
+func (f *fsck) volumeListsDevice(volumekey string, devid string) bool {
+	if len(volumekey) == 0 {
+		return false
+	}
+	volumeid, backend := ParseVolumekey(volumekey)
+	vl, ok := f.volumes[fsckName(backend, volumeid)]
+	if !ok {
+		return false
+	}
+	for _, ad := range vl.Devices {
+		if string(ad.Deviceid) == devid {
+			return true
+		}
+	}
+	return false
+}
+
+// putDevice writes the device under the key its volume calls for, in use
+// with a volume and free without.
+func (f *fsck) putDevice(d *fsckDevice) error {
+	old := d.key()
+	d.free = len(d.dv.Volumekey) == 0
+	if !f.repair {
+		return nil
+	}
+	if old != d.key() {
+		if err := f.remove(old); err != nil {
+			return err
+		}
+	}
+	return setAndEncodeDevice(string(d.dv.Id), d.dv, d.backend, d.free)
+}
+
+func (f *fsck) putVolume(vl *metaproto.Volume, backend string) error {
+	if !f.repair {
+		return nil
+	}
+	return setAndEncodeVolume(vl, backend)
+}
+
+func (f *fsck) putHost(hs *metaproto.Host) error {
+	if !f.repair {
+		return nil
+	}
+	return setAndEncodeHost(string(hs.Ip), hs)
+}
+
+func (f *fsck) putContainer(ct *metaproto.Container) error {
+	if !f.repair {
+		return nil
+	}
+	return setAndEncodeContainer(ct)
+}
+
+func (f *fsck) volumeNames() []string {
+	names := make(map[string]bool)
+	for name := range f.volumes {
+		names[name] = true
+	}
+	return sortedNames(names)
+}
+
+func (f *fsck) deviceNames() []string {
+	names := make(map[string]bool)
+	for name := range f.devices {
+		names[name] = true
+	}
+	return sortedNames(names)
+}
+
+// migrationOwner tells whether the host copying the volume is gone, from
+// the migration record: a host the failure detector took offline for its
+// missing heartbeats, a host removed, or the caller when it doesn't run
+// the migration. Any other host may still be copying.
+func (f *fsck) migrationOwner(name string, mg *metaproto.Migration) (string, bool) {
+	if mg == nil {
+		return "no migration record", false
+	}
+	host := string(mg.Host)
+	switch {
+	case len(host) == 0:
+		return "no host in the migration record", false
+	case host == f.host:
+		return "migration abandoned by this host", true
+	}
+	hs, ok := f.hosts[host]
+	if !ok {
+		return fmt.Sprintf("host %s is gone", host), true
+	}
+	if status, _ := HostStatus(hs); status == HOST_OFFLINE {
+		return fmt.Sprintf("host %s lost its heartbeat", host), true
+	}
+	return fmt.Sprintf("host %s may still be copying", host), false
+}
+
+// checkMigrations ends the migrations whose host is gone, the copy they
+// left on the target device is freed by checkDeviceVolumes. The others are
+// only reported, they may still run.
+func (f *fsck) checkMigrations() error {
+	for _, name := range f.volumeNames() {
+		vl := f.volumes[name]
+		if !isMigrating(vl) || f.busy[name] {
+			f.running[name] = f.busy[name]
+			continue
+		}
+		backend := filepath.Dir(name)
+		volumeid := string(vl.Id)
+
+		mg := &metaproto.Migration{}
+		if ok, err := f.get(GenerateMigrationKey(volumeid, backend), mg); err != nil {
+			return err
+		} else if !ok {
+			mg = nil
+		}
+		reason, gone := f.migrationOwner(name, mg)
+		f.report(FSCK_VOLUME_MIGRATING, GenerateVolumeKey(volumeid, backend), gone, "volume stuck migrating, %s", reason)
+		if !gone {
+			f.running[name] = true
+			continue
+		}
+
+		vl.Status = IntegerToBytes(VOLUME_ONLINE)
+		if err := f.putVolume(vl, backend); err != nil {
+			return err
+		}
+		mg.Status, mg.Error = []byte(MIGRATION_FAILED), []byte(reason)
+		if f.repair {
+			if err := SetMigration(mg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkVolumeDevices drops the devices of a volume that are gone, and
+// claims back those left free.
+func (f *fsck) checkVolumeDevices() error {
+	for _, name := range f.volumeNames() {
+		vl := f.volumes[name]
+		backend := filepath.Dir(name)
+		volumekey := GenerateVolumeKey(string(vl.Id), backend)
+
+		devices := []*metaproto.Volume_AttachDevice{}
+		for _, ad := range vl.Devices {
+			d, ok := f.devices[fsckName(backend, string(ad.Deviceid))]
+			switch {
+			case !ok:
+				f.report(FSCK_VOLUME_DEVICE, volumekey, true, "device %s not found", ad.Deviceid)
+				continue
+			case PoolBackend(backend):
+				// a pool is shared by its volumes, none claims it
+			case len(d.dv.Volumekey) == 0:
+				f.report(FSCK_VOLUME_DEVICE, volumekey, true, "device %s is free", ad.Deviceid)
+				d.dv.Volumekey = []byte(volumekey)
+				if status, _ := DeviceStatus(d.dv); status == DEVICE_READY {
+					d.dv.Status = IntegerToBytes(DEVICE_INUSE)
+				}
+				if err := f.putDevice(d); err != nil {
+					return err
+				}
+			case string(d.dv.Volumekey) != volumekey:
+				f.report(FSCK_VOLUME_DEVICE, volumekey, false, "device %s is used by %s", ad.Deviceid, d.dv.Volumekey)
+			}
+			devices = append(devices, ad)
+		}
+		if len(devices) != len(vl.Devices) {
+			vl.Devices = devices
+			if err := f.putVolume(vl, backend); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkDeviceVolumes frees the devices whose volume doesn't use them, and
+// moves the others under the key matching their volume.
+func (f *fsck) checkDeviceVolumes() error {
+	for _, name := range f.deviceNames() {
+		d := f.devices[name]
+		devid := string(d.dv.Id)
+		volumekey := string(d.dv.Volumekey)
+		if len(volumekey) != 0 {
+			volumeid, backend := ParseVolumekey(volumekey)
+			// the target of a running migration
+			if f.running[fsckName(backend, volumeid)] {
+				continue
+			}
+			if !f.volumeListsDevice(volumekey, devid) {
+				f.report(FSCK_DEVICE_VOLUME, d.key(), true, "volume %s doesn't use the device", volumekey)
+				d.dv.Volumekey = []byte{}
+				if status, _ := DeviceStatus(d.dv); status == DEVICE_INUSE {
+					d.dv.Status = IntegerToBytes(DEVICE_READY)
+				}
+				if err := f.putDevice(d); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		status, _ := DeviceStatus(d.dv)
+		if d.free && len(volumekey) != 0 || !d.free && len(volumekey) == 0 && status == DEVICE_READY {
+			f.report(FSCK_DEVICE_VOLUME, d.key(), true, "device misplaced, volume %q", volumekey)
+			if err := f.putDevice(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkHostDevices drops the devices a host lists twice or that are gone.
+func (f *fsck) checkHostDevices() error {
+	ips := make(map[string]bool)
+	for ip := range f.hosts {
+		ips[ip] = true
+	}
+	for _, ip := range sortedNames(ips) {
+		hs := f.hosts[ip]
+		seen := make(map[string]bool)
+		devices := [][]byte{}
+		for _, key := range hs.Devices {
+			devid, backend := ParseDeviceKey(string(key))
+			name := fsckName(backend, devid)
+			if seen[name] {
+				f.report(FSCK_HOST_DEVICE, GenerateHostKey(ip), true, "device %s listed twice", name)
+				continue
+			}
+			if _, ok := f.devices[name]; !ok {
+				f.report(FSCK_HOST_DEVICE, GenerateHostKey(ip), true, "device %s not found", name)
+				continue
+			}
+			seen[name] = true
+			devices = append(devices, key)
+		}
+		if len(devices) != len(hs.Devices) {
+			hs.Devices = devices
+			if err := f.putHost(hs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkDeviceHosts adds the devices to the host they are on when it
+// doesn't list them, devices of a missing host are only reported.
+func (f *fsck) checkDeviceHosts() error {
+	for _, name := range f.deviceNames() {
+		d := f.devices[name]
+		hs, ok := f.hosts[string(d.dv.Host)]
+		if !ok {
+			f.report(FSCK_DEVICE_HOST, d.key(), false, "host %s not found", d.dv.Host)
+			continue
+		}
+		listed := false
+		for _, key := range hs.Devices {
+			devid, backend := ParseDeviceKey(string(key))
+			if fsckName(backend, devid) == name {
+				listed = true
+				break
+			}
+		}
+		if listed {
+			continue
+		}
+		f.report(FSCK_DEVICE_HOST, d.key(), true, "host %s doesn't list the device", hs.Ip)
+		hs.Devices = append(hs.Devices, []byte(d.key()))
+		if err := f.putHost(hs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkVolumeContainers clears a writer that is no owner, and records the
+// volume on the containers owning it. Owners without container record are
+// CSI nodes.
+func (f *fsck) checkVolumeContainers() error {
+	for _, name := range f.volumeNames() {
+		vl := f.volumes[name]
+		backend := filepath.Dir(name)
+		volumekey := GenerateVolumeKey(string(vl.Id), backend)
+
+		writer := len(vl.Writable) == 0
+		for _, c := range vl.Containers {
+			if string(c.Containerid) == string(vl.Writable) {
+				writer = true
+			}
+			ct, ok := f.containers[string(c.Containerid)]
+			if !ok {
+				continue
+			}
+			listed := false
+			for _, cvl := range ct.Volumes {
+				if string(cvl.Volumeid) == string(vl.Id) && (len(cvl.Driver) == 0 || string(cvl.Driver) == backend) {
+					listed = true
+					break
+				}
+			}
+			if listed {
+				continue
+			}
+			f.report(FSCK_VOLUME_CONTAINER, volumekey, true, "container %s doesn't list the volume", c.Containerid)
+			ct.Volumes = append(ct.Volumes, &metaproto.Container_AttachVolume{Volumeid: vl.Id, Mode: c.Mode, Driver: []byte(backend)})
+			if err := f.putContainer(ct); err != nil {
+				return err
+			}
+		}
+		if !writer {
+			f.report(FSCK_VOLUME_CONTAINER, volumekey, true, "writer %s is no owner", vl.Writable)
+			vl.Writable = []byte{}
+			if err := f.putVolume(vl, backend); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkContainerVolumes drops the volumes of a container that are gone or
+// don't name it as owner.
+func (f *fsck) checkContainerVolumes() error {
+	ids := make(map[string]bool)
+	for id := range f.containers {
+		ids[id] = true
+	}
+	for _, id := range sortedNames(ids) {
+		ct := f.containers[id]
+		volumes := []*metaproto.Container_AttachVolume{}
+		for _, cvl := range ct.Volumes {
+			// old records carry no driver
+			backends := ListBackends()
+			if len(cvl.Driver) != 0 {
+				backends = []string{string(cvl.Driver)}
+			}
+			found, owner := false, false
+			for _, backend := range backends {
+				vl, ok := f.volumes[fsckName(backend, string(cvl.Volumeid))]
+				if !ok {
+					continue
+				}
+				found = true
+				for _, c := range vl.Containers {
+					if string(c.Containerid) == id {
+						owner = true
+					}
+				}
+			}
+			if !found {
+				f.report(FSCK_CONTAINER_VOLUME, GenerateContainerKey(id), true, "volume %s not found", cvl.Volumeid)
+				continue
+			}
+			if !owner {
+				f.report(FSCK_CONTAINER_VOLUME, GenerateContainerKey(id), true, "volume %s doesn't list the container", cvl.Volumeid)
+				continue
+			}
+			volumes = append(volumes, cvl)
+		}
+		if len(volumes) != len(ct.Volumes) {
+			ct.Volumes = volumes
+			if err := f.putContainer(ct); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -161,6 +161,7 @@ func listHosts() ([]string, error) {
 }
 
 func setAndEncodeHost(ip string, hs *metaproto.Host) error {
+	upgradeRecord(hs)
 	data, err := proto.Marshal(hs)
 	if err != nil {
 		return NewError(EcodeRequestEncodeError, err.Error())
@@ -183,7 +184,7 @@ func setAndEncodeHost(ip string, hs *metaproto.Host) error {
 }
 
 func checkHostStatus(status int) bool {
-	return status > HOST_MIN && status < HOST_MAX
+	return HostStates.Valid(status)
 }
 
 func IsHostExist(ip string) (bool, error) {
@@ -351,6 +352,13 @@ func ModHostStatus(ip string, status int) error {
 	if err != nil {
 		return err
 	}
+	current, err := HostStatus(hs)
+	if err != nil {
+		current = 0
+	}
+	if err := HostStates.Check(current, status); err != nil {
+		return err
+	}
 
 	hs.Status = IntegerToBytes(status)
 
@@ -362,6 +370,23 @@ func ModHostStatus(ip string, status int) error {
 	return nil
 }
 
+// HostHeartbeat records that the agent of a host is alive, the optime of
+// the host is its last heartbeat.
+func HostHeartbeat(ip string, optime int) error {
+	if util.ValidIPAddr(ip) == false {
+		return NewError(EcodeParameterError, "Not Valid IP Addr.")
+	}
+
+	hs, err := getAndDecodeHost(ip)
+	if err != nil {
+		return err
+	}
+
+	hs.Optime = IntegerToBytes(optime)
+
+	return setAndEncodeHost(ip, hs)
+}
+
 func AddHostDevices(ip string, devices [][]byte) error {
 	if util.ValidIPAddr(ip) == false {
 		return NewError(EcodeParameterError, "Not Valid IP Addr.")
@@ -423,3 +448,47 @@ func DelHostDevices(ip string, devices [][]byte) error {
 	hs.Devices = newdevs
 	return setAndEncodeHost(ip, hs)
 }
+
+// DrainHost puts a host and all its devices in maintenance.
+func DrainHost(ip string) error {
+	if util.ValidIPAddr(ip) == false {
+		return NewError(EcodeParameterError, "Not Valid IP Addr.")
+	}
+
+	hs, err := getAndDecodeHost(ip)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range hs.Devices {
+		devid, backend := ParseDeviceKey(string(key))
+		if err := DrainDevice(devid, backend); err != nil {
+			log.Errorf("[DrainHost] DrainDevice %s error: %s", devid, err.Error())
+			return err
+		}
+	}
+
+	return ModHostStatus(ip, HOST_MAINTENANCE)
+}
+
+// ResumeHost takes a host and all its devices out of maintenance.
+func ResumeHost(ip string) error {
+	if util.ValidIPAddr(ip) == false {
+		return NewError(EcodeParameterError, "Not Valid IP Addr.")
+	}
+
+	hs, err := getAndDecodeHost(ip)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range hs.Devices {
+		devid, backend := ParseDeviceKey(string(key))
+		if err := ResumeDevice(devid, backend); err != nil {
+			log.Errorf("[ResumeHost] ResumeDevice %s error: %s", devid, err.Error())
+			return err
+		}
+	}
+
+	return ModHostStatus(ip, HOST_ONLINE)
+}
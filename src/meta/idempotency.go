@@ -0,0 +1,89 @@
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+
+	"meta/proto"
+	"store"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Requests sent with an idempotency key keep their response under the key
+// until they expire, so a client retrying a request it got no answer for
+// gets the first response instead of doing it twice. A record without
+// status is a request still running.
+
+// requestID is where the record of a key is kept, the key being any string
+// sent by the client.
+func requestID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetIdempotency returns the record of key, nil when there is none or it
+// expired. The caller holds the metadata lock.
+func GetIdempotency(key string, now int64) (*metaproto.Idempotency, error) {
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"sorted": "false",
+		"qurum":  "false",
+	}
+	data, err := driver.Get(GenerateRequestKey(requestID(key)), opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return nil, nil
+		}
+		return nil, NewError(EcodeBackendError, err.Error())
+	}
+
+	rec := &metaproto.Idempotency{}
+	if err := proto.Unmarshal([]byte(data), rec); err != nil {
+		return nil, NewError(EcodeRequestDecodeError, err.Error())
+	}
+	// the backend may keep it a little longer than asked
+	if rec.GetExpire() <= now {
+		return nil, nil
+	}
+	return rec, nil
+}
+
+// SetIdempotency records a request, the backend dropping it once expired.
+// The caller holds the metadata lock.
+func SetIdempotency(rec *metaproto.Idempotency, now int64) error {
+	data, err := proto.Marshal(rec)
+	if err != nil {
+		return NewError(EcodeRequestEncodeError, err.Error())
+	}
+
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"ttl":       strconv.FormatInt(rec.GetExpire()-now, 10),
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	if err := driver.Set(GenerateRequestKey(requestID(string(rec.Key))), string(data), opts); err != nil {
+		log.Errorf("[SetIdempotency] driver.Set error: %s", err.Error())
+		return NewError(EcodeBackendError, err.Error())
+	}
+	return nil
+}
+
+// DelIdempotency forgets the request of key, for it to be sent again. The
+// caller holds the metadata lock.
+func DelIdempotency(key string) error {
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"recursive": "false",
+		"dir":       "false",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	err := driver.Remove(GenerateRequestKey(requestID(key)), opts)
+	if err != nil && ValidKeyNotFoundError(err) == false {
+		return NewError(EcodeBackendError, err.Error())
+	}
+	return nil
+}
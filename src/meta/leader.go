@@ -0,0 +1,48 @@
+package metadata
+
+import (
+	"meta/proto"
+	"store"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// AcquireLeader takes or renews the lease of the leader for ttl seconds,
+// and tells whether id holds it. Jobs that must run on a single daemon of
+// the cluster run on the leader. The caller holds the metadata lock.
+func AcquireLeader(id string, ttl int, now int) (bool, error) {
+	driver := store.GetDriver()
+
+	leader := &metaproto.Leader{}
+	data, err := driver.Get(LEADERKEY, map[string]string{"sorted": "false", "qurum": "false"})
+	if err != nil {
+		if ValidKeyNotFoundError(err) == false {
+			return false, NewError(EcodeBackendError, err.Error())
+		}
+	} else if err := proto.Unmarshal([]byte(data), leader); err != nil {
+		return false, NewError(EcodeRequestDecodeError, err.Error())
+	}
+
+	expire, _ := BytesToInteger(leader.Expire)
+	if len(leader.Id) != 0 && string(leader.Id) != id && expire > now {
+		return false, nil
+	}
+
+	if string(leader.Id) != id {
+		log.Infof("[AcquireLeader] %s takes the lead from %q", id, leader.Id)
+	}
+	leader = &metaproto.Leader{Id: []byte(id), Expire: IntegerToBytes(now + ttl)}
+	value, err := proto.Marshal(leader)
+	if err != nil {
+		return false, NewError(EcodeRequestEncodeError, err.Error())
+	}
+	opts := map[string]string{
+		"ttl":       "0",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	if err := driver.Set(LEADERKEY, string(value), opts); err != nil {
+		return false, NewError(EcodeBackendError, err.Error())
+	}
+	return true, nil
+}
@@ -0,0 +1,100 @@
+package metadata
+
+import (
+	"meta/proto"
+	"store"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// A migration record tells how the last migration of a volume went, it is
+// kept until the next migration of the volume so any daemon can tell its
+// progress, and names the host of the daemon copying the volume.
+
+// MIGRATION_FAILED is the status of a migration that ended without moving
+// the volume, the daemon tells the same.
+const MIGRATION_FAILED = "failed"
+
+func getAndDecodeMigration(volumeid string, driverName string) (*metaproto.Migration, error) {
+	driver := store.GetDriver()
+
+	migrationkey := GenerateMigrationKey(volumeid, driverName)
+	opts := map[string]string{
+		"sorted": "false",
+		"qurum":  "false",
+	}
+	data, err := driver.Get(migrationkey, opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return nil, NewError(EcodeMigrateNotFound, "Migration not found.")
+		}
+		log.Errorf("[getAndDecodeMigration] driver.Get error: %s, key: %s", err.Error(), migrationkey)
+		return nil, NewError(EcodeBackendError, err.Error())
+	}
+
+	mg := &metaproto.Migration{}
+	err = proto.Unmarshal([]byte(data), mg)
+	if err != nil {
+		log.Errorf("[getAndDecodeMigration] proto.Unmarshal error: %s, key: %s", err.Error(), migrationkey)
+		return nil, NewError(EcodeRequestDecodeError, err.Error())
+	}
+
+	return mg, nil
+}
+
+// SetMigration records the migration of a volume, replacing the one before.
+func SetMigration(mg *metaproto.Migration) error {
+	if mg == nil || validVolumeID(string(mg.Volumeid)) == false {
+		return NewError(EcodeParameterError, "Not Valid Migration Struct.")
+	}
+	if ValidDriverName(string(mg.Driver)) == false {
+		return NewError(EcodeParameterError, "Not Valid Volume Driver Name.")
+	}
+
+	data, err := proto.Marshal(mg)
+	if err != nil {
+		log.Errorf("[SetMigration] proto.marshal error: %s", err.Error())
+		return NewError(EcodeRequestEncodeError, err.Error())
+	}
+
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"ttl":       "0",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	err = driver.Set(GenerateMigrationKey(string(mg.Volumeid), string(mg.Driver)), string(data), opts)
+	if err != nil {
+		log.Errorf("[SetMigration] driver.Set error: %s", err.Error())
+		return NewError(EcodeBackendError, err.Error())
+	}
+
+	return nil
+}
+
+func GetMigration(volumeid string, driverName string) (*metaproto.Migration, error) {
+	if validVolumeID(volumeid) == false {
+		return nil, NewError(EcodeParameterError, "Not Valid Volume ID.")
+	}
+	if ValidDriverName(driverName) == false {
+		return nil, NewError(EcodeParameterError, "Not Valid Volume Driver Name.")
+	}
+
+	return getAndDecodeMigration(volumeid, driverName)
+}
+
+func delMigration(volumeid string, driverName string) error {
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"recursive": "false",
+		"dir":       "false",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	err := driver.Remove(GenerateMigrationKey(volumeid, driverName), opts)
+	if err != nil && ValidKeyNotFoundError(err) == false {
+		return NewError(EcodeBackendError, err.Error())
+	}
+
+	return nil
+}
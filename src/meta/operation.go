@@ -0,0 +1,109 @@
+package metadata
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"meta/proto"
+	"store"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Operation records follow the volume operations a daemon queued, from
+// their prepare on, so their result can still be read once the daemon
+// restarted, and the daemon can resolve those it never finished. An ended
+// record is dropped by the backend after the retention it was set with.
+
+func validOperationID(operationid string) bool {
+	return len(operationid) != 0 && !strings.ContainsAny(operationid, "/.")
+}
+
+// GetOperation returns the record of an operation. The caller holds the
+// metadata lock.
+func GetOperation(operationid string) (*metaproto.Operation, error) {
+	if validOperationID(operationid) == false {
+		return nil, NewError(EcodeOperationNotFound, "Operation not found.")
+	}
+
+	driver := store.GetDriver()
+	operationkey := GenerateOperationKey(operationid)
+	opts := map[string]string{
+		"sorted": "false",
+		"qurum":  "false",
+	}
+	data, err := driver.Get(operationkey, opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return nil, NewError(EcodeOperationNotFound, "Operation not found.")
+		}
+		log.Errorf("[GetOperation] driver.Get error: %s, key: %s", err.Error(), operationkey)
+		return nil, NewError(EcodeBackendError, err.Error())
+	}
+
+	o := &metaproto.Operation{}
+	if err := proto.Unmarshal([]byte(data), o); err != nil {
+		log.Errorf("[GetOperation] proto.Unmarshal error: %s, key: %s", err.Error(), operationkey)
+		return nil, NewError(EcodeRequestDecodeError, err.Error())
+	}
+	return o, nil
+}
+
+// SetOperation records an operation, the backend dropping the record after
+// ttl seconds, never when ttl is 0. The caller holds the metadata lock.
+func SetOperation(o *metaproto.Operation, ttl int64) error {
+	if o == nil || validOperationID(string(o.Id)) == false {
+		return NewError(EcodeParameterError, "Not Valid Operation Struct.")
+	}
+
+	data, err := proto.Marshal(o)
+	if err != nil {
+		log.Errorf("[SetOperation] proto.marshal error: %s", err.Error())
+		return NewError(EcodeRequestEncodeError, err.Error())
+	}
+
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"ttl":       strconv.FormatInt(ttl, 10),
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	if err := driver.Set(GenerateOperationKey(string(o.Id)), string(data), opts); err != nil {
+		log.Errorf("[SetOperation] driver.Set error: %s", err.Error())
+		return NewError(EcodeBackendError, err.Error())
+	}
+	return nil
+}
+
+// ListOperations returns the records of the operations. The caller holds
+// the metadata lock.
+func ListOperations() ([]*metaproto.Operation, error) {
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"recursive": "false",
+		"sorted":    "false",
+		"quorum":    "false",
+	}
+	keys, err := driver.List(GenerateOperationKey(""), opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return nil, nil
+		}
+		return nil, NewError(EcodeBackendError, err.Error())
+	}
+
+	operations := []*metaproto.Operation{}
+	for i := 0; i < len(keys); i++ {
+		o, err := GetOperation(filepath.Base(keys[i]))
+		if err != nil {
+			// dropped since listed
+			if e, ok := err.(*Error); ok && e.Code == EcodeOperationNotFound {
+				continue
+			}
+			return nil, err
+		}
+		operations = append(operations, o)
+	}
+	return operations, nil
+}
@@ -6,13 +6,17 @@
 Package metaproto is a generated protocol buffer package.
 
 It is generated from these files:
+
 	meta.proto
 
 It has these top-level messages:
+
 	Host
 	Device
 	Container
 	Volume
+	Snapshot
+	Backup
 */
 package metaproto
 
@@ -29,12 +33,211 @@ var _ = math.Inf
 // is compatible with the proto package it is being compiled against.
 const _ = proto.ProtoPackageIsVersion1
 
+type HostStatus int32
+
+const (
+	HostStatus_HOST_ONLINE      HostStatus = 1
+	HostStatus_HOST_OFFLINE     HostStatus = 2
+	HostStatus_HOST_DEGRADE     HostStatus = 3
+	HostStatus_HOST_ERROR       HostStatus = 4
+	HostStatus_HOST_MAINTENANCE HostStatus = 5
+)
+
+var HostStatus_name = map[int32]string{
+	1: "HOST_ONLINE",
+	2: "HOST_OFFLINE",
+	3: "HOST_DEGRADE",
+	4: "HOST_ERROR",
+	5: "HOST_MAINTENANCE",
+}
+var HostStatus_value = map[string]int32{
+	"HOST_ONLINE":      1,
+	"HOST_OFFLINE":     2,
+	"HOST_DEGRADE":     3,
+	"HOST_ERROR":       4,
+	"HOST_MAINTENANCE": 5,
+}
+
+func (x HostStatus) Enum() *HostStatus {
+	p := new(HostStatus)
+	*p = x
+	return p
+}
+func (x HostStatus) String() string {
+	return proto.EnumName(HostStatus_name, int32(x))
+}
+func (x *HostStatus) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(HostStatus_value, data, "HostStatus")
+	if err != nil {
+		return err
+	}
+	*x = HostStatus(value)
+	return nil
+}
+func (HostStatus) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+type DeviceStatus int32
+
+const (
+	DeviceStatus_DEVICE_INUSE       DeviceStatus = 11
+	DeviceStatus_DEVICE_READY       DeviceStatus = 12
+	DeviceStatus_DEVICE_OFFLINE     DeviceStatus = 13
+	DeviceStatus_DEVICE_UNKNOWN     DeviceStatus = 14
+	DeviceStatus_DEVICE_MAINTENANCE DeviceStatus = 15
+)
+
+var DeviceStatus_name = map[int32]string{
+	11: "DEVICE_INUSE",
+	12: "DEVICE_READY",
+	13: "DEVICE_OFFLINE",
+	14: "DEVICE_UNKNOWN",
+	15: "DEVICE_MAINTENANCE",
+}
+var DeviceStatus_value = map[string]int32{
+	"DEVICE_INUSE":       11,
+	"DEVICE_READY":       12,
+	"DEVICE_OFFLINE":     13,
+	"DEVICE_UNKNOWN":     14,
+	"DEVICE_MAINTENANCE": 15,
+}
+
+func (x DeviceStatus) Enum() *DeviceStatus {
+	p := new(DeviceStatus)
+	*p = x
+	return p
+}
+func (x DeviceStatus) String() string {
+	return proto.EnumName(DeviceStatus_name, int32(x))
+}
+func (x *DeviceStatus) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(DeviceStatus_value, data, "DeviceStatus")
+	if err != nil {
+		return err
+	}
+	*x = DeviceStatus(value)
+	return nil
+}
+func (DeviceStatus) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+type ContainerStatus int32
+
+const (
+	ContainerStatus_CONTAINER_ONLINE  ContainerStatus = 20
+	ContainerStatus_CONTAINER_OFFLINE ContainerStatus = 21
+)
+
+var ContainerStatus_name = map[int32]string{
+	20: "CONTAINER_ONLINE",
+	21: "CONTAINER_OFFLINE",
+}
+var ContainerStatus_value = map[string]int32{
+	"CONTAINER_ONLINE":  20,
+	"CONTAINER_OFFLINE": 21,
+}
+
+func (x ContainerStatus) Enum() *ContainerStatus {
+	p := new(ContainerStatus)
+	*p = x
+	return p
+}
+func (x ContainerStatus) String() string {
+	return proto.EnumName(ContainerStatus_name, int32(x))
+}
+func (x *ContainerStatus) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(ContainerStatus_value, data, "ContainerStatus")
+	if err != nil {
+		return err
+	}
+	*x = ContainerStatus(value)
+	return nil
+}
+func (ContainerStatus) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+type VolumeStatus int32
+
+const (
+	VolumeStatus_VOLUME_ONLINE    VolumeStatus = 30
+	VolumeStatus_VOLUME_UNKNOWN   VolumeStatus = 31
+	VolumeStatus_VOLUME_INUSE     VolumeStatus = 32
+	VolumeStatus_VOLUME_MIGRATING VolumeStatus = 33
+	VolumeStatus_VOLUME_CREATING  VolumeStatus = 34
+	VolumeStatus_VOLUME_DELETING  VolumeStatus = 35
+)
+
+var VolumeStatus_name = map[int32]string{
+	30: "VOLUME_ONLINE",
+	31: "VOLUME_UNKNOWN",
+	32: "VOLUME_INUSE",
+	33: "VOLUME_MIGRATING",
+	34: "VOLUME_CREATING",
+	35: "VOLUME_DELETING",
+}
+var VolumeStatus_value = map[string]int32{
+	"VOLUME_ONLINE":    30,
+	"VOLUME_UNKNOWN":   31,
+	"VOLUME_INUSE":     32,
+	"VOLUME_MIGRATING": 33,
+	"VOLUME_CREATING":  34,
+	"VOLUME_DELETING":  35,
+}
+
+func (x VolumeStatus) Enum() *VolumeStatus {
+	p := new(VolumeStatus)
+	*p = x
+	return p
+}
+func (x VolumeStatus) String() string {
+	return proto.EnumName(VolumeStatus_name, int32(x))
+}
+func (x *VolumeStatus) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(VolumeStatus_value, data, "VolumeStatus")
+	if err != nil {
+		return err
+	}
+	*x = VolumeStatus(value)
+	return nil
+}
+func (VolumeStatus) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+type SnapshotStatus int32
+
+const (
+	SnapshotStatus_SNAPSHOT_READY SnapshotStatus = 40
+)
+
+var SnapshotStatus_name = map[int32]string{
+	40: "SNAPSHOT_READY",
+}
+var SnapshotStatus_value = map[string]int32{
+	"SNAPSHOT_READY": 40,
+}
+
+func (x SnapshotStatus) Enum() *SnapshotStatus {
+	p := new(SnapshotStatus)
+	*p = x
+	return p
+}
+func (x SnapshotStatus) String() string {
+	return proto.EnumName(SnapshotStatus_name, int32(x))
+}
+func (x *SnapshotStatus) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(SnapshotStatus_value, data, "SnapshotStatus")
+	if err != nil {
+		return err
+	}
+	*x = SnapshotStatus(value)
+	return nil
+}
+func (SnapshotStatus) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
 type Host struct {
-	Ip               []byte   `protobuf:"bytes,1,opt,name=ip" json:"ip,omitempty"`
-	Status           []byte   `protobuf:"bytes,2,opt,name=status" json:"status,omitempty"`
-	Optime           []byte   `protobuf:"bytes,3,opt,name=optime" json:"optime,omitempty"`
-	Devices          [][]byte `protobuf:"bytes,4,rep,name=devices" json:"devices,omitempty"`
-	XXX_unrecognized []byte   `json:"-"`
+	Ip               []byte      `protobuf:"bytes,1,opt,name=ip" json:"ip,omitempty"`
+	Status           []byte      `protobuf:"bytes,2,opt,name=status" json:"status,omitempty"`
+	Optime           []byte      `protobuf:"bytes,3,opt,name=optime" json:"optime,omitempty"`
+	Devices          [][]byte    `protobuf:"bytes,4,rep,name=devices" json:"devices,omitempty"`
+	StatusV2         *HostStatus `protobuf:"varint,5,opt,name=status_v2,enum=metaproto.HostStatus" json:"status_v2,omitempty"`
+	OptimeV2         *int64      `protobuf:"varint,6,opt,name=optime_v2" json:"optime_v2,omitempty"`
+	XXX_unrecognized []byte      `json:"-"`
 }
 
 func (m *Host) Reset()                    { *m = Host{} }
@@ -70,18 +273,37 @@ func (m *Host) GetDevices() [][]byte {
 	return nil
 }
 
+func (m *Host) GetStatusV2() HostStatus {
+	if m != nil && m.StatusV2 != nil {
+		return *m.StatusV2
+	}
+	return HostStatus_HOST_ONLINE
+}
+
+func (m *Host) GetOptimeV2() int64 {
+	if m != nil && m.OptimeV2 != nil {
+		return *m.OptimeV2
+	}
+	return 0
+}
+
 type Device struct {
-	Id               []byte `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
-	Host             []byte `protobuf:"bytes,2,opt,name=host" json:"host,omitempty"`
-	Port             []byte `protobuf:"bytes,3,opt,name=port" json:"port,omitempty"`
-	Total            []byte `protobuf:"bytes,4,opt,name=total" json:"total,omitempty"`
-	Free             []byte `protobuf:"bytes,5,opt,name=free" json:"free,omitempty"`
-	Status           []byte `protobuf:"bytes,6,opt,name=status" json:"status,omitempty"`
-	Identify         []byte `protobuf:"bytes,7,opt,name=identify" json:"identify,omitempty"`
-	Volumekey        []byte `protobuf:"bytes,8,opt,name=volumekey" json:"volumekey,omitempty"`
-	Backend          []byte `protobuf:"bytes,9,opt,name=backend" json:"backend,omitempty"`
-	Optime           []byte `protobuf:"bytes,10,opt,name=optime" json:"optime,omitempty"`
-	XXX_unrecognized []byte `json:"-"`
+	Id               []byte        `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Host             []byte        `protobuf:"bytes,2,opt,name=host" json:"host,omitempty"`
+	Port             []byte        `protobuf:"bytes,3,opt,name=port" json:"port,omitempty"`
+	Total            []byte        `protobuf:"bytes,4,opt,name=total" json:"total,omitempty"`
+	Free             []byte        `protobuf:"bytes,5,opt,name=free" json:"free,omitempty"`
+	Status           []byte        `protobuf:"bytes,6,opt,name=status" json:"status,omitempty"`
+	Identify         []byte        `protobuf:"bytes,7,opt,name=identify" json:"identify,omitempty"`
+	Volumekey        []byte        `protobuf:"bytes,8,opt,name=volumekey" json:"volumekey,omitempty"`
+	Backend          []byte        `protobuf:"bytes,9,opt,name=backend" json:"backend,omitempty"`
+	Optime           []byte        `protobuf:"bytes,10,opt,name=optime" json:"optime,omitempty"`
+	PortV2           *int32        `protobuf:"varint,11,opt,name=port_v2" json:"port_v2,omitempty"`
+	TotalV2          *int64        `protobuf:"varint,12,opt,name=total_v2" json:"total_v2,omitempty"`
+	FreeV2           *int64        `protobuf:"varint,13,opt,name=free_v2" json:"free_v2,omitempty"`
+	StatusV2         *DeviceStatus `protobuf:"varint,14,opt,name=status_v2,enum=metaproto.DeviceStatus" json:"status_v2,omitempty"`
+	OptimeV2         *int64        `protobuf:"varint,15,opt,name=optime_v2" json:"optime_v2,omitempty"`
+	XXX_unrecognized []byte        `json:"-"`
 }
 
 func (m *Device) Reset()                    { *m = Device{} }
@@ -159,11 +381,48 @@ func (m *Device) GetOptime() []byte {
 	return nil
 }
 
+func (m *Device) GetPortV2() int32 {
+	if m != nil && m.PortV2 != nil {
+		return *m.PortV2
+	}
+	return 0
+}
+
+func (m *Device) GetTotalV2() int64 {
+	if m != nil && m.TotalV2 != nil {
+		return *m.TotalV2
+	}
+	return 0
+}
+
+func (m *Device) GetFreeV2() int64 {
+	if m != nil && m.FreeV2 != nil {
+		return *m.FreeV2
+	}
+	return 0
+}
+
+func (m *Device) GetStatusV2() DeviceStatus {
+	if m != nil && m.StatusV2 != nil {
+		return *m.StatusV2
+	}
+	return DeviceStatus_DEVICE_INUSE
+}
+
+func (m *Device) GetOptimeV2() int64 {
+	if m != nil && m.OptimeV2 != nil {
+		return *m.OptimeV2
+	}
+	return 0
+}
+
 type Container struct {
 	Id               []byte                    `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
 	Status           []byte                    `protobuf:"bytes,2,opt,name=status" json:"status,omitempty"`
 	Optime           []byte                    `protobuf:"bytes,3,opt,name=optime" json:"optime,omitempty"`
 	Volumes          []*Container_AttachVolume `protobuf:"bytes,4,rep,name=volumes" json:"volumes,omitempty"`
+	StatusV2         *ContainerStatus          `protobuf:"varint,5,opt,name=status_v2,enum=metaproto.ContainerStatus" json:"status_v2,omitempty"`
+	OptimeV2         *int64                    `protobuf:"varint,6,opt,name=optime_v2" json:"optime_v2,omitempty"`
 	XXX_unrecognized []byte                    `json:"-"`
 }
 
@@ -200,6 +459,20 @@ func (m *Container) GetVolumes() []*Container_AttachVolume {
 	return nil
 }
 
+func (m *Container) GetStatusV2() ContainerStatus {
+	if m != nil && m.StatusV2 != nil {
+		return *m.StatusV2
+	}
+	return ContainerStatus_CONTAINER_ONLINE
+}
+
+func (m *Container) GetOptimeV2() int64 {
+	if m != nil && m.OptimeV2 != nil {
+		return *m.OptimeV2
+	}
+	return 0
+}
+
 type Container_AttachVolume struct {
 	Volumeid         []byte `protobuf:"bytes,1,opt,name=volumeid" json:"volumeid,omitempty"`
 	Mode             []byte `protobuf:"bytes,2,opt,name=mode" json:"mode,omitempty"`
@@ -241,6 +514,9 @@ type Volume struct {
 	Optime           []byte                   `protobuf:"bytes,5,opt,name=optime" json:"optime,omitempty"`
 	Containers       []*Volume_OwnerContainer `protobuf:"bytes,6,rep,name=containers" json:"containers,omitempty"`
 	Devices          []*Volume_AttachDevice   `protobuf:"bytes,7,rep,name=devices" json:"devices,omitempty"`
+	StatusV2         *VolumeStatus            `protobuf:"varint,8,opt,name=status_v2,enum=metaproto.VolumeStatus" json:"status_v2,omitempty"`
+	CapacityV2       *int64                   `protobuf:"varint,9,opt,name=capacity_v2" json:"capacity_v2,omitempty"`
+	OptimeV2         *int64                   `protobuf:"varint,10,opt,name=optime_v2" json:"optime_v2,omitempty"`
 	XXX_unrecognized []byte                   `json:"-"`
 }
 
@@ -298,9 +574,31 @@ func (m *Volume) GetDevices() []*Volume_AttachDevice {
 	return nil
 }
 
+func (m *Volume) GetStatusV2() VolumeStatus {
+	if m != nil && m.StatusV2 != nil {
+		return *m.StatusV2
+	}
+	return VolumeStatus_VOLUME_ONLINE
+}
+
+func (m *Volume) GetCapacityV2() int64 {
+	if m != nil && m.CapacityV2 != nil {
+		return *m.CapacityV2
+	}
+	return 0
+}
+
+func (m *Volume) GetOptimeV2() int64 {
+	if m != nil && m.OptimeV2 != nil {
+		return *m.OptimeV2
+	}
+	return 0
+}
+
 type Volume_OwnerContainer struct {
 	Containerid      []byte `protobuf:"bytes,1,opt,name=containerid" json:"containerid,omitempty"`
 	Mode             []byte `protobuf:"bytes,2,opt,name=mode" json:"mode,omitempty"`
+	Host             []byte `protobuf:"bytes,3,opt,name=host" json:"host,omitempty"`
 	XXX_unrecognized []byte `json:"-"`
 }
 
@@ -323,9 +621,18 @@ func (m *Volume_OwnerContainer) GetMode() []byte {
 	return nil
 }
 
+func (m *Volume_OwnerContainer) GetHost() []byte {
+	if m != nil {
+		return m.Host
+	}
+	return nil
+}
+
 type Volume_AttachDevice struct {
 	Deviceid         []byte `protobuf:"bytes,1,opt,name=deviceid" json:"deviceid,omitempty"`
 	Status           []byte `protobuf:"bytes,2,opt,name=status" json:"status,omitempty"`
+	Target           []byte `protobuf:"bytes,3,opt,name=target" json:"target,omitempty"`
+	Lun              []byte `protobuf:"bytes,4,opt,name=lun" json:"lun,omitempty"`
 	XXX_unrecognized []byte `json:"-"`
 }
 
@@ -348,6 +655,548 @@ func (m *Volume_AttachDevice) GetStatus() []byte {
 	return nil
 }
 
+func (m *Volume_AttachDevice) GetTarget() []byte {
+	if m != nil {
+		return m.Target
+	}
+	return nil
+}
+
+func (m *Volume_AttachDevice) GetLun() []byte {
+	if m != nil {
+		return m.Lun
+	}
+	return nil
+}
+
+type Snapshot struct {
+	Id               []byte          `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Volumeid         []byte          `protobuf:"bytes,2,opt,name=volumeid" json:"volumeid,omitempty"`
+	Size             []byte          `protobuf:"bytes,3,opt,name=size" json:"size,omitempty"`
+	Status           []byte          `protobuf:"bytes,4,opt,name=status" json:"status,omitempty"`
+	Optime           []byte          `protobuf:"bytes,5,opt,name=optime" json:"optime,omitempty"`
+	SizeV2           *int64          `protobuf:"varint,6,opt,name=size_v2" json:"size_v2,omitempty"`
+	StatusV2         *SnapshotStatus `protobuf:"varint,7,opt,name=status_v2,enum=metaproto.SnapshotStatus" json:"status_v2,omitempty"`
+	OptimeV2         *int64          `protobuf:"varint,8,opt,name=optime_v2" json:"optime_v2,omitempty"`
+	XXX_unrecognized []byte          `json:"-"`
+}
+
+func (m *Snapshot) Reset()                    { *m = Snapshot{} }
+func (m *Snapshot) String() string            { return proto.CompactTextString(m) }
+func (*Snapshot) ProtoMessage()               {}
+func (*Snapshot) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *Snapshot) GetId() []byte {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *Snapshot) GetVolumeid() []byte {
+	if m != nil {
+		return m.Volumeid
+	}
+	return nil
+}
+
+func (m *Snapshot) GetSize() []byte {
+	if m != nil {
+		return m.Size
+	}
+	return nil
+}
+
+func (m *Snapshot) GetStatus() []byte {
+	if m != nil {
+		return m.Status
+	}
+	return nil
+}
+
+func (m *Snapshot) GetOptime() []byte {
+	if m != nil {
+		return m.Optime
+	}
+	return nil
+}
+
+func (m *Snapshot) GetSizeV2() int64 {
+	if m != nil && m.SizeV2 != nil {
+		return *m.SizeV2
+	}
+	return 0
+}
+
+func (m *Snapshot) GetStatusV2() SnapshotStatus {
+	if m != nil && m.StatusV2 != nil {
+		return *m.StatusV2
+	}
+	return SnapshotStatus_SNAPSHOT_READY
+}
+
+func (m *Snapshot) GetOptimeV2() int64 {
+	if m != nil && m.OptimeV2 != nil {
+		return *m.OptimeV2
+	}
+	return 0
+}
+
+type Backup struct {
+	Id               []byte `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Volumeid         []byte `protobuf:"bytes,2,opt,name=volumeid" json:"volumeid,omitempty"`
+	Driver           []byte `protobuf:"bytes,3,opt,name=driver" json:"driver,omitempty"`
+	Parent           []byte `protobuf:"bytes,4,opt,name=parent" json:"parent,omitempty"`
+	Snapshot         []byte `protobuf:"bytes,5,opt,name=snapshot" json:"snapshot,omitempty"`
+	Capacity         []byte `protobuf:"bytes,6,opt,name=capacity" json:"capacity,omitempty"`
+	Size             []byte `protobuf:"bytes,7,opt,name=size" json:"size,omitempty"`
+	Store            []byte `protobuf:"bytes,8,opt,name=store" json:"store,omitempty"`
+	Optime           []byte `protobuf:"bytes,9,opt,name=optime" json:"optime,omitempty"`
+	CapacityV2       *int64 `protobuf:"varint,10,opt,name=capacity_v2" json:"capacity_v2,omitempty"`
+	SizeV2           *int64 `protobuf:"varint,11,opt,name=size_v2" json:"size_v2,omitempty"`
+	OptimeV2         *int64 `protobuf:"varint,12,opt,name=optime_v2" json:"optime_v2,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *Backup) Reset()                    { *m = Backup{} }
+func (m *Backup) String() string            { return proto.CompactTextString(m) }
+func (*Backup) ProtoMessage()               {}
+func (*Backup) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *Backup) GetId() []byte {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *Backup) GetVolumeid() []byte {
+	if m != nil {
+		return m.Volumeid
+	}
+	return nil
+}
+
+func (m *Backup) GetDriver() []byte {
+	if m != nil {
+		return m.Driver
+	}
+	return nil
+}
+
+func (m *Backup) GetParent() []byte {
+	if m != nil {
+		return m.Parent
+	}
+	return nil
+}
+
+func (m *Backup) GetSnapshot() []byte {
+	if m != nil {
+		return m.Snapshot
+	}
+	return nil
+}
+
+func (m *Backup) GetCapacity() []byte {
+	if m != nil {
+		return m.Capacity
+	}
+	return nil
+}
+
+func (m *Backup) GetSize() []byte {
+	if m != nil {
+		return m.Size
+	}
+	return nil
+}
+
+func (m *Backup) GetStore() []byte {
+	if m != nil {
+		return m.Store
+	}
+	return nil
+}
+
+func (m *Backup) GetOptime() []byte {
+	if m != nil {
+		return m.Optime
+	}
+	return nil
+}
+
+func (m *Backup) GetCapacityV2() int64 {
+	if m != nil && m.CapacityV2 != nil {
+		return *m.CapacityV2
+	}
+	return 0
+}
+
+func (m *Backup) GetSizeV2() int64 {
+	if m != nil && m.SizeV2 != nil {
+		return *m.SizeV2
+	}
+	return 0
+}
+
+func (m *Backup) GetOptimeV2() int64 {
+	if m != nil && m.OptimeV2 != nil {
+		return *m.OptimeV2
+	}
+	return 0
+}
+
+type Event struct {
+	Id               []byte `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Kind             []byte `protobuf:"bytes,2,opt,name=kind" json:"kind,omitempty"`
+	Object           []byte `protobuf:"bytes,3,opt,name=object" json:"object,omitempty"`
+	Message          []byte `protobuf:"bytes,4,opt,name=message" json:"message,omitempty"`
+	Optime           []byte `protobuf:"bytes,5,opt,name=optime" json:"optime,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *Event) Reset()                    { *m = Event{} }
+func (m *Event) String() string            { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()               {}
+func (*Event) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *Event) GetId() []byte {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *Event) GetKind() []byte {
+	if m != nil {
+		return m.Kind
+	}
+	return nil
+}
+
+func (m *Event) GetObject() []byte {
+	if m != nil {
+		return m.Object
+	}
+	return nil
+}
+
+func (m *Event) GetMessage() []byte {
+	if m != nil {
+		return m.Message
+	}
+	return nil
+}
+
+func (m *Event) GetOptime() []byte {
+	if m != nil {
+		return m.Optime
+	}
+	return nil
+}
+
+type Leader struct {
+	Id               []byte `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Expire           []byte `protobuf:"bytes,2,opt,name=expire" json:"expire,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *Leader) Reset()                    { *m = Leader{} }
+func (m *Leader) String() string            { return proto.CompactTextString(m) }
+func (*Leader) ProtoMessage()               {}
+func (*Leader) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *Leader) GetId() []byte {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *Leader) GetExpire() []byte {
+	if m != nil {
+		return m.Expire
+	}
+	return nil
+}
+
+type Schema struct {
+	Version          *int64 `protobuf:"varint,1,opt,name=version" json:"version,omitempty"`
+	Cursor           []byte `protobuf:"bytes,2,opt,name=cursor" json:"cursor,omitempty"`
+	Optime           *int64 `protobuf:"varint,3,opt,name=optime" json:"optime,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *Schema) Reset()                    { *m = Schema{} }
+func (m *Schema) String() string            { return proto.CompactTextString(m) }
+func (*Schema) ProtoMessage()               {}
+func (*Schema) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *Schema) GetVersion() int64 {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return 0
+}
+
+func (m *Schema) GetCursor() []byte {
+	if m != nil {
+		return m.Cursor
+	}
+	return nil
+}
+
+func (m *Schema) GetOptime() int64 {
+	if m != nil && m.Optime != nil {
+		return *m.Optime
+	}
+	return 0
+}
+
+type Idempotency struct {
+	Key              []byte `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Fingerprint      []byte `protobuf:"bytes,2,opt,name=fingerprint" json:"fingerprint,omitempty"`
+	Status           *int64 `protobuf:"varint,3,opt,name=status" json:"status,omitempty"`
+	Response         []byte `protobuf:"bytes,4,opt,name=response" json:"response,omitempty"`
+	Expire           *int64 `protobuf:"varint,5,opt,name=expire" json:"expire,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *Idempotency) Reset()                    { *m = Idempotency{} }
+func (m *Idempotency) String() string            { return proto.CompactTextString(m) }
+func (*Idempotency) ProtoMessage()               {}
+func (*Idempotency) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *Idempotency) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *Idempotency) GetFingerprint() []byte {
+	if m != nil {
+		return m.Fingerprint
+	}
+	return nil
+}
+
+func (m *Idempotency) GetStatus() int64 {
+	if m != nil && m.Status != nil {
+		return *m.Status
+	}
+	return 0
+}
+
+func (m *Idempotency) GetResponse() []byte {
+	if m != nil {
+		return m.Response
+	}
+	return nil
+}
+
+func (m *Idempotency) GetExpire() int64 {
+	if m != nil && m.Expire != nil {
+		return *m.Expire
+	}
+	return 0
+}
+
+type Migration struct {
+	Volumeid         []byte `protobuf:"bytes,1,opt,name=volumeid" json:"volumeid,omitempty"`
+	Driver           []byte `protobuf:"bytes,2,opt,name=driver" json:"driver,omitempty"`
+	Host             []byte `protobuf:"bytes,3,opt,name=host" json:"host,omitempty"`
+	From             []byte `protobuf:"bytes,4,opt,name=from" json:"from,omitempty"`
+	To               []byte `protobuf:"bytes,5,opt,name=to" json:"to,omitempty"`
+	Status           []byte `protobuf:"bytes,6,opt,name=status" json:"status,omitempty"`
+	Done             *int64 `protobuf:"varint,7,opt,name=done" json:"done,omitempty"`
+	Total            *int64 `protobuf:"varint,8,opt,name=total" json:"total,omitempty"`
+	Error            []byte `protobuf:"bytes,9,opt,name=error" json:"error,omitempty"`
+	Optime           []byte `protobuf:"bytes,10,opt,name=optime" json:"optime,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *Migration) Reset()                    { *m = Migration{} }
+func (m *Migration) String() string            { return proto.CompactTextString(m) }
+func (*Migration) ProtoMessage()               {}
+func (*Migration) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *Migration) GetVolumeid() []byte {
+	if m != nil {
+		return m.Volumeid
+	}
+	return nil
+}
+
+func (m *Migration) GetDriver() []byte {
+	if m != nil {
+		return m.Driver
+	}
+	return nil
+}
+
+func (m *Migration) GetHost() []byte {
+	if m != nil {
+		return m.Host
+	}
+	return nil
+}
+
+func (m *Migration) GetFrom() []byte {
+	if m != nil {
+		return m.From
+	}
+	return nil
+}
+
+func (m *Migration) GetTo() []byte {
+	if m != nil {
+		return m.To
+	}
+	return nil
+}
+
+func (m *Migration) GetStatus() []byte {
+	if m != nil {
+		return m.Status
+	}
+	return nil
+}
+
+func (m *Migration) GetDone() int64 {
+	if m != nil && m.Done != nil {
+		return *m.Done
+	}
+	return 0
+}
+
+func (m *Migration) GetTotal() int64 {
+	if m != nil && m.Total != nil {
+		return *m.Total
+	}
+	return 0
+}
+
+func (m *Migration) GetError() []byte {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+func (m *Migration) GetOptime() []byte {
+	if m != nil {
+		return m.Optime
+	}
+	return nil
+}
+
+type Operation struct {
+	Id               []byte                 `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Kind             []byte                 `protobuf:"bytes,2,opt,name=kind" json:"kind,omitempty"`
+	Volumeid         []byte                 `protobuf:"bytes,3,opt,name=volumeid" json:"volumeid,omitempty"`
+	Driver           []byte                 `protobuf:"bytes,4,opt,name=driver" json:"driver,omitempty"`
+	Host             []byte                 `protobuf:"bytes,5,opt,name=host" json:"host,omitempty"`
+	Status           []byte                 `protobuf:"bytes,6,opt,name=status" json:"status,omitempty"`
+	Result           *int64                 `protobuf:"varint,7,opt,name=result" json:"result,omitempty"`
+	Request          []byte                 `protobuf:"bytes,8,opt,name=request" json:"request,omitempty"`
+	Owner            *Volume_OwnerContainer `protobuf:"bytes,9,opt,name=owner" json:"owner,omitempty"`
+	Response         []byte                 `protobuf:"bytes,10,opt,name=response" json:"response,omitempty"`
+	Created          *int64                 `protobuf:"varint,11,opt,name=created" json:"created,omitempty"`
+	Ended            *int64                 `protobuf:"varint,12,opt,name=ended" json:"ended,omitempty"`
+	XXX_unrecognized []byte                 `json:"-"`
+}
+
+func (m *Operation) Reset()                    { *m = Operation{} }
+func (m *Operation) String() string            { return proto.CompactTextString(m) }
+func (*Operation) ProtoMessage()               {}
+func (*Operation) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *Operation) GetId() []byte {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *Operation) GetKind() []byte {
+	if m != nil {
+		return m.Kind
+	}
+	return nil
+}
+
+func (m *Operation) GetVolumeid() []byte {
+	if m != nil {
+		return m.Volumeid
+	}
+	return nil
+}
+
+func (m *Operation) GetDriver() []byte {
+	if m != nil {
+		return m.Driver
+	}
+	return nil
+}
+
+func (m *Operation) GetHost() []byte {
+	if m != nil {
+		return m.Host
+	}
+	return nil
+}
+
+func (m *Operation) GetStatus() []byte {
+	if m != nil {
+		return m.Status
+	}
+	return nil
+}
+
+func (m *Operation) GetResult() int64 {
+	if m != nil && m.Result != nil {
+		return *m.Result
+	}
+	return 0
+}
+
+func (m *Operation) GetRequest() []byte {
+	if m != nil {
+		return m.Request
+	}
+	return nil
+}
+
+func (m *Operation) GetOwner() *Volume_OwnerContainer {
+	if m != nil {
+		return m.Owner
+	}
+	return nil
+}
+
+func (m *Operation) GetResponse() []byte {
+	if m != nil {
+		return m.Response
+	}
+	return nil
+}
+
+func (m *Operation) GetCreated() int64 {
+	if m != nil && m.Created != nil {
+		return *m.Created
+	}
+	return 0
+}
+
+func (m *Operation) GetEnded() int64 {
+	if m != nil && m.Ended != nil {
+		return *m.Ended
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Host)(nil), "metaproto.Host")
 	proto.RegisterType((*Device)(nil), "metaproto.Device")
@@ -356,30 +1205,88 @@ func init() {
 	proto.RegisterType((*Volume)(nil), "metaproto.Volume")
 	proto.RegisterType((*Volume_OwnerContainer)(nil), "metaproto.Volume.OwnerContainer")
 	proto.RegisterType((*Volume_AttachDevice)(nil), "metaproto.Volume.AttachDevice")
+	proto.RegisterType((*Snapshot)(nil), "metaproto.Snapshot")
+	proto.RegisterType((*Backup)(nil), "metaproto.Backup")
+	proto.RegisterType((*Event)(nil), "metaproto.Event")
+	proto.RegisterType((*Leader)(nil), "metaproto.Leader")
+	proto.RegisterType((*Schema)(nil), "metaproto.Schema")
+	proto.RegisterType((*Idempotency)(nil), "metaproto.Idempotency")
+	proto.RegisterType((*Migration)(nil), "metaproto.Migration")
+	proto.RegisterType((*Operation)(nil), "metaproto.Operation")
+	proto.RegisterEnum("metaproto.HostStatus", HostStatus_name, HostStatus_value)
+	proto.RegisterEnum("metaproto.DeviceStatus", DeviceStatus_name, DeviceStatus_value)
+	proto.RegisterEnum("metaproto.ContainerStatus", ContainerStatus_name, ContainerStatus_value)
+	proto.RegisterEnum("metaproto.VolumeStatus", VolumeStatus_name, VolumeStatus_value)
+	proto.RegisterEnum("metaproto.SnapshotStatus", SnapshotStatus_name, SnapshotStatus_value)
 }
 
 var fileDescriptor0 = []byte{
-	// 351 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x84, 0x52, 0x3d, 0x6f, 0xf2, 0x30,
-	0x10, 0x56, 0xf8, 0x48, 0xc8, 0x91, 0x97, 0xb7, 0xa5, 0x8b, 0xc5, 0x50, 0xd1, 0x4c, 0x4c, 0x69,
-	0x45, 0x3b, 0x57, 0xaa, 0xe8, 0xd0, 0xad, 0x5b, 0x77, 0x93, 0x1c, 0xc2, 0x82, 0xc4, 0x51, 0x72,
-	0x80, 0xf8, 0x3d, 0x95, 0xba, 0xf4, 0x4f, 0xd6, 0xb1, 0x1d, 0x42, 0xe9, 0xd7, 0xe6, 0xe7, 0x9c,
-	0x7b, 0xbe, 0x62, 0x80, 0x14, 0x89, 0x47, 0x79, 0x21, 0x49, 0x0e, 0xfd, 0xea, 0xac, 0x8f, 0xe1,
-	0x0c, 0x3a, 0x4f, 0xb2, 0xa4, 0x21, 0x40, 0x4b, 0xe4, 0xcc, 0x19, 0x3b, 0x93, 0x60, 0x38, 0x00,
-	0xb7, 0x24, 0x4e, 0x9b, 0x92, 0xb5, 0x6a, 0x2c, 0x73, 0x12, 0x29, 0xb2, 0xb6, 0xc6, 0xff, 0xc1,
-	0x4b, 0x70, 0x2b, 0x62, 0x2c, 0x59, 0x67, 0xdc, 0x9e, 0x04, 0xe1, 0xab, 0x03, 0xee, 0xa3, 0x9e,
-	0x68, 0x9e, 0xc4, 0xf2, 0x04, 0xd0, 0x59, 0x2a, 0x6e, 0xcb, 0xa2, 0x50, 0x2e, 0x0b, 0xb2, 0x1c,
-	0xff, 0xa0, 0x4b, 0x92, 0xf8, 0x5a, 0x31, 0xd8, 0xcb, 0x45, 0x81, 0xc8, 0xba, 0x27, 0x06, 0x5c,
-	0x8d, 0xcf, 0xa0, 0x27, 0x12, 0xcc, 0x48, 0x2c, 0xf6, 0xcc, 0xd3, 0x93, 0x73, 0xf0, 0xb7, 0x72,
-	0xbd, 0x49, 0x71, 0x85, 0x7b, 0xd6, 0xab, 0x5d, 0xcd, 0x79, 0xbc, 0xc2, 0x2c, 0x61, 0xfe, 0x89,
-	0x6d, 0xa8, 0x70, 0xf8, 0xee, 0x80, 0x3f, 0x93, 0x19, 0x71, 0x91, 0x61, 0xf1, 0xc9, 0xe8, 0x5f,
-	0x81, 0xa7, 0xe0, 0x19, 0x35, 0x13, 0xb8, 0x3f, 0xbd, 0x8a, 0x0e, 0x0d, 0x46, 0x07, 0xca, 0xe8,
-	0x81, 0x88, 0xc7, 0xcb, 0x17, 0xfd, 0xe5, 0xe8, 0x1e, 0x82, 0x63, 0x5c, 0x65, 0x30, 0x1c, 0xc7,
-	0xf5, 0xa4, 0x32, 0xc1, 0x46, 0x33, 0x29, 0xc4, 0x16, 0x0b, 0xa3, 0x19, 0xbe, 0xb5, 0xc0, 0xb5,
-	0xab, 0xbf, 0x59, 0x55, 0xb4, 0x31, 0xcf, 0x79, 0x2c, 0x68, 0x6f, 0xcd, 0xaa, 0xc9, 0xae, 0x10,
-	0xc4, 0xe7, 0x6b, 0xb4, 0xe5, 0x36, 0x71, 0x4c, 0xbd, 0x77, 0x00, 0x71, 0x6d, 0xba, 0xaa, 0xb8,
-	0x4a, 0x34, 0x3e, 0x4a, 0x64, 0x64, 0xa3, 0xe7, 0x9d, 0xba, 0x6f, 0x0a, 0xbb, 0x6e, 0xfe, 0xba,
-	0xa7, 0x57, 0x2e, 0xbf, 0xae, 0x98, 0xc4, 0xe6, 0x29, 0x8c, 0x6e, 0x61, 0x70, 0x42, 0x71, 0x01,
-	0xfd, 0x83, 0xf0, 0xf7, 0x35, 0x8c, 0x6e, 0xea, 0xda, 0xec, 0x7b, 0x52, 0x69, 0x8c, 0xea, 0x4f,
-	0x0d, 0x7c, 0x04, 0x00, 0x00, 0xff, 0xff, 0xcc, 0x51, 0x72, 0x94, 0xd9, 0x02, 0x00, 0x00,
+	// 1065 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x55, 0xcd, 0x72, 0xe2, 0x46,
+	0x17, 0x2d, 0x19, 0x10, 0xe2, 0x22, 0x83, 0xac, 0xb1, 0xbf, 0x4f, 0xf1, 0x62, 0xc2, 0x28, 0xb3,
+	0xa0, 0xa8, 0xc4, 0x53, 0xe5, 0xca, 0x26, 0x9b, 0xa9, 0x22, 0x46, 0x63, 0x53, 0xb1, 0x45, 0x0a,
+	0x6c, 0xa7, 0xb2, 0x72, 0xc9, 0x52, 0x1b, 0x2b, 0x06, 0xb5, 0xd2, 0x6a, 0x98, 0x38, 0xab, 0xec,
+	0xf2, 0x06, 0xd9, 0x67, 0x91, 0xaa, 0x3c, 0x4e, 0x5e, 0x23, 0x8b, 0xbc, 0x43, 0xaa, 0xff, 0x40,
+	0x12, 0x30, 0x93, 0x1d, 0x7d, 0xe8, 0xee, 0x7b, 0xce, 0xb9, 0x47, 0xb7, 0x01, 0xe6, 0x88, 0x06,
+	0x27, 0x29, 0xc1, 0x14, 0xdb, 0x0d, 0xf6, 0x9b, 0xff, 0x74, 0x7f, 0xd1, 0xa0, 0x7a, 0x81, 0x33,
+	0x6a, 0x03, 0xec, 0xc5, 0xa9, 0xa3, 0x75, 0xb4, 0xae, 0x69, 0xb7, 0x40, 0xcf, 0x68, 0x40, 0x17,
+	0x99, 0xb3, 0xa7, 0xd6, 0x38, 0xa5, 0xf1, 0x1c, 0x39, 0x15, 0xbe, 0x6e, 0x43, 0x3d, 0x42, 0xcb,
+	0x38, 0x44, 0x99, 0x53, 0xed, 0x54, 0xba, 0xa6, 0xdd, 0x85, 0x86, 0x38, 0x70, 0xb7, 0x3c, 0x75,
+	0x6a, 0x1d, 0xad, 0xdb, 0x3a, 0x3d, 0x3a, 0x59, 0x15, 0x39, 0x61, 0x05, 0x26, 0xfc, 0x7f, 0xfb,
+	0x00, 0x1a, 0xe2, 0x2a, 0xb6, 0x53, 0xef, 0x68, 0xdd, 0x8a, 0xfb, 0xdb, 0x1e, 0xe8, 0x03, 0x7e,
+	0x1d, 0x27, 0x11, 0x49, 0x12, 0x26, 0x54, 0x1f, 0x71, 0x46, 0x25, 0x05, 0x13, 0xaa, 0x29, 0x26,
+	0x54, 0x12, 0xd8, 0x87, 0x1a, 0xc5, 0x34, 0x98, 0x39, 0x55, 0xf5, 0xe7, 0x03, 0x41, 0xc8, 0xa9,
+	0x29, 0xb6, 0x92, 0xbd, 0xce, 0xd7, 0x16, 0x18, 0x71, 0x84, 0x12, 0x1a, 0x3f, 0x3c, 0x3b, 0x75,
+	0x8e, 0x1c, 0x40, 0x63, 0x89, 0x67, 0x8b, 0x39, 0x7a, 0x42, 0xcf, 0x8e, 0xa1, 0x24, 0xdd, 0x07,
+	0xe1, 0x13, 0x4a, 0x22, 0xa7, 0x51, 0xd2, 0x0c, 0x6a, 0x03, 0x23, 0xc0, 0x68, 0x37, 0x3b, 0x5a,
+	0xb7, 0xc6, 0xae, 0xe5, 0x1c, 0x18, 0x62, 0x32, 0x21, 0x6c, 0x0b, 0xa3, 0xc1, 0x80, 0x7d, 0x0e,
+	0xf4, 0xf2, 0xb6, 0xb4, 0xb8, 0x2d, 0xff, 0xcf, 0xd9, 0x22, 0x44, 0x6f, 0x33, 0xa6, 0xcd, 0x8d,
+	0xf9, 0x47, 0x83, 0xc6, 0x19, 0x4e, 0x68, 0x10, 0x27, 0x88, 0x14, 0xbc, 0xf9, 0x58, 0x83, 0x4e,
+	0xa1, 0x2e, 0x04, 0x8a, 0x06, 0x35, 0x4f, 0x5f, 0xe5, 0xca, 0xae, 0xae, 0x3c, 0xe9, 0x53, 0x1a,
+	0x84, 0x8f, 0xb7, 0x7c, 0xa7, 0xfd, 0xc5, 0x66, 0x0f, 0x8f, 0xb7, 0x9d, 0xda, 0xd9, 0xc8, 0xe3,
+	0xb7, 0x60, 0x16, 0x6e, 0xb4, 0xc0, 0x10, 0x2c, 0xf2, 0x3d, 0x9d, 0xe3, 0x08, 0xad, 0x59, 0x47,
+	0x24, 0x5e, 0x22, 0x22, 0x58, 0xbb, 0x7f, 0x54, 0x40, 0x97, 0x47, 0x3f, 0x24, 0xd6, 0x02, 0x23,
+	0x0c, 0xd2, 0x20, 0x8c, 0xe9, 0xb3, 0x94, 0x6b, 0x81, 0xf1, 0x9e, 0xc4, 0x34, 0xb8, 0x9f, 0x21,
+	0xa7, 0x5a, 0x32, 0x44, 0x64, 0xe2, 0x4b, 0x80, 0x50, 0x09, 0x60, 0xb9, 0x60, 0x9e, 0x74, 0x72,
+	0xea, 0x44, 0xd9, 0x93, 0xd1, 0xfb, 0x04, 0x91, 0xb5, 0xe5, 0x6f, 0xd6, 0x39, 0xaf, 0xf3, 0x23,
+	0x2f, 0x37, 0x8f, 0x08, 0xc5, 0x32, 0xbf, 0x85, 0x86, 0x1b, 0x1b, 0x0d, 0x17, 0x47, 0xa4, 0x81,
+	0x2f, 0xa0, 0xa9, 0x64, 0xb0, 0xdd, 0x0d, 0x9e, 0x98, 0x82, 0xab, 0xc0, 0x5d, 0xed, 0x43, 0xab,
+	0x44, 0x8b, 0x9d, 0x54, 0x8b, 0x1d, 0xd6, 0xaa, 0x8f, 0x87, 0xfb, 0x73, 0x7c, 0x05, 0x66, 0x81,
+	0xa6, 0x05, 0x86, 0xd0, 0xf5, 0xa1, 0x40, 0xd1, 0x80, 0x4c, 0x91, 0xfa, 0xe0, 0x9a, 0x50, 0x99,
+	0x2d, 0x12, 0x61, 0xae, 0xfb, 0xa7, 0x06, 0xc6, 0x24, 0x09, 0xd2, 0xec, 0x11, 0xd3, 0x42, 0xa7,
+	0xf2, 0x0d, 0x5f, 0xf1, 0xc8, 0xe2, 0x9f, 0x55, 0x2c, 0xd7, 0x55, 0xb6, 0x77, 0xa9, 0x0d, 0x75,
+	0xb6, 0x7b, 0x95, 0x28, 0xfb, 0xf3, 0xbc, 0x9f, 0x75, 0xee, 0xe7, 0x27, 0x39, 0x3f, 0x15, 0x89,
+	0x6d, 0x91, 0x34, 0xf8, 0x27, 0xf4, 0x97, 0x06, 0xfa, 0xd7, 0x41, 0xf8, 0xb4, 0x48, 0x3f, 0x42,
+	0xb4, 0x94, 0x45, 0xb6, 0x4e, 0x03, 0x82, 0x12, 0x2a, 0xa9, 0x5a, 0x60, 0x64, 0xb2, 0x9a, 0x53,
+	0xdb, 0x88, 0xa1, 0x5e, 0x10, 0x5b, 0x57, 0x33, 0x2a, 0xa3, 0x98, 0x20, 0xc7, 0x28, 0x69, 0x15,
+	0xf3, 0xa5, 0xd4, 0x7e, 0x50, 0x13, 0x44, 0x19, 0xd0, 0xdc, 0xcc, 0x03, 0x9f, 0x32, 0xee, 0x18,
+	0x6a, 0xde, 0x12, 0x25, 0xb4, 0x3c, 0x2c, 0x9f, 0xe2, 0x24, 0x27, 0x06, 0xdf, 0xff, 0x80, 0x42,
+	0xba, 0x9e, 0xd7, 0x73, 0x94, 0x65, 0xc1, 0x74, 0xc7, 0xe7, 0xe1, 0xbe, 0x06, 0xfd, 0x12, 0x05,
+	0xd1, 0xe6, 0x94, 0x41, 0x3f, 0xa5, 0x31, 0x91, 0xa1, 0x72, 0xbf, 0x02, 0x7d, 0x12, 0x3e, 0xa2,
+	0x79, 0xc0, 0x2e, 0x5c, 0x22, 0x92, 0xc5, 0x38, 0xe1, 0x5b, 0x2b, 0x6c, 0x6b, 0xb8, 0x20, 0x19,
+	0x26, 0x5b, 0x07, 0x52, 0xc5, 0x0d, 0xa0, 0x39, 0x8c, 0xd0, 0x3c, 0xc5, 0x14, 0x25, 0xe1, 0x33,
+	0x8b, 0x13, 0x1b, 0xbd, 0x9a, 0x72, 0xe2, 0x21, 0x4e, 0xa6, 0x88, 0xa4, 0x24, 0x4e, 0xe8, 0xfa,
+	0x02, 0x19, 0x15, 0x7e, 0x01, 0x73, 0x9b, 0xa0, 0x2c, 0xc5, 0x49, 0x96, 0xd3, 0x20, 0xd9, 0xd5,
+	0x78, 0x89, 0xdf, 0x35, 0x68, 0x5c, 0xc5, 0x53, 0x12, 0xd0, 0x18, 0x27, 0x5b, 0x66, 0xcf, 0xba,
+	0xc3, 0x5b, 0x3e, 0x11, 0xf1, 0x84, 0xe0, 0xb9, 0xbc, 0x1b, 0x60, 0x8f, 0xe2, 0x1d, 0xcf, 0x89,
+	0x09, 0xd5, 0x08, 0x27, 0xa2, 0xcb, 0x95, 0xf5, 0x4b, 0x64, 0xa8, 0x25, 0x22, 0x04, 0x93, 0xed,
+	0x8f, 0x88, 0xfb, 0xb7, 0x06, 0x8d, 0x51, 0x8a, 0x24, 0xc7, 0xdd, 0x0d, 0xcc, 0xb3, 0xaf, 0x94,
+	0xd8, 0x57, 0x0b, 0xec, 0xb7, 0x73, 0x6c, 0x81, 0x4e, 0x50, 0xb6, 0x98, 0x51, 0xc9, 0xb2, 0x0d,
+	0x75, 0x82, 0x7e, 0x5c, 0xa0, 0x8c, 0xca, 0x34, 0xbe, 0x81, 0x1a, 0x66, 0x43, 0x85, 0xf3, 0xfc,
+	0x2f, 0xa3, 0x30, 0xef, 0xff, 0xea, 0x41, 0x0c, 0x09, 0x0a, 0x28, 0x8a, 0x9c, 0xe6, 0x4a, 0x7b,
+	0x12, 0xa1, 0x48, 0xe4, 0xb4, 0x37, 0x05, 0xc8, 0xbd, 0xfb, 0x6d, 0x68, 0x5e, 0x8c, 0x26, 0xd7,
+	0x77, 0x23, 0xff, 0x72, 0xe8, 0x7b, 0x96, 0x66, 0x5b, 0x60, 0x0a, 0xe0, 0xdd, 0x3b, 0x8e, 0xec,
+	0xad, 0x90, 0x81, 0x77, 0x3e, 0xee, 0x0f, 0x3c, 0x8b, 0xa5, 0x0a, 0x38, 0xe2, 0x8d, 0xc7, 0xa3,
+	0xb1, 0x55, 0xb5, 0x0f, 0xc1, 0xe2, 0xeb, 0xab, 0xfe, 0xd0, 0xbf, 0xf6, 0xfc, 0xbe, 0x7f, 0xe6,
+	0x59, 0xb5, 0x1e, 0x01, 0xb3, 0xf0, 0x92, 0x5a, 0x60, 0x0e, 0xbc, 0xdb, 0xe1, 0x99, 0x77, 0x37,
+	0xf4, 0x6f, 0x26, 0x9e, 0xd5, 0xcc, 0x21, 0x63, 0xaf, 0x3f, 0xf8, 0xde, 0x32, 0x6d, 0x1b, 0x5a,
+	0x12, 0x51, 0xf5, 0xf7, 0x73, 0xd8, 0x8d, 0xff, 0x8d, 0x3f, 0xfa, 0xce, 0xb7, 0x5a, 0xf6, 0xff,
+	0xc0, 0x96, 0x58, 0xbe, 0x66, 0xbb, 0xf7, 0x16, 0xda, 0xe5, 0x07, 0xf1, 0x10, 0xac, 0xb3, 0x91,
+	0x7f, 0xdd, 0x1f, 0xfa, 0xde, 0x58, 0xc9, 0x3c, 0xb4, 0x8f, 0xe0, 0x20, 0x87, 0xca, 0x5a, 0x47,
+	0xbd, 0x5f, 0x35, 0x30, 0x0b, 0xaf, 0xc1, 0x01, 0xec, 0xdf, 0x8e, 0x2e, 0x6f, 0xae, 0x3c, 0x75,
+	0xf4, 0x25, 0xe3, 0x23, 0x21, 0xc5, 0xe7, 0x53, 0xa6, 0x44, 0x62, 0x42, 0x5b, 0x87, 0x95, 0x95,
+	0xc8, 0xd5, 0xf0, 0x7c, 0xdc, 0xbf, 0x1e, 0xfa, 0xe7, 0xd6, 0x2b, 0xfb, 0x05, 0xb4, 0x25, 0x7a,
+	0x36, 0xf6, 0x04, 0xe8, 0xe6, 0xc0, 0x81, 0x77, 0xe9, 0x71, 0xf0, 0xb3, 0xde, 0x6b, 0x68, 0x95,
+	0xc6, 0xa8, 0x0d, 0xad, 0x89, 0xdf, 0xff, 0x76, 0x72, 0x31, 0xba, 0x96, 0x7e, 0x75, 0xff, 0x1d,
+	0x00, 0x14, 0x7d, 0xfb, 0xce, 0x3e, 0x0a, 0x00, 0x00,
 }
@@ -0,0 +1,334 @@
+package metadata
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"meta/proto"
+	"store"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// The schema version tells the format the records are at, it is kept
+// under SCHEMAKEY, a store without it is at version 1. A daemon runs on a
+// store of any version from SCHEMA_MIN_VERSION to SCHEMA_VERSION and
+// upgrades it online, a batch of records at a time under the lock, so the
+// other daemons keep working meanwhile. Records written by a daemon are
+// at SCHEMA_VERSION already. The cursor keeps the last key upgraded, an
+// upgrade stopped half way goes on from there.
+//
+// A version adds fields, never changes the meaning of one, so daemons of
+// the previous version can run on the store during a rollout.
+
+const (
+	SCHEMA_VERSION     = 2
+	SCHEMA_MIN_VERSION = 1
+
+	// records upgraded under one lock
+	SCHEMA_BATCH = 100
+)
+
+// schemaUpgrades upgrades a record to the version they are keyed by, from
+// the previous one. An upgrade makes the same record whatever the record
+// was at, it is run on every write as well.
+var schemaUpgrades = map[int]func(msg proto.Message){
+	2: upgradeV2,
+}
+
+// upgradeRecord brings a record to SCHEMA_VERSION before it is written.
+func upgradeRecord(msg proto.Message) {
+	for version := SCHEMA_MIN_VERSION + 1; version <= SCHEMA_VERSION; version++ {
+		schemaUpgrades[version](msg)
+	}
+}
+
+// typedInt parses a bytes field, nil when it holds no integer.
+func typedInt(b []byte) *int64 {
+	i, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &i
+}
+
+func typedInt32(b []byte) *int32 {
+	i, err := strconv.ParseInt(string(b), 10, 32)
+	if err != nil {
+		return nil
+	}
+	i32 := int32(i)
+	return &i32
+}
+
+// typedStatus parses a bytes status, false when it names no value of the
+// enum.
+func typedStatus(b []byte, names map[int32]string) (int32, bool) {
+	i := typedInt32(b)
+	if i == nil {
+		return 0, false
+	}
+	_, ok := names[*i]
+	return *i, ok
+}
+
+// bytesOr is the bytes field, the typed field standing for it only when
+// the bytes hold no integer. The bytes field is the one read while daemons
+// of schema 1 may run, as they update it and write the typed field they
+// don't know back as they read it.
+func bytesOr(b []byte, v2 *int64) (int, error) {
+	i, err := BytesToInteger(b)
+	if err != nil && v2 != nil {
+		return int(*v2), nil
+	}
+	return i, err
+}
+
+func bytesOr32(b []byte, v2 *int32) (int, error) {
+	i, err := BytesToInteger(b)
+	if err != nil && v2 != nil {
+		return int(*v2), nil
+	}
+	return i, err
+}
+
+// The readers of the fields with a typed version.
+
+func HostStatus(hs *metaproto.Host) (int, error) {
+	return bytesOr32(hs.Status, (*int32)(hs.StatusV2))
+}
+
+func HostOptime(hs *metaproto.Host) (int, error) {
+	return bytesOr(hs.Optime, hs.OptimeV2)
+}
+
+func DeviceStatus(dv *metaproto.Device) (int, error) {
+	return bytesOr32(dv.Status, (*int32)(dv.StatusV2))
+}
+
+func DevicePort(dv *metaproto.Device) (int, error) {
+	return bytesOr32(dv.Port, dv.PortV2)
+}
+
+func DeviceTotal(dv *metaproto.Device) (int, error) {
+	return bytesOr(dv.Total, dv.TotalV2)
+}
+
+func DeviceFree(dv *metaproto.Device) (int, error) {
+	return bytesOr(dv.Free, dv.FreeV2)
+}
+
+func DeviceOptime(dv *metaproto.Device) (int, error) {
+	return bytesOr(dv.Optime, dv.OptimeV2)
+}
+
+func ContainerStatus(ct *metaproto.Container) (int, error) {
+	return bytesOr32(ct.Status, (*int32)(ct.StatusV2))
+}
+
+func VolumeCapacity(vl *metaproto.Volume) (int, error) {
+	return bytesOr(vl.Capacity, vl.CapacityV2)
+}
+
+func SnapshotSize(sp *metaproto.Snapshot) (int, error) {
+	return bytesOr(sp.Size, sp.SizeV2)
+}
+
+func SnapshotOptime(sp *metaproto.Snapshot) (int, error) {
+	return bytesOr(sp.Optime, sp.OptimeV2)
+}
+
+func BackupOptime(bk *metaproto.Backup) (int, error) {
+	return bytesOr(bk.Optime, bk.OptimeV2)
+}
+
+// upgradeV2 fills the typed fields from the bytes fields.
+func upgradeV2(msg proto.Message) {
+	switch r := msg.(type) {
+	case *metaproto.Host:
+		r.StatusV2 = nil
+		if status, ok := typedStatus(r.Status, metaproto.HostStatus_name); ok {
+			r.StatusV2 = metaproto.HostStatus(status).Enum()
+		}
+		r.OptimeV2 = typedInt(r.Optime)
+	case *metaproto.Device:
+		r.PortV2 = typedInt32(r.Port)
+		r.TotalV2 = typedInt(r.Total)
+		r.FreeV2 = typedInt(r.Free)
+		r.StatusV2 = nil
+		if status, ok := typedStatus(r.Status, metaproto.DeviceStatus_name); ok {
+			r.StatusV2 = metaproto.DeviceStatus(status).Enum()
+		}
+		r.OptimeV2 = typedInt(r.Optime)
+	case *metaproto.Container:
+		r.StatusV2 = nil
+		if status, ok := typedStatus(r.Status, metaproto.ContainerStatus_name); ok {
+			r.StatusV2 = metaproto.ContainerStatus(status).Enum()
+		}
+		r.OptimeV2 = typedInt(r.Optime)
+	case *metaproto.Volume:
+		r.StatusV2 = nil
+		if status, ok := typedStatus(r.Status, metaproto.VolumeStatus_name); ok {
+			r.StatusV2 = metaproto.VolumeStatus(status).Enum()
+		}
+		r.CapacityV2 = typedInt(r.Capacity)
+		r.OptimeV2 = typedInt(r.Optime)
+	case *metaproto.Snapshot:
+		r.SizeV2 = typedInt(r.Size)
+		r.StatusV2 = nil
+		if status, ok := typedStatus(r.Status, metaproto.SnapshotStatus_name); ok {
+			r.StatusV2 = metaproto.SnapshotStatus(status).Enum()
+		}
+		r.OptimeV2 = typedInt(r.Optime)
+	case *metaproto.Backup:
+		r.CapacityV2 = typedInt(r.Capacity)
+		r.SizeV2 = typedInt(r.Size)
+		r.OptimeV2 = typedInt(r.Optime)
+	}
+}
+
+func getSchema() (*metaproto.Schema, error) {
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"sorted": "false",
+		"qurum":  "false",
+	}
+	sc := &metaproto.Schema{}
+	data, err := driver.Get(SCHEMAKEY, opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			version := int64(SCHEMA_MIN_VERSION)
+			sc.Version = &version
+			return sc, nil
+		}
+		return nil, NewError(EcodeBackendError, err.Error())
+	}
+	if err := proto.Unmarshal([]byte(data), sc); err != nil {
+		return nil, NewError(EcodeRequestDecodeError, err.Error())
+	}
+	return sc, nil
+}
+
+func setSchema(sc *metaproto.Schema) error {
+	optime := time.Now().Unix()
+	sc.Optime = &optime
+	data, err := proto.Marshal(sc)
+	if err != nil {
+		return NewError(EcodeRequestEncodeError, err.Error())
+	}
+
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"ttl":       "0",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	if err := driver.Set(SCHEMAKEY, string(data), opts); err != nil {
+		return NewError(EcodeBackendError, err.Error())
+	}
+	return nil
+}
+
+// GetSchema returns the version every record is at, and the last key
+// upgraded to the next version.
+func GetSchema() (int, string, error) {
+	sc, err := getSchema()
+	if err != nil {
+		return 0, "", err
+	}
+	return int(sc.GetVersion()), string(sc.Cursor), nil
+}
+
+// CheckSchema refuses a store this daemon can't run on.
+func CheckSchema() error {
+	version, _, err := GetSchema()
+	if err != nil {
+		return err
+	}
+	if version < SCHEMA_MIN_VERSION || version > SCHEMA_VERSION {
+		return NewError(EcodeSchemaVersion, fmt.Sprintf("store at schema %d, this daemon runs on %d to %d", version, SCHEMA_MIN_VERSION, SCHEMA_VERSION))
+	}
+	return nil
+}
+
+// MigrateSchema upgrades up to batch records to the version following the
+// one of the store, and tells whether the store is at SCHEMA_VERSION. The
+// caller holds the metadata lock.
+func MigrateSchema(batch int) (bool, error) {
+	sc, err := getSchema()
+	if err != nil {
+		return false, err
+	}
+	version := int(sc.GetVersion())
+	if version >= SCHEMA_VERSION {
+		return true, nil
+	}
+
+	keys, err := listArchived()
+	if err != nil {
+		return false, err
+	}
+	driver := store.GetDriver()
+	getOpts := map[string]string{
+		"sorted": "false",
+		"qurum":  "false",
+	}
+	setOpts := map[string]string{
+		"ttl":       "0",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	dirs := archiveDirs()
+	upgrade := schemaUpgrades[version+1]
+
+	done := 0
+	for _, key := range keys {
+		if key <= string(sc.Cursor) {
+			continue
+		}
+		if done == batch {
+			break
+		}
+		done++
+		sc.Cursor = []byte(key)
+
+		data, err := driver.Get(key, getOpts)
+		if err != nil {
+			if ValidKeyNotFoundError(err) == true {
+				continue
+			}
+			return false, NewError(EcodeBackendError, err.Error())
+		}
+		msg := archiveKinds[dirs[filepath.Dir(key)]]()
+		if err := proto.Unmarshal([]byte(data), msg); err != nil {
+			// left as it is for fsck to report
+			log.Warnf("[MigrateSchema] decode %s error: %s", key, err.Error())
+			continue
+		}
+		old := proto.Clone(msg)
+		upgrade(msg)
+		if proto.Equal(old, msg) {
+			continue
+		}
+		value, err := proto.Marshal(msg)
+		if err != nil {
+			return false, NewError(EcodeRequestEncodeError, err.Error())
+		}
+		if err := driver.Set(key, string(value), setOpts); err != nil {
+			return false, NewError(EcodeBackendError, err.Error())
+		}
+	}
+
+	if done < batch {
+		next := int64(version + 1)
+		sc.Version = &next
+		sc.Cursor = nil
+		log.Infof("[MigrateSchema] store upgraded to schema %d", next)
+	}
+	if err := setSchema(sc); err != nil {
+		return false, err
+	}
+	return int(sc.GetVersion()) >= SCHEMA_VERSION, nil
+}
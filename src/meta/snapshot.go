@@ -0,0 +1,145 @@
+package metadata
+
+import (
+	"meta/proto"
+	"path/filepath"
+	"store"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func getAndDecodeSnapshot(snapshotid string, driverName string) (*metaproto.Snapshot, error) {
+	driver := store.GetDriver()
+
+	snapshotkey := GenerateSnapshotKey(snapshotid, driverName)
+	opts := map[string]string{
+		"sorted": "false",
+		"qurum":  "false",
+	}
+	data, err := driver.Get(snapshotkey, opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return nil, NewError(EcodeSnapshotNotFound, "Snapshot not found.")
+		}
+		log.Errorf("[getAndDecodeSnapshot] driver.Get error: %s, key: %s", err.Error(), snapshotkey)
+		return nil, NewError(EcodeBackendError, err.Error())
+	}
+
+	sp := &metaproto.Snapshot{}
+	err = proto.Unmarshal([]byte(data), sp)
+	if err != nil {
+		log.Errorf("[getAndDecodeSnapshot] proto.Unmarshal error: %s, key: %s", err.Error(), snapshotkey)
+		return nil, NewError(EcodeRequestDecodeError, err.Error())
+	}
+
+	return sp, nil
+}
+
+func setAndEncodeSnapshot(sp *metaproto.Snapshot, driverName string) error {
+	upgradeRecord(sp)
+	data, err := proto.Marshal(sp)
+	if err != nil {
+		log.Errorf("[setAndEncodeSnapshot] proto.marshal error: %s", err.Error())
+		return NewError(EcodeRequestEncodeError, err.Error())
+	}
+
+	driver := store.GetDriver()
+	snapshotkey := GenerateSnapshotKey(string(sp.Id), driverName)
+	opts := map[string]string{
+		"ttl":       "0",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	err = driver.Set(snapshotkey, string(data), opts)
+	if err != nil {
+		log.Errorf("[setAndEncodeSnapshot] driver.Set error: %s", err.Error())
+		return NewError(EcodeBackendError, err.Error())
+	}
+
+	return nil
+}
+
+func AddSnapshot(sp *metaproto.Snapshot, driverName string) error {
+	if sp == nil || validVolumeID(string(sp.Id)) == false {
+		return NewError(EcodeParameterError, "Not Valid Snapshot Struct.")
+	}
+	if ValidDriverName(driverName) == false {
+		return NewError(EcodeParameterError, "Not Valid Snapshot Driver Name.")
+	}
+
+	_, err := getAndDecodeSnapshot(string(sp.Id), driverName)
+	if err == nil {
+		return NewError(EcodeSnapshotExist, "Snapshot already exists.")
+	}
+	if err.(*Error).Code != EcodeSnapshotNotFound {
+		return err
+	}
+
+	return setAndEncodeSnapshot(sp, driverName)
+}
+
+func GetSnapshot(snapshotid string, driverName string) (*metaproto.Snapshot, error) {
+	if validVolumeID(snapshotid) == false {
+		return nil, NewError(EcodeParameterError, "Not Valid Snapshot ID.")
+	}
+	if ValidDriverName(driverName) == false {
+		return nil, NewError(EcodeParameterError, "Not Valid Snapshot Driver Name.")
+	}
+
+	return getAndDecodeSnapshot(snapshotid, driverName)
+}
+
+func ListSnapshotsName(driverName string) ([]string, error) {
+	if ValidDriverName(driverName) == false {
+		return nil, NewError(EcodeParameterError, "Not Valid Snapshot Driver Name.")
+	}
+
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"recursive": "false",
+		"sorted":    "false",
+		"quorum":    "false",
+	}
+	snapshots, err := driver.List(GenerateSnapshotDriverKey(driverName), opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return nil, nil
+		}
+		return nil, NewError(EcodeBackendError, err.Error())
+	}
+
+	names := []string{}
+	for i := 0; i < len(snapshots); i++ {
+		if name := filepath.Base(snapshots[i]); len(name) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+func DelSnapshot(snapshotid string, driverName string) error {
+	if validVolumeID(snapshotid) == false {
+		return NewError(EcodeParameterError, "Not Valid Snapshot ID.")
+	}
+	if ValidDriverName(driverName) == false {
+		return NewError(EcodeParameterError, "Not Valid Snapshot Driver Name.")
+	}
+
+	driver := store.GetDriver()
+	opts := map[string]string{
+		"recursive": "false",
+		"dir":       "false",
+		"prevValue": "",
+		"prevIndex": "0",
+	}
+	err := driver.Remove(GenerateSnapshotKey(snapshotid, driverName), opts)
+	if err != nil {
+		if ValidKeyNotFoundError(err) == true {
+			return NewError(EcodeSnapshotNotFound, "Snapshot not found.")
+		}
+		return NewError(EcodeBackendError, err.Error())
+	}
+
+	return nil
+}
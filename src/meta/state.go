@@ -0,0 +1,144 @@
+package metadata
+
+import (
+	"fmt"
+	"strings"
+
+	"meta/proto"
+)
+
+// stateMachine tells the statuses a record can be in, their names and the
+// changes of status allowed. Staying in a status is always allowed, a
+// record without status is in status 0.
+type stateMachine struct {
+	kind  string
+	names map[int32]string
+	next  map[int][]int
+}
+
+var (
+	// an offline host only comes back online or is drained, maintenance is
+	// only left by a resume
+	HostStates = &stateMachine{
+		kind:  "host",
+		names: metaproto.HostStatus_name,
+		next: map[int][]int{
+			0:                {HOST_ONLINE, HOST_OFFLINE, HOST_DEGRADE, HOST_ERROR, HOST_MAINTENANCE},
+			HOST_ONLINE:      {HOST_OFFLINE, HOST_DEGRADE, HOST_ERROR, HOST_MAINTENANCE},
+			HOST_OFFLINE:     {HOST_ONLINE, HOST_MAINTENANCE},
+			HOST_DEGRADE:     {HOST_ONLINE, HOST_OFFLINE, HOST_ERROR, HOST_MAINTENANCE},
+			HOST_ERROR:       {HOST_ONLINE, HOST_OFFLINE, HOST_MAINTENANCE},
+			HOST_MAINTENANCE: {HOST_ONLINE},
+		},
+	}
+
+	// a device in maintenance keeps it until resumed, to ready or inuse
+	// after whether it holds a volume
+	DeviceStates = &stateMachine{
+		kind:  "device",
+		names: metaproto.DeviceStatus_name,
+		next: map[int][]int{
+			0:                  {DEVICE_READY, DEVICE_INUSE, DEVICE_OFFLINE, DEVICE_UNKNOWN, DEVICE_MAINTENANCE},
+			DEVICE_READY:       {DEVICE_INUSE, DEVICE_OFFLINE, DEVICE_UNKNOWN, DEVICE_MAINTENANCE},
+			DEVICE_INUSE:       {DEVICE_READY, DEVICE_OFFLINE, DEVICE_UNKNOWN, DEVICE_MAINTENANCE},
+			DEVICE_OFFLINE:     {DEVICE_READY, DEVICE_INUSE, DEVICE_UNKNOWN, DEVICE_MAINTENANCE},
+			DEVICE_UNKNOWN:     {DEVICE_READY, DEVICE_INUSE, DEVICE_OFFLINE, DEVICE_MAINTENANCE},
+			DEVICE_MAINTENANCE: {DEVICE_READY, DEVICE_INUSE},
+		},
+	}
+
+	// a volume is created, is online until attached and inuse while held
+	// by containers, migrates only when online and is deleted only when
+	// online, going back online when the backend fails to delete it
+	VolumeStates = &stateMachine{
+		kind:  "volume",
+		names: metaproto.VolumeStatus_name,
+		next: map[int][]int{
+			0:                {VOLUME_CREATING, VOLUME_ONLINE, VOLUME_INUSE},
+			VOLUME_CREATING:  {VOLUME_ONLINE, VOLUME_DELETING},
+			VOLUME_ONLINE:    {VOLUME_INUSE, VOLUME_MIGRATING, VOLUME_DELETING},
+			VOLUME_INUSE:     {VOLUME_ONLINE},
+			VOLUME_MIGRATING: {VOLUME_ONLINE},
+			VOLUME_DELETING:  {VOLUME_ONLINE},
+			VOLUME_UNKNOWN:   {VOLUME_ONLINE, VOLUME_INUSE, VOLUME_MIGRATING, VOLUME_DELETING},
+		},
+	}
+
+	ContainerStates = &stateMachine{
+		kind:  "container",
+		names: metaproto.ContainerStatus_name,
+		next: map[int][]int{
+			0:                {CONTAINERONLINE, CONTAINEROFFLINE},
+			CONTAINERONLINE:  {CONTAINEROFFLINE},
+			CONTAINEROFFLINE: {CONTAINERONLINE},
+		},
+	}
+)
+
+// Valid tells whether status is one of the statuses of the machine.
+func (sm *stateMachine) Valid(status int) bool {
+	_, ok := sm.names[int32(status)]
+	return ok
+}
+
+// Name is the name of a status as shown to users, "online" for
+// HOST_ONLINE, empty for no status.
+func (sm *stateMachine) Name(status int) string {
+	name, ok := sm.names[int32(status)]
+	if !ok {
+		if status == 0 {
+			return ""
+		}
+		return fmt.Sprintf("invalid(%d)", status)
+	}
+	name = strings.TrimPrefix(name, strings.ToUpper(sm.kind)+"_")
+	return strings.ToLower(name)
+}
+
+// Lookup is the status of a name given by Name.
+func (sm *stateMachine) Lookup(name string) (int, bool) {
+	for status := range sm.names {
+		if sm.Name(int(status)) == name {
+			return int(status), true
+		}
+	}
+	return 0, false
+}
+
+// NameBytes is the name of a status kept as bytes in a record.
+func (sm *stateMachine) NameBytes(status []byte) string {
+	if len(status) == 0 {
+		return ""
+	}
+	i, err := BytesToInteger(status)
+	if err != nil {
+		return fmt.Sprintf("invalid(%s)", string(status))
+	}
+	return sm.Name(i)
+}
+
+// Check refuses a change of status the machine doesn't allow.
+func (sm *stateMachine) Check(from int, to int) error {
+	if !sm.Valid(to) {
+		return NewError(EcodeParameterError, fmt.Sprintf("Not Valid %s Status %d.", strings.Title(sm.kind), to))
+	}
+	if from == to {
+		return nil
+	}
+	for _, next := range sm.next[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return NewError(EcodeIllegalTransition, fmt.Sprintf("%s can't go from %s to %s", sm.kind, sm.Name(from), sm.Name(to)))
+}
+
+// CheckBytes is Check from a status kept as bytes in a record, a status
+// that doesn't parse being no status.
+func (sm *stateMachine) CheckBytes(from []byte, to int) error {
+	status, err := BytesToInteger(from)
+	if err != nil {
+		status = 0
+	}
+	return sm.Check(status, to)
+}
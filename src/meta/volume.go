@@ -65,6 +65,7 @@ func listVolumes(driverName string) ([]string, error) {
 }
 
 func setAndEncodeVolume(vl *metaproto.Volume, driverName string) error {
+	upgradeRecord(vl)
 	data, err := proto.Marshal(vl)
 	if err != nil {
 		log.Errorf("[setAndEncodeVolume] proto.marshal error: %s", err.Error())
@@ -125,6 +126,12 @@ func AddVolume(vl *metaproto.Volume, driverName string) error {
 		return NewError(EcodeParameterError, "Not Valid Volume Driver Name.")
 	}
 
+	status := VolumeStatus(vl)
+	if err := VolumeStates.Check(0, status); err != nil {
+		return err
+	}
+	vl.Status = IntegerToBytes(status)
+
 	err := setAndEncodeVolume(vl, driverName)
 	if err != nil {
 		return err
@@ -132,18 +139,19 @@ func AddVolume(vl *metaproto.Volume, driverName string) error {
 
 	// set devices status INUSE
 	for i := 0; i < len(vl.Devices); i++ {
-		err := UseDevice(string(vl.Devices[i].Deviceid), driverName, string(vl.Id))
+		err := useDevice(string(vl.Devices[i].Deviceid), driverName, string(vl.Id), capacityOf(vl))
 		if err != nil {
 			for j := 0; j <= i; j++ {
 				//USQueue.Enqueue(string(vl.Devices[j].Deviceid), DEVICE_READY, driverName) //undo all done
 				//USQueue.Count <- i                                                        //notify update status goroutine to handle events
 				t := strconv.FormatInt(time.Now().Unix(), 10)
 				ev := &UpdateStatusEvent{
-					Devid:   string(vl.Devices[j].Deviceid),
-					Volid:   string(vl.Id),
-					Status:  DEVICE_READY,
-					Backend: driverName,
-					Time:    t,
+					Devid:    string(vl.Devices[j].Deviceid),
+					Volid:    string(vl.Id),
+					Status:   DEVICE_READY,
+					Backend:  driverName,
+					Time:     t,
+					Capacity: capacityOf(vl),
 				}
 				PendingOps.Add(EVENT_UPDATE_DEVICE_STATUS, ev)
 
@@ -215,6 +223,12 @@ func DelVolume(volumeid string, driverName string) error {
 	if len(vl.Containers) != 0 {
 		return NewError(EcodeVolumeInUse, "Volume in use")
 	}
+	if isMigrating(vl) {
+		return NewError(EcodeVolumeMigrating, "Volume is migrating.")
+	}
+	if err := VolumeStates.Check(VolumeStatus(vl), VOLUME_DELETING); err != nil {
+		return err
+	}
 
 	driver := store.GetDriver()
 	opts := map[string]string{
@@ -234,20 +248,26 @@ func DelVolume(volumeid string, driverName string) error {
 
 	//update device READY state
 	for i := 0; i < len(vl.Devices); i++ {
-		err = FreeDevice(string(vl.Devices[i].Deviceid), driverName, volumeid)
+		err = freeDevice(string(vl.Devices[i].Deviceid), driverName, volumeid, capacityOf(vl))
 		if err != nil {
 			t := strconv.FormatInt(time.Now().Unix(), 10)
 			ev := &UpdateStatusEvent{
-				Devid:   string(vl.Devices[i].Deviceid),
-				Volid:   volumeid,
-				Status:  DEVICE_READY,
-				Backend: driverName,
-				Time:    t,
+				Devid:    string(vl.Devices[i].Deviceid),
+				Volid:    volumeid,
+				Status:   DEVICE_READY,
+				Backend:  driverName,
+				Time:     t,
+				Capacity: capacityOf(vl),
 			}
 			PendingOps.Add(EVENT_UPDATE_DEVICE_STATUS, ev)
 		}
 	}
 
+	// the last migration goes with the volume
+	if err := delMigration(volumeid, driverName); err != nil {
+		log.Warnf("[DelVolume] delete migration of volume %s error: %s", volumeid, err.Error())
+	}
+
 	return nil
 }
 
@@ -308,9 +328,17 @@ func SetVolumeContainer(volumeid string, vct *metaproto.Volume_OwnerContainer, d
 		return err
 	}
 
-	if len(vl.Writable) != 0 && force == false {
+	if isMigrating(vl) {
+		return NewError(EcodeVolumeMigrating, "Volume is migrating.")
+	}
+
+	shared := SharedBackend(driverName)
+	if len(vl.Writable) != 0 && force == false && !shared {
 		return NewError(EcodeWRContainerExist, "rw container already exists.")
 	}
+	if err := moveVolume(vl, VOLUME_INUSE); err != nil {
+		return err
+	}
 
 	var c *metaproto.Volume_OwnerContainer
 	for i := 0; i < len(vl.Containers); i++ {
@@ -325,7 +353,7 @@ func SetVolumeContainer(volumeid string, vct *metaproto.Volume_OwnerContainer, d
 		}
 	}
 
-	if string(vct.Mode) == RWVolume {
+	if string(vct.Mode) == RWVolume && !shared {
 		vl.Writable = vct.Containerid
 	}
 
@@ -334,6 +362,48 @@ func SetVolumeContainer(volumeid string, vct *metaproto.Volume_OwnerContainer, d
 	return setAndEncodeVolume(vl, driverName)
 }
 
+// ShareVolumeContainer adds a container to the owners of a volume its host
+// holds for a writer of its own, the container sharing the mount of that
+// writer. Writable stays the writer, it goes to another container of the
+// host once the writer is gone.
+func ShareVolumeContainer(volumeid string, vct *metaproto.Volume_OwnerContainer, driverName string) error {
+	if validVolumeID(volumeid) == false {
+		return NewError(EcodeParameterError, "Not Valid Volume ID.")
+	}
+	if vct == nil {
+		return NewError(EcodeParameterError, "Not Valid Volume OwnerContainer.")
+	}
+	if ValidDriverName(driverName) == false {
+		return NewError(EcodeParameterError, "Not Valid Volume Driver Name.")
+	}
+
+	vl, err := getAndDecodeVolume(volumeid, driverName)
+	if err != nil {
+		return err
+	}
+
+	if isMigrating(vl) {
+		return NewError(EcodeVolumeMigrating, "Volume is migrating.")
+	}
+
+	var writer *metaproto.Volume_OwnerContainer
+	for _, c := range vl.Containers {
+		if string(c.Containerid) == string(vct.Containerid) {
+			return nil
+		}
+		if string(c.Containerid) == string(vl.Writable) {
+			writer = c
+		}
+	}
+	if writer == nil || string(writer.Host) != string(vct.Host) {
+		return NewError(EcodeWRContainerExist, "rw container already exists.")
+	}
+
+	vl.Containers = append(vl.Containers, vct)
+
+	return setAndEncodeVolume(vl, driverName)
+}
+
 func DelVolumeContainer(volumeid string, driverName string, containerid string) error {
 	if validVolumeID(volumeid) == false {
 		return NewError(EcodeParameterError, "Not Valid Volume ID.")
@@ -351,18 +421,32 @@ func DelVolumeContainer(volumeid string, driverName string, containerid string)
 	}
 
 	newCons := []*metaproto.Volume_OwnerContainer{}
+	var host []byte
 	var c *metaproto.Volume_OwnerContainer
 	for i := 0; i < len(vl.Containers); i++ {
 		c = vl.Containers[i]
 		if string(c.Containerid) == containerid {
-			vl.Writable = []byte("")
+			host = c.Host
 			continue
 		}
 
 		newCons = append(newCons, c)
 	}
 
+	if string(vl.Writable) == containerid {
+		// the containers of a host sharing its mount write through it
+		vl.Writable = []byte("")
+		for _, c := range newCons {
+			if string(c.Mode) == RWVolume && string(c.Host) == string(host) {
+				vl.Writable = c.Containerid
+				break
+			}
+		}
+	}
 	vl.Containers = newCons
+	if len(newCons) == 0 && VolumeStatus(vl) == VOLUME_INUSE {
+		vl.Status = IntegerToBytes(VOLUME_ONLINE)
+	}
 
 	return setAndEncodeVolume(vl, driverName)
 }
@@ -427,3 +511,139 @@ func DelVolumeDevice(volumeid string, driverName string, deviceid string) error
 
 	return setAndEncodeVolume(vl, driverName)
 }
+
+func UpdateVolumeCapacity(volumeid string, driverName string, capacity int) error {
+	if validVolumeID(volumeid) == false {
+		return NewError(EcodeParameterError, "Not Valid Volume ID.")
+	}
+	if ValidDriverName(driverName) == false {
+		return NewError(EcodeParameterError, "Not Valid Volume Driver Name.")
+	}
+	if capacity <= 0 {
+		return NewError(EcodeParameterError, "Not Valid Capacity.")
+	}
+
+	vl, err := getAndDecodeVolume(volumeid, driverName)
+	if err != nil {
+		return err
+	}
+
+	// the pools of the volume give the growth out of their free space
+	if grown := capacity - capacityOf(vl); PoolBackend(driverName) && grown > 0 {
+		for _, ad := range vl.Devices {
+			if err := useDevice(string(ad.Deviceid), driverName, volumeid, grown); err != nil {
+				return err
+			}
+		}
+	}
+	vl.Capacity = IntegerToBytes(capacity)
+
+	return setAndEncodeVolume(vl, driverName)
+}
+
+func isMigrating(vl *metaproto.Volume) bool {
+	return VolumeStatus(vl) == VOLUME_MIGRATING
+}
+
+// VolumeStatus is the status of the volume, records written before volumes
+// had one are inuse when held by containers and online otherwise.
+func VolumeStatus(vl *metaproto.Volume) int {
+	status, err := BytesToInteger(vl.Status)
+	if err == nil && VolumeStates.Valid(status) {
+		return status
+	}
+	if vl.StatusV2 != nil {
+		return int(*vl.StatusV2)
+	}
+	if len(vl.Containers) != 0 {
+		return VOLUME_INUSE
+	}
+	return VOLUME_ONLINE
+}
+
+// moveVolume changes the status of the record, if the volume state
+// machine allows it.
+func moveVolume(vl *metaproto.Volume, status int) error {
+	if err := VolumeStates.Check(VolumeStatus(vl), status); err != nil {
+		return err
+	}
+	vl.Status = IntegerToBytes(status)
+	return nil
+}
+
+// SetVolumeStatus changes the status of the volume, if the volume state
+// machine allows it.
+func SetVolumeStatus(volumeid string, driverName string, status int) error {
+	if validVolumeID(volumeid) == false {
+		return NewError(EcodeParameterError, "Not Valid Volume ID.")
+	}
+	if ValidDriverName(driverName) == false {
+		return NewError(EcodeParameterError, "Not Valid Volume Driver Name.")
+	}
+
+	vl, err := getAndDecodeVolume(volumeid, driverName)
+	if err != nil {
+		return err
+	}
+	if err := moveVolume(vl, status); err != nil {
+		return err
+	}
+	return setAndEncodeVolume(vl, driverName)
+}
+
+// StartVolumeMigration marks the volume as migrating, it can then be
+// neither attached nor deleted until FinishVolumeMigration. Volumes used
+// by containers are refused, their data would change under the copy.
+func StartVolumeMigration(volumeid string, driverName string) error {
+	if validVolumeID(volumeid) == false {
+		return NewError(EcodeParameterError, "Not Valid Volume ID.")
+	}
+	if ValidDriverName(driverName) == false {
+		return NewError(EcodeParameterError, "Not Valid Volume Driver Name.")
+	}
+
+	vl, err := getAndDecodeVolume(volumeid, driverName)
+	if err != nil {
+		return err
+	}
+	if isMigrating(vl) {
+		return NewError(EcodeVolumeMigrating, "Volume is migrating.")
+	}
+	if len(vl.Containers) != 0 {
+		return NewError(EcodeVolumeInUse, "Volume in use")
+	}
+
+	if err := moveVolume(vl, VOLUME_MIGRATING); err != nil {
+		return err
+	}
+	return setAndEncodeVolume(vl, driverName)
+}
+
+// FinishVolumeMigration ends the migration of the volume, switching it to
+// devices at once when given, and returns the record as it was before.
+// The devices themselves are used and freed by the caller.
+func FinishVolumeMigration(volumeid string, driverName string, devices []*metaproto.Volume_AttachDevice) (*metaproto.Volume, error) {
+	if validVolumeID(volumeid) == false {
+		return nil, NewError(EcodeParameterError, "Not Valid Volume ID.")
+	}
+	if ValidDriverName(driverName) == false {
+		return nil, NewError(EcodeParameterError, "Not Valid Volume Driver Name.")
+	}
+
+	vl, err := getAndDecodeVolume(volumeid, driverName)
+	if err != nil {
+		return nil, err
+	}
+	old := proto.Clone(vl).(*metaproto.Volume)
+
+	if err := moveVolume(vl, VOLUME_ONLINE); err != nil {
+		return nil, err
+	}
+	if devices != nil {
+		vl.Devices = devices
+	}
+	if err := setAndEncodeVolume(vl, driverName); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
@@ -3,6 +3,7 @@ package scheduler
 import (
 	"strconv"
 
+	"meta"
 	"meta/proto"
 )
 
@@ -13,8 +14,12 @@ type CapacityFilter struct {
 func (cfilter *CapacityFilter) Filter(devices []*metaproto.Device) []*metaproto.Device {
 	deviceFilter := []*metaproto.Device{}
 	for _, v := range devices {
-		vTotal, _ := strconv.ParseInt(string(v.Total), 10, 64)
-		if vTotal >= cfilter.Capacity {
+		vTotal, _ := metadata.DeviceTotal(v)
+		// a pool is shared, only its free space is left to the volume
+		if metadata.PoolBackend(string(v.Backend)) {
+			vTotal, _ = metadata.DeviceFree(v)
+		}
+		if int64(vTotal) >= cfilter.Capacity {
 			deviceFilter = append(deviceFilter, v)
 		}
 	}
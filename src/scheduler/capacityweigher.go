@@ -3,6 +3,7 @@ package scheduler
 import (
 	"strconv"
 
+	"meta"
 	"meta/proto"
 )
 
@@ -14,7 +15,7 @@ func (weigher *CapacityWeigher) Weigher(devices []*metaproto.Device) []float64 {
 	capacityScore := make([]float64, len(devices))
 	allTotal := make([]float64, len(devices))
 	for index, v := range devices {
-		vTotal, _ := strconv.ParseInt(string(v.Total), 10, 64)
+		vTotal, _ := metadata.DeviceTotal(v)
 		allTotal[index] = float64(vTotal)
 	}
 	sumTotal := SumofSliceFloat64(allTotal)
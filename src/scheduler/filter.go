@@ -12,6 +12,7 @@ type makeFilterFunc func(string) (Filter, error)
 
 var FilterFactory = map[string]makeFilterFunc{
 	FilterCapacity: MakeCapacityFilter,
+	FilterHost:     MakeHostFilter,
 	//"FilterCore":     MakeCoreFilter,
 }
 
@@ -0,0 +1,26 @@
+package scheduler
+
+import (
+	"meta"
+	"meta/proto"
+)
+
+// HostFilter keeps the devices of one host, to move a volume there.
+type HostFilter struct {
+	Host string
+}
+
+func (hfilter *HostFilter) Filter(devices []*metaproto.Device) []*metaproto.Device {
+	deviceFilter := []*metaproto.Device{}
+	for _, v := range devices {
+		if metadata.GetHostIpFromKey(string(v.Host)) == hfilter.Host {
+			deviceFilter = append(deviceFilter, v)
+		}
+	}
+	return deviceFilter
+}
+
+func MakeHostFilter(value string) (Filter, error) {
+	var filterPtr Filter = &HostFilter{value}
+	return filterPtr, nil
+}
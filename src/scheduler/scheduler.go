@@ -11,6 +11,7 @@ import (
 
 const (
 	FilterCapacity = "FilterCapacity"
+	FilterHost     = "FilterHost"
 
 	WeigherCapacity = "WeigherCapacity"
 
@@ -52,6 +53,7 @@ func DoScheduler(opts map[string]string) ([]*metaproto.Device, error) {
 	*/
 
 	filters, _ := MakeFilters(opts)
+	filters = append(filters, &StatusFilter{})
 
 	for _, filter := range filters {
 		devices = filter.Filter(devices)
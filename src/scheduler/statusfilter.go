@@ -0,0 +1,23 @@
+package scheduler
+
+import (
+	"strconv"
+
+	"meta"
+	"meta/proto"
+)
+
+// StatusFilter drops devices that are known to be unusable or drained, it
+// is applied whatever the options.
+type StatusFilter struct{}
+
+func (sfilter *StatusFilter) Filter(devices []*metaproto.Device) []*metaproto.Device {
+	deviceFilter := []*metaproto.Device{}
+	for _, v := range devices {
+		status, _ := strconv.Atoi(string(v.Status))
+		if status != metadata.DEVICE_OFFLINE && status != metadata.DEVICE_MAINTENANCE {
+			deviceFilter = append(deviceFilter, v)
+		}
+	}
+	return deviceFilter
+}
@@ -3,8 +3,8 @@ package scheduler
 import (
 	"fmt"
 	"math"
-	"strconv"
 
+	"meta"
 	"meta/proto"
 )
 
@@ -43,14 +43,14 @@ func (dw DeviceWrapper) Less(i, j int) bool {
 }
 
 func ByTotal(p, q *metaproto.Device) bool {
-	iTotal, _ := strconv.ParseInt(string(p.Total), 10, 64)
-	jTotal, _ := strconv.ParseInt(string(q.Total), 10, 64)
+	iTotal, _ := metadata.DeviceTotal(p)
+	jTotal, _ := metadata.DeviceTotal(q)
 	return iTotal < jTotal
 }
 
 func ReverseByTotal(p, q *metaproto.Device) bool {
-	iTotal, _ := strconv.ParseInt(string(p.Total), 10, 64)
-	jTotal, _ := strconv.ParseInt(string(q.Total), 10, 64)
+	iTotal, _ := metadata.DeviceTotal(p)
+	jTotal, _ := metadata.DeviceTotal(q)
 	return iTotal > jTotal
 }
 
@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"store"
+)
+
+// MemoryStoreDriver keeps the keys in process memory, it is meant for tests
+// and single node experiments, nothing survives a restart.
+type MemoryStoreDriver struct {
+	mutex     sync.Mutex
+	storeLock sync.Mutex
+	keys      map[string]string
+}
+
+// NewStore installs a fresh memory store as the store backend, replacing
+// whatever backend was installed before.
+func NewStore() *MemoryStoreDriver {
+	mstore := &MemoryStoreDriver{
+		keys: make(map[string]string),
+	}
+	store.Backend = mstore
+	return mstore
+}
+
+// keyNotFound mimics the etcd error string that metadata.ValidKeyNotFoundError checks.
+func keyNotFound(key string) error {
+	return fmt.Errorf("100: Key not found (%s)", key)
+}
+
+func (mstore *MemoryStoreDriver) HealthCheck() (string, error) {
+	return "memory store is healthy", nil
+}
+
+func (mstore *MemoryStoreDriver) Get(key string, opts map[string]string) (string, error) {
+	mstore.mutex.Lock()
+	defer mstore.mutex.Unlock()
+
+	key = path.Clean(key)
+	value, ok := mstore.keys[key]
+	if !ok {
+		if mstore.isDir(key) {
+			return "", fmt.Errorf("directory")
+		}
+		return "", keyNotFound(key)
+	}
+	return value, nil
+}
+
+// List returns the keys right under the directory key, like the etcd driver
+// sub directories are skipped.
+func (mstore *MemoryStoreDriver) List(key string, opts map[string]string) ([]string, error) {
+	mstore.mutex.Lock()
+	defer mstore.mutex.Unlock()
+
+	key = path.Clean(key)
+	if !mstore.isDir(key) {
+		return nil, keyNotFound(key)
+	}
+
+	values := []string{}
+	for k := range mstore.keys {
+		if path.Dir(k) == key {
+			values = append(values, k)
+		}
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+func (mstore *MemoryStoreDriver) Set(key string, value string, opts map[string]string) error {
+	mstore.mutex.Lock()
+	defer mstore.mutex.Unlock()
+
+	mstore.keys[path.Clean(key)] = value
+	return nil
+}
+
+func (mstore *MemoryStoreDriver) Remove(key string, opts map[string]string) error {
+	mstore.mutex.Lock()
+	defer mstore.mutex.Unlock()
+
+	key = path.Clean(key)
+	if _, ok := mstore.keys[key]; !ok {
+		return keyNotFound(key)
+	}
+	delete(mstore.keys, key)
+	return nil
+}
+
+func (mstore *MemoryStoreDriver) Lock() error {
+	mstore.storeLock.Lock()
+	return nil
+}
+
+func (mstore *MemoryStoreDriver) Unlock() error {
+	mstore.storeLock.Unlock()
+	return nil
+}
+
+func (mstore *MemoryStoreDriver) isDir(key string) bool {
+	prefix := strings.TrimSuffix(key, "/") + "/"
+	for k := range mstore.keys {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
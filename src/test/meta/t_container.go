@@ -55,7 +55,7 @@ func test_list_container_name() {
 }
 
 func test_add_container_volume(containerid string, volumeid []byte, mode []byte) {
-	err := metadata.AddContainerVolume(string(containerid), volumeid, []byte(metadata.ROVolume))
+	err := metadata.AddContainerVolume(string(containerid), volumeid, []byte(metadata.ROVolume), metadata.CEPH)
 	if err != nil {
 		fmt.Println("%s\n", err.Error())
 		return
@@ -46,7 +46,7 @@ func EncodeData(v interface{}) (*bytes.Buffer, error) {
 }
 
 func NewUUID() string {
-	return string(uuid.NewUUID())
+	return uuid.NewUUID().String()
 }
 
 func ExtractUUIDs(names []string, prefix, suffix string) ([]string, error) {
@@ -209,6 +209,101 @@ func DetachLoopbackDevice(file, dev string) error {
 	return nil
 }
 
+// FormatDevice creates a filesystem on dev unless it already carries one.
+func FormatDevice(dev, fsType string) error {
+	if _, err := Execute("blkid", []string{"-o", "value", "-s", "TYPE", dev}); err == nil {
+		return nil
+	}
+	if _, err := Execute("mkfs."+fsType, []string{dev}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func MountDevice(dev, mountPoint string, readonly bool) error {
+	if err := MkdirIfNotExists(mountPoint); err != nil {
+		return err
+	}
+	params := []string{}
+	if readonly {
+		params = append(params, "-o", "ro")
+	}
+	params = append(params, dev, mountPoint)
+	if _, err := Execute("mount", params); err != nil {
+		return err
+	}
+	return nil
+}
+
+// BindMount makes source visible at mountPoint, remounting it read only
+// when asked since a bind mount ignores "-o ro" on most kernels.
+func BindMount(source, mountPoint string, readonly bool) error {
+	if err := MkdirIfNotExists(mountPoint); err != nil {
+		return err
+	}
+	if _, err := Execute("mount", []string{"--bind", source, mountPoint}); err != nil {
+		return err
+	}
+	if readonly {
+		if _, err := Execute("mount", []string{"-o", "remount,bind,ro", mountPoint}); err != nil {
+			UnmountDevice(mountPoint)
+			return err
+		}
+	}
+	return nil
+}
+
+func UnmountDevice(mountPoint string) error {
+	if _, err := Execute("umount", []string{mountPoint}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func IsMounted(mountPoint string) bool {
+	data, err := ioutil.ReadFile("/proc/mounts")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[1] == mountPoint {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMountDevice returns the device mounted at mountPoint.
+func GetMountDevice(mountPoint string) (string, error) {
+	data, err := ioutil.ReadFile("/proc/mounts")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[1] == mountPoint {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("%v is not mounted", mountPoint)
+}
+
+// ResizeFilesystem grows the filesystem on dev, mounted at mountPoint, to
+// the size of the device.
+func ResizeFilesystem(dev, mountPoint string) error {
+	fsType, err := Execute("blkid", []string{"-o", "value", "-s", "TYPE", dev})
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(fsType) == "xfs" {
+		_, err = Execute("xfs_growfs", []string{mountPoint})
+	} else {
+		_, err = Execute("resize2fs", []string{dev})
+	}
+	return err
+}
+
 func ValidateUUID(s string) bool {
 	return uuid.Parse(s) != nil
 }